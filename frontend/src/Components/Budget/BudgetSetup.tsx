@@ -60,12 +60,12 @@ const BudgetSetup = () => {
 
   
 
-  const { dispatch, sessionToken, user_id, totalIncome } =
+  const { dispatch, sessionToken, totalIncome } =
   useContext(Context);
 
 
   const getCategories = useCallback(async () => {
-    const response = await fetch("/api/categories", {method: "GET",headers: {
+    const response = await fetch("/api/v1/categories", {method: "GET",headers: {
         "Content-Type": "application/json",
         "Authorization": sessionToken,
       }
@@ -82,7 +82,7 @@ const BudgetSetup = () => {
    }, [dispatch])
 
    const getDashboardInfo = useCallback(async () => {
-    const response = await fetch("/api/budget?user_id="+user_id, {method: "GET",headers: {
+    const response = await fetch("/api/v1/budget", {method: "GET",headers: {
         "Content-Type": "application/json",
         "Authorization": sessionToken,
       }
@@ -297,7 +297,8 @@ const BudgetSetup = () => {
         return {
           id: expense.id,
           description: expense.description,
-          amount: expense.amount,
+          // Amounts arrive as decimal strings.
+          amount: Number(expense.amount),
           category: expense.category,
           allocation_type: expense.allocation_type,
         }
@@ -309,7 +310,7 @@ const BudgetSetup = () => {
     return responseIncomeData.map((income: any) => {
       return {
         id: income.id,
-        amount: income.amount,
+        amount: Number(income.amount),
         frequency: income.frequency,
       };
     });
@@ -320,7 +321,7 @@ const BudgetSetup = () => {
         return {
           type: allocation.allocation_type,
           description: allocation.allocation_description,
-          factor: allocation.allocation_factor,
+          factor: Number(allocation.allocation_factor),
         }
       })
   } 
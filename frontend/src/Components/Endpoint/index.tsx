@@ -4,7 +4,7 @@ import Note from "plaid-threads/Note";
 import Context from "../../Context";
 import Table from "../Table";
 import Error from "../Error";
-import { DataItem, Categories, ErrorDataItem, Data } from "../../dataUtilities";
+import { DataItem, Categories, ErrorDataItem, Data, toErrorDataItem } from "../../dataUtilities";
 
 import styles from "./index.module.scss";
 
@@ -29,20 +29,19 @@ const Endpoint = (props: Props) => {
   const [error, setError] = useState<ErrorDataItem | null>(null);
   const [isLoading, setIsLoading] = useState(false);
 
-  const { sessionToken, accessToken } = useContext(Context)
+  const { sessionToken } = useContext(Context)
 
   const getData = async () => {
 
     setIsLoading(true);
-    const response = await fetch(`/api/${props.endpoint}`, { method: "GET", 
+    const response = await fetch(`/api/v1/plaid/${props.endpoint}`, { method: "GET", 
                                                             headers: {
                                                              "Content-Type": "application/json",
                                                              "Authorization": sessionToken,
-                                                             "AccessToken": accessToken,
                                                             }});
     const data = await response.json();
     if (data.error != null) {
-      setError(data.error);
+      setError(toErrorDataItem(data.error, response.status));
       setIsLoading(false);
       return;
     }
@@ -16,32 +16,29 @@ const Link = () => {
       // If the access_token is needed, send public_token to server
       console.log("public token", public_token);
       const exchangePublicTokenForAccessToken = async () => {
-        const response = await fetch("/api/set_access_token", {
+        const response = await fetch("/api/v1/plaid/set_access_token", {
           method: "POST",
           headers: {
-            "Content-Type": "application/x-www-form-urlencoded;charset=UTF-8",
+            "Content-Type": "application/json",
             "Authorization":sessionToken
           },
-          body: `public_token=${public_token}&user=${user}`,
+          body: JSON.stringify({ public_token }),
         });
         if (!response.ok) {
           dispatch({
             type: "SET_STATE",
             state: {
               itemId: `no item_id retrieved`,
-              accessToken: `no access_token retrieved`,
               isItemAccess: false,
             },
           });
           return;
         }
         const data = await response.json();
-        console.log("access token data", data);
         dispatch({
           type: "SET_STATE",
           state: {
             itemId: data.item_id,
-            accessToken: data.access_token,
             isItemAccess: true,
           },
         });
@@ -10,6 +10,8 @@ import AuthError  from "../Error/autherror";
 const Login = () => {
     const [user, setEmail] = useState("");
     const [password, setPassword] = useState("");
+    const [totpCode, setTotpCode] = useState("");
+    const [challengeToken, setChallengeToken] = useState("");
     const { dispatch, authError, isAuthenticated, sessionToken, accessToken } = useContext(Context);
 
     const navigate = useNavigate();
@@ -18,17 +20,22 @@ const Login = () => {
     const handleSubmit = async (e: React.FormEvent) => {
         e.preventDefault();
 
-        const formData = new FormData();
-        formData.append("user", user);
-        formData.append("password", password);
-        const response = await fetch(`/api/auth/login`, {
+        const body = challengeToken
+            ? { challenge_token: challengeToken, code: totpCode }
+            : { username: user, password: password };
+        const response = await fetch(challengeToken ? `/api/v1/auth/login/totp` : `/api/v1/auth/login`, {
         method: "POST",
-        body: formData,
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify(body),
         });
         if (response.ok) {
             const data = await response.json();
-            if (data.plaidToken) {
-                dispatch({ type: "SET_STATE", state: { user_id: data.user_id, user: data.username, isAuthenticated: true, sessionToken: data.token, accessToken: data.plaidToken, linkSuccess: true }});
+            if (data.totp_required) {
+                setChallengeToken(data.challenge_token);
+                return;
+            }
+            if (data.plaid_linked) {
+                dispatch({ type: "SET_STATE", state: { user_id: data.user_id, user: data.username, isAuthenticated: true, sessionToken: data.token, linkSuccess: true }});
             } else {
                 dispatch({ type: "SET_STATE", state: { user_id: data.user_id, user: data.username, isAuthenticated: true, sessionToken: data.token }});
             }
@@ -45,6 +52,16 @@ const Login = () => {
         <div className={styles.mainContainer}>
             <div className={styles.container}>
             <form onSubmit={handleSubmit} className={styles.loginForm}>
+                {challengeToken ? (
+                <input
+                type="text"
+                value={totpCode}
+                onChange={(e) => setTotpCode(e.target.value)}
+                placeholder="Authentication code"
+                autoComplete="one-time-code"
+                />
+                ) : (
+                <>
                 <input
                 type="text"
                 value={user}
@@ -57,6 +74,8 @@ const Login = () => {
                 onChange={(e) => setPassword(e.target.value)}
                 placeholder="Password"
                 />
+                </>
+                )}
                 <button type="submit" className={styles.formButton}>Login</button>
                 <label> Not Registered? </label><a href="#">Register Here</a>
             </form>
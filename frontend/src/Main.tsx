@@ -9,6 +9,7 @@ import Sidebar from "./Components/Navigation/Sidebar";
 
 import styles from "./App.module.scss";
 import { CraCheckReportProduct } from "plaid";
+import { toErrorDataItem } from "./dataUtilities";
 import { Navigate, useNavigate } from "react-router";
 import Dashboard from "./Components/Dashboard/Dashboard";
 import ContentView from "./Components/Views/ContentView";
@@ -26,7 +27,7 @@ const Main = () => {
     navigate("/login")
   }
   const getInfo = useCallback(async () => {
-    const response = await fetch("/api/info", { method: "POST" });
+    const response = await fetch("/api/v1/info", { method: "POST" });
     if (!response.ok) {
       dispatch({ type: "SET_STATE", state: { backend: false } });
       return { paymentInitiation: false };
@@ -56,23 +57,22 @@ const Main = () => {
   }, [dispatch]);
 
   const generateUserToken = useCallback(async () => {
-    const response = await fetch("api/create_user_token", { method: "POST" });
+    const response = await fetch("/api/v1/plaid/create_user_token", { method: "POST" });
+    const data = await response.json().catch(() => null);
     if (!response.ok) {
       dispatch({ type: "SET_STATE", state: { userToken: null } });
-      return;
-    }
-    const data = await response.json();
-    if (data) {
-      if (data.error != null) {
+      if (data?.error != null) {
         dispatch({
           type: "SET_STATE",
           state: {
             linkToken: null,
-            linkTokenError: data.error,
+            linkTokenError: toErrorDataItem(data.error, response.status),
           },
         });
-        return;
       }
+      return;
+    }
+    if (data) {
       dispatch({ type: "SET_STATE", state: { userToken: data.user_token } });
       return data.user_token;
     }
@@ -83,8 +83,8 @@ const Main = () => {
 
       // Link tokens for 'payment_initiation' use a different creation flow in your backend.
       const path = isPaymentInitiation
-        ? "/api/create_link_token_for_payment"
-        : "/api/create_link_token";
+        ? "/api/v1/plaid/create_link_token_for_payment"
+        : "/api/v1/plaid/create_link_token";
       const response = await fetch(path, {
         method: "POST",
         headers: {
@@ -94,23 +94,21 @@ const Main = () => {
       });
 
 
+      const data = await response.json().catch(() => null);
       if (!response.ok) {
         dispatch({ type: "SET_STATE", state: { linkToken: null } });
-        return;
-      }
-      const data = await response.json();
-
-      if (data) {
-        if (data.error != null) {
+        if (data?.error != null) {
           dispatch({
             type: "SET_STATE",
             state: {
-              linkToken: null,
-              linkTokenError: data.error,
+              linkTokenError: toErrorDataItem(data.error, response.status),
             },
           });
-          return;
         }
+        return;
+      }
+
+      if (data) {
         dispatch({ type: "SET_STATE", state: { linkToken: data.link_token } });
       }
       // Save the link_token to be used later in the Oauth flow.
@@ -167,6 +167,26 @@ export interface ErrorDataItem {
   status_code: number | null;
 }
 
+// The error envelope of /api/v1. Plaid errors carry the Plaid error as
+// details.
+export interface ApiError {
+  code: string;
+  message: string;
+  details: ErrorDataItem | null;
+  request_id: string;
+}
+
+export const toErrorDataItem = (error: ApiError, status: number): ErrorDataItem =>
+  error.details != null
+    ? { ...error.details, status_code: status }
+    : {
+        error_type: "API_ERROR",
+        error_code: error.code.toUpperCase(),
+        error_message: error.message,
+        display_message: null,
+        status_code: status,
+      };
+
 //all possible product data interfaces
 export type DataItem =
   | AuthDataItem
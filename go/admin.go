@@ -0,0 +1,395 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	plaid "github.com/plaid/plaid-go/v31/plaid"
+	"github.com/plaid/quickstart/repository"
+)
+
+// CatalogCategory is the full admin view of a row in the global "Category" table.
+type CatalogCategory = repository.Category
+
+type categoryRequest struct {
+	PlaidPrimary  string `json:"plaid_category_primary_descriptor"`
+	PlaidDetailed string `json:"plaid_category_detailed_descriptor"`
+	Name          string `json:"name" binding:"required"`
+	Description   string `json:"description" binding:"required"`
+}
+
+type AdminUser = repository.UserSummary
+
+type adminCategoriesResponse struct {
+	Categories []CatalogCategory `json:"categories"`
+}
+
+// idResponse names the resource a request created or changed.
+type idResponse struct {
+	ID uuid.UUID `json:"id"`
+}
+
+type adminUsersResponse struct {
+	Users []AdminUser `json:"users"`
+}
+
+type adminItemsResponse struct {
+	Items []AdminPlaidItem `json:"items"`
+}
+
+type roleRequest struct {
+	Role string `json:"role" binding:"required,oneof=user household-owner admin"`
+}
+
+func adminListCategories(c *gin.Context) {
+	categories, err := repos.Categories.List(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load categories")
+		return
+	}
+
+	c.JSON(http.StatusOK, adminCategoriesResponse{Categories: categories})
+}
+
+func adminCreateCategory(c *gin.Context) {
+	var request categoryRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "Name and description are required")
+		return
+	}
+
+	id, err := repos.Categories.Create(c.Request.Context(), request.category(uuid.Nil))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not create category")
+		return
+	}
+
+	_, after := auditDiff(nil, request)
+	recordAudit(c, repository.AuditEntry{
+		Action:       AuditCategoryCreated,
+		ResourceType: "category",
+		ResourceID:   id.String(),
+		After:        after,
+	})
+
+	c.JSON(http.StatusCreated, idResponse{ID: id})
+}
+
+func adminUpdateCategory(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid category id")
+		return
+	}
+
+	var request categoryRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "Name and description are required")
+		return
+	}
+
+	previous, err := repos.Categories.Get(c.Request.Context(), id)
+	if err == repository.ErrNotFound {
+		respondError(c, http.StatusNotFound, "Category not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load category")
+		return
+	}
+
+	err = repos.Categories.Update(c.Request.Context(), request.category(id))
+	if err == repository.ErrNotFound {
+		respondError(c, http.StatusNotFound, "Category not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not update category")
+		return
+	}
+
+	before, after := auditDiff(categoryAuditFields(previous), request)
+	recordAudit(c, repository.AuditEntry{
+		Action:       AuditCategoryUpdated,
+		ResourceType: "category",
+		ResourceID:   id.String(),
+		Before:       before,
+		After:        after,
+	})
+
+	c.JSON(http.StatusOK, idResponse{ID: id})
+}
+
+func adminDeleteCategory(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid category id")
+		return
+	}
+
+	previous, err := repos.Categories.Get(c.Request.Context(), id)
+	if err == repository.ErrNotFound {
+		respondError(c, http.StatusNotFound, "Category not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load category")
+		return
+	}
+
+	err = repos.Categories.Delete(c.Request.Context(), id)
+	if err == repository.ErrConflict {
+		respondError(c, http.StatusConflict, "Category is still used by expenses")
+		return
+	}
+	if err == repository.ErrNotFound {
+		respondError(c, http.StatusNotFound, "Category not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not delete category")
+		return
+	}
+
+	before, _ := auditDiff(categoryAuditFields(previous), nil)
+	recordAudit(c, repository.AuditEntry{
+		Action:       AuditCategoryDeleted,
+		ResourceType: "category",
+		ResourceID:   id.String(),
+		Before:       before,
+	})
+
+	c.Status(http.StatusNoContent)
+}
+
+func (request categoryRequest) category(id uuid.UUID) CatalogCategory {
+	return CatalogCategory{
+		ID:            id,
+		PlaidPrimary:  request.PlaidPrimary,
+		PlaidDetailed: request.PlaidDetailed,
+		Name:          request.Name,
+		Description:   request.Description,
+	}
+}
+
+// categoryAuditFields returns the editable fields, so audit diffs skip timestamps.
+func categoryAuditFields(category CatalogCategory) categoryRequest {
+	return categoryRequest{
+		PlaidPrimary:  category.PlaidPrimary,
+		PlaidDetailed: category.PlaidDetailed,
+		Name:          category.Name,
+		Description:   category.Description,
+	}
+}
+
+func adminListUsers(c *gin.Context) {
+	users, err := repos.Users.List(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load users")
+		return
+	}
+
+	c.JSON(http.StatusOK, adminUsersResponse{Users: users})
+}
+
+func adminSetUserRole(c *gin.Context) {
+	var request roleRequest
+	if err := c.ShouldBindJSON(&request); err != nil || !validRole(request.Role) {
+		respondError(c, http.StatusBadRequest, "Role must be one of user, household-owner, admin")
+		return
+	}
+
+	setUserField(c, AuditUserRoleChanged, "role", request.Role)
+}
+
+func adminDisableUser(c *gin.Context) {
+	if c.Param("id") == c.GetString("userid") {
+		respondError(c, http.StatusBadRequest, "Admins cannot disable their own account")
+		return
+	}
+
+	setUserField(c, AuditUserDisabled, "disabled", true)
+}
+
+func adminEnableUser(c *gin.Context) {
+	setUserField(c, AuditUserEnabled, "disabled", false)
+}
+
+// setUserField updates the role or disabled column of the user in the :id
+// path parameter and audits the change. An update that would leave no
+// enabled admin is refused.
+func setUserField(c *gin.Context, action string, field string, value interface{}) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+
+	ctx := c.Request.Context()
+	role, disabled, err := userAccountStatus(ctx, id.String(), repos.Users)
+	if err == repository.ErrNotFound {
+		respondError(c, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load user")
+		return
+	}
+
+	switch field {
+	case "role":
+		err = repos.Users.SetRole(ctx, id.String(), value.(string))
+	case "disabled":
+		err = repos.Users.SetDisabled(ctx, id.String(), value.(bool))
+	}
+	if err == repository.ErrConflict {
+		respondError(c, http.StatusConflict, "At least one enabled admin is required")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not update user")
+		return
+	}
+
+	previous := gin.H{"role": role, "disabled": disabled}
+	updated := gin.H{"role": role, "disabled": disabled, field: value}
+	before, after := auditDiff(previous, updated)
+	recordAudit(c, repository.AuditEntry{
+		SubjectID:    id.String(),
+		Action:       action,
+		ResourceType: "user",
+		ResourceID:   id.String(),
+		Before:       before,
+		After:        after,
+	})
+
+	c.JSON(http.StatusOK, idResponse{ID: id})
+}
+
+// adminUnlinkItem removes a Plaid item at Plaid and deletes it, along with its
+// synced transactions, from our database.
+func adminUnlinkItem(c *gin.Context) {
+	userid, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+	itemid, err := uuid.Parse(c.Param("item_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid item id")
+		return
+	}
+
+	ctx := requestContext(c)
+
+	item, err := repos.PlaidItems.Get(ctx, userid.String(), itemid)
+	if err == repository.ErrNotFound {
+		respondError(c, http.StatusNotFound, "Item not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load item")
+		return
+	}
+	// Manual items and items restored from a backup have no token, so there
+	// is nothing to remove at Plaid.
+	isCurrent := false
+	if item.AccessToken != "" {
+		itemAccessToken, err := tokenCipher.Decrypt(ctx, item.AccessToken, repository.ItemAccessToken, item.ID.String())
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "Could not decrypt item access token")
+			return
+		}
+		userAccessToken, err := userPlaidAccessToken(ctx, userid.String(), repos.Users)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "Could not load user")
+			return
+		}
+
+		_, _, err = client.PlaidApi.ItemRemove(ctx).ItemRemoveRequest(
+			*plaid.NewItemRemoveRequest(itemAccessToken),
+		).Execute()
+		if err != nil {
+			// An item Plaid no longer knows about can still be dropped locally.
+			if plaidErr, convErr := plaid.ToPlaidError(err); convErr != nil || plaidErr.ErrorCode != "ITEM_NOT_FOUND" {
+				renderError(c, err)
+				return
+			}
+		}
+		isCurrent = userAccessToken == itemAccessToken
+	}
+
+	err = repos.PlaidItems.Delete(ctx, userid.String(), itemid, isCurrent)
+	if err != nil && err != repository.ErrNotFound {
+		respondError(c, http.StatusInternalServerError, "Could not unlink item")
+		return
+	}
+
+	recordAudit(c, repository.AuditEntry{
+		SubjectID:    userid.String(),
+		Action:       AuditPlaidItemUnlinked,
+		ResourceType: "plaid_item",
+		ResourceID:   itemid.String(),
+	})
+
+	c.Status(http.StatusNoContent)
+}
+
+type AdminPlaidItem = repository.PlaidItem
+
+func adminListUserItems(c *gin.Context) {
+	userid, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+
+	items, err := repos.PlaidItems.ListByUser(c.Request.Context(), userid.String())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load items")
+		return
+	}
+
+	recordAudit(c, repository.AuditEntry{
+		SubjectID:    userid.String(),
+		Action:       AuditUserDataRead,
+		ResourceType: "plaid_item",
+	})
+
+	c.JSON(http.StatusOK, adminItemsResponse{Items: items})
+}
+
+// adminGetUserBudget returns the budget of the user in the :id path
+// parameter. Like every admin read of another user's data, it is audited.
+func adminGetUserBudget(c *gin.Context) {
+	userid, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := repos.Users.ByID(ctx, userid.String()); err == repository.ErrNotFound {
+		respondError(c, http.StatusNotFound, "User not found")
+		return
+	} else if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load user")
+		return
+	}
+
+	budget, err := repos.Budgets.Load(ctx, userid.String())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load budget")
+		return
+	}
+
+	recordAudit(c, repository.AuditEntry{
+		SubjectID:    userid.String(),
+		Action:       AuditUserDataRead,
+		ResourceType: "budget",
+		ResourceID:   userid.String(),
+	})
+
+	c.JSON(http.StatusOK, budgetResponse{Budget: budget})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/plaid/quickstart/repository"
+)
+
+// fakeBudgetRepo answers Load with budget and remembers whose budget was loaded.
+type fakeBudgetRepo struct {
+	repository.BudgetRepo
+	budget repository.Budget
+	loaded []string
+}
+
+func (r *fakeBudgetRepo) Load(ctx context.Context, userid string) (repository.Budget, error) {
+	r.loaded = append(r.loaded, userid)
+	return r.budget, nil
+}
+
+const (
+	adminTestAdmin = "ed1bec4c-0a1b-4783-b47f-16ba0650b821"
+	adminTestOther = "5d3c1f0e-7b7a-4c53-9a8e-2f4b6c1d9e01"
+)
+
+func TestAdminGetUserBudget(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		userID     string
+		wantStatus int
+		wantLoaded bool
+	}{
+		{"another user", adminTestOther, http.StatusOK, true},
+		{"unknown user", "00000000-0000-0000-0000-000000000000", http.StatusNotFound, false},
+		{"invalid id", "not-a-uuid", http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audit := useFakeAudit(t)
+			budgets := &fakeBudgetRepo{}
+			repos.Budgets = budgets
+			repos.Users = &fakeUserRepo{users: map[string]repository.User{
+				adminTestAdmin: {ID: adminTestAdmin, Role: RoleAdmin},
+				adminTestOther: {ID: adminTestOther, Role: RoleUser},
+			}}
+
+			r := gin.New()
+			r.GET("/admin/users/:id/budget", func(c *gin.Context) {
+				c.Set("userid", adminTestAdmin)
+			}, adminGetUserBudget)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/users/"+tt.userID+"/budget", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if !tt.wantLoaded {
+				if len(budgets.loaded) != 0 || len(audit.appended) != 0 {
+					t.Errorf("loaded %v and audited %v, want neither", budgets.loaded, audit.appended)
+				}
+				return
+			}
+			if len(budgets.loaded) != 1 || budgets.loaded[0] != tt.userID {
+				t.Errorf("loaded budgets of %v, want %s", budgets.loaded, tt.userID)
+			}
+			if len(audit.appended) != 1 {
+				t.Fatalf("audited %d entries, want 1", len(audit.appended))
+			}
+			entry := audit.appended[0]
+			if entry.Action != AuditUserDataRead || entry.ActorID != adminTestAdmin || entry.SubjectID != tt.userID || entry.ResourceType != "budget" {
+				t.Errorf("audit entry = %+v", entry)
+			}
+		})
+	}
+}
+
+func TestGetBudgetHandlerIgnoresUserID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useFakeAudit(t)
+	budgets := &fakeBudgetRepo{}
+	repos.Budgets = budgets
+
+	r := gin.New()
+	r.GET("/budget", func(c *gin.Context) {
+		c.Set("userid", adminTestAdmin)
+		c.Set("role", RoleAdmin)
+	}, getBudgetHandler)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/budget?user_id="+adminTestOther, nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if len(budgets.loaded) != 1 || budgets.loaded[0] != adminTestAdmin {
+		t.Errorf("loaded budgets of %v, want only the caller's", budgets.loaded)
+	}
+}
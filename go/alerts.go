@@ -0,0 +1,952 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	// Quiet hours are kept in the user's time zone, which must resolve even
+	// on hosts without a zoneinfo database.
+	_ "time/tzdata"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	plaid "github.com/plaid/plaid-go/v31/plaid"
+	"github.com/plaid/quickstart/money"
+	"github.com/plaid/quickstart/repository"
+)
+
+// Kinds of alert rule. Percent-of-plan rules watch the expense id or
+// allocation type in Target and fire at Threshold percent of the month's
+// plan; the others compare amounts in the reporting currency to Threshold.
+const (
+	alertExpensePercent    = "expense_percent"
+	alertAllocationPercent = "allocation_percent"
+	alertLargeTransaction  = "large_transaction"
+	alertLowBalance        = "low_balance"
+	alertRecurringCharge   = "recurring_charge"
+	alertLoginRequired     = "item_login_required"
+)
+
+var alertKinds = []string{alertExpensePercent, alertAllocationPercent, alertLargeTransaction, alertLowBalance, alertRecurringCharge, alertLoginRequired}
+
+// Alert channels. Every alert is kept in the in-app inbox; rules name the
+// channels it is also delivered through.
+const (
+	alertChannelInbox   = "inbox"
+	alertChannelEmail   = "email"
+	alertChannelWebhook = "webhook"
+)
+
+const (
+	// alertDeliveryInterval is how often queued email and webhook
+	// deliveries are sent, including those held back by quiet hours.
+	alertDeliveryInterval = time.Minute
+	alertDeliveryBatch    = 100
+	// alertMaxAttempts bounds retries; the delay doubles from a minute.
+	alertMaxAttempts = 6
+
+	// alertLookbackDays keeps transaction rules from firing for old
+	// history when a stale item catches up.
+	alertLookbackDays = 7
+	// alertMaxPercent bounds the threshold of percent-of-plan rules.
+	alertMaxPercent = 1000
+
+	// alertRecurringDays is the history searched for recurring charges:
+	// enough to tell a new monthly charge from one seen three times.
+	alertRecurringDays = 100
+
+	alertInboxDefaultLimit = 50
+	alertInboxMaxLimit     = 500
+
+	plaidLoginRequired = "ITEM_LOGIN_REQUIRED"
+)
+
+// alertEvaluation is what one user's rules are checked against, loaded
+// once per run.
+type alertEvaluation struct {
+	userid string
+	now    time.Time
+	// from is the start of the current month, which plans are compared
+	// over, and period its YYYY-MM name.
+	from   time.Time
+	period string
+	fx     *fxConverter
+	budget repository.Budget
+	// spent is the month's spending by expense, in fx's currency.
+	spent map[uuid.UUID]money.Decimal
+	// outflows are the posted outflows of the last alertRecurringDays,
+	// oldest first, loaded on first use.
+	outflows []repository.Transaction
+}
+
+func newAlertEvaluation(ctx context.Context, userid string, now time.Time) (*alertEvaluation, error) {
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	e := &alertEvaluation{userid: userid, now: now, from: from, period: from.Format(statementPeriodLayout)}
+
+	budget, err := repos.Budgets.Load(ctx, userid)
+	if err != nil {
+		return nil, err
+	}
+	e.budget = budget
+	e.fx, err = newFXConverter(ctx, userid, now.AddDate(0, 0, -alertRecurringDays), now)
+	if err != nil {
+		return nil, err
+	}
+	totals, err := repos.Transactions.ExpenseTotals(ctx, userid, from, now)
+	if err != nil {
+		return nil, err
+	}
+	e.spent = e.fx.ExpenseTotals(totals)
+	return e, nil
+}
+
+// evaluateAlerts checks the user's enabled rules and fires an alert for
+// each condition that holds. It runs after every sync; dedupe keys keep a
+// condition from firing again on the next one.
+func evaluateAlerts(ctx context.Context, userid string, now time.Time) error {
+	rules, err := repos.Alerts.Rules(ctx, userid)
+	if err != nil {
+		return err
+	}
+	var e *alertEvaluation
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		if e == nil {
+			if e, err = newAlertEvaluation(ctx, userid, now); err != nil {
+				return err
+			}
+		}
+		alerts, err := e.check(ctx, rule)
+		if err != nil {
+			return err
+		}
+		for _, alert := range alerts {
+			ruleID := rule.ID
+			alert.ID, alert.UserID, alert.RuleID, alert.Kind, alert.CreatedAt = uuid.New(), userid, &ruleID, rule.Kind, now
+			alert.DedupeKey = rule.ID.String() + ":" + alert.DedupeKey
+			fired, err := repos.Alerts.Fire(ctx, alert, deliveryChannels(rule.Channels))
+			if err != nil {
+				return err
+			}
+			if fired {
+				publishEvent(ctx, userid, webhookEvent{ID: alert.ID, Type: eventAlertFired, CreatedAt: now, Data: alert})
+			}
+		}
+	}
+	return nil
+}
+
+// checkAlerts is evaluateAlerts for sync paths, which log a failure rather
+// than fail the sync.
+func checkAlerts(ctx context.Context, userid string) {
+	runJob(ctx, "alerts", func() {
+		if err := evaluateAlerts(ctx, userid, time.Now().UTC()); err != nil {
+			logger("alerts").ErrorContext(ctx, "could not check alerts", "user_id", userid, "err", err)
+		}
+	})
+}
+
+func (e *alertEvaluation) check(ctx context.Context, rule repository.AlertRule) ([]repository.Alert, error) {
+	switch rule.Kind {
+	case alertExpensePercent:
+		return e.expensePercent(rule), nil
+	case alertAllocationPercent:
+		return e.allocationPercent(rule), nil
+	case alertLargeTransaction:
+		return e.largeTransactions(ctx, rule)
+	case alertLowBalance:
+		return e.lowBalance(ctx, rule)
+	case alertRecurringCharge:
+		return e.recurringCharges(ctx, rule)
+	case alertLoginRequired:
+		return e.loginRequired(ctx, rule)
+	}
+	return nil, nil
+}
+
+// percentOfPlan returns the alert for spent reaching rule.Threshold percent
+// of planned, once a month, or nil.
+func (e *alertEvaluation) percentOfPlan(rule repository.AlertRule, name string, spent money.Decimal, planned money.Decimal) []repository.Alert {
+	if planned.Sign() <= 0 || spent.MulInt(100).LessThan(planned.Mul(rule.Threshold)) {
+		return nil
+	}
+	percent := spent.Float64() / planned.Float64() * 100
+	return []repository.Alert{{
+		DedupeKey: e.period,
+		Title:     fmt.Sprintf("%s has reached %.0f%% of plan", name, percent),
+		Body: fmt.Sprintf("You have spent %s %s of the %s %s planned for %s in %s.",
+			formatAmount(spent), e.fx.Currency, formatAmount(planned), e.fx.Currency, name, e.from.Format("January 2006")),
+	}}
+}
+
+func (e *alertEvaluation) expensePercent(rule repository.AlertRule) []repository.Alert {
+	for _, expense := range e.budget.Expenses {
+		if expense.Id.String() == rule.Target {
+			return e.percentOfPlan(rule, expense.Description, e.spent[expense.Id], expense.Amount)
+		}
+	}
+	return nil
+}
+
+// allocationPercent compares the spending on an allocation's expenses with
+// their plans, as the statement's allocation rows do.
+func (e *alertEvaluation) allocationPercent(rule repository.AlertRule) []repository.Alert {
+	for _, allocation := range e.budget.Allocations {
+		if allocation.AllocationType != rule.Target {
+			continue
+		}
+		spent, planned := money.Zero, money.Zero
+		for _, expense := range e.budget.Expenses {
+			if expense.AllocationType == allocation.AllocationType {
+				spent = spent.Add(e.spent[expense.Id])
+				planned = planned.Add(expense.Amount)
+			}
+		}
+		return e.percentOfPlan(rule, allocation.AllocationDescription, spent, planned)
+	}
+	return nil
+}
+
+// loadOutflows loads the posted outflows of the last alertRecurringDays.
+// Pending transactions are left out: Plaid gives them a new id once they
+// post, which would fire their alerts twice.
+func (e *alertEvaluation) loadOutflows(ctx context.Context) ([]repository.Transaction, error) {
+	if e.outflows != nil {
+		return e.outflows, nil
+	}
+	notPending := false
+	query := repository.TransactionQuery{
+		From: e.now.AddDate(0, 0, -alertRecurringDays), To: e.now, Pending: &notPending,
+		Sort: repository.SortByDate, Limit: transactionsMaxPageSize,
+	}
+	e.outflows = []repository.Transaction{}
+	for {
+		page, err := repos.Transactions.Search(ctx, e.userid, query)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range page.Transactions {
+			if t.Amount.Sign() > 0 {
+				e.outflows = append(e.outflows, t)
+			}
+		}
+		if page.NextCursor == "" {
+			return e.outflows, nil
+		}
+		query.After = page.NextCursor
+	}
+}
+
+// since is the first day transaction rules look at. Banks post charges a
+// day or more after they are made, so it reaches back alertLookbackDays
+// rather than to the last run or the rule's creation.
+func (e *alertEvaluation) since() time.Time {
+	since := e.now.AddDate(0, 0, -alertLookbackDays)
+	return time.Date(since.Year(), since.Month(), since.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func transactionPayee(t repository.Transaction) string {
+	if t.MerchantName != "" {
+		return t.MerchantName
+	}
+	return t.Name
+}
+
+func (e *alertEvaluation) largeTransactions(ctx context.Context, rule repository.AlertRule) ([]repository.Alert, error) {
+	outflows, err := e.loadOutflows(ctx)
+	if err != nil {
+		return nil, err
+	}
+	since := e.since()
+	alerts := []repository.Alert{}
+	for _, t := range outflows {
+		if t.Date.Before(since) {
+			continue
+		}
+		amount := e.fx.Convert(t.Amount, t.ISOCurrencyCode, t.Date)
+		if !amount.GreaterThan(rule.Threshold) {
+			continue
+		}
+		alerts = append(alerts, repository.Alert{
+			DedupeKey: "transaction:" + t.ID.String(),
+			Title:     "Large transaction at " + transactionPayee(t),
+			Body: fmt.Sprintf("%s charged %s %s on %s, over your limit of %s %s.",
+				transactionPayee(t), formatAmount(amount), e.fx.Currency, t.Date.Format("Jan 2, 2006"), formatAmount(rule.Threshold), e.fx.Currency),
+		})
+	}
+	return alerts, nil
+}
+
+// recurringCharges finds payees charged twice, about a month apart, for
+// about the same amount, and not otherwise within alertRecurringDays: a
+// subscription seen for the first time. Payees charged more often are
+// already known or are not subscriptions. Threshold is the smallest charge
+// worth an alert.
+func (e *alertEvaluation) recurringCharges(ctx context.Context, rule repository.AlertRule) ([]repository.Alert, error) {
+	outflows, err := e.loadOutflows(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byPayee := map[string][]repository.Transaction{}
+	payees := []string{}
+	for _, t := range outflows {
+		key := strings.ToLower(strings.TrimSpace(transactionPayee(t)))
+		if _, ok := byPayee[key]; !ok {
+			payees = append(payees, key)
+		}
+		byPayee[key] = append(byPayee[key], t)
+	}
+
+	since := e.since()
+	alerts := []repository.Alert{}
+	for _, key := range payees {
+		charges := byPayee[key]
+		if len(charges) != 2 || charges[1].Date.Before(since) {
+			continue
+		}
+		first, second := charges[0], charges[1]
+		days := second.Date.Sub(first.Date).Hours() / 24
+		if days < 26 || days > 35 {
+			continue
+		}
+		a, b := e.fx.Convert(first.Amount, first.ISOCurrencyCode, first.Date), e.fx.Convert(second.Amount, second.ISOCurrencyCode, second.Date)
+		larger, difference := a, a.Sub(b).Abs()
+		if b.GreaterThan(a) {
+			larger = b
+		}
+		// Amounts within a tenth of each other allow for price changes and
+		// exchange rates.
+		if difference.MulInt(10).GreaterThan(larger) || b.LessThan(rule.Threshold) {
+			continue
+		}
+		if len(key) > 150 {
+			key = key[:150]
+		}
+		alerts = append(alerts, repository.Alert{
+			DedupeKey: "payee:" + key,
+			Title:     "New recurring charge from " + transactionPayee(second),
+			Body: fmt.Sprintf("%s charged %s %s on %s and %s %s on %s. It looks like a new monthly charge.",
+				transactionPayee(second), formatAmount(a), e.fx.Currency, first.Date.Format("Jan 2"), formatAmount(b), e.fx.Currency, second.Date.Format("Jan 2, 2006")),
+		})
+	}
+	return alerts, nil
+}
+
+// lowBalance projects the cash left at the end of the month: the latest
+// balances of depository accounts less what remains of this month's
+// expense plans. It fires once a month.
+func (e *alertEvaluation) lowBalance(ctx context.Context, rule repository.AlertRule) ([]repository.Alert, error) {
+	today := time.Date(e.now.Year(), e.now.Month(), e.now.Day(), 0, 0, 0, 0, time.UTC)
+	snapshots, err := repos.Balances.History(ctx, e.userid, today, today)
+	if err != nil {
+		return nil, err
+	}
+	latest := map[string]repository.BalanceSnapshot{}
+	for _, s := range snapshots {
+		if s.Type == string(plaid.ACCOUNTTYPE_DEPOSITORY) {
+			latest[s.ItemID.String()+":"+s.PlaidAccountID] = s
+		}
+	}
+	if len(latest) == 0 {
+		return nil, nil
+	}
+
+	cash := money.Zero
+	for _, s := range latest {
+		balance := s.Available
+		if balance == nil {
+			balance = s.Current
+		}
+		if balance != nil {
+			cash = cash.Add(e.fx.Convert(*balance, s.ISOCurrencyCode, s.Date))
+		}
+	}
+	remaining := money.Zero
+	for _, expense := range e.budget.Expenses {
+		if left := expense.Amount.Sub(e.spent[expense.Id]); left.Sign() > 0 {
+			remaining = remaining.Add(left)
+		}
+	}
+	balance := cash.Round(money.MinorUnits(e.fx.Currency))
+	projected := balance.Sub(remaining)
+	if !projected.LessThan(rule.Threshold) {
+		return nil, nil
+	}
+	return []repository.Alert{{
+		DedupeKey: e.period,
+		Title:     fmt.Sprintf("Projected balance below %s %s", formatAmount(rule.Threshold), e.fx.Currency),
+		Body: fmt.Sprintf("Your cash accounts hold %s %s and %s %s of this month's plan is still to be spent, leaving %s %s.",
+			formatAmount(balance), e.fx.Currency, formatAmount(remaining), e.fx.Currency, formatAmount(projected), e.fx.Currency),
+	}}, nil
+}
+
+func (e *alertEvaluation) loginRequired(ctx context.Context, rule repository.AlertRule) ([]repository.Alert, error) {
+	items, err := repos.PlaidItems.ListByUser(ctx, e.userid)
+	if err != nil {
+		return nil, err
+	}
+	alerts := []repository.Alert{}
+	for _, item := range items {
+		if item.LoginRequiredAt == nil {
+			continue
+		}
+		name := item.InstitutionName
+		if name == "" {
+			name = "A linked institution"
+		}
+		alerts = append(alerts, repository.Alert{
+			DedupeKey: fmt.Sprintf("item:%s:%d", item.ID, item.LoginRequiredAt.Unix()),
+			Title:     name + " needs you to sign in again",
+			Body:      fmt.Sprintf("SmartSplit can no longer sync %s. Reconnect it to resume syncing.", name),
+		})
+	}
+	return alerts, nil
+}
+
+// plaidErrorCode returns the error_code of a Plaid API error, or "".
+func plaidErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	plaidErr, convErr := plaid.ToPlaidError(err)
+	if convErr != nil {
+		return ""
+	}
+	return plaidErr.ErrorCode
+}
+
+// recordItemLogin notes whether a Plaid call for the item, which failed
+// with err or succeeded if err is nil, shows the user must sign in to the
+// institution again. Other errors say nothing about the login.
+func recordItemLogin(ctx context.Context, itemid uuid.UUID, err error) {
+	required := plaidErrorCode(err) == plaidLoginRequired
+	if err != nil && !required {
+		return
+	}
+	if err := repos.PlaidItems.SetLoginRequired(ctx, itemid, required); err != nil {
+		logger("alerts").ErrorContext(ctx, "could not record item login", "item_id", itemid, "err", err)
+	}
+}
+
+// deliveryChannels lists the channels besides the inbox, once each.
+func deliveryChannels(channels []string) []string {
+	out := []string{}
+	for _, channel := range channels {
+		if channel == alertChannelInbox || containsString(out, channel) {
+			continue
+		}
+		out = append(out, channel)
+	}
+	return out
+}
+
+// AlertChannel delivers an alert outside the app.
+type AlertChannel interface {
+	Deliver(ctx context.Context, delivery repository.AlertDelivery, settings repository.AlertSettings) error
+}
+
+var alertChannels = map[string]AlertChannel{
+	alertChannelEmail:   emailAlertChannel{},
+	alertChannelWebhook: webhookAlertChannel{client: webhookClient},
+}
+
+// emailAlertChannel sends alerts through the mailer statements use.
+type emailAlertChannel struct{}
+
+func (emailAlertChannel) Deliver(ctx context.Context, delivery repository.AlertDelivery, settings repository.AlertSettings) error {
+	if mailer == nil {
+		return errors.New("email is not configured")
+	}
+	if delivery.Email == "" {
+		return errors.New("user has no email address")
+	}
+	return mailer.Send(ctx, MailMessage{
+		To:      delivery.Email,
+		Subject: delivery.Alert.Title,
+		Body:    delivery.Alert.Body + "\n\nYou are receiving this because of an alert rule you set up in SmartSplit.\n",
+	})
+}
+
+// webhookAlertChannel POSTs alerts as JSON to the user's webhook URL,
+// signed with their webhook secret.
+type webhookAlertChannel struct {
+	client *http.Client
+}
+
+func (ch webhookAlertChannel) Deliver(ctx context.Context, delivery repository.AlertDelivery, settings repository.AlertSettings) error {
+	if settings.WebhookURL == "" || settings.WebhookSecret == "" {
+		return errors.New("no webhook is set up")
+	}
+	secret, err := tokenCipher.Decrypt(ctx, settings.WebhookSecret, repository.AlertWebhookSecret, delivery.Alert.UserID)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(webhookEvent{ID: delivery.Alert.ID, Type: eventAlertFired, CreatedAt: delivery.Alert.CreatedAt, Data: delivery.Alert})
+	if err != nil {
+		return err
+	}
+	_, err = postWebhook(ctx, ch.client, settings.WebhookURL, secret, eventAlertFired, delivery.Alert.ID, body)
+	return err
+}
+
+// parseClock reads HH:MM as minutes after midnight.
+func parseClock(s string) (int, bool) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+// inQuietHours reports whether now falls within the user's quiet hours,
+// which may span midnight, as 22:00 to 07:00 does.
+func inQuietHours(settings repository.AlertSettings, now time.Time) bool {
+	start, okStart := parseClock(settings.QuietStart)
+	end, okEnd := parseClock(settings.QuietEnd)
+	if !okStart || !okEnd || start == end {
+		return false
+	}
+	location, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// deliverAlerts sends the queued deliveries that are due, holding back
+// those of users in their quiet hours until the hours end.
+func deliverAlerts(ctx context.Context, now time.Time) {
+	deliveries, err := repos.Alerts.PendingDeliveries(ctx, now, alertMaxAttempts, alertDeliveryBatch)
+	if err != nil {
+		logger("alerts").ErrorContext(ctx, "could not load alert deliveries", "err", err)
+		return
+	}
+	settings := map[string]repository.AlertSettings{}
+	for _, delivery := range deliveries {
+		userSettings, ok := settings[delivery.Alert.UserID]
+		if !ok {
+			userSettings, err = repos.Alerts.Settings(ctx, delivery.Alert.UserID)
+			if err != nil {
+				logger("alerts").ErrorContext(ctx, "could not load alert settings", "user_id", delivery.Alert.UserID, "err", err)
+				continue
+			}
+			settings[delivery.Alert.UserID] = userSettings
+		}
+		if inQuietHours(userSettings, now) {
+			continue
+		}
+
+		var deliveryErr error
+		if channel, ok := alertChannels[delivery.Channel]; ok {
+			deliveryErr = channel.Deliver(ctx, delivery, userSettings)
+		} else {
+			deliveryErr = fmt.Errorf("unknown channel %q", delivery.Channel)
+		}
+		if deliveryErr != nil {
+			logger("alerts").WarnContext(ctx, "alert delivery failed", "alert_id", delivery.Alert.ID, "channel", delivery.Channel, "attempts", delivery.Attempts+1, "err", deliveryErr)
+		}
+		retryAt := now.Add(deliveryRetryDelay(delivery.Attempts))
+		if err := repos.Alerts.MarkAttempted(ctx, delivery.Alert.ID, delivery.Channel, deliveryErr, retryAt); err != nil {
+			logger("alerts").ErrorContext(ctx, "could not record alert delivery", "alert_id", delivery.Alert.ID, "channel", delivery.Channel, "err", err)
+		}
+	}
+}
+
+// runAlertDelivery sends queued alert deliveries now and every
+// alertDeliveryInterval until ctx is done. ALERT_DELIVERY=false turns the
+// job off; alerts still reach the inbox.
+func runAlertDelivery(ctx context.Context) {
+	if os.Getenv("ALERT_DELIVERY") == "false" {
+		return
+	}
+	ticker := time.NewTicker(alertDeliveryInterval)
+	defer ticker.Stop()
+	for {
+		runJob(ctx, "alerts", func() { deliverAlerts(ctx, time.Now().UTC()) })
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type alertsResponse struct {
+	Alerts []repository.Alert `json:"alerts"`
+}
+
+func getAlertsHandler(c *gin.Context) {
+	unreadOnly := false
+	if v := c.Query("unread"); v != "" {
+		var err error
+		if unreadOnly, err = strconv.ParseBool(v); err != nil {
+			respondError(c, http.StatusBadRequest, "unread must be true or false")
+			return
+		}
+	}
+	limit := alertInboxDefaultLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			respondError(c, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = min(n, alertInboxMaxLimit)
+	}
+
+	alerts, err := repos.Alerts.List(c.Request.Context(), c.GetString("userid"), unreadOnly, limit)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load alerts")
+		return
+	}
+	c.JSON(http.StatusOK, alertsResponse{Alerts: alerts})
+}
+
+func markAlertReadHandler(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid alert id")
+		return
+	}
+	err = repos.Alerts.MarkRead(c.Request.Context(), c.GetString("userid"), id)
+	if err == repository.ErrNotFound {
+		respondError(c, http.StatusNotFound, "Alert not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not update alert")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type alertRulesResponse struct {
+	Rules []repository.AlertRule `json:"rules"`
+	// Kinds are the kinds of rule that can be created.
+	Kinds          []string `json:"kinds"`
+	EmailAvailable bool     `json:"email_available"`
+}
+
+func getAlertRulesHandler(c *gin.Context) {
+	rules, err := repos.Alerts.Rules(c.Request.Context(), c.GetString("userid"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load alert rules")
+		return
+	}
+	c.JSON(http.StatusOK, alertRulesResponse{Rules: rules, Kinds: alertKinds, EmailAvailable: mailer != nil})
+}
+
+type alertRuleRequest struct {
+	Kind      string        `json:"kind" binding:"required"`
+	Name      string        `json:"name" binding:"required"`
+	Target    string        `json:"target"`
+	Threshold money.Decimal `json:"threshold"`
+	Channels  []string      `json:"channels"`
+	// Enabled defaults to true.
+	Enabled *bool `json:"enabled"`
+}
+
+// rule validates the request against the user's budget and settings and
+// returns the rule it describes, or a message for the user.
+func (r alertRuleRequest) rule(ctx context.Context, userid string, id uuid.UUID) (repository.AlertRule, string, error) {
+	rule := repository.AlertRule{ID: id, Kind: r.Kind, Name: strings.TrimSpace(r.Name), Target: r.Target, Threshold: r.Threshold, Channels: []string{alertChannelInbox}, Enabled: true}
+	if r.Enabled != nil {
+		rule.Enabled = *r.Enabled
+	}
+	if rule.Name == "" {
+		return rule, "name is required", nil
+	}
+
+	if r.Threshold.Abs().GreaterThan(money.MaxAmount) {
+		return rule, "threshold must be at most " + money.MaxAmount.StringFixed(0) + " in magnitude", nil
+	}
+	switch r.Kind {
+	case alertExpensePercent, alertAllocationPercent:
+		if r.Threshold.Sign() <= 0 || r.Threshold.GreaterThan(money.FromInt(alertMaxPercent)) {
+			return rule, fmt.Sprintf("threshold must be a percentage above 0 and at most %d", alertMaxPercent), nil
+		}
+		budget, err := repos.Budgets.Load(ctx, userid)
+		if err != nil {
+			return rule, "", err
+		}
+		found := false
+		for _, expense := range budget.Expenses {
+			found = found || (r.Kind == alertExpensePercent && expense.Id.String() == r.Target)
+		}
+		for _, allocation := range budget.Allocations {
+			found = found || (r.Kind == alertAllocationPercent && allocation.AllocationType == r.Target)
+		}
+		if !found {
+			return rule, "target must be an expense id or allocation type of your budget", nil
+		}
+	case alertLargeTransaction:
+		if r.Threshold.Sign() <= 0 {
+			return rule, "threshold must be a positive amount", nil
+		}
+		rule.Target = ""
+	case alertLowBalance, alertRecurringCharge:
+		if r.Kind == alertRecurringCharge && r.Threshold.Sign() < 0 {
+			return rule, "threshold must not be negative", nil
+		}
+		rule.Target = ""
+	case alertLoginRequired:
+		rule.Target, rule.Threshold = "", money.Zero
+	default:
+		return rule, "kind must be one of " + strings.Join(alertKinds, ", "), nil
+	}
+
+	for _, channel := range r.Channels {
+		switch channel {
+		case alertChannelInbox:
+		case alertChannelEmail:
+			if mailer == nil {
+				return rule, "email is not configured on this server", nil
+			}
+		case alertChannelWebhook:
+			settings, err := repos.Alerts.Settings(ctx, userid)
+			if err != nil {
+				return rule, "", err
+			}
+			if settings.WebhookURL == "" {
+				return rule, "set a webhook URL in alert settings first", nil
+			}
+		default:
+			return rule, fmt.Sprintf("unknown channel %q", channel), nil
+		}
+		if !containsString(rule.Channels, channel) {
+			rule.Channels = append(rule.Channels, channel)
+		}
+	}
+	sort.Strings(rule.Channels[1:])
+	return rule, "", nil
+}
+
+// saveAlertRuleHandler creates a rule on POST and replaces one on PUT.
+func saveAlertRuleHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	userid := c.GetString("userid")
+
+	id := uuid.Nil
+	var previous *repository.AlertRule
+	if c.Param("id") != "" {
+		var err error
+		if id, err = uuid.Parse(c.Param("id")); err != nil {
+			respondError(c, http.StatusBadRequest, "Invalid alert rule id")
+			return
+		}
+		rules, err := repos.Alerts.Rules(ctx, userid)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "Could not load alert rules")
+			return
+		}
+		for i := range rules {
+			if rules[i].ID == id {
+				previous = &rules[i]
+			}
+		}
+		if previous == nil {
+			respondError(c, http.StatusNotFound, "Alert rule not found")
+			return
+		}
+	}
+
+	var request alertRuleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "A kind and a name are required")
+		return
+	}
+	rule, message, err := request.rule(ctx, userid, id)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not check alert rule")
+		return
+	}
+	if message != "" {
+		respondError(c, http.StatusBadRequest, message)
+		return
+	}
+
+	saved, err := repos.Alerts.SaveRule(ctx, userid, rule)
+	if err == repository.ErrNotFound {
+		respondError(c, http.StatusNotFound, "Alert rule not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not save alert rule")
+		return
+	}
+
+	var before interface{}
+	if previous != nil {
+		before = *previous
+	}
+	beforeFields, afterFields := auditDiff(before, saved)
+	recordAudit(c, repository.AuditEntry{
+		Action:       AuditAlertRuleSaved,
+		ResourceType: "alert_rule",
+		ResourceID:   saved.ID.String(),
+		Before:       beforeFields,
+		After:        afterFields,
+	})
+
+	c.JSON(http.StatusOK, saved)
+}
+
+func deleteAlertRuleHandler(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid alert rule id")
+		return
+	}
+	err = repos.Alerts.DeleteRule(c.Request.Context(), c.GetString("userid"), id)
+	if err == repository.ErrNotFound {
+		respondError(c, http.StatusNotFound, "Alert rule not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not delete alert rule")
+		return
+	}
+
+	recordAudit(c, repository.AuditEntry{
+		Action:       AuditAlertRuleDeleted,
+		ResourceType: "alert_rule",
+		ResourceID:   id.String(),
+	})
+	c.Status(http.StatusNoContent)
+}
+
+type alertSettingsRequest struct {
+	QuietStart string `json:"quiet_start"`
+	QuietEnd   string `json:"quiet_end"`
+	Timezone   string `json:"timezone"`
+	WebhookURL string `json:"webhook_url"`
+	// RotateWebhookSecret replaces the secret webhooks are signed with. A
+	// secret is created with the first webhook URL.
+	RotateWebhookSecret bool `json:"rotate_webhook_secret"`
+}
+
+// alertSettingsResponse shows the webhook secret, which receivers need to
+// check signatures.
+type alertSettingsResponse struct {
+	QuietStart     string `json:"quiet_start"`
+	QuietEnd       string `json:"quiet_end"`
+	Timezone       string `json:"timezone"`
+	WebhookURL     string `json:"webhook_url"`
+	WebhookSecret  string `json:"webhook_secret"`
+	EmailAvailable bool   `json:"email_available"`
+}
+
+func alertSettingsFor(ctx context.Context, userid string, settings repository.AlertSettings) (alertSettingsResponse, error) {
+	secret := ""
+	if settings.WebhookSecret != "" {
+		var err error
+		if secret, err = tokenCipher.Decrypt(ctx, settings.WebhookSecret, repository.AlertWebhookSecret, userid); err != nil {
+			return alertSettingsResponse{}, err
+		}
+	}
+	return alertSettingsResponse{
+		QuietStart:     settings.QuietStart,
+		QuietEnd:       settings.QuietEnd,
+		Timezone:       settings.Timezone,
+		WebhookURL:     settings.WebhookURL,
+		WebhookSecret:  secret,
+		EmailAvailable: mailer != nil,
+	}, nil
+}
+
+func getAlertSettingsHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	userid := c.GetString("userid")
+	settings, err := repos.Alerts.Settings(ctx, userid)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load alert settings")
+		return
+	}
+	response, err := alertSettingsFor(ctx, userid, settings)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load alert settings")
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// putAlertSettingsHandler sets the caller's quiet hours, during which
+// email and webhook deliveries wait, and webhook URL.
+func putAlertSettingsHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	userid := c.GetString("userid")
+
+	var request alertSettingsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid alert settings")
+		return
+	}
+	if (request.QuietStart == "") != (request.QuietEnd == "") {
+		respondError(c, http.StatusBadRequest, "quiet_start and quiet_end must be set together")
+		return
+	}
+	for _, clock := range []string{request.QuietStart, request.QuietEnd} {
+		if _, ok := parseClock(clock); clock != "" && !ok {
+			respondError(c, http.StatusBadRequest, "Quiet hours must be times in HH:MM format")
+			return
+		}
+	}
+	if request.Timezone == "" {
+		request.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(request.Timezone); err != nil {
+		respondError(c, http.StatusBadRequest, "timezone must be an IANA time zone such as Europe/Berlin")
+		return
+	}
+	if request.WebhookURL != "" {
+		if message, err := checkWebhookURL(ctx, request.WebhookURL); err != nil {
+			respondError(c, http.StatusBadGateway, "Could not resolve the webhook_url's host")
+			return
+		} else if message != "" {
+			respondError(c, http.StatusBadRequest, "webhook_url "+message)
+			return
+		}
+	}
+
+	settings, err := repos.Alerts.Settings(ctx, userid)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load alert settings")
+		return
+	}
+	settings.QuietStart, settings.QuietEnd, settings.Timezone, settings.WebhookURL = request.QuietStart, request.QuietEnd, request.Timezone, request.WebhookURL
+	if request.WebhookURL != "" && (settings.WebhookSecret == "" || request.RotateWebhookSecret) {
+		secret, err := newWebhookSecret()
+		if err == nil {
+			settings.WebhookSecret, err = tokenCipher.Encrypt(ctx, secret, repository.AlertWebhookSecret, userid)
+		}
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "Could not create webhook secret")
+			return
+		}
+	}
+	if err := repos.Alerts.SaveSettings(ctx, userid, settings); err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not save alert settings")
+		return
+	}
+
+	response, err := alertSettingsFor(ctx, userid, settings)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load alert settings")
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/plaid/quickstart/money"
+	"github.com/plaid/quickstart/repository"
+)
+
+func TestAlertRuleRequestThreshold(t *testing.T) {
+	expense := uuid.New()
+	saved := repos
+	repos.Budgets = &fakeBudgetRepo{budget: repository.Budget{
+		Expenses:    []repository.Expense{{Id: expense, Description: "Rent"}},
+		Allocations: []repository.Allocation{{AllocationType: "needs"}},
+	}}
+	t.Cleanup(func() { repos = saved })
+
+	tests := []struct {
+		name      string
+		kind      string
+		target    string
+		threshold string
+		valid     bool
+	}{
+		{"expense percent", alertExpensePercent, expense.String(), "80", true},
+		{"allocation percent at the cap", alertAllocationPercent, "needs", "1000", true},
+		{"percent above the cap", alertExpensePercent, expense.String(), "1000.01", false},
+		{"huge percent", alertExpensePercent, expense.String(), "900000000000000", false},
+		{"zero percent", alertAllocationPercent, "needs", "0", false},
+		{"unknown target", alertExpensePercent, uuid.NewString(), "80", false},
+		{"large transaction", alertLargeTransaction, "", "5000", true},
+		{"large transaction at MaxAmount", alertLargeTransaction, "", money.MaxAmount.String(), true},
+		{"large transaction past MaxAmount", alertLargeTransaction, "", "100000000000.01", false},
+		{"low balance below -MaxAmount", alertLowBalance, "", "-100000000001", false},
+		{"negative recurring charge", alertRecurringCharge, "", "-1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := alertRuleRequest{Kind: tt.kind, Name: "rule", Target: tt.target, Threshold: money.MustParse(tt.threshold)}
+			_, problem, err := request.rule(context.Background(), auditTestUser, uuid.New())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (problem == "") != tt.valid {
+				t.Errorf("problem = %q, want valid = %v", problem, tt.valid)
+			}
+		})
+	}
+}
+
+func TestInQuietHours(t *testing.T) {
+	at := func(hour int, minute int) time.Time { return time.Date(2024, 5, 1, hour, minute, 0, 0, time.UTC) }
+	overnight := repository.AlertSettings{QuietStart: "22:00", QuietEnd: "07:00", Timezone: "UTC"}
+	daytime := repository.AlertSettings{QuietStart: "09:00", QuietEnd: "17:30", Timezone: "UTC"}
+
+	tests := []struct {
+		name     string
+		settings repository.AlertSettings
+		now      time.Time
+		want     bool
+	}{
+		{"overnight before start", overnight, at(21, 59), false},
+		{"overnight at start", overnight, at(22, 0), true},
+		{"overnight after midnight", overnight, at(3, 0), true},
+		{"overnight at end", overnight, at(7, 0), false},
+		{"daytime within", daytime, at(12, 0), true},
+		{"daytime before", daytime, at(8, 59), false},
+		{"daytime at end", daytime, at(17, 30), false},
+		{"in the user's time zone", repository.AlertSettings{QuietStart: "22:00", QuietEnd: "07:00", Timezone: "America/New_York"}, at(3, 0), true},
+		{"time zone moves it out", repository.AlertSettings{QuietStart: "22:00", QuietEnd: "07:00", Timezone: "America/New_York"}, at(12, 0), false},
+		{"unknown time zone is UTC", repository.AlertSettings{QuietStart: "22:00", QuietEnd: "07:00", Timezone: "Nowhere/City"}, at(23, 0), true},
+		{"not set", repository.AlertSettings{}, at(3, 0), false},
+		{"empty range", repository.AlertSettings{QuietStart: "22:00", QuietEnd: "22:00"}, at(22, 0), false},
+		{"malformed", repository.AlertSettings{QuietStart: "10pm", QuietEnd: "07:00"}, at(23, 0), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := inQuietHours(tt.settings, tt.now); got != tt.want {
+				t.Errorf("inQuietHours at %s = %v, want %v", tt.now.Format("15:04"), got, tt.want)
+			}
+		})
+	}
+}
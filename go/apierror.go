@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/plaid/plaid-go/v31/plaid"
+)
+
+// apiError is the body of every error response, as {"error": {...}}. Code
+// is a stable snake_case identifier for clients to switch on; Message is
+// for people. Details carries extra context such as the Plaid error, and
+// is null otherwise.
+type apiError struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details"`
+	RequestID string      `json:"request_id"`
+}
+
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// errorCodes are the codes of errors that have no more specific one.
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "invalid_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+	http.StatusUnprocessableEntity:   "unprocessable_entity",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusBadGateway:            "upstream_error",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+// errorCode is the default code of an error response with this status.
+func errorCode(status int) string {
+	if code, ok := errorCodes[status]; ok {
+		return code
+	}
+	return strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+}
+
+// respondError writes the error envelope with the status's default code.
+func respondError(c *gin.Context, status int, message string) {
+	respondErrorCode(c, status, errorCode(status), message, nil)
+}
+
+// respondErrorCode writes the error envelope with a specific code and
+// details.
+func respondErrorCode(c *gin.Context, status int, code string, message string, details interface{}) {
+	c.JSON(status, apiErrorResponse{Error: apiError{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: c.GetString(requestIDContextKey),
+	}})
+}
+
+// abortError is respondError for middleware: it also stops the chain.
+func abortError(c *gin.Context, status int, message string) {
+	respondError(c, status, message)
+	c.Abort()
+}
+
+// plaidErrorStatus maps Plaid error types to the status we answer with.
+// Item errors mostly mean the user has to act, e.g. log in again through
+// Link, hence 409 rather than a server error. Invalid request, input and
+// result errors describe the request this server built or the credentials
+// it holds, not anything our client sent, so they are a bad gateway.
+var plaidErrorStatus = map[plaid.PlaidErrorType]int{
+	plaid.PLAIDERRORTYPE_INVALID_REQUEST:     http.StatusBadGateway,
+	plaid.PLAIDERRORTYPE_INVALID_INPUT:       http.StatusBadGateway,
+	plaid.PLAIDERRORTYPE_INVALID_RESULT:      http.StatusBadGateway,
+	plaid.PLAIDERRORTYPE_RATE_LIMIT_EXCEEDED: http.StatusTooManyRequests,
+	plaid.PLAIDERRORTYPE_ITEM_ERROR:          http.StatusConflict,
+	plaid.PLAIDERRORTYPE_ASSET_REPORT_ERROR:  http.StatusConflict,
+	plaid.PLAIDERRORTYPE_API_ERROR:           http.StatusBadGateway,
+	plaid.PLAIDERRORTYPE_INSTITUTION_ERROR:   http.StatusServiceUnavailable,
+	plaid.PLAIDERRORTYPE_PAYMENT_ERROR:       http.StatusUnprocessableEntity,
+	plaid.PLAIDERRORTYPE_TRANSFER_ERROR:      http.StatusUnprocessableEntity,
+	plaid.PLAIDERRORTYPE_BANK_TRANSFER_ERROR: http.StatusUnprocessableEntity,
+}
+
+// renderError answers with err. Plaid errors keep their code, message and
+// the full Plaid error as details; anything else is logged and reported
+// as an internal error without its text, which may describe our internals.
+func renderError(c *gin.Context, err error) {
+	if plaidErr, convErr := plaid.ToPlaidError(err); convErr == nil {
+		status, ok := plaidErrorStatus[plaidErr.ErrorType]
+		if !ok {
+			status = http.StatusBadGateway
+		}
+		message := plaidErr.GetDisplayMessage()
+		if message == "" {
+			message = plaidErr.ErrorMessage
+		}
+		respondErrorCode(c, status, strings.ToLower(plaidErr.ErrorCode), message, plaidErr)
+		return
+	}
+
+	logger("http").ErrorContext(c.Request.Context(), "request failed", "method", c.Request.Method, "path", c.Request.URL.Path, "err", err)
+	respondError(c, http.StatusInternalServerError, "Internal server error")
+}
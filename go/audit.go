@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/plaid/quickstart/repository"
+)
+
+// Audit actions. Keep these stable: they are stored and filtered on.
+const (
+	AuditLoginSucceeded         = "auth.login_succeeded"
+	AuditLoginFailed            = "auth.login_failed"
+	AuditTOTPEnrolled           = "auth.totp_enrolled"
+	AuditTOTPEnabled            = "auth.totp_enabled"
+	AuditPlaidItemLinked        = "plaid.item_linked"
+	AuditPlaidItemUnlinked      = "plaid.item_unlinked"
+	AuditBudgetSaved            = "budget.saved"
+	AuditTransactionsImported   = "transactions.imported"
+	AuditTransactionCategorized = "transaction.categorized"
+	AuditBackupCreated          = "backup.created"
+	AuditBackupRestored         = "backup.restored"
+	AuditGoalSaved              = "goal.saved"
+	AuditGoalDeleted            = "goal.deleted"
+	AuditManualAssetSaved       = "manual_asset.saved"
+	AuditManualAssetDeleted     = "manual_asset.deleted"
+	AuditAlertRuleSaved         = "alert_rule.saved"
+	AuditAlertRuleDeleted       = "alert_rule.deleted"
+	AuditWebhookSaved           = "webhook.saved"
+	AuditWebhookDeleted         = "webhook.deleted"
+	AuditStatementEmailed       = "statement.emailed"
+	AuditCategoryCreated        = "admin.category_created"
+	AuditCategoryUpdated        = "admin.category_updated"
+	AuditCategoryDeleted        = "admin.category_deleted"
+	AuditUserRoleChanged        = "admin.user_role_changed"
+	AuditUserDisabled           = "admin.user_disabled"
+	AuditUserEnabled            = "admin.user_enabled"
+	AuditUserDataRead           = "admin.user_data_read"
+	AuditTokenKeysRotated       = "security.token_keys_rotated"
+	AuditFXRatesLoaded          = "admin.fx_rates_loaded"
+)
+
+const (
+	auditDefaultPageSize = 50
+	auditMaxPageSize     = 500
+
+	requestIDHeader     = "X-Request-ID"
+	requestIDContextKey = "requestID"
+)
+
+// RequestIDMiddleware tags every request with an id, reusing a well-formed
+// X-Request-ID from the caller, and echoes it back in the response.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(requestIDHeader)
+		if requestID == "" || len(requestID) > 64 || strings.ContainsAny(requestID, " \t\r\n") {
+			requestID = uuid.NewString()
+		}
+
+		c.Set(requestIDContextKey, requestID)
+		c.Request = c.Request.WithContext(withRequestID(c.Request.Context(), requestID))
+		c.Header(requestIDHeader, requestID)
+		c.Next()
+	}
+}
+
+// auditDiff reduces two snapshots to the top-level fields that changed.
+// Either side may be nil for creations and deletions.
+func auditDiff(before interface{}, after interface{}) (json.RawMessage, json.RawMessage) {
+	beforeFields := auditFields(before)
+	afterFields := auditFields(after)
+	if beforeFields == nil || afterFields == nil {
+		return auditJSON(beforeFields), auditJSON(afterFields)
+	}
+
+	changedBefore := map[string]interface{}{}
+	changedAfter := map[string]interface{}{}
+	for key, value := range beforeFields {
+		if !reflect.DeepEqual(value, afterFields[key]) {
+			changedBefore[key] = value
+			changedAfter[key] = afterFields[key]
+		}
+	}
+	for key, value := range afterFields {
+		if _, seen := beforeFields[key]; !seen {
+			changedAfter[key] = value
+		}
+	}
+
+	return auditJSON(changedBefore), auditJSON(changedAfter)
+}
+
+func auditFields(v interface{}) map[string]interface{} {
+	if v == nil {
+		return nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil
+	}
+	return fields
+}
+
+func auditJSON(v map[string]interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return raw
+}
+
+// writeAudit appends an entry. Auditing never fails the request that triggered
+// it; write errors are logged instead.
+func writeAudit(ctx context.Context, entry repository.AuditEntry) {
+	if err := repos.Audit.Append(ctx, entry); err != nil {
+		logger("audit").ErrorContext(ctx, "could not record audit entry", "action", entry.Action, "err", err)
+	}
+}
+
+// recordAudit fills in the actor, client IP and request id from the request
+// and writes the entry. SubjectID defaults to the actor.
+func recordAudit(c *gin.Context, entry repository.AuditEntry) {
+	if entry.ActorID == "" {
+		entry.ActorID = c.GetString("userid")
+	}
+	if entry.SubjectID == "" {
+		entry.SubjectID = entry.ActorID
+	}
+	entry.IP = c.ClientIP()
+	entry.RequestID = c.GetString(requestIDContextKey)
+
+	writeAudit(c.Request.Context(), entry)
+}
+
+// auditMetadata marshals small key/value maps for AuditEntry.Metadata.
+func auditMetadata(fields gin.H) json.RawMessage {
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return nil
+	}
+	return raw
+}
+
+type auditEntriesResponse struct {
+	Entries []repository.AuditEntry `json:"entries"`
+}
+
+// auditListParams reads the action, since, limit and offset query parameters.
+func auditListParams(c *gin.Context) (repository.AuditQuery, bool) {
+	query := repository.AuditQuery{Action: c.Query("action"), Limit: auditDefaultPageSize}
+	if v := c.Query("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "since must be an RFC 3339 timestamp")
+			return query, false
+		}
+		query.Since = t
+	}
+
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			respondError(c, http.StatusBadRequest, "limit must be a positive integer")
+			return query, false
+		}
+		query.Limit = min(n, auditMaxPageSize)
+	}
+
+	if v := c.Query("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			respondError(c, http.StatusBadRequest, "offset must be a non-negative integer")
+			return query, false
+		}
+		query.Offset = n
+	}
+
+	return query, true
+}
+
+// getAuditHandler lists the caller's own activity and actions taken on their data.
+func getAuditHandler(c *gin.Context) {
+	query, ok := auditListParams(c)
+	if !ok {
+		return
+	}
+	query.UserID = c.GetString("userid")
+
+	entries, err := repos.Audit.List(c.Request.Context(), query)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load audit log")
+		return
+	}
+
+	c.JSON(http.StatusOK, auditEntriesResponse{Entries: entries})
+}
+
+// adminGetAuditHandler lists everyone's activity, optionally narrowed with ?user_id=.
+func adminGetAuditHandler(c *gin.Context) {
+	query, ok := auditListParams(c)
+	if !ok {
+		return
+	}
+
+	query.UserID = c.Query("user_id")
+	if query.UserID != "" {
+		if _, err := uuid.Parse(query.UserID); err != nil {
+			respondError(c, http.StatusBadRequest, "Invalid user id")
+			return
+		}
+	}
+
+	entries, err := repos.Audit.List(c.Request.Context(), query)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load audit log")
+		return
+	}
+
+	c.JSON(http.StatusOK, auditEntriesResponse{Entries: entries})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/plaid/quickstart/repository"
+)
+
+// fakeAuditRepo keeps appended entries and answers List with the query it
+// was given.
+type fakeAuditRepo struct {
+	appended []repository.AuditEntry
+	query    repository.AuditQuery
+	entries  []repository.AuditEntry
+	err      error
+}
+
+func (r *fakeAuditRepo) Append(ctx context.Context, entry repository.AuditEntry) error {
+	r.appended = append(r.appended, entry)
+	return r.err
+}
+
+func (r *fakeAuditRepo) List(ctx context.Context, query repository.AuditQuery) ([]repository.AuditEntry, error) {
+	r.query = query
+	return r.entries, r.err
+}
+
+// useFakeAudit points repos.Audit at a fake for the rest of the test.
+func useFakeAudit(t *testing.T) *fakeAuditRepo {
+	t.Helper()
+	fake := &fakeAuditRepo{}
+	saved := repos
+	repos.Audit = fake
+	t.Cleanup(func() { repos = saved })
+	return fake
+}
+
+const auditTestUser = "5d3c1f0e-7b7a-4c53-9a8e-2f4b6c1d9e01"
+
+func TestGetAuditHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	since := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		admin     bool
+		target    string
+		repoErr   error
+		wantCode  int
+		wantQuery repository.AuditQuery
+	}{
+		{"own entries", false, "/audit", nil, http.StatusOK,
+			repository.AuditQuery{UserID: auditTestUser, Limit: auditDefaultPageSize}},
+		{"filters", false, "/audit?action=auth.login_failed&since=2024-05-01T12:00:00Z&limit=10&offset=20", nil, http.StatusOK,
+			repository.AuditQuery{UserID: auditTestUser, Action: AuditLoginFailed, Since: since, Limit: 10, Offset: 20}},
+		{"user_id is ignored for users", false, "/audit?user_id=00000000-0000-0000-0000-000000000001", nil, http.StatusOK,
+			repository.AuditQuery{UserID: auditTestUser, Limit: auditDefaultPageSize}},
+		{"limit is capped", false, "/audit?limit=100000", nil, http.StatusOK,
+			repository.AuditQuery{UserID: auditTestUser, Limit: auditMaxPageSize}},
+		{"bad since", false, "/audit?since=yesterday", nil, http.StatusBadRequest, repository.AuditQuery{}},
+		{"bad limit", false, "/audit?limit=0", nil, http.StatusBadRequest, repository.AuditQuery{}},
+		{"bad offset", false, "/audit?offset=-1", nil, http.StatusBadRequest, repository.AuditQuery{}},
+		{"store fails", false, "/audit", errors.New("down"), http.StatusInternalServerError,
+			repository.AuditQuery{UserID: auditTestUser, Limit: auditDefaultPageSize}},
+		{"admin lists everyone", true, "/audit", nil, http.StatusOK,
+			repository.AuditQuery{Limit: auditDefaultPageSize}},
+		{"admin narrows to a user", true, "/audit?user_id=00000000-0000-0000-0000-000000000001", nil, http.StatusOK,
+			repository.AuditQuery{UserID: "00000000-0000-0000-0000-000000000001", Limit: auditDefaultPageSize}},
+		{"admin bad user id", true, "/audit?user_id=bob", nil, http.StatusBadRequest, repository.AuditQuery{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useFakeAudit(t)
+			fake.err = tt.repoErr
+			fake.entries = []repository.AuditEntry{{Action: AuditLoginSucceeded, ActorID: auditTestUser}}
+
+			handler := getAuditHandler
+			if tt.admin {
+				handler = adminGetAuditHandler
+			}
+			r := gin.New()
+			r.GET("/audit", func(c *gin.Context) { c.Set("userid", auditTestUser) }, handler)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if fake.query != tt.wantQuery {
+				t.Errorf("query = %+v, want %+v", fake.query, tt.wantQuery)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var response auditEntriesResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if len(response.Entries) != 1 || response.Entries[0].Action != AuditLoginSucceeded {
+				t.Errorf("entries = %+v", response.Entries)
+			}
+		})
+	}
+}
+
+func TestRecordAuditFillsRequestDetails(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name        string
+		entry       repository.AuditEntry
+		wantActor   string
+		wantSubject string
+	}{
+		{"defaults to the caller", repository.AuditEntry{Action: AuditGoalSaved}, auditTestUser, auditTestUser},
+		{"keeps an admin's subject", repository.AuditEntry{Action: AuditUserDisabled, SubjectID: "other"}, auditTestUser, "other"},
+		{"keeps an explicit actor", repository.AuditEntry{Action: AuditLoginFailed, ActorID: "someone"}, "someone", "someone"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useFakeAudit(t)
+			r := gin.New()
+			r.POST("/", RequestIDMiddleware(), func(c *gin.Context) {
+				c.Set("userid", auditTestUser)
+				recordAudit(c, tt.entry)
+			})
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			req.Header.Set(requestIDHeader, "req-1")
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			if len(fake.appended) != 1 {
+				t.Fatalf("appended %d entries, want 1", len(fake.appended))
+			}
+			got := fake.appended[0]
+			if got.ActorID != tt.wantActor || got.SubjectID != tt.wantSubject || got.IP != "192.0.2.1" || got.RequestID != "req-1" {
+				t.Errorf("entry = %+v", got)
+			}
+		})
+	}
+}
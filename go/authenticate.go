@@ -1,18 +1,40 @@
 package main
 
 import (
-	"database/sql"
-	"log"
+	"context"
+	"errors"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/plaid/quickstart/repository"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var jwtSecret = []byte("your_secret_key")
+// jwtSecret signs session and challenge tokens. It is loaded from
+// JWT_SECRET by loadJWTSecret before the server starts.
+var jwtSecret []byte
+
+// jwtSecretMinLength is the shortest JWT_SECRET accepted, in bytes.
+const jwtSecretMinLength = 32
+
+// loadJWTSecret reads JWT_SECRET. There is no default: anyone who knows the
+// key can mint a token for any user.
+func loadJWTSecret() ([]byte, error) {
+	secret := os.Getenv("JWT_SECRET")
+	if len(secret) < jwtSecretMinLength {
+		return nil, errors.New("JWT_SECRET must be set to at least 32 random bytes, e.g. the output of `openssl rand -base64 32`")
+	}
+	return []byte(secret), nil
+}
+
+// totpChallengePurpose marks the short-lived token handed out by loginHandler
+// when the user still has to supply a TOTP code. It is not a session token.
+const totpChallengePurpose = "totp_challenge"
 
 func hashPassword(password string) (string, error) {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -35,21 +57,19 @@ func comparePasswords(hashedPwd string, plainPwd string) bool {
 	return true
 }
 
-func AuthenthicateUser(username string, password string, db *sql.DB) (bool, string, string, error) {
-	var hashedPassword, plaidAccesToken, userid sql.NullString
-	err := db.QueryRow(`SELECT user_id, password_hash, plaid_access_token FROM "Users" WHERE username = $1`, username).Scan(&userid, &hashedPassword, &plaidAccesToken)
+func AuthenthicateUser(ctx context.Context, username string, password string, users repository.UserRepo) (bool, string, string, error) {
+	user, err := users.ByUsername(ctx, username)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			// User not found
-			return false, "", "", err
-		}
+		// repository.ErrNotFound when the user does not exist
 		return false, "", "", err
 	}
 
-	return comparePasswords(hashedPassword.String, password), userid.String, plaidAccesToken.String, nil
+	return comparePasswords(user.PasswordHash, password), user.ID, user.PlaidAccessToken, nil
 }
 
-func GenerateJWT(userid string, db *sql.DB) (string, error) {
+// GenerateJWT issues a session token. It carries no role: AuthMiddleware
+// reads the current one from the database on every request.
+func GenerateJWT(userid string) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"userid": userid,
 		"exp":    time.Now().Add(time.Minute * 15).Unix(),
@@ -58,52 +78,137 @@ func GenerateJWT(userid string, db *sql.DB) (string, error) {
 	return token.SignedString(jwtSecret)
 }
 
-func saveAccessToken(accessToken string, user string, db *sql.DB) (bool, error) {
-	_, err := db.Exec(`UPDATE "Users" SET plaid_access_token = $1 where username = $2`, accessToken, user)
+// saveAccessToken encrypts a freshly exchanged access token and stores it on
+// the user and as a new PlaidItem.
+func saveAccessToken(ctx context.Context, accessToken string, plaidItemID string, userid string, items repository.PlaidItemRepo) (bool, error) {
+	itemid := uuid.New()
+	userToken, err := tokenCipher.Encrypt(ctx, accessToken, repository.UserAccessToken, userid)
 	if err != nil {
 		return false, err
 	}
-	log.Print("Access token inserted for user: $1", user)
+	itemToken, err := tokenCipher.Encrypt(ctx, accessToken, repository.ItemAccessToken, itemid.String())
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := items.Link(ctx, userid, itemid, plaidItemID, userToken, itemToken); err != nil {
+		return false, err
+	}
+
+	logger("auth").InfoContext(ctx, "access token stored", "plaid_item_id", plaidItemID)
 	return true, nil
+}
 
+// userPlaidAccessToken loads and decrypts the access token of the user's current item.
+func userPlaidAccessToken(ctx context.Context, userid string, users repository.UserRepo) (string, error) {
+	user, err := users.ByID(ctx, userid)
+	if err != nil {
+		return "", err
+	}
+	if user.PlaidAccessToken == "" {
+		return "", nil
+	}
+
+	return tokenCipher.Decrypt(ctx, user.PlaidAccessToken, repository.UserAccessToken, userid)
 }
 
-func ValidateJWT(tokenString string) (bool, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
+// GenerateChallengeJWT issues the token a user exchanges, together with a TOTP
+// code, for a session token at /api/v1/auth/login/totp.
+func GenerateChallengeJWT(userid string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"userid":  userid,
+		"purpose": totpChallengePurpose,
+		"exp":     time.Now().Add(time.Minute * 5).Unix(),
 	})
+
+	return token.SignedString(jwtSecret)
+}
+
+func parseJWT(tokenString string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// ValidateJWT returns the user id carried by a session token and when the
+// token expires. Every error means the token is malformed, forged or expired.
+func ValidateJWT(tokenString string) (string, time.Time, error) {
+	claims, err := parseJWT(tokenString)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	// Challenge tokens only prove the password step, never a full login.
+	purpose, _ := claims["purpose"].(string)
+	userid, _ := claims["userid"].(string)
+	if purpose != "" || userid == "" {
+		return "", time.Time{}, jwt.ErrTokenInvalidClaims
+	}
+	expires, err := claims.GetExpirationTime()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return userid, expires.Time, nil
+}
+
+// ValidateChallengeJWT returns the user id carried by a TOTP challenge token.
+func ValidateChallengeJWT(tokenString string) (string, error) {
+	claims, err := parseJWT(tokenString)
+	if err != nil {
+		return "", err
+	}
+
+	purpose, _ := claims["purpose"].(string)
+	userid, _ := claims["userid"].(string)
+	if purpose != totpChallengePurpose || userid == "" {
+		return "", jwt.ErrTokenInvalidClaims
 	}
 
-	return token.Valid, nil
+	return userid, nil
 }
 
 func AuthMiddleware() gin.HandlerFunc {
 
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
-		accessToken = c.GetHeader("AccessToken")
 
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
-			c.Abort()
+			abortError(c, http.StatusUnauthorized, "Authorization header required")
 			return
 		}
 
 		token := strings.TrimPrefix(authHeader, "Bearer ")
-		valid, err := ValidateJWT(token)
+		userid, expires, err := ValidateJWT(token)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to validate token"})
-			c.Abort()
+			abortError(c, http.StatusUnauthorized, "Invalid or expired token")
 			return
 		}
-		if token == "" || !valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
-			c.Abort()
+
+		// The role and disabled flag are read on every request, so role
+		// changes and disabling an account apply before its tokens expire.
+		role, disabled, err := userAccountStatus(c.Request.Context(), userid, repos.Users)
+		if err == repository.ErrNotFound || (err == nil && disabled) {
+			abortError(c, http.StatusUnauthorized, "Invalid or expired token")
+			return
+		}
+		if err != nil {
+			logger("auth").ErrorContext(c.Request.Context(), "could not load account status", "err", err)
+			abortError(c, http.StatusInternalServerError, "Unable to validate token")
 			return
 		}
 
+		c.Set("userid", userid)
+		c.Request = c.Request.WithContext(withLogUser(c.Request.Context(), userid))
+		c.Set("role", role)
+		c.Set("tokenExpiresAt", expires)
+
 		c.Next()
 
 	}
@@ -129,3 +234,18 @@ func AuthMiddleware() gin.HandlerFunc {
 		next.ServeHTTP(w, r)
 	})*/
 }
+
+// PlaidTokenMiddleware decrypts the user's Plaid access token for the handlers
+// behind it. The token only ever lives in the request context, never in a response.
+func PlaidTokenMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		accessToken, err := userPlaidAccessToken(c.Request.Context(), c.GetString("userid"), repos.Users)
+		if err != nil {
+			abortError(c, http.StatusInternalServerError, "Unable to load Plaid access token")
+			return
+		}
+
+		c.Set("plaidAccessToken", accessToken)
+		c.Next()
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/plaid/quickstart/repository"
+)
+
+// fakeUserRepo answers ByID from users; the other methods are not used by
+// the tests and panic.
+type fakeUserRepo struct {
+	repository.UserRepo
+	users map[string]repository.User
+	err   error
+}
+
+func (r *fakeUserRepo) ByID(ctx context.Context, id string) (repository.User, error) {
+	if r.err != nil {
+		return repository.User{}, r.err
+	}
+	user, ok := r.users[id]
+	if !ok {
+		return repository.User{}, repository.ErrNotFound
+	}
+	return user, nil
+}
+
+func signedTestToken(t *testing.T, secret []byte, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	savedSecret, savedRepos := jwtSecret, repos
+	jwtSecret = []byte("0123456789abcdef0123456789abcdef")
+	t.Cleanup(func() { jwtSecret, repos = savedSecret, savedRepos })
+
+	const active, disabled = "11111111-1111-1111-1111-111111111111", "22222222-2222-2222-2222-222222222222"
+	valid := func(userid string) string {
+		token, err := GenerateJWT(userid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + token
+	}
+	challenge, err := GenerateChallengeJWT(active)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		header   string
+		repoErr  error
+		wantCode int
+	}{
+		{"valid token", valid(active), nil, http.StatusOK},
+		{"no header", "", nil, http.StatusUnauthorized},
+		{"empty bearer", "Bearer ", nil, http.StatusUnauthorized},
+		{"malformed token", "Bearer not.a.token", nil, http.StatusUnauthorized},
+		{"expired token", "Bearer " + signedTestToken(t, jwtSecret, jwt.MapClaims{"userid": active, "exp": time.Now().Add(-time.Minute).Unix()}), nil, http.StatusUnauthorized},
+		{"wrong key", "Bearer " + signedTestToken(t, []byte("another-secret-another-secret-xx"), jwt.MapClaims{"userid": active, "exp": time.Now().Add(time.Minute).Unix()}), nil, http.StatusUnauthorized},
+		{"no expiry", "Bearer " + signedTestToken(t, jwtSecret, jwt.MapClaims{"userid": active}), nil, http.StatusUnauthorized},
+		{"no user id", "Bearer " + signedTestToken(t, jwtSecret, jwt.MapClaims{"exp": time.Now().Add(time.Minute).Unix()}), nil, http.StatusUnauthorized},
+		{"challenge token", "Bearer " + challenge, nil, http.StatusUnauthorized},
+		{"unknown user", valid("33333333-3333-3333-3333-333333333333"), nil, http.StatusUnauthorized},
+		{"disabled user", valid(disabled), nil, http.StatusUnauthorized},
+		{"database down", valid(active), errors.New("connection refused"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repos.Users = &fakeUserRepo{
+				users: map[string]repository.User{
+					active:   {ID: active, Role: RoleUser},
+					disabled: {ID: disabled, Role: RoleUser, Disabled: true},
+				},
+				err: tt.repoErr,
+			}
+			r := gin.New()
+			var gotUser, gotRole string
+			r.GET("/", AuthMiddleware(), func(c *gin.Context) {
+				gotUser, gotRole = c.GetString("userid"), c.GetString("role")
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if w.Code == http.StatusOK && (gotUser != active || gotRole != RoleUser) {
+				t.Errorf("context user = %q role %q", gotUser, gotRole)
+			}
+		})
+	}
+}
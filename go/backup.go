@@ -0,0 +1,1126 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/plaid/quickstart/money"
+	"github.com/plaid/quickstart/repository"
+)
+
+// A backup is a zip archive of JSON Lines files, one per table, described by
+// manifest.json. Bump backupVersion whenever files are added or a file's
+// layout changes incompatibly. backupMaxBytes bounds the upload and the
+// archive's total uncompressed size.
+const (
+	backupFormat           = "smartsplit-backup"
+	backupVersion          = 6
+	backupManifestName     = "manifest.json"
+	backupMaxBytes         = 100 << 20
+	backupManifestMaxBytes = 1 << 20
+)
+
+const (
+	backupProfileFile       = "profile.json"
+	backupItemsFile         = "plaid_items.jsonl"
+	backupTransactionsFile  = "transactions.jsonl"
+	backupAllocationsFile   = "allocations.jsonl"
+	backupExpensesFile      = "expenses.jsonl"
+	backupIncomesFile       = "incomes.jsonl"
+	backupCategoriesFile    = "categories.jsonl"
+	backupAssignmentsFile   = "transaction_expenses.jsonl"
+	backupGoalsFile         = "goals.jsonl"
+	backupNotesFile         = "statement_notes.jsonl"
+	backupAssetsFile        = "manual_assets.jsonl"
+	backupAssetValuesFile   = "manual_asset_values.jsonl"
+	backupCurrencyFile      = "currency_settings.json"
+	backupAlertRulesFile    = "alert_rules.jsonl"
+	backupAlertSettingsFile = "alert_settings.json"
+	backupWebhooksFile      = "webhook_endpoints.jsonl"
+)
+
+// backupFiles lists, in writing order, the files archives hold and the
+// version that added each. Archives of older versions lack the newer files.
+var backupFiles = []struct {
+	name  string
+	since int
+}{
+	{backupProfileFile, 1}, {backupItemsFile, 1}, {backupTransactionsFile, 1}, {backupAllocationsFile, 1},
+	{backupExpensesFile, 1}, {backupIncomesFile, 1}, {backupCategoriesFile, 1}, {backupAssignmentsFile, 1},
+	{backupGoalsFile, 2}, {backupNotesFile, 2},
+	{backupAssetsFile, 3}, {backupAssetValuesFile, 3},
+	{backupCurrencyFile, 4},
+	{backupAlertRulesFile, 5}, {backupAlertSettingsFile, 5},
+	{backupWebhooksFile, 6},
+}
+
+// errInvalidBackup marks archives that are damaged or do not fit this instance.
+var errInvalidBackup = errors.New("invalid backup")
+
+type backupManifest struct {
+	Format        string       `json:"format"`
+	Version       int          `json:"version"`
+	CreatedAt     time.Time    `json:"created_at"`
+	UserID        string       `json:"user_id"`
+	Username      string       `json:"username"`
+	SchemaVersion int64        `json:"schema_version"`
+	Files         []backupFile `json:"files"`
+}
+
+type backupFile struct {
+	Name   string `json:"name"`
+	Rows   int    `json:"rows"`
+	SHA256 string `json:"sha256"`
+}
+
+// backupProfile is the user without credentials, tokens or TOTP secrets. It
+// is informational: a restore never changes the account it restores into.
+type backupProfile struct {
+	Username    string    `json:"username"`
+	Email       string    `json:"email"`
+	Role        string    `json:"role"`
+	TOTPEnabled bool      `json:"totp_enabled"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// backupItem is PlaidItem metadata. Access tokens and sync cursors are not
+// backed up, so restored items must be linked again before they sync.
+type backupItem struct {
+	ID              uuid.UUID  `json:"id"`
+	PlaidItemID     string     `json:"plaid_item_id"`
+	InstitutionName string     `json:"institution_name"`
+	CreatedAt       time.Time  `json:"created_at"`
+	LastSyncedAt    *time.Time `json:"last_synced_at"`
+}
+
+type backupTransaction struct {
+	ID                      uuid.UUID       `json:"id"`
+	ItemID                  uuid.UUID       `json:"item_id"`
+	PlaidTransactionID      string          `json:"plaid_transaction_id"`
+	PlaidAccountID          string          `json:"plaid_account_id"`
+	Name                    string          `json:"name"`
+	MerchantName            string          `json:"merchant_name,omitempty"`
+	Amount                  money.Decimal   `json:"amount"`
+	ISOCurrencyCode         string          `json:"iso_currency_code,omitempty"`
+	Date                    string          `json:"date"`
+	Pending                 bool            `json:"pending"`
+	CategoryPrimary         string          `json:"category_primary,omitempty"`
+	CategoryDetailed        string          `json:"category_detailed,omitempty"`
+	PlaidCategory           json.RawMessage `json:"plaid_category,omitempty"`
+	PersonalFinanceCategory json.RawMessage `json:"personal_finance_category,omitempty"`
+	Raw                     json.RawMessage `json:"raw,omitempty"`
+}
+
+// backupAssetValue is one recorded value of a manual asset.
+type backupAssetValue struct {
+	AssetID uuid.UUID     `json:"asset_id"`
+	Date    string        `json:"date"`
+	Value   money.Decimal `json:"value"`
+}
+
+type backupAssignment struct {
+	TransactionID uuid.UUID `json:"transaction_id"`
+	ExpenseID     uuid.UUID `json:"expense_id"`
+	Confidence    float64   `json:"confidence"`
+	Source        string    `json:"source"`
+}
+
+// backupArchive is a read and verified archive.
+type backupArchive struct {
+	Manifest     backupManifest
+	Profile      backupProfile
+	Items        []backupItem
+	Transactions []backupTransaction
+	Allocations  []Allocation
+	Expenses     []Expense
+	Incomes      []Income
+	Categories   []CatalogCategory
+	Assignments  []backupAssignment
+	// Goals and Notes are nil in archives of versions before 2.
+	Goals []repository.Goal
+	Notes []repository.StatementNote
+	// ManualAssets and AssetValues are nil in archives of versions before 3.
+	ManualAssets []repository.ManualAsset
+	AssetValues  []backupAssetValue
+	// Currency is nil in archives of versions before 4.
+	Currency *currencySettings
+	// AlertRules and AlertSettings are nil in archives of versions before 5.
+	// The settings hold no webhook secret.
+	AlertRules    []repository.AlertRule
+	AlertSettings *repository.AlertSettings
+	// WebhookEndpoints are nil in archives of versions before 6. They hold
+	// no secrets.
+	WebhookEndpoints []repository.WebhookEndpoint
+}
+
+// schemaVersion returns the newest applied migration.
+func schemaVersion(ctx context.Context) (int64, error) {
+	migrator, err := NewMigrator(DB, dbDriver)
+	if err != nil {
+		return 0, err
+	}
+	migrations, appliedAt, err := migrator.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var version int64
+	for _, migration := range migrations {
+		if _, ok := appliedAt[migration.Version]; ok && migration.Version > version {
+			version = migration.Version
+		}
+	}
+	return version, nil
+}
+
+// writeBackup writes an archive of everything the user owns to w.
+func writeBackup(ctx context.Context, w io.Writer, userid string) (backupManifest, error) {
+	user, err := repos.Users.ByID(ctx, userid)
+	if err != nil {
+		return backupManifest{}, err
+	}
+	version, err := schemaVersion(ctx)
+	if err != nil {
+		return backupManifest{}, err
+	}
+	budget, err := repos.Budgets.Load(ctx, userid)
+	if err != nil {
+		return backupManifest{}, err
+	}
+
+	manifest := backupManifest{
+		Format:        backupFormat,
+		Version:       backupVersion,
+		CreatedAt:     time.Now().UTC(),
+		UserID:        user.ID,
+		Username:      user.Username,
+		SchemaVersion: version,
+	}
+	archive := zip.NewWriter(w)
+
+	// addFile writes one file through an encoder that hashes what it writes.
+	addFile := func(name string, write func(enc *json.Encoder) (int, error)) error {
+		f, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		hash := sha256.New()
+		rows, err := write(json.NewEncoder(io.MultiWriter(f, hash)))
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		manifest.Files = append(manifest.Files, backupFile{Name: name, Rows: rows, SHA256: hex.EncodeToString(hash.Sum(nil))})
+		return nil
+	}
+	encodeAll := func(rows ...interface{}) func(enc *json.Encoder) (int, error) {
+		return func(enc *json.Encoder) (int, error) {
+			for _, row := range rows {
+				if err := enc.Encode(row); err != nil {
+					return 0, err
+				}
+			}
+			return len(rows), nil
+		}
+	}
+	rowsOf := func(n int, row func(i int) interface{}) []interface{} {
+		rows := make([]interface{}, n)
+		for i := range rows {
+			rows[i] = row(i)
+		}
+		return rows
+	}
+
+	err = addFile(backupProfileFile, encodeAll(backupProfile{
+		Username:    user.Username,
+		Email:       user.Email,
+		Role:        user.Role,
+		TOTPEnabled: user.TOTPEnabled,
+		CreatedAt:   user.CreatedAt,
+	}))
+	if err != nil {
+		return manifest, err
+	}
+
+	items, err := repos.PlaidItems.ListByUser(ctx, userid)
+	if err != nil {
+		return manifest, err
+	}
+	err = addFile(backupItemsFile, encodeAll(rowsOf(len(items), func(i int) interface{} {
+		return backupItem{ID: items[i].ID, PlaidItemID: items[i].PlaidItemID, InstitutionName: items[i].InstitutionName, CreatedAt: items[i].CreatedAt, LastSyncedAt: items[i].LastSyncedAt}
+	})...))
+	if err != nil {
+		return manifest, err
+	}
+
+	// Transactions are paged so large histories are never held in memory.
+	err = addFile(backupTransactionsFile, func(enc *json.Encoder) (int, error) {
+		rows := 0
+		query := repository.TransactionQuery{Sort: repository.SortByDate, Limit: exportPageSize}
+		for {
+			page, err := repos.Transactions.Search(ctx, userid, query)
+			if err != nil {
+				return rows, err
+			}
+			for _, t := range page.Transactions {
+				err := enc.Encode(backupTransaction{
+					ID: t.ID, ItemID: t.ItemID, PlaidTransactionID: t.PlaidTransactionID, PlaidAccountID: t.PlaidAccountID,
+					Name: t.Name, MerchantName: t.MerchantName, Amount: t.Amount, ISOCurrencyCode: t.ISOCurrencyCode,
+					Date: t.Date.Format(time.DateOnly), Pending: t.Pending, CategoryPrimary: t.CategoryPrimary, CategoryDetailed: t.CategoryDetailed,
+					PlaidCategory: t.PlaidCategory, PersonalFinanceCategory: t.PersonalFinanceCategory, Raw: t.Raw,
+				})
+				if err != nil {
+					return rows, err
+				}
+				rows++
+			}
+			if page.NextCursor == "" {
+				return rows, nil
+			}
+			query.After = page.NextCursor
+		}
+	})
+	if err != nil {
+		return manifest, err
+	}
+
+	err = addFile(backupAllocationsFile, encodeAll(rowsOf(len(budget.Allocations), func(i int) interface{} { return budget.Allocations[i] })...))
+	if err != nil {
+		return manifest, err
+	}
+	err = addFile(backupExpensesFile, encodeAll(rowsOf(len(budget.Expenses), func(i int) interface{} { return budget.Expenses[i] })...))
+	if err != nil {
+		return manifest, err
+	}
+	err = addFile(backupIncomesFile, encodeAll(rowsOf(len(budget.Incomes), func(i int) interface{} { return budget.Incomes[i] })...))
+	if err != nil {
+		return manifest, err
+	}
+
+	// The catalog is shared, so only the categories the budget uses are
+	// included, for matching against the catalog of the restoring instance.
+	catalog, err := repos.Categories.List(ctx)
+	if err != nil {
+		return manifest, err
+	}
+	used := map[string]bool{}
+	for _, expense := range budget.Expenses {
+		used[expense.Category] = true
+	}
+	categories := []interface{}{}
+	for _, category := range catalog {
+		if used[category.ID.String()] {
+			categories = append(categories, category)
+		}
+	}
+	if err := addFile(backupCategoriesFile, encodeAll(categories...)); err != nil {
+		return manifest, err
+	}
+
+	assignments, err := repos.Transactions.Assignments(ctx, userid)
+	if err != nil {
+		return manifest, err
+	}
+	err = addFile(backupAssignmentsFile, encodeAll(rowsOf(len(assignments), func(i int) interface{} {
+		a := assignments[i]
+		return backupAssignment{TransactionID: a.TransactionID, ExpenseID: a.ExpenseID, Confidence: a.Confidence, Source: a.Source}
+	})...))
+	if err != nil {
+		return manifest, err
+	}
+
+	goals, err := repos.Goals.List(ctx, userid)
+	if err != nil {
+		return manifest, err
+	}
+	err = addFile(backupGoalsFile, encodeAll(rowsOf(len(goals), func(i int) interface{} { return goals[i] })...))
+	if err != nil {
+		return manifest, err
+	}
+	notes, err := repos.Statements.Notes(ctx, userid)
+	if err != nil {
+		return manifest, err
+	}
+	err = addFile(backupNotesFile, encodeAll(rowsOf(len(notes), func(i int) interface{} { return notes[i] })...))
+	if err != nil {
+		return manifest, err
+	}
+
+	assets, err := repos.Balances.ListManual(ctx, userid)
+	if err != nil {
+		return manifest, err
+	}
+	err = addFile(backupAssetsFile, encodeAll(rowsOf(len(assets), func(i int) interface{} { return assets[i] })...))
+	if err != nil {
+		return manifest, err
+	}
+	// Every value ever recorded, from the first date to the last.
+	values, err := repos.Balances.ManualHistory(ctx, userid, time.Time{}, time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		return manifest, err
+	}
+	err = addFile(backupAssetValuesFile, encodeAll(rowsOf(len(values), func(i int) interface{} {
+		return backupAssetValue{AssetID: values[i].AssetID, Date: values[i].Date.Format(time.DateOnly), Value: values[i].Value}
+	})...))
+	if err != nil {
+		return manifest, err
+	}
+
+	currency, err := repos.FX.ReportingCurrency(ctx, userid)
+	if err != nil {
+		return manifest, err
+	}
+	if err := addFile(backupCurrencyFile, encodeAll(currencySettings{ReportingCurrency: currency})); err != nil {
+		return manifest, err
+	}
+
+	rules, err := repos.Alerts.Rules(ctx, userid)
+	if err != nil {
+		return manifest, err
+	}
+	err = addFile(backupAlertRulesFile, encodeAll(rowsOf(len(rules), func(i int) interface{} { return rules[i] })...))
+	if err != nil {
+		return manifest, err
+	}
+	// The webhook secret is left out of the encoding.
+	alertSettings, err := repos.Alerts.Settings(ctx, userid)
+	if err != nil {
+		return manifest, err
+	}
+	if err := addFile(backupAlertSettingsFile, encodeAll(alertSettings)); err != nil {
+		return manifest, err
+	}
+
+	endpoints, err := repos.Webhooks.Endpoints(ctx, userid)
+	if err != nil {
+		return manifest, err
+	}
+	err = addFile(backupWebhooksFile, encodeAll(rowsOf(len(endpoints), func(i int) interface{} { return endpoints[i] })...))
+	if err != nil {
+		return manifest, err
+	}
+
+	f, err := archive.Create(backupManifestName)
+	if err != nil {
+		return manifest, err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return manifest, err
+	}
+
+	return manifest, archive.Close()
+}
+
+// readJSONLines decodes one value per line.
+func readJSONLines[T any](data []byte) ([]T, error) {
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	rows := []T{}
+	for {
+		var row T
+		err := decoder.Decode(&row)
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+// readBackupEntry reads f, failing if it holds more than limit bytes
+// whatever its header claims.
+func readBackupEntry(f *zip.File, limit int64) ([]byte, error) {
+	if f.UncompressedSize64 > uint64(limit) {
+		return nil, fmt.Errorf("%w: %s is too large", errInvalidBackup, f.Name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", errInvalidBackup, f.Name, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", errInvalidBackup, f.Name, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: %s is too large", errInvalidBackup, f.Name)
+	}
+	return data, nil
+}
+
+// readBackup opens an archive and checks it against its manifest. Only the
+// manifest and the files it lists are read, each name once, and together
+// they may not uncompress to more than backupMaxBytes.
+func readBackup(r io.ReaderAt, size int64) (backupArchive, error) {
+	var archive backupArchive
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return archive, fmt.Errorf("%w: not a zip archive", errInvalidBackup)
+	}
+
+	entries := map[string]*zip.File{}
+	for _, f := range reader.File {
+		if _, ok := entries[f.Name]; ok {
+			return archive, fmt.Errorf("%w: %s appears twice", errInvalidBackup, f.Name)
+		}
+		entries[f.Name] = f
+	}
+
+	manifestEntry, ok := entries[backupManifestName]
+	if !ok {
+		return archive, fmt.Errorf("%w: %s is missing", errInvalidBackup, backupManifestName)
+	}
+	raw, err := readBackupEntry(manifestEntry, backupManifestMaxBytes)
+	if err != nil {
+		return archive, err
+	}
+	if err := json.Unmarshal(raw, &archive.Manifest); err != nil {
+		return archive, fmt.Errorf("%w: %s: %v", errInvalidBackup, backupManifestName, err)
+	}
+	manifest := archive.Manifest
+	if manifest.Format != backupFormat {
+		return archive, fmt.Errorf("%w: not a %s archive", errInvalidBackup, backupFormat)
+	}
+	if manifest.Version < 1 || manifest.Version > backupVersion {
+		return archive, fmt.Errorf("%w: unsupported version %d", errInvalidBackup, manifest.Version)
+	}
+
+	expected := map[string]bool{}
+	for _, file := range backupFiles {
+		expected[file.name] = file.since <= manifest.Version
+	}
+	listed := map[string]backupFile{}
+	for _, file := range manifest.Files {
+		if !expected[file.Name] {
+			return archive, fmt.Errorf("%w: manifest lists unexpected file %s", errInvalidBackup, file.Name)
+		}
+		if _, ok := listed[file.Name]; ok {
+			return archive, fmt.Errorf("%w: manifest lists %s twice", errInvalidBackup, file.Name)
+		}
+		listed[file.Name] = file
+	}
+	for name := range entries {
+		if _, ok := listed[name]; !ok && name != backupManifestName {
+			return archive, fmt.Errorf("%w: %s is not in the manifest", errInvalidBackup, name)
+		}
+	}
+
+	contents := map[string][]byte{}
+	remaining := int64(backupMaxBytes - len(raw))
+	for _, expectedFile := range backupFiles {
+		name := expectedFile.name
+		if !expected[name] {
+			continue
+		}
+		file, ok := listed[name]
+		if !ok {
+			return archive, fmt.Errorf("%w: manifest does not list %s", errInvalidBackup, name)
+		}
+		entry, ok := entries[name]
+		if !ok {
+			return archive, fmt.Errorf("%w: %s is missing", errInvalidBackup, name)
+		}
+		data, err := readBackupEntry(entry, remaining)
+		if err != nil {
+			return archive, err
+		}
+		remaining -= int64(len(data))
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != file.SHA256 {
+			return archive, fmt.Errorf("%w: checksum mismatch for %s", errInvalidBackup, name)
+		}
+		contents[name] = data
+	}
+
+	// decode reads a listed file and checks its row count. Files newer than
+	// the archive are skipped.
+	decode := func(name string, dst func([]byte) (int, error)) error {
+		if !expected[name] {
+			return nil
+		}
+		rows, err := dst(contents[name])
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", errInvalidBackup, name, err)
+		}
+		if rows != listed[name].Rows {
+			return fmt.Errorf("%w: %s has %d rows, manifest says %d", errInvalidBackup, name, rows, listed[name].Rows)
+		}
+		return nil
+	}
+	err = decode(backupProfileFile, func(data []byte) (int, error) {
+		profiles, err := readJSONLines[backupProfile](data)
+		if err != nil || len(profiles) == 0 {
+			return 0, err
+		}
+		archive.Profile = profiles[0]
+		return len(profiles), nil
+	})
+	if err == nil {
+		err = decode(backupItemsFile, func(data []byte) (int, error) {
+			archive.Items, err = readJSONLines[backupItem](data)
+			return len(archive.Items), err
+		})
+	}
+	if err == nil {
+		err = decode(backupTransactionsFile, func(data []byte) (int, error) {
+			archive.Transactions, err = readJSONLines[backupTransaction](data)
+			return len(archive.Transactions), err
+		})
+	}
+	if err == nil {
+		err = decode(backupAllocationsFile, func(data []byte) (int, error) {
+			archive.Allocations, err = readJSONLines[Allocation](data)
+			return len(archive.Allocations), err
+		})
+	}
+	if err == nil {
+		err = decode(backupExpensesFile, func(data []byte) (int, error) {
+			archive.Expenses, err = readJSONLines[Expense](data)
+			return len(archive.Expenses), err
+		})
+	}
+	if err == nil {
+		err = decode(backupIncomesFile, func(data []byte) (int, error) {
+			archive.Incomes, err = readJSONLines[Income](data)
+			return len(archive.Incomes), err
+		})
+	}
+	if err == nil {
+		err = decode(backupCategoriesFile, func(data []byte) (int, error) {
+			archive.Categories, err = readJSONLines[CatalogCategory](data)
+			return len(archive.Categories), err
+		})
+	}
+	if err == nil {
+		err = decode(backupAssignmentsFile, func(data []byte) (int, error) {
+			archive.Assignments, err = readJSONLines[backupAssignment](data)
+			return len(archive.Assignments), err
+		})
+	}
+	if err == nil {
+		err = decode(backupGoalsFile, func(data []byte) (int, error) {
+			archive.Goals, err = readJSONLines[repository.Goal](data)
+			return len(archive.Goals), err
+		})
+	}
+	if err == nil {
+		err = decode(backupNotesFile, func(data []byte) (int, error) {
+			archive.Notes, err = readJSONLines[repository.StatementNote](data)
+			return len(archive.Notes), err
+		})
+	}
+	if err == nil {
+		err = decode(backupAssetsFile, func(data []byte) (int, error) {
+			archive.ManualAssets, err = readJSONLines[repository.ManualAsset](data)
+			return len(archive.ManualAssets), err
+		})
+	}
+	if err == nil {
+		err = decode(backupAssetValuesFile, func(data []byte) (int, error) {
+			archive.AssetValues, err = readJSONLines[backupAssetValue](data)
+			return len(archive.AssetValues), err
+		})
+	}
+	if err == nil {
+		err = decode(backupCurrencyFile, func(data []byte) (int, error) {
+			settings, err := readJSONLines[currencySettings](data)
+			if err != nil || len(settings) == 0 {
+				return 0, err
+			}
+			archive.Currency = &settings[0]
+			return len(settings), nil
+		})
+	}
+	if err == nil {
+		err = decode(backupAlertRulesFile, func(data []byte) (int, error) {
+			archive.AlertRules, err = readJSONLines[repository.AlertRule](data)
+			return len(archive.AlertRules), err
+		})
+	}
+	if err == nil {
+		err = decode(backupAlertSettingsFile, func(data []byte) (int, error) {
+			settings, err := readJSONLines[repository.AlertSettings](data)
+			if err != nil || len(settings) == 0 {
+				return 0, err
+			}
+			archive.AlertSettings = &settings[0]
+			return len(settings), nil
+		})
+	}
+	if err == nil {
+		err = decode(backupWebhooksFile, func(data []byte) (int, error) {
+			archive.WebhookEndpoints, err = readJSONLines[repository.WebhookEndpoint](data)
+			return len(archive.WebhookEndpoints), err
+		})
+	}
+
+	return archive, err
+}
+
+type backupRestoreResult struct {
+	SourceUserID string `json:"source_user_id"`
+	Items        int    `json:"items"`
+	Transactions int    `json:"transactions"`
+	Allocations  int    `json:"allocations"`
+	Expenses     int    `json:"expenses"`
+	Incomes      int    `json:"incomes"`
+	Assignments  int    `json:"assignments"`
+	Goals        int    `json:"goals"`
+	Notes        int    `json:"notes"`
+	ManualAssets int    `json:"manual_assets"`
+	AlertRules   int    `json:"alert_rules"`
+	Webhooks     int    `json:"webhooks"`
+}
+
+// restoreBackup replaces the user's budget and transactions with the
+// archive's. Every restored row gets a new id, so an archive can be restored
+// into any account on any instance; references between rows are remapped to
+// match. Goals, notes, manual assets, the reporting currency, alert rules
+// and settings and webhook endpoints are left alone when the archive
+// predates them. A restored alert webhook keeps the user's current secret,
+// or gets a new one; restored webhook endpoints always get new secrets, and
+// every webhook URL must still resolve to public addresses.
+// Items the user still has, by Plaid item id, are reused and keep their
+// access tokens. Categories are matched against the local catalog by id, then
+// by descriptor and name.
+func restoreBackup(ctx context.Context, userid string, archive backupArchive) (backupRestoreResult, error) {
+	result := backupRestoreResult{SourceUserID: archive.Manifest.UserID}
+	snapshot := repository.Snapshot{
+		Budget: repository.Budget{Allocations: []Allocation{}, Expenses: []Expense{}, Incomes: []Income{}},
+	}
+
+	catalog, err := repos.Categories.List(ctx)
+	if err != nil {
+		return result, err
+	}
+	categoryIDs := map[string]string{}
+	for _, archived := range archive.Categories {
+		for _, local := range catalog {
+			if local.ID == archived.ID {
+				categoryIDs[archived.ID.String()] = local.ID.String()
+				break
+			}
+		}
+		if _, ok := categoryIDs[archived.ID.String()]; ok {
+			continue
+		}
+		for _, local := range catalog {
+			if local.PlaidDetailed == archived.PlaidDetailed && strings.EqualFold(local.Name, archived.Name) {
+				categoryIDs[archived.ID.String()] = local.ID.String()
+				break
+			}
+		}
+		if _, ok := categoryIDs[archived.ID.String()]; !ok {
+			return result, fmt.Errorf("%w: category %q is not in this instance's catalog", errInvalidBackup, archived.Name)
+		}
+	}
+
+	existing, err := repos.PlaidItems.ListByUser(ctx, userid)
+	if err != nil {
+		return result, err
+	}
+	itemIDs := map[uuid.UUID]uuid.UUID{}
+	for _, item := range archive.Items {
+		for _, local := range existing {
+			if local.PlaidItemID == item.PlaidItemID {
+				itemIDs[item.ID] = local.ID
+				break
+			}
+		}
+		if _, ok := itemIDs[item.ID]; ok {
+			continue
+		}
+		itemIDs[item.ID] = uuid.New()
+		snapshot.Items = append(snapshot.Items, repository.PlaidItem{
+			ID: itemIDs[item.ID], PlaidItemID: item.PlaidItemID, InstitutionName: item.InstitutionName, CreatedAt: item.CreatedAt, LastSyncedAt: item.LastSyncedAt,
+		})
+	}
+
+	allocationIDs := map[string]string{}
+	for _, allocation := range archive.Allocations {
+		allocationIDs[allocation.AllocationType] = uuid.NewString()
+		allocation.AllocationType = allocationIDs[allocation.AllocationType]
+		snapshot.Budget.Allocations = append(snapshot.Budget.Allocations, allocation)
+	}
+
+	expenseIDs := map[uuid.UUID]uuid.UUID{}
+	for _, expense := range archive.Expenses {
+		allocationType, ok := allocationIDs[expense.AllocationType]
+		if !ok {
+			return result, fmt.Errorf("%w: expense %q references a missing allocation", errInvalidBackup, expense.Description)
+		}
+		category, ok := categoryIDs[expense.Category]
+		if !ok {
+			return result, fmt.Errorf("%w: expense %q references a missing category", errInvalidBackup, expense.Description)
+		}
+		expenseIDs[expense.Id] = uuid.New()
+		expense.Id, expense.AllocationType, expense.Category = expenseIDs[expense.Id], allocationType, category
+		snapshot.Budget.Expenses = append(snapshot.Budget.Expenses, expense)
+	}
+
+	for _, income := range archive.Incomes {
+		income.Id = uuid.New()
+		snapshot.Budget.Incomes = append(snapshot.Budget.Incomes, income)
+	}
+
+	transactionIDs := map[uuid.UUID]uuid.UUID{}
+	plaidTransactionIDs := map[string]bool{}
+	for _, t := range archive.Transactions {
+		itemID, ok := itemIDs[t.ItemID]
+		if !ok {
+			return result, fmt.Errorf("%w: transaction %s references a missing item", errInvalidBackup, t.PlaidTransactionID)
+		}
+		if plaidTransactionIDs[t.PlaidTransactionID] {
+			return result, fmt.Errorf("%w: transaction %s appears twice", errInvalidBackup, t.PlaidTransactionID)
+		}
+		plaidTransactionIDs[t.PlaidTransactionID] = true
+		date, err := time.Parse(time.DateOnly, t.Date)
+		if err != nil {
+			return result, fmt.Errorf("%w: transaction %s has an invalid date", errInvalidBackup, t.PlaidTransactionID)
+		}
+
+		transactionIDs[t.ID] = uuid.New()
+		snapshot.Transactions = append(snapshot.Transactions, repository.Transaction{
+			ID: transactionIDs[t.ID], ItemID: itemID, PlaidTransactionID: t.PlaidTransactionID, PlaidAccountID: t.PlaidAccountID,
+			Name: t.Name, MerchantName: t.MerchantName, Amount: t.Amount, ISOCurrencyCode: t.ISOCurrencyCode, Date: date, Pending: t.Pending,
+			CategoryPrimary: t.CategoryPrimary, CategoryDetailed: t.CategoryDetailed,
+			PlaidCategory: t.PlaidCategory, PersonalFinanceCategory: t.PersonalFinanceCategory, Raw: t.Raw,
+		})
+	}
+
+	for _, a := range archive.Assignments {
+		transactionID, ok := transactionIDs[a.TransactionID]
+		expenseID, found := expenseIDs[a.ExpenseID]
+		if !ok || !found {
+			return result, fmt.Errorf("%w: an assignment references a missing transaction or expense", errInvalidBackup)
+		}
+		snapshot.Assignments = append(snapshot.Assignments, repository.TransactionExpense{
+			TransactionID: transactionID, ExpenseID: expenseID, Confidence: a.Confidence, Source: a.Source,
+		})
+	}
+
+	if archive.Goals != nil {
+		snapshot.Goals = []repository.Goal{}
+		for _, goal := range archive.Goals {
+			goal.ID = uuid.New()
+			snapshot.Goals = append(snapshot.Goals, goal)
+		}
+	}
+	if archive.Notes != nil {
+		snapshot.Notes = []repository.StatementNote{}
+		periods := map[string]bool{}
+		for _, note := range archive.Notes {
+			if _, err := time.Parse(statementPeriodLayout, note.Period); err != nil || periods[note.Period] {
+				return result, fmt.Errorf("%w: statement note period %q is invalid or repeated", errInvalidBackup, note.Period)
+			}
+			periods[note.Period] = true
+			snapshot.Notes = append(snapshot.Notes, note)
+		}
+	}
+
+	if archive.ManualAssets != nil {
+		snapshot.ManualAssets = []repository.ManualAsset{}
+		snapshot.ManualAssetValues = []repository.ManualAssetValue{}
+		assetIDs := map[uuid.UUID]uuid.UUID{}
+		for _, asset := range archive.ManualAssets {
+			if asset.Kind != repository.ManualAssetKindAsset && asset.Kind != repository.ManualAssetKindLiability {
+				return result, fmt.Errorf("%w: manual asset %q has an invalid kind", errInvalidBackup, asset.Name)
+			}
+			assetIDs[asset.ID] = uuid.New()
+			asset.ID = assetIDs[asset.ID]
+			snapshot.ManualAssets = append(snapshot.ManualAssets, asset)
+		}
+		dates := map[string]bool{}
+		for _, value := range archive.AssetValues {
+			assetID, ok := assetIDs[value.AssetID]
+			if !ok {
+				return result, fmt.Errorf("%w: a manual asset value references a missing asset", errInvalidBackup)
+			}
+			date, err := time.Parse(time.DateOnly, value.Date)
+			key := value.AssetID.String() + " " + value.Date
+			if err != nil || dates[key] {
+				return result, fmt.Errorf("%w: manual asset value date %q is invalid or repeated", errInvalidBackup, value.Date)
+			}
+			dates[key] = true
+			snapshot.ManualAssetValues = append(snapshot.ManualAssetValues, repository.ManualAssetValue{AssetID: assetID, Date: date, Value: value.Value})
+		}
+	}
+
+	if archive.Currency != nil {
+		currency := archive.Currency.ReportingCurrency
+		if currency != "" && !currencyCodePattern.MatchString(currency) {
+			return result, fmt.Errorf("%w: reporting currency %q is not an ISO 4217 code", errInvalidBackup, currency)
+		}
+		snapshot.ReportingCurrency = &currency
+	}
+
+	if archive.AlertRules != nil {
+		snapshot.AlertRules = []repository.AlertRule{}
+		for _, rule := range archive.AlertRules {
+			switch rule.Kind {
+			case alertExpensePercent:
+				archived, err := uuid.Parse(rule.Target)
+				expenseID, ok := expenseIDs[archived]
+				if err != nil || !ok {
+					return result, fmt.Errorf("%w: alert rule %q references a missing expense", errInvalidBackup, rule.Name)
+				}
+				rule.Target = expenseID.String()
+			case alertAllocationPercent:
+				allocationType, ok := allocationIDs[rule.Target]
+				if !ok {
+					return result, fmt.Errorf("%w: alert rule %q references a missing allocation", errInvalidBackup, rule.Name)
+				}
+				rule.Target = allocationType
+			default:
+				if !containsString(alertKinds, rule.Kind) {
+					return result, fmt.Errorf("%w: alert rule %q has an unknown kind", errInvalidBackup, rule.Name)
+				}
+			}
+			for _, channel := range rule.Channels {
+				if channel != alertChannelInbox && channel != alertChannelEmail && channel != alertChannelWebhook {
+					return result, fmt.Errorf("%w: alert rule %q has an unknown channel", errInvalidBackup, rule.Name)
+				}
+			}
+			rule.ID = uuid.New()
+			snapshot.AlertRules = append(snapshot.AlertRules, rule)
+		}
+	}
+	if archive.AlertSettings != nil {
+		settings := *archive.AlertSettings
+		if (settings.QuietStart == "") != (settings.QuietEnd == "") {
+			return result, fmt.Errorf("%w: alert quiet hours must have a start and an end", errInvalidBackup)
+		}
+		for _, clock := range []string{settings.QuietStart, settings.QuietEnd} {
+			if _, ok := parseClock(clock); clock != "" && !ok {
+				return result, fmt.Errorf("%w: alert quiet hours must be times in HH:MM format", errInvalidBackup)
+			}
+		}
+		if _, err := time.LoadLocation(settings.Timezone); err != nil || settings.Timezone == "" {
+			return result, fmt.Errorf("%w: alert time zone %q is invalid", errInvalidBackup, settings.Timezone)
+		}
+		if settings.WebhookURL != "" {
+			if message, err := checkWebhookURL(ctx, settings.WebhookURL); err != nil {
+				return result, err
+			} else if message != "" {
+				return result, fmt.Errorf("%w: alert webhook_url %s", errInvalidBackup, message)
+			}
+		}
+		current, err := repos.Alerts.Settings(ctx, userid)
+		if err != nil {
+			return result, err
+		}
+		settings.WebhookSecret = current.WebhookSecret
+		if settings.WebhookURL != "" && settings.WebhookSecret == "" {
+			secret, err := newWebhookSecret()
+			if err == nil {
+				settings.WebhookSecret, err = tokenCipher.Encrypt(ctx, secret, repository.AlertWebhookSecret, userid)
+			}
+			if err != nil {
+				return result, err
+			}
+		}
+		snapshot.AlertSettings = &settings
+	}
+
+	if archive.WebhookEndpoints != nil {
+		if len(archive.WebhookEndpoints) > maxWebhookEndpoints {
+			return result, fmt.Errorf("%w: more than %d webhooks", errInvalidBackup, maxWebhookEndpoints)
+		}
+		snapshot.WebhookEndpoints = []repository.WebhookEndpoint{}
+		for _, endpoint := range archive.WebhookEndpoints {
+			if message, err := checkWebhookURL(ctx, endpoint.URL); err != nil {
+				return result, err
+			} else if message != "" {
+				return result, fmt.Errorf("%w: webhook url %s", errInvalidBackup, message)
+			}
+			events, message := endpointEvents(endpoint.Events)
+			if message != "" {
+				return result, fmt.Errorf("%w: webhook %s: %s", errInvalidBackup, endpoint.URL, message)
+			}
+			endpoint.ID, endpoint.Events = uuid.New(), events
+			secret, err := newWebhookSecret()
+			if err == nil {
+				endpoint.Secret, err = tokenCipher.Encrypt(ctx, secret, repository.WebhookEndpointSecret, endpoint.ID.String())
+			}
+			if err != nil {
+				return result, err
+			}
+			snapshot.WebhookEndpoints = append(snapshot.WebhookEndpoints, endpoint)
+		}
+	}
+
+	if err := repos.Backups.Restore(ctx, userid, snapshot); err != nil {
+		return result, err
+	}
+
+	result.Items = len(archive.Items)
+	result.Transactions = len(snapshot.Transactions)
+	result.Allocations = len(snapshot.Budget.Allocations)
+	result.Expenses = len(snapshot.Budget.Expenses)
+	result.Incomes = len(snapshot.Budget.Incomes)
+	result.Assignments = len(snapshot.Assignments)
+	result.Goals = len(snapshot.Goals)
+	result.Notes = len(snapshot.Notes)
+	result.ManualAssets = len(snapshot.ManualAssets)
+	result.AlertRules = len(snapshot.AlertRules)
+	result.Webhooks = len(snapshot.WebhookEndpoints)
+	return result, nil
+}
+
+func backupFilename(username string) string {
+	return fmt.Sprintf("%s-%s-%s.zip", backupFormat, username, time.Now().UTC().Format("20060102-150405"))
+}
+
+// getBackupHandler streams an archive of the caller's data.
+func getBackupHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	userid := c.GetString("userid")
+
+	user, err := repos.Users.ByID(ctx, userid)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load user")
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, backupFilename(user.Username)))
+	c.Status(http.StatusOK)
+	manifest, err := writeBackup(ctx, c.Writer, userid)
+	if err != nil {
+		// The archive is cut short, which the client sees as a corrupt zip.
+		logger("backup").WarnContext(ctx, "backup cut short", "err", err)
+		return
+	}
+
+	recordAudit(c, repository.AuditEntry{
+		Action:       AuditBackupCreated,
+		ResourceType: "user",
+		ResourceID:   userid,
+		Metadata:     auditMetadata(gin.H{"files": manifest.Files}),
+	})
+}
+
+// restoreForm is the multipart upload restoreBackupHandler reads, for the
+// API description.
+type restoreForm struct {
+	Archive apiFile `form:"archive" binding:"required"`
+}
+
+// restoreBackupHandler restores the multipart field "archive" into the
+// caller's account, replacing their budget and transactions.
+func restoreBackupHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, backupMaxBytes)
+
+	file, header, err := c.Request.FormFile("archive")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "A backup archive is required")
+		return
+	}
+	defer file.Close()
+
+	archive, err := readBackup(file, header.Size)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	result, err := restoreBackup(c.Request.Context(), c.GetString("userid"), archive)
+	if errors.Is(err, errInvalidBackup) {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not restore backup")
+		return
+	}
+
+	recordAudit(c, repository.AuditEntry{
+		Action:       AuditBackupRestored,
+		ResourceType: "user",
+		ResourceID:   c.GetString("userid"),
+		Metadata:     auditMetadata(gin.H{"result": result, "created_at": archive.Manifest.CreatedAt}),
+	})
+
+	c.JSON(http.StatusOK, result)
+}
+
+// runBackupCommand implements `backup -user NAME FILE` and `restore -user NAME FILE`.
+func runBackupCommand(ctx context.Context, command string, args []string) error {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	username := flags.String("user", "", "username to "+command)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || *username == "" {
+		return fmt.Errorf("usage: %s -user NAME FILE", command)
+	}
+	path := flags.Arg(0)
+
+	user, err := repos.Users.ByUsername(ctx, *username)
+	if err != nil {
+		return fmt.Errorf("user %s: %w", *username, err)
+	}
+
+	if command == "backup" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		manifest, err := writeBackup(ctx, f, user.ID)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		writeAudit(ctx, repository.AuditEntry{
+			SubjectID:    user.ID,
+			Action:       AuditBackupCreated,
+			ResourceType: "user",
+			ResourceID:   user.ID,
+			Metadata:     auditMetadata(gin.H{"files": manifest.Files}),
+		})
+		logger("backup").InfoContext(ctx, "wrote backup", "username", user.Username, "path", path)
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	archive, err := readBackup(f, info.Size())
+	if err != nil {
+		return err
+	}
+	result, err := restoreBackup(ctx, user.ID, archive)
+	if err != nil {
+		return err
+	}
+	writeAudit(ctx, repository.AuditEntry{
+		SubjectID:    user.ID,
+		Action:       AuditBackupRestored,
+		ResourceType: "user",
+		ResourceID:   user.ID,
+		Metadata:     auditMetadata(gin.H{"result": result, "created_at": archive.Manifest.CreatedAt}),
+	})
+	logger("backup").InfoContext(ctx, "restored backup", "username", user.Username, "path", path, "transactions", result.Transactions, "expenses", result.Expenses)
+	return nil
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/plaid/quickstart/money"
+	"github.com/plaid/quickstart/repository"
+)
+
+// testBackup describes an archive for buildTestBackup: the files of its
+// version, the current one unless given, which are empty unless given, and
+// changes to make afterwards.
+type testBackup struct {
+	version  int
+	files    map[string]string
+	manifest func(*backupManifest)
+	extra    map[string]string
+}
+
+func buildTestBackup(t *testing.T, b testBackup) []byte {
+	t.Helper()
+	manifest := backupManifest{Format: backupFormat, Version: backupVersion, CreatedAt: time.Now().UTC(), UserID: seedUserID}
+	if b.version != 0 {
+		manifest.Version = b.version
+	}
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	write := func(name string, content string) {
+		f, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range backupFiles {
+		if file.since > manifest.Version {
+			continue
+		}
+		content := b.files[file.name]
+		if file.name == backupProfileFile && content == "" {
+			content = `{"username":"test"}` + "\n"
+		}
+		write(file.name, content)
+		sum := sha256.Sum256([]byte(content))
+		manifest.Files = append(manifest.Files, backupFile{Name: file.name, Rows: strings.Count(content, "\n"), SHA256: hex.EncodeToString(sum[:])})
+	}
+	for name, content := range b.extra {
+		write(name, content)
+	}
+	if b.manifest != nil {
+		b.manifest(&manifest)
+	}
+	raw, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(backupManifestName, string(raw))
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadBackup(t *testing.T) {
+	incomes := `{"description":"Salary","amount":"3000","frequency":"monthly"}` + "\n"
+	tests := []struct {
+		name    string
+		backup  testBackup
+		wantErr string
+	}{
+		{"valid", testBackup{files: map[string]string{backupIncomesFile: incomes}}, ""},
+		{"version 1", testBackup{version: 1, files: map[string]string{backupIncomesFile: incomes}}, ""},
+		{"file newer than version", testBackup{version: 1, extra: map[string]string{backupGoalsFile: ""}}, "not in the manifest"},
+		{"checksum mismatch", testBackup{
+			files: map[string]string{backupIncomesFile: incomes},
+			manifest: func(m *backupManifest) {
+				for i := range m.Files {
+					if m.Files[i].Name == backupIncomesFile {
+						m.Files[i].SHA256 = strings.Repeat("0", 64)
+					}
+				}
+			},
+		}, "checksum mismatch"},
+		{"row count mismatch", testBackup{
+			files: map[string]string{backupIncomesFile: incomes},
+			manifest: func(m *backupManifest) {
+				for i := range m.Files {
+					if m.Files[i].Name == backupIncomesFile {
+						m.Files[i].Rows = 2
+					}
+				}
+			},
+		}, "manifest says 2"},
+		{"newer version", testBackup{manifest: func(m *backupManifest) { m.Version = backupVersion + 1 }}, "unsupported version"},
+		{"version zero", testBackup{manifest: func(m *backupManifest) { m.Version = 0 }}, "unsupported version"},
+		{"other format", testBackup{manifest: func(m *backupManifest) { m.Format = "zip" }}, "not a smartsplit-backup archive"},
+		{"unlisted entry", testBackup{extra: map[string]string{"padding.bin": "x"}}, "not in the manifest"},
+		{"duplicate entry", testBackup{extra: map[string]string{backupIncomesFile: incomes}}, "appears twice"},
+		{"unexpected listed file", testBackup{manifest: func(m *backupManifest) {
+			m.Files = append(m.Files, backupFile{Name: "extra.jsonl"})
+		}}, "unexpected file"},
+		{"missing listing", testBackup{manifest: func(m *backupManifest) { m.Files = m.Files[1:] }}, "not in the manifest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := buildTestBackup(t, tt.backup)
+			archive, err := readBackup(bytes.NewReader(data), int64(len(data)))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(archive.Incomes) != 1 || archive.Incomes[0].Amount != money.MustParse("3000") {
+					t.Errorf("incomes = %+v", archive.Incomes)
+				}
+				return
+			}
+			if !errors.Is(err, errInvalidBackup) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadBackupCapsUncompressedSize(t *testing.T) {
+	// A highly compressible file that uncompresses past the cap.
+	huge := strings.Repeat("\n", backupMaxBytes)
+	data := buildTestBackup(t, testBackup{files: map[string]string{backupTransactionsFile: huge}})
+	if len(data) > backupMaxBytes/100 {
+		t.Fatalf("test archive is %d bytes, want it small", len(data))
+	}
+	_, err := readBackup(bytes.NewReader(data), int64(len(data)))
+	if !errors.Is(err, errInvalidBackup) || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("err = %v, want a too large error", err)
+	}
+}
+
+// useSeededRepos points repos at a migrated and seeded database.
+func useSeededRepos(t *testing.T) {
+	t.Helper()
+	ctx := context.Background()
+	db := openTestSQLite(t)
+	if err := runMigrateCommand(ctx, db, driverSQLite, []string{"up"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Seed(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	saved := repos
+	repos = repository.New(db)
+	t.Cleanup(func() { repos = saved })
+}
+
+func TestRestoreBackupRemapsIDs(t *testing.T) {
+	ctx := context.Background()
+	useSeededRepos(t)
+
+	catalog, err := repos.Categories.List(ctx)
+	if err != nil || len(catalog) == 0 {
+		t.Fatalf("catalog: %v, %v", catalog, err)
+	}
+	item, allocation, expense, transaction := uuid.New(), uuid.NewString(), uuid.New(), uuid.New()
+	archive := backupArchive{
+		Manifest:    backupManifest{UserID: "someone-else"},
+		Items:       []backupItem{{ID: item, PlaidItemID: "item-from-backup"}},
+		Allocations: []Allocation{{AllocationType: allocation, AllocationDescription: "Needs", AllocationFactor: money.MustParse("0.5")}},
+		Expenses:    []Expense{{Id: expense, Description: "Rent", Amount: money.MustParse("1200"), Category: catalog[0].ID.String(), AllocationType: allocation}},
+		Categories:  []CatalogCategory{catalog[0]},
+		Transactions: []backupTransaction{{
+			ID: transaction, ItemID: item, PlaidTransactionID: "txn-from-backup", Name: "Landlord", Amount: money.MustParse("1200"), Date: "2024-05-01",
+		}},
+		Assignments: []backupAssignment{{TransactionID: transaction, ExpenseID: expense, Confidence: 1, Source: "manual"}},
+	}
+	if _, err := restoreBackup(ctx, seedUserID, archive); err != nil {
+		t.Fatal(err)
+	}
+
+	budget, err := repos.Budgets.Load(ctx, seedUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(budget.Expenses) != 1 || len(budget.Allocations) != 1 {
+		t.Fatalf("budget = %+v", budget)
+	}
+	restored := budget.Expenses[0]
+	if restored.Id == expense || budget.Allocations[0].AllocationType == allocation {
+		t.Error("restored rows kept the archive's ids")
+	}
+	if restored.AllocationType != budget.Allocations[0].AllocationType {
+		t.Errorf("expense allocation %s, want the restored allocation %s", restored.AllocationType, budget.Allocations[0].AllocationType)
+	}
+	assignments, err := repos.Transactions.Assignments(ctx, seedUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, a := range assignments {
+		if a.ExpenseID == restored.Id {
+			found = a.TransactionID != transaction
+		}
+	}
+	if !found {
+		t.Errorf("assignments %+v do not point a new transaction id at the restored expense", assignments)
+	}
+}
+
+func TestRestoreBackupGoalsAndNotes(t *testing.T) {
+	ctx := context.Background()
+	useSeededRepos(t)
+
+	if _, err := repos.Goals.Save(ctx, seedUserID, repository.Goal{Name: "Old goal", TargetAmount: money.MustParse("100")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Statements.SetNote(ctx, seedUserID, "2024-01", "old note"); err != nil {
+		t.Fatal(err)
+	}
+
+	// An archive from before goals were backed up leaves them alone.
+	if _, err := restoreBackup(ctx, seedUserID, backupArchive{Manifest: backupManifest{Version: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	goals, err := repos.Goals.List(ctx, seedUserID)
+	if err != nil || len(goals) != 1 || goals[0].Name != "Old goal" {
+		t.Fatalf("goals after a version 1 restore = %+v, %v", goals, err)
+	}
+
+	archived := repository.Goal{ID: uuid.New(), Name: "Car", TargetAmount: money.MustParse("5000"), SavedAmount: money.MustParse("250"), CreatedAt: time.Now()}
+	archive := backupArchive{
+		Manifest: backupManifest{Version: 2},
+		Goals:    []repository.Goal{archived},
+		Notes:    []repository.StatementNote{{Period: "2024-02", Body: "new note"}},
+	}
+	if _, err := restoreBackup(ctx, seedUserID, archive); err != nil {
+		t.Fatal(err)
+	}
+	goals, err = repos.Goals.List(ctx, seedUserID)
+	if err != nil || len(goals) != 1 || goals[0].Name != "Car" || goals[0].ID == archived.ID || goals[0].SavedAmount != archived.SavedAmount {
+		t.Errorf("goals = %+v, %v, want the archive's goal under a new id", goals, err)
+	}
+	notes, err := repos.Statements.Notes(ctx, seedUserID)
+	if err != nil || len(notes) != 1 || notes[0] != archive.Notes[0] {
+		t.Errorf("notes = %+v, %v, want %+v", notes, err, archive.Notes)
+	}
+
+	archive.Notes = append(archive.Notes, repository.StatementNote{Period: "February", Body: "bad"})
+	if _, err := restoreBackup(ctx, seedUserID, archive); !errors.Is(err, errInvalidBackup) {
+		t.Errorf("restoring a note with an invalid period: err = %v", err)
+	}
+}
+
+func TestRestoreBackupManualAssets(t *testing.T) {
+	ctx := context.Background()
+	useSeededRepos(t)
+
+	house := uuid.New()
+	archive := backupArchive{
+		Manifest:     backupManifest{Version: 3},
+		ManualAssets: []repository.ManualAsset{{ID: house, Name: "House", Kind: repository.ManualAssetKindAsset, Type: "property", ISOCurrencyCode: "USD"}},
+		AssetValues: []backupAssetValue{
+			{AssetID: house, Date: "2023-01-01", Value: money.MustParse("300000")},
+			{AssetID: house, Date: "2024-01-01", Value: money.MustParse("320000")},
+		},
+	}
+	if _, err := restoreBackup(ctx, seedUserID, archive); err != nil {
+		t.Fatal(err)
+	}
+	assets, err := repos.Balances.ListManual(ctx, seedUserID)
+	if err != nil || len(assets) != 1 || assets[0].ID == house || assets[0].Value != money.MustParse("320000") {
+		t.Fatalf("assets = %+v, %v, want the house under a new id at its latest value", assets, err)
+	}
+	values, err := repos.Balances.ManualHistory(ctx, seedUserID, time.Time{}, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil || len(values) != 2 || values[0].AssetID != assets[0].ID {
+		t.Errorf("values = %+v, %v", values, err)
+	}
+
+	for name, values := range map[string][]backupAssetValue{
+		"missing asset":  {{AssetID: uuid.New(), Date: "2024-01-01"}},
+		"repeated date":  {{AssetID: house, Date: "2024-01-01"}, {AssetID: house, Date: "2024-01-01"}},
+		"malformed date": {{AssetID: house, Date: "01/01/2024"}},
+	} {
+		archive.AssetValues = values
+		if _, err := restoreBackup(ctx, seedUserID, archive); !errors.Is(err, errInvalidBackup) {
+			t.Errorf("%s: err = %v, want errInvalidBackup", name, err)
+		}
+	}
+}
+
+func TestRestoreBackupReportingCurrency(t *testing.T) {
+	ctx := context.Background()
+	useSeededRepos(t)
+	if err := repos.FX.SetReportingCurrency(ctx, seedUserID, "EUR"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		archive backupArchive
+		want    string
+		wantErr bool
+	}{
+		{"version 3 keeps it", backupArchive{Manifest: backupManifest{Version: 3}}, "EUR", false},
+		{"restores it", backupArchive{Manifest: backupManifest{Version: 4}, Currency: &currencySettings{ReportingCurrency: "GBP"}}, "GBP", false},
+		{"invalid code", backupArchive{Manifest: backupManifest{Version: 4}, Currency: &currencySettings{ReportingCurrency: "pounds"}}, "GBP", true},
+		{"restores the default", backupArchive{Manifest: backupManifest{Version: 4}, Currency: &currencySettings{}}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := restoreBackup(ctx, seedUserID, tt.archive)
+			if tt.wantErr != errors.Is(err, errInvalidBackup) || (!tt.wantErr && err != nil) {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			currency, err := repos.FX.ReportingCurrency(ctx, seedUserID)
+			if err != nil || currency != tt.want {
+				t.Errorf("reporting currency = %q, %v, want %q", currency, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoreBackupAlertRules(t *testing.T) {
+	ctx := context.Background()
+	useSeededRepos(t)
+	savedCipher := tokenCipher
+	tokenCipher = &TokenCipher{keys: testKeyProvider("k1", "k1")}
+	t.Cleanup(func() { tokenCipher = savedCipher })
+
+	category, err := repos.Categories.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allocation, expense := uuid.NewString(), uuid.New()
+	archive := backupArchive{
+		Manifest:    backupManifest{Version: 5},
+		Allocations: []Allocation{{AllocationType: allocation, AllocationDescription: "Needs", AllocationFactor: money.MustParse("0.5")}},
+		Expenses:    []Expense{{Id: expense, Description: "Rent", Amount: money.MustParse("1200"), Category: category[0].ID.String(), AllocationType: allocation}},
+		Categories:  []CatalogCategory{category[0]},
+		AlertRules: []repository.AlertRule{
+			{ID: uuid.New(), Kind: alertExpensePercent, Name: "Rent", Target: expense.String(), Threshold: money.MustParse("90"), Channels: []string{alertChannelInbox}, Enabled: true},
+			{ID: uuid.New(), Kind: alertAllocationPercent, Name: "Needs", Target: allocation, Threshold: money.MustParse("100"), Channels: []string{alertChannelInbox, alertChannelWebhook}, Enabled: true},
+			{ID: uuid.New(), Kind: alertLargeTransaction, Name: "Large", Threshold: money.MustParse("500"), Channels: []string{alertChannelInbox}},
+		},
+		AlertSettings: &repository.AlertSettings{QuietStart: "22:00", QuietEnd: "07:00", Timezone: "Europe/Berlin", WebhookURL: "https://93.184.216.34/alerts"},
+	}
+	if _, err := restoreBackup(ctx, seedUserID, archive); err != nil {
+		t.Fatal(err)
+	}
+
+	budget, err := repos.Budgets.Load(ctx, seedUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := repos.Alerts.Rules(ctx, seedUserID)
+	if err != nil || len(rules) != 3 {
+		t.Fatalf("rules = %+v, %v", rules, err)
+	}
+	targets := map[string]string{}
+	for _, rule := range rules {
+		targets[rule.Kind] = rule.Target
+	}
+	if targets[alertExpensePercent] != budget.Expenses[0].Id.String() || targets[alertAllocationPercent] != budget.Allocations[0].AllocationType {
+		t.Errorf("rule targets = %v, want the restored expense %s and allocation %s", targets, budget.Expenses[0].Id, budget.Allocations[0].AllocationType)
+	}
+	settings, err := repos.Alerts.Settings(ctx, seedUserID)
+	if err != nil || settings.Timezone != "Europe/Berlin" || settings.WebhookURL != archive.AlertSettings.WebhookURL || settings.WebhookSecret == "" {
+		t.Errorf("settings = %+v, %v, want the archive's with a new webhook secret", settings, err)
+	}
+
+	invalid := map[string]func(*backupArchive){
+		"unknown expense": func(a *backupArchive) {
+			a.AlertRules = []repository.AlertRule{{Kind: alertExpensePercent, Name: "x", Target: uuid.NewString()}}
+		},
+		"unknown allocation": func(a *backupArchive) {
+			a.AlertRules = []repository.AlertRule{{Kind: alertAllocationPercent, Name: "x", Target: "other"}}
+		},
+		"unknown kind":  func(a *backupArchive) { a.AlertRules = []repository.AlertRule{{Kind: "weather", Name: "x"}} },
+		"bad time zone": func(a *backupArchive) { a.AlertSettings = &repository.AlertSettings{Timezone: "Mars/Olympus"} },
+		"bad webhook url": func(a *backupArchive) {
+			a.AlertSettings = &repository.AlertSettings{Timezone: "UTC", WebhookURL: "ftp://example.com"}
+		},
+		"private webhook": func(a *backupArchive) {
+			a.AlertSettings = &repository.AlertSettings{Timezone: "UTC", WebhookURL: "http://10.0.0.5/hook"}
+		},
+		"half quiet hours": func(a *backupArchive) {
+			a.AlertSettings = &repository.AlertSettings{Timezone: "UTC", QuietStart: "22:00"}
+		},
+		"malformed quiet": func(a *backupArchive) {
+			a.AlertSettings = &repository.AlertSettings{Timezone: "UTC", QuietStart: "10pm", QuietEnd: "7am"}
+		},
+	}
+	for name, change := range invalid {
+		broken := archive
+		change(&broken)
+		if _, err := restoreBackup(ctx, seedUserID, broken); !errors.Is(err, errInvalidBackup) {
+			t.Errorf("%s: err = %v, want errInvalidBackup", name, err)
+		}
+	}
+}
+
+func TestRestoreBackupWebhookEndpoints(t *testing.T) {
+	ctx := context.Background()
+	useSeededRepos(t)
+	savedCipher := tokenCipher
+	tokenCipher = &TokenCipher{keys: testKeyProvider("k1", "k1")}
+	t.Cleanup(func() { tokenCipher = savedCipher })
+
+	endpoint := repository.WebhookEndpoint{ID: uuid.New(), URL: "https://93.184.216.34/hook", Description: "Ledger", Events: []string{eventBudgetSaved}, Enabled: true}
+	archive := backupArchive{Manifest: backupManifest{Version: 6}, WebhookEndpoints: []repository.WebhookEndpoint{endpoint}}
+	if _, err := restoreBackup(ctx, seedUserID, archive); err != nil {
+		t.Fatal(err)
+	}
+	endpoints, err := repos.Webhooks.Endpoints(ctx, seedUserID)
+	if err != nil || len(endpoints) != 1 {
+		t.Fatalf("endpoints = %+v, %v", endpoints, err)
+	}
+	restored := endpoints[0]
+	if restored.ID == endpoint.ID || restored.URL != endpoint.URL || restored.Description != endpoint.Description {
+		t.Errorf("endpoint = %+v, want the archive's under a new id", restored)
+	}
+	if _, err := tokenCipher.Decrypt(ctx, restored.Secret, repository.WebhookEndpointSecret, restored.ID.String()); err != nil {
+		t.Errorf("restored secret does not decrypt for the new id: %v", err)
+	}
+
+	for name, broken := range map[string]repository.WebhookEndpoint{
+		"private address": {URL: "http://127.0.0.1:8000/hook", Events: []string{eventBudgetSaved}},
+		"unknown event":   {URL: endpoint.URL, Events: []string{"weather.changed"}},
+	} {
+		archive.WebhookEndpoints = []repository.WebhookEndpoint{broken}
+		if _, err := restoreBackup(ctx, seedUserID, archive); !errors.Is(err, errInvalidBackup) {
+			t.Errorf("%s: err = %v, want errInvalidBackup", name, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/plaid/quickstart/repository"
+)
+
+// Confidence of an assignment made from the transaction's category.
+const (
+	categoryDetailedConfidence = 1.0
+	categoryPrimaryConfidence  = 0.5
+)
+
+// categorizeTransactions assigns outflows the user has not categorized yet to
+// the budget expense whose catalog category matches the transaction's
+// category. Detailed descriptors and catalog names are tried before the
+// broader primary descriptor. It returns how many transactions were assigned.
+// Source, sync or import, is where the transactions came from.
+func categorizeTransactions(ctx context.Context, userid string, source string, transactions []repository.Transaction) (int, error) {
+	eligible := 0
+	for _, t := range transactions {
+		// Plaid amounts are positive for money leaving the account.
+		if t.ExpenseID == nil && t.Amount.Sign() > 0 {
+			eligible++
+		}
+	}
+	if eligible == 0 {
+		return 0, nil
+	}
+
+	budget, err := repos.Budgets.Load(ctx, userid)
+	if err != nil {
+		return 0, err
+	}
+	if len(budget.Expenses) == 0 {
+		countCategorizations(source, eligible, 0)
+		return 0, nil
+	}
+	categories, err := repos.Categories.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// The first expense of each category wins.
+	expenseByCategory := map[string]uuid.UUID{}
+	for _, expense := range budget.Expenses {
+		if _, ok := expenseByCategory[expense.Category]; !ok {
+			expenseByCategory[expense.Category] = expense.Id
+		}
+	}
+	byDetailed := map[string]uuid.UUID{}
+	byPrimary := map[string]uuid.UUID{}
+	for _, category := range categories {
+		expenseID, ok := expenseByCategory[category.ID.String()]
+		if !ok {
+			continue
+		}
+		for _, key := range []string{category.PlaidDetailed, category.Name} {
+			if _, seen := byDetailed[strings.ToLower(key)]; !seen {
+				byDetailed[strings.ToLower(key)] = expenseID
+			}
+		}
+		if _, seen := byPrimary[strings.ToLower(category.PlaidPrimary)]; !seen {
+			byPrimary[strings.ToLower(category.PlaidPrimary)] = expenseID
+		}
+	}
+
+	assignments := []repository.TransactionExpense{}
+	for _, t := range transactions {
+		if t.ExpenseID != nil || t.Amount.Sign() <= 0 {
+			continue
+		}
+		assignment := repository.TransactionExpense{TransactionID: t.ID, Source: repository.CategorizedByPlaidCategory}
+		if expenseID, ok := byDetailed[strings.ToLower(t.CategoryDetailed)]; ok && t.CategoryDetailed != "" {
+			assignment.ExpenseID = expenseID
+			assignment.Confidence = categoryDetailedConfidence
+		} else if expenseID, ok := byPrimary[strings.ToLower(t.CategoryPrimary)]; ok && t.CategoryPrimary != "" {
+			assignment.ExpenseID = expenseID
+			assignment.Confidence = categoryPrimaryConfidence
+		} else {
+			continue
+		}
+		assignments = append(assignments, assignment)
+	}
+
+	assigned, err := repos.Transactions.Assign(ctx, assignments)
+	if err != nil {
+		return 0, err
+	}
+	countCategorizations(source, eligible, len(assigned))
+	if len(assigned) > 0 {
+		data := transactionCategorizedData{Assignments: make([]categorizedTransaction, 0, len(assigned))}
+		for _, a := range assigned {
+			data.Assignments = append(data.Assignments, categorizedTransaction{TransactionID: a.TransactionID, ExpenseID: a.ExpenseID, Confidence: a.Confidence, Source: a.Source})
+			// Only uncategorized transactions are assigned, so there is no
+			// expense before.
+			expenseID := a.ExpenseID
+			before, after := auditDiff(transactionExpenseRequest{}, transactionExpenseRequest{ExpenseID: &expenseID})
+			writeAudit(ctx, repository.AuditEntry{
+				SubjectID:    userid,
+				Action:       AuditTransactionCategorized,
+				ResourceType: "transaction",
+				ResourceID:   a.TransactionID.String(),
+				RequestID:    requestIDFrom(ctx),
+				Before:       before,
+				After:        after,
+				Metadata:     auditMetadata(gin.H{"source": a.Source, "confidence": a.Confidence, "from": source}),
+			})
+		}
+		publishEvent(ctx, userid, newWebhookEvent(eventTransactionCategorized, data))
+	}
+	return len(assigned), nil
+}
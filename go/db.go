@@ -1,29 +1,214 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 )
 
+// Supported DB_DRIVER values. Both are also the database/sql driver names.
+const (
+	driverPostgres = "postgres"
+	driverSQLite   = "sqlite"
+)
+
+// dbDriver is the driver InitDB connected with.
+var dbDriver = driverSQLite
+
+// DBConfig is read from the environment by loadDBConfig.
+type DBConfig struct {
+	Driver          string
+	DSN             string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	ConnectTimeout  time.Duration
+}
+
+var dbSSLModes = map[string]bool{
+	"disable":     true,
+	"allow":       true,
+	"prefer":      true,
+	"require":     true,
+	"verify-ca":   true,
+	"verify-full": true,
+}
+
+// loadDBConfig reads DB_DRIVER, which is sqlite unless set to postgres, the
+// connection settings for that driver and the pool settings.
+func loadDBConfig() (DBConfig, error) {
+	config := DBConfig{
+		Driver:          envOrDefault("DB_DRIVER", driverSQLite),
+		MaxOpenConns:    25,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: 30 * time.Minute,
+		ConnMaxIdleTime: 5 * time.Minute,
+		ConnectTimeout:  30 * time.Second,
+	}
+
+	for env, target := range map[string]*int{
+		"DB_MAX_OPEN_CONNS": &config.MaxOpenConns,
+		"DB_MAX_IDLE_CONNS": &config.MaxIdleConns,
+	} {
+		if v := os.Getenv(env); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return config, fmt.Errorf("%s: invalid value %q", env, v)
+			}
+			*target = n
+		}
+	}
+	for env, target := range map[string]*time.Duration{
+		"DB_CONN_MAX_LIFETIME":  &config.ConnMaxLifetime,
+		"DB_CONN_MAX_IDLE_TIME": &config.ConnMaxIdleTime,
+		"DB_CONNECT_TIMEOUT":    &config.ConnectTimeout,
+	} {
+		if v := os.Getenv(env); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				return config, fmt.Errorf("%s: invalid duration %q", env, v)
+			}
+			*target = d
+		}
+	}
+
+	switch config.Driver {
+	case driverPostgres:
+		dsn, err := postgresDSN()
+		if err != nil {
+			return config, err
+		}
+		config.DSN = dsn
+	case driverSQLite:
+		path := envOrDefault("DB_PATH", "smartsplit.db")
+		config.DSN = sqliteDSN(path)
+		if path == ":memory:" {
+			// Every connection would get its own empty in-memory database.
+			config.MaxOpenConns = 1
+		}
+	default:
+		return config, fmt.Errorf("DB_DRIVER: unsupported driver %q", config.Driver)
+	}
+
+	return config, nil
+}
+
+// postgresDSN uses DATABASE_URL when it is set. Otherwise the DSN is built
+// from DB_HOST, DB_PORT, DB_USER, DB_PASSWORD, DB_NAME and DB_SSLMODE, which
+// default to the local development database.
+func postgresDSN() (string, error) {
+	if url := os.Getenv("DATABASE_URL"); url != "" {
+		return url, nil
+	}
+
+	sslmode := envOrDefault("DB_SSLMODE", "disable")
+	if !dbSSLModes[sslmode] {
+		return "", fmt.Errorf("DB_SSLMODE: unsupported mode %q", sslmode)
+	}
+
+	params := []struct {
+		key   string
+		value string
+	}{
+		{"host", envOrDefault("DB_HOST", "localhost")},
+		{"port", envOrDefault("DB_PORT", "5432")},
+		{"user", envOrDefault("DB_USER", "postgres")},
+		{"password", envOrDefault("DB_PASSWORD", "password")},
+		{"dbname", envOrDefault("DB_NAME", "cust_data")},
+		{"sslmode", sslmode},
+		{"sslrootcert", os.Getenv("DB_SSLROOTCERT")},
+	}
+	parts := []string{}
+	for _, p := range params {
+		if p.value != "" {
+			parts = append(parts, p.key+"="+quoteDSNValue(p.value))
+		}
+	}
+
+	return strings.Join(parts, " "), nil
+}
+
+// sqliteDSN opens path with foreign keys enforced, which SQLite leaves off by
+// default. Immediate transactions wait on busy_timeout instead of failing
+// when two writers collide.
+func sqliteDSN(path string) string {
+	return "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_time_format=sqlite&_txlock=immediate"
+}
+
+func envOrDefault(env string, fallback string) string {
+	if v := os.Getenv(env); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// quoteDSNValue quotes a value for a key=value connection string.
+func quoteDSNValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
+
+// pingWithRetry waits for the database to accept connections, backing off
+// between attempts, so the server can start before Postgres is ready.
+func pingWithRetry(db *sql.DB, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("database not reachable after %d attempts: %w", attempt, err)
+		}
+
+		logger("db").Warn("database not ready", "attempt", attempt, "retry_in", backoff.String(), "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("database not reachable after %d attempts: %w", attempt, err)
+		}
+		backoff = min(backoff*2, 5*time.Second)
+	}
+}
+
 func InitDB() (*sql.DB, error) {
+	config, err := loadDBConfig()
+	if err != nil {
+		return nil, err
+	}
 
-	db, err := sql.Open("postgres", "host=localhost port=5432 user=postgres password=password dbname=cust_data sslmode=disable")
+	db, err := openLoggedDB(config.Driver, config.DSN)
 	if err != nil {
 		return nil, err
 	}
+	db.SetMaxOpenConns(config.MaxOpenConns)
+	db.SetMaxIdleConns(config.MaxIdleConns)
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+
+	if err := pingWithRetry(db, config.ConnectTimeout); err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	DB = db
+	dbDriver = config.Driver
 	return db, nil
 }
 
 func CloseDB(db *sql.DB) {
 	db.Close()
 }
-
-func executeQuery(query string, db *sql.DB) (*sql.Rows, error) {
-	rows, err := db.Query(query)
-	if err != nil {
-		return nil, err
-	}
-	return rows, nil
-}
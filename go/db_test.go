@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLoadDBConfig(t *testing.T) {
+	tests := []struct {
+		name         string
+		env          map[string]string
+		wantDriver   string
+		wantDSN      string
+		wantMaxConns int
+		wantErr      bool
+	}{
+		{"sqlite by default", nil, driverSQLite, sqliteDSN("smartsplit.db"), 25, false},
+		{"sqlite path", map[string]string{"DB_PATH": "/tmp/budget.db"}, driverSQLite, sqliteDSN("/tmp/budget.db"), 25, false},
+		{"in-memory sqlite keeps one connection", map[string]string{"DB_PATH": ":memory:"}, driverSQLite, sqliteDSN(":memory:"), 1, false},
+		{"postgres url", map[string]string{"DB_DRIVER": "postgres", "DATABASE_URL": "postgres://u:p@db/x"}, driverPostgres, "postgres://u:p@db/x", 25, false},
+		{"postgres prefers ssl", map[string]string{"DB_DRIVER": "postgres", "DB_SSLMODE": "prefer"}, driverPostgres,
+			"host=localhost port=5432 user=postgres password=password dbname=cust_data sslmode=prefer", 25, false},
+		{"postgres allows ssl", map[string]string{"DB_DRIVER": "postgres", "DB_SSLMODE": "allow"}, driverPostgres,
+			"host=localhost port=5432 user=postgres password=password dbname=cust_data sslmode=allow", 25, false},
+		{"unknown ssl mode", map[string]string{"DB_DRIVER": "postgres", "DB_SSLMODE": "maybe"}, "", "", 0, true},
+		{"unknown driver", map[string]string{"DB_DRIVER": "mysql"}, "", "", 0, true},
+		{"bad pool size", map[string]string{"DB_MAX_OPEN_CONNS": "many"}, "", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, env := range []string{"DB_DRIVER", "DB_PATH", "DATABASE_URL", "DB_MAX_OPEN_CONNS", "DB_SSLMODE", "DB_HOST", "DB_PORT", "DB_USER", "DB_PASSWORD", "DB_NAME", "DB_SSLROOTCERT"} {
+				t.Setenv(env, tt.env[env])
+			}
+
+			config, err := loadDBConfig()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("loadDBConfig() = %+v, want an error", config)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Driver != tt.wantDriver || config.DSN != tt.wantDSN || config.MaxOpenConns != tt.wantMaxConns {
+				t.Errorf("loadDBConfig() = %s %q %d conns, want %s %q %d conns",
+					config.Driver, config.DSN, config.MaxOpenConns, tt.wantDriver, tt.wantDSN, tt.wantMaxConns)
+			}
+		})
+	}
+}
+
+func TestInMemorySQLiteMigratesAndSeeds(t *testing.T) {
+	ctx := context.Background()
+	db := openTestSQLite(t)
+	if err := runMigrateCommand(ctx, db, driverSQLite, []string{"up"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Seed(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{"Users", "PlaidItem"} {
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM "` + table + `"`).Scan(&n); err != nil || n == 0 {
+			t.Errorf("table %s has %d seeded rows, %v", table, n, err)
+		}
+	}
+}
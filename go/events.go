@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/plaid/quickstart/repository"
+)
+
+// eventSyncProgress reports the stages of a Plaid transactions sync. It is
+// only streamed; webhooks get transaction.synced once the sync is stored.
+const eventSyncProgress = "sync.progress"
+
+// Stages of sync.progress.
+const (
+	syncWaiting  = "waiting"
+	syncFetching = "fetching"
+	syncDone     = "done"
+	syncFailed   = "failed"
+)
+
+// syncPollInterval is how long a sync waits for Plaid to have the item's
+// first transactions before asking again.
+const syncPollInterval = 2 * time.Second
+
+// eventResync tells a client that the events since its Last-Event-ID are
+// no longer buffered, so it should reload what it shows.
+const eventResync = "resync"
+
+const (
+	// eventBufferSize and eventBufferAge bound the events kept per user for
+	// clients that reconnect with Last-Event-ID.
+	eventBufferSize = 200
+	eventBufferAge  = 5 * time.Minute
+	// eventHeartbeat keeps idle streams from being closed by proxies. The
+	// user's account is checked again at each one.
+	eventHeartbeat = 25 * time.Second
+	// eventSubscriberBuffer is how far a client may fall behind before its
+	// stream is closed; it then reconnects and replays what it missed.
+	eventSubscriberBuffer = 64
+	// eventMaxStreams is how many streams a user may have open; opening
+	// another closes their oldest.
+	eventMaxStreams = 8
+	// eventRetry is the reconnect delay suggested to clients.
+	eventRetry = 3 * time.Second
+)
+
+// streamEvent is one event of a user's stream. Seq counts the user's
+// events since the server started.
+type streamEvent struct {
+	Seq  uint64
+	Type string
+	Data []byte
+	At   time.Time
+}
+
+// eventHub fans events out to each user's open streams and keeps a short
+// buffer of them for replay. It lives in memory: after a restart clients
+// are told to resync.
+type eventHub struct {
+	// boot prefixes event ids, so ids from before a restart are recognised.
+	boot string
+
+	mu sync.Mutex
+	// published counts every event. A user's sequence starts from it when
+	// their state is created, so ids given out before the state was evicted
+	// are never reused and reconnects with them resync.
+	published uint64
+	users     map[string]*eventUser
+	swept     time.Time
+}
+
+// eventUser is the state of one user with open streams or recent events.
+type eventUser struct {
+	seq         uint64
+	buffer      []streamEvent
+	subscribers map[*eventSubscription]struct{}
+	opened      uint64
+}
+
+type eventSubscription struct {
+	hub    *eventHub
+	userid string
+	C      chan streamEvent
+	// Latest is the user's last event when the subscription opened.
+	Latest streamEvent
+	opened uint64
+}
+
+var liveEvents = newEventHub()
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		boot:  strconv.FormatInt(time.Now().UnixMilli(), 36),
+		users: map[string]*eventUser{},
+		swept: time.Now(),
+	}
+}
+
+// eventID is the SSE id of e.
+func (h *eventHub) eventID(e streamEvent) string {
+	return h.boot + "-" + strconv.FormatUint(e.Seq, 10)
+}
+
+// user returns the user's state, creating it. The caller holds h.mu.
+func (h *eventHub) user(userid string) *eventUser {
+	u, ok := h.users[userid]
+	if !ok {
+		u = &eventUser{seq: h.published, subscribers: map[*eventSubscription]struct{}{}}
+		h.users[userid] = u
+	}
+	return u
+}
+
+// Publish buffers the event and sends it to the user's open streams. A
+// stream too far behind to take it is closed.
+func (h *eventHub) Publish(userid string, eventType string, data []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	h.sweep(now)
+	u := h.user(userid)
+	h.published++
+	u.seq++
+	e := streamEvent{Seq: u.seq, Type: eventType, Data: data, At: now}
+	u.buffer = append(h.prune(u.buffer, e.At), e)
+	if n := len(u.buffer); n > eventBufferSize {
+		u.buffer = u.buffer[n-eventBufferSize:]
+	}
+
+	for sub := range u.subscribers {
+		select {
+		case sub.C <- e:
+		default:
+			h.remove(sub)
+		}
+	}
+}
+
+// prune drops events older than eventBufferAge.
+func (h *eventHub) prune(buffer []streamEvent, now time.Time) []streamEvent {
+	i := 0
+	for i < len(buffer) && now.Sub(buffer[i].At) > eventBufferAge {
+		i++
+	}
+	return buffer[i:]
+}
+
+// sweep forgets, every eventBufferAge, the users without streams whose
+// events have all aged out. The caller holds h.mu.
+func (h *eventHub) sweep(now time.Time) {
+	if now.Sub(h.swept) < eventBufferAge {
+		return
+	}
+	h.swept = now
+	for userid, u := range h.users {
+		if len(u.subscribers) == 0 && len(h.prune(u.buffer, now)) == 0 {
+			delete(h.users, userid)
+		}
+	}
+}
+
+// Subscribe opens a stream of the user's events, closing their oldest if
+// they have eventMaxStreams open. With a lastEventID it also returns the
+// buffered events after it; complete is false if some of them are gone and
+// the client has to resync.
+func (h *eventHub) Subscribe(userid string, lastEventID string) (sub *eventSubscription, replay []streamEvent, complete bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	h.sweep(now)
+	u := h.user(userid)
+	for len(u.subscribers) >= eventMaxStreams {
+		var oldest *eventSubscription
+		for s := range u.subscribers {
+			if oldest == nil || s.opened < oldest.opened {
+				oldest = s
+			}
+		}
+		h.remove(oldest)
+	}
+	u.opened++
+	sub = &eventSubscription{hub: h, userid: userid, C: make(chan streamEvent, eventSubscriberBuffer), Latest: streamEvent{Seq: u.seq}, opened: u.opened}
+	u.subscribers[sub] = struct{}{}
+
+	if lastEventID == "" {
+		return sub, nil, true
+	}
+	u.buffer = h.prune(u.buffer, now)
+	boot, seqText, _ := strings.Cut(lastEventID, "-")
+	last, err := strconv.ParseUint(seqText, 10, 64)
+	if boot != h.boot || err != nil || last > u.seq {
+		return sub, nil, false
+	}
+	// The event after last must still be buffered, unless there is none.
+	if last < u.seq && (len(u.buffer) == 0 || u.buffer[0].Seq > last+1) {
+		return sub, nil, false
+	}
+	for _, e := range u.buffer {
+		if e.Seq > last {
+			replay = append(replay, e)
+		}
+	}
+	return sub, replay, true
+}
+
+// remove closes sub, and forgets its user if nothing else is kept for
+// them; the caller holds h.mu.
+func (h *eventHub) remove(sub *eventSubscription) {
+	u, ok := h.users[sub.userid]
+	if !ok {
+		return
+	}
+	if _, ok := u.subscribers[sub]; !ok {
+		return
+	}
+	delete(u.subscribers, sub)
+	close(sub.C)
+	if len(u.subscribers) == 0 && len(h.prune(u.buffer, time.Now())) == 0 {
+		delete(h.users, sub.userid)
+	}
+}
+
+func (sub *eventSubscription) Close() {
+	sub.hub.mu.Lock()
+	defer sub.hub.mu.Unlock()
+	sub.hub.remove(sub)
+}
+
+// publishLive streams an event to the user's dashboards only.
+func publishLive(userid string, event webhookEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		logger("events").Error("could not encode event", "user_id", userid, "type", event.Type, "err", err)
+		return
+	}
+	liveEvents.Publish(userid, event.Type, data)
+}
+
+type syncProgressData struct {
+	Stage    string `json:"stage"`
+	Added    int    `json:"added"`
+	Modified int    `json:"modified"`
+	Removed  int    `json:"removed"`
+}
+
+// eventStreamToken lets EventSource clients, which cannot set headers,
+// pass their token as ?access_token= instead of in Authorization.
+func eventStreamToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := c.Query("access_token"); token != "" && c.GetHeader("Authorization") == "" {
+			c.Request.Header.Set("Authorization", "Bearer "+token)
+		}
+		c.Next()
+	}
+}
+
+func writeStreamEvent(w gin.ResponseWriter, id string, eventType string, data []byte) error {
+	var b strings.Builder
+	if id != "" {
+		fmt.Fprintf(&b, "id: %s\n", id)
+	}
+	fmt.Fprintf(&b, "event: %s\n", eventType)
+	// SSE data fields end at a newline, so each line of data needs its own.
+	for _, line := range strings.Split(string(data), "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	_, err := w.WriteString(b.String())
+	return err
+}
+
+// eventsHandler streams the caller's events as Server-Sent Events: sync
+// progress, synced and categorized transactions, budget saves and alerts.
+// Each event's data is the JSON webhooks receive. A client reconnecting
+// with Last-Event-ID first gets the events it missed, or a resync event
+// if they are no longer buffered. The stream ends when the caller's token
+// expires or their account is disabled, so that they reconnect with a
+// token that is still good.
+func eventsHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	userid := c.GetString("userid")
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	sub, replay, complete := liveEvents.Subscribe(userid, lastEventID)
+	defer sub.Close()
+
+	w := c.Writer
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// nginx buffers responses unless told otherwise.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetry.Milliseconds())
+	if !complete {
+		// The resync carries the latest id, so that the client's next
+		// reconnect resumes from here.
+		data, _ := json.Marshal(newWebhookEvent(eventResync, nil))
+		writeStreamEvent(w, liveEvents.eventID(sub.Latest), eventResync, data)
+	}
+	for _, e := range replay {
+		if err := writeStreamEvent(w, liveEvents.eventID(e), e.Type, e.Data); err != nil {
+			return
+		}
+	}
+	w.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	// Tokens without an expiry are refused by AuthMiddleware.
+	expiry := time.NewTimer(time.Until(c.GetTime("tokenExpiresAt")))
+	defer expiry.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-expiry.C:
+			return
+		case <-heartbeat.C:
+			role, disabled, err := userAccountStatus(ctx, userid, repos.Users)
+			if err != nil && err != repository.ErrNotFound {
+				logger("events").WarnContext(ctx, "could not check account status", "err", err)
+			}
+			if err != nil || disabled || !hasRole(role, RoleUser) {
+				return
+			}
+			if _, err := w.WriteString(": ping\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if err := writeStreamEvent(w, liveEvents.eventID(e), e.Type, e.Data); err != nil {
+				return
+			}
+		}
+		w.Flush()
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestEventHubReplay(t *testing.T) {
+	hub := newEventHub()
+	first, _, _ := hub.Subscribe("u1", "")
+	for _, eventType := range []string{"a", "b", "c"} {
+		hub.Publish("u1", eventType, []byte(`{}`))
+	}
+	hub.Publish("u2", "other", []byte(`{}`))
+	var ids []string
+	for i := 0; i < 3; i++ {
+		ids = append(ids, hub.eventID(<-first.C))
+	}
+	first.Close()
+
+	tests := []struct {
+		name         string
+		lastEventID  string
+		wantReplay   []string
+		wantComplete bool
+	}{
+		{"no id", "", nil, true},
+		{"after the first", ids[0], []string{"b", "c"}, true},
+		{"up to date", ids[2], nil, true},
+		{"before a restart", "0-1", nil, false},
+		{"from the future", hub.boot + "-9", nil, false},
+		{"malformed", "garbage", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, replay, complete := hub.Subscribe("u1", tt.lastEventID)
+			defer sub.Close()
+			var got []string
+			for _, e := range replay {
+				got = append(got, e.Type)
+			}
+			if complete != tt.wantComplete || strings.Join(got, ",") != strings.Join(tt.wantReplay, ",") {
+				t.Errorf("replay = %v, complete = %v, want %v, %v", got, complete, tt.wantReplay, tt.wantComplete)
+			}
+		})
+	}
+}
+
+func TestEventHubResyncsWhenBufferRanOut(t *testing.T) {
+	hub := newEventHub()
+	sub, _, _ := hub.Subscribe("u1", "")
+	hub.Publish("u1", "first", nil)
+	firstID := hub.eventID(<-sub.C)
+	sub.Close()
+	for i := 0; i < eventBufferSize+1; i++ {
+		hub.Publish("u1", "filler", nil)
+	}
+
+	sub, replay, complete := hub.Subscribe("u1", firstID)
+	defer sub.Close()
+	if complete || replay != nil {
+		t.Errorf("replay of %d events, complete = %v, want a resync", len(replay), complete)
+	}
+}
+
+func TestEventHubForgetsIdleUsers(t *testing.T) {
+	hub := newEventHub()
+	sub, _, _ := hub.Subscribe("u1", "")
+	hub.Publish("u1", "old", nil)
+	oldID := hub.eventID(<-sub.C)
+	sub.Close()
+
+	// Age the buffer out and let the next publish sweep.
+	hub.mu.Lock()
+	for _, u := range hub.users {
+		for i := range u.buffer {
+			u.buffer[i].At = u.buffer[i].At.Add(-2 * eventBufferAge)
+		}
+	}
+	hub.swept = hub.swept.Add(-2 * eventBufferAge)
+	hub.mu.Unlock()
+	hub.Publish("u2", "other", nil)
+	if _, ok := hub.users["u1"]; ok {
+		t.Fatal("u1 is still kept after its events aged out")
+	}
+
+	// Ids from before u1 was forgotten are not reused.
+	hub.Publish("u1", "new", nil)
+	sub, replay, complete := hub.Subscribe("u1", oldID)
+	defer sub.Close()
+	if complete || replay != nil {
+		t.Errorf("reconnect with %s: replay = %v, complete = %v, want a resync", oldID, replay, complete)
+	}
+}
+
+func TestEventHubCapsStreams(t *testing.T) {
+	hub := newEventHub()
+	var subs []*eventSubscription
+	for i := 0; i <= eventMaxStreams; i++ {
+		sub, _, _ := hub.Subscribe("u1", "")
+		subs = append(subs, sub)
+	}
+	if _, ok := <-subs[0].C; ok {
+		t.Error("the oldest stream is still open")
+	}
+	if n := len(hub.users["u1"].subscribers); n != eventMaxStreams {
+		t.Errorf("%d streams open, want %d", n, eventMaxStreams)
+	}
+	for _, sub := range subs {
+		sub.Close()
+	}
+	if len(hub.users) != 0 {
+		t.Errorf("users = %v after every stream closed, want none", hub.users)
+	}
+}
+
+func TestEventsHandlerEndsAtTokenExpiry(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/events", func(c *gin.Context) {
+		c.Set("userid", "u1")
+		c.Set("tokenExpiresAt", time.Now().Add(50*time.Millisecond))
+		eventsHandler(c)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/events", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if ctx.Err() != nil {
+		t.Fatal("the stream outlived its token")
+	}
+	if !strings.HasPrefix(w.Body.String(), "retry: ") {
+		t.Errorf("body = %q", w.Body.String())
+	}
+}
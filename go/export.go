@@ -0,0 +1,632 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/plaid/quickstart/money"
+	"github.com/plaid/quickstart/repository"
+	"github.com/xuri/excelize/v2"
+)
+
+// Export formats accepted by the ?format= parameter.
+const (
+	exportFormatCSV  = "csv"
+	exportFormatJSON = "json"
+	exportFormatXLSX = "xlsx"
+)
+
+const (
+	exportPageSize = 500
+	// exportUnassigned is the bucket of rows without an allocation.
+	exportUnassigned = "Unassigned"
+	// exportIncomes is the bucket of incomes in the budget export.
+	exportIncomes    = "Incomes"
+	xlsxMaxSheetName = 31
+)
+
+var exportContentTypes = map[string]string{
+	exportFormatCSV:  "text/csv; charset=utf-8",
+	exportFormatJSON: "application/json; charset=utf-8",
+	exportFormatXLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// tableWriter writes rows of one export. Rows are grouped into sheets by
+// allocation bucket; formats without sheets keep the bucket as a column only.
+type tableWriter interface {
+	WriteRow(sheet string, row []interface{}) error
+	Close() error
+}
+
+func newTableWriter(format string, w io.Writer, header []string) tableWriter {
+	switch format {
+	case exportFormatJSON:
+		return &jsonTableWriter{w: w, header: header}
+	case exportFormatXLSX:
+		return &xlsxTableWriter{w: w, header: header, file: excelize.NewFile(), sheets: map[string]*excelize.StreamWriter{}}
+	default:
+		return &csvTableWriter{w: csv.NewWriter(w), header: header}
+	}
+}
+
+type csvTableWriter struct {
+	w       *csv.Writer
+	header  []string
+	started bool
+	rows    int
+}
+
+func (t *csvTableWriter) WriteRow(sheet string, row []interface{}) error {
+	if !t.started {
+		t.started = true
+		if err := t.w.Write(t.header); err != nil {
+			return err
+		}
+	}
+	record := make([]string, len(row))
+	for i, v := range row {
+		record[i] = exportCell(v)
+	}
+	if err := t.w.Write(record); err != nil {
+		return err
+	}
+	// Flush regularly so large exports reach the client as they are produced.
+	t.rows++
+	if t.rows%exportPageSize == 0 {
+		t.w.Flush()
+	}
+	return t.w.Error()
+}
+
+func (t *csvTableWriter) Close() error {
+	if !t.started {
+		if err := t.w.Write(t.header); err != nil {
+			return err
+		}
+	}
+	t.w.Flush()
+	return t.w.Error()
+}
+
+// jsonTableWriter streams an indented array of objects keyed by the header.
+type jsonTableWriter struct {
+	w      io.Writer
+	header []string
+	rows   int
+}
+
+func (t *jsonTableWriter) WriteRow(sheet string, row []interface{}) error {
+	// Objects are assembled by hand to keep the columns in header order.
+	var object strings.Builder
+	object.WriteString("{")
+	for i, v := range row {
+		key, _ := json.Marshal(t.header[i])
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			object.WriteString(",")
+		}
+		fmt.Fprintf(&object, "\n    %s: %s", key, value)
+	}
+	object.WriteString("\n  }")
+
+	separator := ",\n  "
+	if t.rows == 0 {
+		separator = "[\n  "
+	}
+	t.rows++
+	_, err := io.WriteString(t.w, separator+object.String())
+	return err
+}
+
+func (t *jsonTableWriter) Close() error {
+	closing := "\n]\n"
+	if t.rows == 0 {
+		closing = "[]\n"
+	}
+	_, err := io.WriteString(t.w, closing)
+	return err
+}
+
+// xlsxTableWriter writes one worksheet per bucket. Rows go through excelize
+// stream writers, which spill to temporary files, but the workbook itself can
+// only be sent once it is complete.
+type xlsxTableWriter struct {
+	w      io.Writer
+	header []string
+	file   *excelize.File
+	sheets map[string]*excelize.StreamWriter
+	order  []string
+	names  []string
+	rows   map[string]int
+}
+
+func (t *xlsxTableWriter) sheet(bucket string) (*excelize.StreamWriter, error) {
+	if sw, ok := t.sheets[bucket]; ok {
+		return sw, nil
+	}
+
+	name := xlsxSheetName(bucket, t.names)
+	if len(t.order) == 0 {
+		if err := t.file.SetSheetName("Sheet1", name); err != nil {
+			return nil, err
+		}
+	} else if _, err := t.file.NewSheet(name); err != nil {
+		return nil, err
+	}
+	sw, err := t.file.NewStreamWriter(name)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]interface{}, len(t.header))
+	for i, column := range t.header {
+		header[i] = column
+	}
+	if err := sw.SetRow("A1", header); err != nil {
+		return nil, err
+	}
+
+	t.sheets[bucket] = sw
+	t.order = append(t.order, bucket)
+	t.names = append(t.names, name)
+	if t.rows == nil {
+		t.rows = map[string]int{}
+	}
+	t.rows[bucket] = 1
+	return sw, nil
+}
+
+func (t *xlsxTableWriter) WriteRow(sheet string, row []interface{}) error {
+	if sheet == "" {
+		sheet = exportUnassigned
+	}
+	sw, err := t.sheet(sheet)
+	if err != nil {
+		return err
+	}
+	t.rows[sheet]++
+	// Spreadsheet cells hold numbers as doubles anyway; writing them as
+	// numbers keeps them summable. Strings are written as inline strings,
+	// never formulas, so they need no quoting.
+	for i, v := range row {
+		if d, ok := v.(money.Decimal); ok {
+			row[i] = d.Float64()
+		}
+	}
+	cell, err := excelize.CoordinatesToCellName(1, t.rows[sheet])
+	if err != nil {
+		return err
+	}
+	return sw.SetRow(cell, row)
+}
+
+func (t *xlsxTableWriter) Close() error {
+	defer t.file.Close()
+	if len(t.order) == 0 {
+		if _, err := t.sheet(exportUnassigned); err != nil {
+			return err
+		}
+	}
+	for _, bucket := range t.order {
+		if err := t.sheets[bucket].Flush(); err != nil {
+			return err
+		}
+	}
+	_, err := t.file.WriteTo(t.w)
+	return err
+}
+
+// xlsxSheetName makes a bucket name a valid worksheet name that is not in
+// used yet. Allocation descriptions are not unique, and sheet names are
+// compared case-insensitively and cut to 31 characters.
+func xlsxSheetName(bucket string, used []string) string {
+	base := []rune(strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '-'
+		}
+		return r
+	}, bucket))
+
+	for n := 1; ; n++ {
+		suffix := ""
+		if n > 1 {
+			suffix = " " + strconv.Itoa(n)
+		}
+		name := base
+		if len(name)+len(suffix) > xlsxMaxSheetName {
+			name = name[:xlsxMaxSheetName-len(suffix)]
+		}
+		candidate := string(name) + suffix
+		taken := false
+		for _, u := range used {
+			if strings.EqualFold(u, candidate) {
+				taken = true
+				break
+			}
+		}
+		if !taken {
+			return candidate
+		}
+	}
+}
+
+func exportCell(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return exportText(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	case money.Decimal:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// exportText keeps spreadsheets from evaluating text that starts like a
+// formula, such as a merchant name of "=HYPERLINK(...)", by quoting it.
+func exportText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// exportParams reads ?format=, ?from= and ?to=. Missing dates are zero.
+func exportParams(c *gin.Context) (string, time.Time, time.Time, bool) {
+	format := c.DefaultQuery("format", exportFormatCSV)
+	if _, ok := exportContentTypes[format]; !ok {
+		respondError(c, http.StatusBadRequest, "format must be csv, json or xlsx")
+		return "", time.Time{}, time.Time{}, false
+	}
+
+	from, to, ok := dateRangeParams(c)
+	return format, from, to, ok
+}
+
+// dateRangeParams reads ?from= and ?to=. Missing dates are zero.
+func dateRangeParams(c *gin.Context) (time.Time, time.Time, bool) {
+	var from, to time.Time
+	for name, dst := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := c.Query(name); v != "" {
+			t, err := time.Parse(time.DateOnly, v)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, name+" must be a date in YYYY-MM-DD format")
+				return time.Time{}, time.Time{}, false
+			}
+			*dst = t
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		respondError(c, http.StatusBadRequest, "to must not be before from")
+		return time.Time{}, time.Time{}, false
+	}
+
+	return from, to, true
+}
+
+// startExport sets the download headers. Nothing may be written to the
+// response before it, and errors after it can only be logged.
+func startExport(c *gin.Context, name string, format string, from time.Time, to time.Time) {
+	filename := name
+	if !from.IsZero() {
+		filename += "-from-" + from.Format(time.DateOnly)
+	}
+	if !to.IsZero() {
+		filename += "-to-" + to.Format(time.DateOnly)
+	}
+	c.Header("Content-Type", exportContentTypes[format])
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, filename, format))
+	c.Status(http.StatusOK)
+}
+
+// budgetLookups indexes a budget for export rows.
+type budgetLookups struct {
+	expenses    map[uuid.UUID]Expense
+	allocations map[string]Allocation
+	categories  map[string]string
+}
+
+func loadBudgetLookups(ctx context.Context, userid string) (repository.Budget, budgetLookups, error) {
+	lookups := budgetLookups{expenses: map[uuid.UUID]Expense{}, allocations: map[string]Allocation{}, categories: map[string]string{}}
+
+	budget, err := repos.Budgets.Load(ctx, userid)
+	if err != nil {
+		return budget, lookups, err
+	}
+	categories, err := repos.Categories.List(ctx)
+	if err != nil {
+		return budget, lookups, err
+	}
+
+	for _, expense := range budget.Expenses {
+		lookups.expenses[expense.Id] = expense
+	}
+	for _, allocation := range budget.Allocations {
+		lookups.allocations[allocation.AllocationType] = allocation
+	}
+	for _, category := range categories {
+		lookups.categories[category.ID.String()] = category.Name
+	}
+	return budget, lookups, nil
+}
+
+// bucket names the allocation an expense is split by.
+func (l budgetLookups) bucket(expense Expense) string {
+	if allocation, ok := l.allocations[expense.AllocationType]; ok && allocation.AllocationDescription != "" {
+		return allocation.AllocationDescription
+	}
+	return exportUnassigned
+}
+
+// savingsBucket names the allocation investment contributions count toward:
+// the first whose description mentions savings, or a bucket of its own.
+func (l budgetLookups) savingsBucket(budget repository.Budget) string {
+	for _, allocation := range budget.Allocations {
+		if strings.Contains(strings.ToLower(allocation.AllocationDescription), "savings") {
+			return allocation.AllocationDescription
+		}
+	}
+	return savingsAllocationName
+}
+
+// allocationTargets splits the planned income for months of the budget
+// between its allocations by their factors, in whole minor units of
+// currency. Income left unallocated because the factors add up to less than
+// one is kept out of the targets, and the targets plus that remainder always
+// add up to the planned income exactly.
+func allocationTargets(budget repository.Budget, months int, currency string) []money.Money {
+	income := money.Zero
+	for _, i := range budget.Incomes {
+		income = income.Add(i.Amount)
+	}
+	weights := make([]money.Decimal, 0, len(budget.Allocations)+1)
+	allocated := money.Zero
+	for _, allocation := range budget.Allocations {
+		weights = append(weights, allocation.AllocationFactor)
+		allocated = allocated.Add(allocation.AllocationFactor)
+	}
+	if unallocated := money.One.Sub(allocated); unallocated.Sign() > 0 {
+		weights = append(weights, unallocated)
+	}
+	planned := money.Money{Amount: income.MulInt(int64(months)), Currency: currency}
+	return planned.Split(weights)[:len(budget.Allocations)]
+}
+
+var transactionExportHeader = []string{"date", "name", "merchant", "account", "amount", "currency", "reporting_amount", "reporting_currency", "category", "pending", "expense", "allocation"}
+
+// exportTransactionsHandler streams the caller's stored transactions, oldest
+// first, with the expense and allocation each one is assigned to. Amounts
+// are kept as stored and repeated in the reporting currency, which is left
+// blank where no exchange rate is known.
+func exportTransactionsHandler(c *gin.Context) {
+	format, from, to, ok := exportParams(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	userid := c.GetString("userid")
+
+	_, lookups, err := loadBudgetLookups(ctx, userid)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load budget")
+		return
+	}
+	ratesTo := to
+	if ratesTo.IsZero() {
+		ratesTo = time.Now().UTC()
+	}
+	fx, err := newFXConverter(ctx, userid, from, ratesTo)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load exchange rates")
+		return
+	}
+
+	startExport(c, "transactions", format, from, to)
+	table := newTableWriter(format, c.Writer, transactionExportHeader)
+	query := repository.TransactionQuery{From: from, To: to, Sort: repository.SortByDate, Limit: exportPageSize}
+	for {
+		page, err := repos.Transactions.Search(ctx, userid, query)
+		if err != nil {
+			logger("export").WarnContext(ctx, "transactions export cut short", "err", err)
+			return
+		}
+		for _, t := range page.Transactions {
+			expense, bucket := "", exportUnassigned
+			if t.ExpenseID != nil {
+				if e, ok := lookups.expenses[*t.ExpenseID]; ok {
+					expense, bucket = e.Description, lookups.bucket(e)
+				}
+			}
+			category := t.CategoryDetailed
+			if category == "" {
+				category = t.CategoryPrimary
+			}
+			var reportingAmount interface{}
+			if rate, ok := fx.Rate(t.ISOCurrencyCode, t.Date); ok {
+				reportingAmount = t.Amount.MulPrecise(rate).Round(money.MinorUnits(fx.Currency))
+			}
+			row := []interface{}{t.Date.Format(time.DateOnly), t.Name, t.MerchantName, t.PlaidAccountID, t.Amount, t.ISOCurrencyCode,
+				reportingAmount, fx.Currency, category, t.Pending, expense, bucket}
+			if err := table.WriteRow(bucket, row); err != nil {
+				logger("export").WarnContext(ctx, "transactions export cut short", "err", err)
+				return
+			}
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query.After = page.NextCursor
+	}
+
+	if err := table.Close(); err != nil {
+		logger("export").WarnContext(ctx, "transactions export cut short", "err", err)
+	}
+}
+
+var budgetExportHeader = []string{"type", "description", "amount", "frequency", "category", "allocation", "allocation_factor"}
+
+// exportBudgetHandler exports the caller's budget plan, as returned by
+// getBudgetHandler: expenses by allocation, then incomes, then each
+// allocation with the share of monthly income it targets.
+func exportBudgetHandler(c *gin.Context) {
+	format, _, _, ok := exportParams(c)
+	if !ok {
+		return
+	}
+	userid := c.GetString("userid")
+
+	budget, lookups, err := loadBudgetLookups(c.Request.Context(), userid)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load budget")
+		return
+	}
+	currency, err := reportingCurrency(c.Request.Context(), userid)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load settings")
+		return
+	}
+
+	startExport(c, "budget", format, time.Time{}, time.Time{})
+	table := newTableWriter(format, c.Writer, budgetExportHeader)
+	for _, expense := range budget.Expenses {
+		bucket := lookups.bucket(expense)
+		factor := lookups.allocations[expense.AllocationType].AllocationFactor
+		row := []interface{}{"expense", expense.Description, expense.Amount, "", lookups.categories[expense.Category], bucket, factor}
+		if err := table.WriteRow(bucket, row); err != nil {
+			logger("export").WarnContext(c.Request.Context(), "budget export cut short", "err", err)
+			return
+		}
+	}
+	for _, income := range budget.Incomes {
+		row := []interface{}{"income", income.Description, income.Amount, income.Frequency, "", "", nil}
+		if err := table.WriteRow(exportIncomes, row); err != nil {
+			logger("export").WarnContext(c.Request.Context(), "budget export cut short", "err", err)
+			return
+		}
+	}
+	targets := allocationTargets(budget, 1, currency)
+	for i, allocation := range budget.Allocations {
+		row := []interface{}{"allocation", allocation.AllocationDescription, targets[i].Amount, "", "", allocation.AllocationDescription, allocation.AllocationFactor}
+		if err := table.WriteRow(allocation.AllocationDescription, row); err != nil {
+			logger("export").WarnContext(c.Request.Context(), "budget export cut short", "err", err)
+			return
+		}
+	}
+
+	if err := table.Close(); err != nil {
+		logger("export").WarnContext(c.Request.Context(), "budget export cut short", "err", err)
+	}
+}
+
+var budgetVsActualHeader = []string{"allocation", "expense", "category", "planned", "actual", "difference", "currency"}
+
+// monthsBetween counts the calendar months the range from..to touches.
+func monthsBetween(from time.Time, to time.Time) int {
+	return (to.Year()-from.Year())*12 + int(to.Month()-from.Month()) + 1
+}
+
+// budgetPeriod fills in a missing end of a date range: from defaults to the
+// start of the current month, or of to's month, and to defaults to today, or
+// the end of from's month.
+func budgetPeriod(from time.Time, to time.Time) (time.Time, time.Time) {
+	now := time.Now().UTC()
+	if from.IsZero() {
+		from = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		if !to.IsZero() && to.Before(from) {
+			from = time.Date(to.Year(), to.Month(), 1, 0, 0, 0, 0, time.UTC)
+		}
+	}
+	if to.IsZero() {
+		to = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		if to.Before(from) {
+			to = from.AddDate(0, 1, -1)
+		}
+	}
+	return from, to
+}
+
+// exportBudgetVsActualHandler compares each expense's planned amount with the
+// transactions assigned to it. Expense amounts are monthly, so the plan is
+// scaled by the number of months the range touches. The range defaults to the
+// current month.
+func exportBudgetVsActualHandler(c *gin.Context) {
+	format, from, to, ok := exportParams(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	userid := c.GetString("userid")
+
+	from, to = budgetPeriod(from, to)
+
+	budget, lookups, err := loadBudgetLookups(ctx, userid)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load budget")
+		return
+	}
+	fx, err := newFXConverter(ctx, userid, from, to)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load exchange rates")
+		return
+	}
+	expenseTotals, err := repos.Transactions.ExpenseTotals(ctx, userid, from, to)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load transactions")
+		return
+	}
+	totals := fx.ExpenseTotals(expenseTotals)
+	contributions, err := investmentContributions(ctx, userid, from, to, fx)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load investment transactions")
+		return
+	}
+
+	months := int64(monthsBetween(from, to))
+	setFXHeaders(c, fx)
+	startExport(c, "budget-vs-actual", format, from, to)
+	table := newTableWriter(format, c.Writer, budgetVsActualHeader)
+	for _, expense := range budget.Expenses {
+		bucket := lookups.bucket(expense)
+		planned, actual := expense.Amount.MulInt(months), totals[expense.Id]
+		row := []interface{}{bucket, expense.Description, lookups.categories[expense.Category], planned, actual, planned.Sub(actual), fx.Currency}
+		if err := table.WriteRow(bucket, row); err != nil {
+			logger("export").WarnContext(ctx, "budget vs actual export cut short", "err", err)
+			return
+		}
+	}
+	if !contributions.IsZero() {
+		bucket := lookups.savingsBucket(budget)
+		row := []interface{}{bucket, investmentContributionsLine, "", money.Zero, contributions, contributions.Neg(), fx.Currency}
+		if err := table.WriteRow(bucket, row); err != nil {
+			logger("export").WarnContext(ctx, "budget vs actual export cut short", "err", err)
+			return
+		}
+	}
+	if unassigned, ok := totals[uuid.Nil]; ok {
+		row := []interface{}{exportUnassigned, "Uncategorized spending", "", money.Zero, unassigned, unassigned.Neg(), fx.Currency}
+		if err := table.WriteRow(exportUnassigned, row); err != nil {
+			logger("export").WarnContext(ctx, "budget vs actual export cut short", "err", err)
+			return
+		}
+	}
+
+	if err := table.Close(); err != nil {
+		logger("export").WarnContext(ctx, "budget vs actual export cut short", "err", err)
+	}
+}
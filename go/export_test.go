@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/plaid/quickstart/money"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestXLSXSheetName(t *testing.T) {
+	long := strings.Repeat("a", 40)
+	tests := []struct {
+		name   string
+		bucket string
+		used   []string
+		want   string
+	}{
+		{"plain", "Needs", nil, "Needs"},
+		{"invalid characters", "Rent/Utilities: [home]?", nil, "Rent-Utilities- -home--"},
+		{"cut to 31", long, nil, long[:31]},
+		{"collision", "Needs", []string{"Needs"}, "Needs 2"},
+		{"case-insensitive collision", "needs", []string{"Needs", "NEEDS 2"}, "needs 3"},
+		{"cut to make room for suffix", long, []string{long[:31]}, long[:29] + " 2"},
+		{"multibyte", strings.Repeat("é", 40), nil, strings.Repeat("é", 31)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := xlsxSheetName(tt.bucket, tt.used); got != tt.want {
+				t.Errorf("xlsxSheetName(%q, %q) = %q, want %q", tt.bucket, tt.used, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportCellQuotesFormulas(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{"=HYPERLINK(\"http://evil\")", "'=HYPERLINK(\"http://evil\")"},
+		{"+1-555", "'+1-555"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tcmd", "'\tcmd"},
+		{"\rcmd", "'\rcmd"},
+		{"Coffee", "Coffee"},
+		{"", ""},
+		{money.MustParse("-12.50"), "-12.50"},
+	}
+	for _, tt := range tests {
+		if got := exportCell(tt.value); got != tt.want {
+			t.Errorf("exportCell(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestXLSXWritesFormulaTextAsString(t *testing.T) {
+	var buf bytes.Buffer
+	table := newTableWriter(exportFormatXLSX, &buf, []string{"name", "amount"})
+	if err := table.WriteRow("Needs", []interface{}{"=1+1", money.MustParse("-3")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := excelize.OpenReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	formula, err := file.GetCellFormula("Needs", "A2")
+	if err != nil || formula != "" {
+		t.Errorf("A2 formula = %q, %v, want none", formula, err)
+	}
+	value, err := file.GetCellValue("Needs", "A2")
+	if err != nil || value != "=1+1" {
+		t.Errorf("A2 = %q, %v, want the text =1+1", value, err)
+	}
+	cellType, err := file.GetCellType("Needs", "B2")
+	if err != nil || cellType == excelize.CellTypeInlineString || cellType == excelize.CellTypeSharedString {
+		t.Errorf("B2 type = %v, %v, want a number", cellType, err)
+	}
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/plaid/quickstart/money"
+	"github.com/plaid/quickstart/repository"
+)
+
+const (
+	// fxFallbackCurrency reports users who have not chosen a currency when
+	// REPORTING_CURRENCY is not set.
+	fxFallbackCurrency = "USD"
+	// fxRefreshInterval is how often the provider is asked for rates newer
+	// than the stored ones.
+	fxRefreshInterval = time.Hour
+	// fxHistoryMonths is how far back the first refresh reaches, matching the
+	// transaction history Plaid returns.
+	fxHistoryMonths = 24
+	fxFileSource    = "file"
+)
+
+var currencyCodePattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// defaultReportingCurrency is the currency of users who have not chosen one.
+func defaultReportingCurrency() string {
+	if currency := strings.ToUpper(os.Getenv("REPORTING_CURRENCY")); currency != "" {
+		return currency
+	}
+	return fxFallbackCurrency
+}
+
+// reportingCurrency returns the currency the user's totals are reported in.
+func reportingCurrency(ctx context.Context, userid string) (string, error) {
+	currency, err := repos.FX.ReportingCurrency(ctx, userid)
+	if err != nil || currency != "" {
+		return currency, err
+	}
+	return defaultReportingCurrency(), nil
+}
+
+type fxPair struct {
+	base     string
+	currency string
+}
+
+// fxConverter converts amounts into a reporting currency at the rate of the
+// day they were made: the latest stored rate on or before that day, taken
+// directly, inverted, or crossed through a common base currency.
+type fxConverter struct {
+	Currency string
+	rates    map[fxPair][]repository.FXRate
+	bases    []string
+	missing  map[string]bool
+}
+
+// newFXConverter loads the rates needed to convert amounts dated within
+// [from, to] into the user's reporting currency.
+func newFXConverter(ctx context.Context, userid string, from time.Time, to time.Time) (*fxConverter, error) {
+	currency, err := reportingCurrency(ctx, userid)
+	if err != nil {
+		return nil, err
+	}
+	rates, err := repos.FX.Rates(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return newFXConverterFromRates(currency, rates), nil
+}
+
+// newFXConverterFromRates builds a converter from rates ordered by date.
+func newFXConverterFromRates(currency string, rates []repository.FXRate) *fxConverter {
+	c := &fxConverter{Currency: currency, rates: map[fxPair][]repository.FXRate{}, missing: map[string]bool{}}
+	for _, rate := range rates {
+		if !containsString(c.bases, rate.BaseCurrency) {
+			c.bases = append(c.bases, rate.BaseCurrency)
+		}
+		pair := fxPair{rate.BaseCurrency, rate.Currency}
+		c.rates[pair] = append(c.rates[pair], rate)
+	}
+	sort.Strings(c.bases)
+	return c
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// pairRate returns how many units of currency one unit of base bought on date.
+func (c *fxConverter) pairRate(base string, currency string, date time.Time) (money.Precise, bool) {
+	rates := c.rates[fxPair{base, currency}]
+	i := sort.Search(len(rates), func(i int) bool { return rates[i].Date.After(date) })
+	if i == 0 || rates[i-1].Rate.Sign() <= 0 {
+		return money.Precise{}, false
+	}
+	return rates[i-1].Rate, true
+}
+
+// Rate returns how many units of the reporting currency one unit of
+// currency bought on date. Amounts without a currency are taken to be in
+// the reporting currency.
+func (c *fxConverter) Rate(currency string, date time.Time) (money.Precise, bool) {
+	if currency == "" || currency == c.Currency {
+		return money.PreciseOne, true
+	}
+	if rate, ok := c.pairRate(currency, c.Currency, date); ok {
+		return rate, true
+	}
+	if rate, ok := c.pairRate(c.Currency, currency, date); ok {
+		return rate.Inverse(), true
+	}
+	for _, base := range c.bases {
+		from, ok := c.pairRate(base, currency, date)
+		if !ok {
+			continue
+		}
+		if to, ok := c.pairRate(base, c.Currency, date); ok {
+			return to.Div(from), true
+		}
+	}
+	return money.Precise{}, false
+}
+
+// Convert returns amount in the reporting currency. Amounts no rate is
+// known for are returned unchanged and their currency listed by Missing.
+func (c *fxConverter) Convert(amount money.Decimal, currency string, date time.Time) money.Decimal {
+	rate, ok := c.Rate(currency, date)
+	if !ok {
+		c.missing[currency] = true
+		return amount
+	}
+	if rate == money.PreciseOne {
+		return amount
+	}
+	return amount.MulPrecise(rate)
+}
+
+// Missing lists the currencies that had no rate, so totals that include
+// them unconverted can say so.
+func (c *fxConverter) Missing() []string {
+	missing := make([]string, 0, len(c.missing))
+	for currency := range c.missing {
+		missing = append(missing, currency)
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// ConvertTotals sums currency totals in the reporting currency, to its
+// minor unit.
+func (c *fxConverter) ConvertTotals(totals []repository.CurrencyTotal) money.Decimal {
+	sum := money.Zero
+	for _, total := range totals {
+		sum = sum.Add(c.Convert(total.Total, total.ISOCurrencyCode, total.Date))
+	}
+	return sum.Round(money.MinorUnits(c.Currency))
+}
+
+// ExpenseTotals sums each expense's spending in the reporting currency.
+// Unassigned outflows are summed under uuid.Nil.
+func (c *fxConverter) ExpenseTotals(totals []repository.ExpenseTotal) map[uuid.UUID]money.Decimal {
+	sums := map[uuid.UUID]money.Decimal{}
+	for _, total := range totals {
+		sums[total.ExpenseID] = sums[total.ExpenseID].Add(c.Convert(total.Total, total.ISOCurrencyCode, total.Date))
+	}
+	for id, sum := range sums {
+		sums[id] = sum.Round(money.MinorUnits(c.Currency))
+	}
+	return sums
+}
+
+// MerchantTotals returns the limit merchants with the largest outflows in
+// the reporting currency, largest first.
+func (c *fxConverter) MerchantTotals(amounts []repository.MerchantAmount, limit int) []repository.MerchantTotal {
+	byName := map[string]*repository.MerchantTotal{}
+	merchants := []repository.MerchantTotal{}
+	for _, amount := range amounts {
+		merchant, ok := byName[amount.Name]
+		if !ok {
+			merchant = &repository.MerchantTotal{Name: amount.Name}
+			byName[amount.Name] = merchant
+		}
+		merchant.Total = merchant.Total.Add(c.Convert(amount.Total, amount.ISOCurrencyCode, amount.Date))
+		merchant.Transactions += amount.Transactions
+	}
+	for _, merchant := range byName {
+		merchant.Total = merchant.Total.Round(money.MinorUnits(c.Currency))
+		merchants = append(merchants, *merchant)
+	}
+	sort.Slice(merchants, func(i, j int) bool {
+		if cmp := merchants[i].Total.Cmp(merchants[j].Total); cmp != 0 {
+			return cmp > 0
+		}
+		return merchants[i].Name < merchants[j].Name
+	})
+	if len(merchants) > limit {
+		merchants = merchants[:limit]
+	}
+	return merchants
+}
+
+// setFXHeaders tells the client which currency a response's totals are in,
+// and which currencies were left unconverted for want of a rate.
+func setFXHeaders(c *gin.Context, fx *fxConverter) {
+	c.Header("X-Reporting-Currency", fx.Currency)
+	if missing := fx.Missing(); len(missing) > 0 {
+		c.Header("X-FX-Missing-Rates", strings.Join(missing, ","))
+	}
+}
+
+var errInvalidFXFile = errors.New("invalid exchange rate file")
+
+// parseFXRates reads rates from an ECB reference rate XML file (.xml) or a
+// CSV file with date, base_currency, currency and rate columns.
+func parseFXRates(r io.Reader, name string) ([]repository.FXRate, error) {
+	if strings.EqualFold(filepath.Ext(name), ".xml") {
+		return parseECBRates(r, fxFileSource)
+	}
+	return parseFXRatesCSV(r)
+}
+
+func parseFXRatesCSV(r io.Reader) ([]repository.FXRate, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidFXFile, err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"date", "base_currency", "currency", "rate"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%w: missing %s column", errInvalidFXFile, name)
+		}
+	}
+
+	rates := []repository.FXRate{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rates, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidFXFile, err)
+		}
+		rate, err := newFXRate(record[columns["date"]], record[columns["base_currency"]], record[columns["currency"]], record[columns["rate"]], fxFileSource)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", errInvalidFXFile, line, err)
+		}
+		rates = append(rates, rate)
+	}
+}
+
+func newFXRate(date string, base string, currency string, rate string, source string) (repository.FXRate, error) {
+	parsed := repository.FXRate{
+		BaseCurrency: strings.ToUpper(strings.TrimSpace(base)),
+		Currency:     strings.ToUpper(strings.TrimSpace(currency)),
+		Source:       source,
+	}
+	var err error
+	if parsed.Date, err = time.Parse(time.DateOnly, strings.TrimSpace(date)); err != nil {
+		return parsed, fmt.Errorf("date %q is not YYYY-MM-DD", date)
+	}
+	if !currencyCodePattern.MatchString(parsed.BaseCurrency) || !currencyCodePattern.MatchString(parsed.Currency) {
+		return parsed, fmt.Errorf("currencies must be ISO 4217 codes")
+	}
+	if parsed.Rate, err = money.ParsePrecise(rate); err != nil || parsed.Rate.Sign() <= 0 {
+		return parsed, fmt.Errorf("rate %q is not a positive number", rate)
+	}
+	return parsed, nil
+}
+
+// ecbEnvelope is the European Central Bank's euro reference rate format:
+// a Cube per day holding a Cube per currency.
+type ecbEnvelope struct {
+	Days []struct {
+		Time  string `xml:"time,attr"`
+		Rates []struct {
+			Currency string `xml:"currency,attr"`
+			Rate     string `xml:"rate,attr"`
+		} `xml:"Cube"`
+	} `xml:"Cube>Cube"`
+}
+
+func parseECBRates(r io.Reader, source string) ([]repository.FXRate, error) {
+	var envelope ecbEnvelope
+	if err := xml.NewDecoder(r).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidFXFile, err)
+	}
+	rates := []repository.FXRate{}
+	for _, day := range envelope.Days {
+		for _, r := range day.Rates {
+			rate, err := newFXRate(day.Time, "EUR", r.Currency, r.Rate, source)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", errInvalidFXFile, day.Time, err)
+			}
+			rates = append(rates, rate)
+		}
+	}
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("%w: no rates", errInvalidFXFile)
+	}
+	return rates, nil
+}
+
+// FXProvider fetches published exchange rates. FX_PROVIDER picks one of
+// fxProviders to keep the stored rates current.
+type FXProvider interface {
+	// Source names the provider on the rates it returns.
+	Source() string
+	// Fetch returns the rates published on or after since.
+	Fetch(ctx context.Context, since time.Time) ([]repository.FXRate, error)
+}
+
+var fxProviders = map[string]func() FXProvider{
+	"ecb": func() FXProvider { return &ecbProvider{client: &http.Client{Timeout: 30 * time.Second}} },
+}
+
+func newFXProviderFromEnv() (FXProvider, error) {
+	name := os.Getenv("FX_PROVIDER")
+	if name == "" {
+		return nil, nil
+	}
+	newProvider, ok := fxProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("FX_PROVIDER %q is not one of ecb", name)
+	}
+	return newProvider(), nil
+}
+
+const (
+	ecbRecentRatesURL  = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-hist-90d.xml"
+	ecbHistoryRatesURL = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-hist.xml"
+)
+
+// ecbProvider reads the European Central Bank's daily euro reference rates.
+type ecbProvider struct {
+	client *http.Client
+}
+
+func (p *ecbProvider) Source() string { return "ecb" }
+
+func (p *ecbProvider) Fetch(ctx context.Context, since time.Time) ([]repository.FXRate, error) {
+	url := ecbRecentRatesURL
+	if since.Before(time.Now().AddDate(0, 0, -85)) {
+		url = ecbHistoryRatesURL
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := p.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ecb rates: %s", response.Status)
+	}
+
+	rates, err := parseECBRates(response.Body, p.Source())
+	if err != nil {
+		return nil, err
+	}
+	recent := rates[:0]
+	for _, rate := range rates {
+		if !rate.Date.Before(since) {
+			recent = append(recent, rate)
+		}
+	}
+	return recent, nil
+}
+
+// refreshFXRates stores the rates the provider published since the newest
+// stored one, and returns how many it stored.
+func refreshFXRates(ctx context.Context, provider FXProvider, now time.Time) (int, error) {
+	latest, err := repos.FX.LatestDate(ctx, provider.Source())
+	if err != nil {
+		return 0, err
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	since := today.AddDate(0, -fxHistoryMonths, 0)
+	if !latest.IsZero() {
+		if !latest.Before(today) {
+			return 0, nil
+		}
+		since = latest.AddDate(0, 0, 1)
+	}
+
+	rates, err := provider.Fetch(ctx, since)
+	if err != nil {
+		return 0, err
+	}
+	return len(rates), repos.FX.SaveRates(ctx, rates)
+}
+
+// runFXRefresh refreshes rates now and every fxRefreshInterval until ctx is done.
+func runFXRefresh(ctx context.Context, provider FXProvider) {
+	ticker := time.NewTicker(fxRefreshInterval)
+	defer ticker.Stop()
+	for {
+		runJob(ctx, "fx", func() {
+			if _, err := refreshFXRates(ctx, provider, time.Now().UTC()); err != nil {
+				logger("fx").ErrorContext(ctx, "could not refresh exchange rates", "source", provider.Source(), "err", err)
+			}
+		})
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runFXCommand implements `fx load FILE` and `fx refresh`.
+func runFXCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("fx", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	switch {
+	case flags.NArg() == 2 && flags.Arg(0) == "load":
+		f, err := os.Open(flags.Arg(1))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		rates, err := parseFXRates(f, f.Name())
+		if err != nil {
+			return err
+		}
+		if err := repos.FX.SaveRates(ctx, rates); err != nil {
+			return err
+		}
+		writeAudit(ctx, repository.AuditEntry{
+			Action:   AuditFXRatesLoaded,
+			Metadata: auditMetadata(gin.H{"source": fxFileSource, "file": filepath.Base(f.Name()), "rates": len(rates)}),
+		})
+		logger("fx").InfoContext(ctx, "loaded exchange rates", "source", f.Name(), "rates", len(rates))
+		return nil
+
+	case flags.NArg() == 1 && flags.Arg(0) == "refresh":
+		provider, err := newFXProviderFromEnv()
+		if err != nil {
+			return err
+		}
+		if provider == nil {
+			return errors.New("fx refresh: FX_PROVIDER is not set")
+		}
+		n, err := refreshFXRates(ctx, provider, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		writeAudit(ctx, repository.AuditEntry{
+			Action:   AuditFXRatesLoaded,
+			Metadata: auditMetadata(gin.H{"source": provider.Source(), "rates": n}),
+		})
+		logger("fx").InfoContext(ctx, "loaded exchange rates", "source", provider.Source(), "rates", n)
+		return nil
+	}
+
+	return errors.New("usage: fx load FILE | fx refresh")
+}
+
+type currencySettings struct {
+	ReportingCurrency string `json:"reporting_currency"`
+}
+
+// currencySettingsResponse says whether the currency is the server default
+// rather than one the caller chose.
+type currencySettingsResponse struct {
+	ReportingCurrency string `json:"reporting_currency"`
+	Default           bool   `json:"default"`
+}
+
+func getCurrencySettingsHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	userid := c.GetString("userid")
+	chosen, err := repos.FX.ReportingCurrency(ctx, userid)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load currency settings")
+		return
+	}
+	currency := chosen
+	if currency == "" {
+		currency = defaultReportingCurrency()
+	}
+	c.JSON(http.StatusOK, currencySettingsResponse{ReportingCurrency: currency, Default: chosen == ""})
+}
+
+// putCurrencySettingsHandler sets the currency the caller's budget-vs-actual,
+// exports, statements and net worth are reported in. An empty currency
+// goes back to the server default.
+func putCurrencySettingsHandler(c *gin.Context) {
+	var request currencySettings
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid currency settings")
+		return
+	}
+	currency := strings.ToUpper(strings.TrimSpace(request.ReportingCurrency))
+	if currency != "" && !currencyCodePattern.MatchString(currency) {
+		respondError(c, http.StatusBadRequest, "reporting_currency must be an ISO 4217 code")
+		return
+	}
+
+	if err := repos.FX.SetReportingCurrency(c.Request.Context(), c.GetString("userid"), currency); err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not save currency settings")
+		return
+	}
+	if currency == "" {
+		c.JSON(http.StatusOK, currencySettingsResponse{ReportingCurrency: defaultReportingCurrency(), Default: true})
+		return
+	}
+	c.JSON(http.StatusOK, currencySettingsResponse{ReportingCurrency: currency})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/plaid/quickstart/money"
+	"github.com/plaid/quickstart/repository"
+)
+
+func TestFXConverterRate(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	rate := func(base string, currency string, d int, r string) repository.FXRate {
+		return repository.FXRate{BaseCurrency: base, Currency: currency, Date: day(d), Rate: money.MustParsePrecise(r)}
+	}
+	fx := newFXConverterFromRates("USD", []repository.FXRate{
+		rate("EUR", "USD", 1, "1.1"),
+		rate("EUR", "JPY", 1, "160"),
+		rate("USD", "GBP", 1, "0.8"),
+		rate("USD", "CHF", 1, "0"),
+		rate("EUR", "USD", 10, "1.2"),
+	})
+
+	tests := []struct {
+		name     string
+		currency string
+		date     time.Time
+		want     string
+	}{
+		{"reporting currency", "USD", day(5), "1.00"},
+		{"no currency", "", day(5), "1.00"},
+		{"direct", "EUR", day(5), "1.10"},
+		{"latest rate on or before the day", "EUR", day(10), "1.20"},
+		{"inverse", "GBP", day(5), "1.25"},
+		{"cross through a base", "JPY", day(5), "0.006875"},
+		{"before any rate", "EUR", time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC), ""},
+		{"zero rate", "CHF", day(5), ""},
+		{"no rate", "AUD", day(5), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := fx.Rate(tt.currency, tt.date)
+			if tt.want == "" {
+				if ok {
+					t.Errorf("Rate(%q) = %s, want none", tt.currency, got)
+				}
+				return
+			}
+			if !ok || got.String() != tt.want {
+				t.Errorf("Rate(%q) = %s, %v, want %s", tt.currency, got, ok, tt.want)
+			}
+		})
+	}
+
+	if got := fx.Convert(money.MustParse("10"), "AUD", day(5)); got.String() != "10.00" {
+		t.Errorf("Convert without a rate = %s, want the amount unchanged", got)
+	}
+	if got := fx.Missing(); len(got) != 1 || got[0] != "AUD" {
+		t.Errorf("Missing = %v, want [AUD]", got)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/plaid/quickstart/money"
+	"github.com/plaid/quickstart/repository"
+)
+
+type goalRequest struct {
+	Name         string        `json:"name" binding:"required"`
+	TargetAmount money.Decimal `json:"target_amount"`
+	SavedAmount  money.Decimal `json:"saved_amount"`
+	// TargetDate is optional, in YYYY-MM-DD format.
+	TargetDate string `json:"target_date"`
+}
+
+func (r goalRequest) goal(id uuid.UUID) (repository.Goal, error) {
+	goal := repository.Goal{ID: id, Name: r.Name, TargetAmount: r.TargetAmount, SavedAmount: r.SavedAmount}
+	if r.TargetDate != "" {
+		date, err := time.Parse(time.DateOnly, r.TargetDate)
+		if err != nil {
+			return goal, err
+		}
+		goal.TargetDate = &date
+	}
+	return goal, nil
+}
+
+type goalsResponse struct {
+	Goals []repository.Goal `json:"goals"`
+}
+
+func getGoalsHandler(c *gin.Context) {
+	goals, err := repos.Goals.List(c.Request.Context(), c.GetString("userid"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load goals")
+		return
+	}
+	c.JSON(http.StatusOK, goalsResponse{Goals: goals})
+}
+
+// saveGoalHandler creates a goal, or updates the one named by :id.
+func saveGoalHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	userid := c.GetString("userid")
+
+	id := uuid.Nil
+	var previous *repository.Goal
+	if param := c.Param("id"); param != "" {
+		var err error
+		if id, err = uuid.Parse(param); err != nil {
+			respondError(c, http.StatusBadRequest, "Invalid goal id")
+			return
+		}
+		goals, err := repos.Goals.List(ctx, userid)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "Could not load goals")
+			return
+		}
+		for i := range goals {
+			if goals[i].ID == id {
+				previous = &goals[i]
+			}
+		}
+		if previous == nil {
+			respondError(c, http.StatusNotFound, "Goal not found")
+			return
+		}
+	}
+
+	var request goalRequest
+	if err := c.ShouldBindJSON(&request); err != nil || request.TargetAmount.Sign() <= 0 || request.SavedAmount.Sign() < 0 {
+		respondError(c, http.StatusBadRequest, "A name and a positive target amount are required")
+		return
+	}
+	goal, err := request.goal(id)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "target_date must be a date in YYYY-MM-DD format")
+		return
+	}
+
+	saved, err := repos.Goals.Save(ctx, userid, goal)
+	if err == repository.ErrNotFound {
+		respondError(c, http.StatusNotFound, "Goal not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not save goal")
+		return
+	}
+
+	var before interface{}
+	if previous != nil {
+		before = *previous
+	}
+	beforeFields, afterFields := auditDiff(before, saved)
+	recordAudit(c, repository.AuditEntry{
+		Action:       AuditGoalSaved,
+		ResourceType: "goal",
+		ResourceID:   saved.ID.String(),
+		Before:       beforeFields,
+		After:        afterFields,
+	})
+
+	c.JSON(http.StatusOK, saved)
+}
+
+func deleteGoalHandler(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid goal id")
+		return
+	}
+
+	err = repos.Goals.Delete(c.Request.Context(), c.GetString("userid"), id)
+	if err == repository.ErrNotFound {
+		respondError(c, http.StatusNotFound, "Goal not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not delete goal")
+		return
+	}
+
+	recordAudit(c, repository.AuditEntry{
+		Action:       AuditGoalDeleted,
+		ResourceType: "goal",
+		ResourceID:   id.String(),
+	})
+
+	c.Status(http.StatusNoContent)
+}
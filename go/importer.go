@@ -0,0 +1,694 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/plaid/quickstart/money"
+	"github.com/plaid/quickstart/repository"
+)
+
+// Statement formats accepted by importStatement. QFX is OFX under another name.
+const (
+	importFormatCSV = "csv"
+	importFormatOFX = "ofx"
+	importFormatQIF = "qif"
+)
+
+const (
+	importMaxFileBytes = 10 << 20
+	// importTransactionPrefix starts the synthetic Plaid transaction id of
+	// imported rows; the rest is the row's fingerprint.
+	importTransactionPrefix = "import:"
+)
+
+// errInvalidStatement marks problems with the uploaded file rather than with the server.
+var errInvalidStatement = errors.New("invalid statement")
+
+// csvMapping says which CSV columns hold which transaction fields. Column
+// names are matched case-insensitively against the header row.
+type csvMapping struct {
+	Date     string `json:"date"`
+	Name     string `json:"name"`
+	Amount   string `json:"amount"`
+	Debit    string `json:"debit"`
+	Credit   string `json:"credit"`
+	Merchant string `json:"merchant"`
+	Category string `json:"category"`
+	// DateFormat is a Go time layout.
+	DateFormat string `json:"date_format"`
+	// NegateAmount is set for files that show money leaving the account as
+	// negative amounts, the opposite of Plaid.
+	NegateAmount bool `json:"negate_amount"`
+	DecimalComma bool `json:"decimal_comma"`
+}
+
+// csvPresets cover common bank exports. More can be added without a rebuild
+// through the JSON file named by IMPORT_CSV_PRESETS.
+var csvPresets = map[string]csvMapping{
+	"generic":      {Date: "Date", Name: "Description", Amount: "Amount", Category: "Category", DateFormat: "2006-01-02", NegateAmount: true},
+	"us":           {Date: "Date", Name: "Description", Amount: "Amount", Category: "Category", DateFormat: "01/02/2006", NegateAmount: true},
+	"debit_credit": {Date: "Date", Name: "Description", Debit: "Debit", Credit: "Credit", Category: "Category", DateFormat: "2006-01-02"},
+	"chase":        {Date: "Posting Date", Name: "Description", Amount: "Amount", DateFormat: "01/02/2006", NegateAmount: true},
+	"european":     {Date: "Date", Name: "Description", Amount: "Amount", Category: "Category", DateFormat: "02.01.2006", NegateAmount: true, DecimalComma: true},
+}
+
+// csvMappingOverrides are the request fields and CLI flags that replace
+// single values of the chosen preset.
+var csvMappingOverrides = []string{"date_column", "name_column", "amount_column", "debit_column", "credit_column", "merchant_column", "category_column", "date_format", "negate_amount", "decimal_comma"}
+
+// csvPreset returns the named preset with the overrides returned by get applied.
+func csvPreset(name string, get func(string) string) (csvMapping, error) {
+	if name == "" {
+		name = "generic"
+	}
+	mapping, ok := csvPresets[name]
+	if path := os.Getenv("IMPORT_CSV_PRESETS"); !ok && path != "" {
+		custom := map[string]csvMapping{}
+		raw, err := os.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(raw, &custom)
+		}
+		if err != nil {
+			// The file is the server's configuration, so its path and
+			// problems are logged rather than shown to the uploader.
+			logger("import").Error("could not read CSV presets", "path", path, "err", err)
+			return csvMapping{}, fmt.Errorf("%w: CSV preset %q could not be loaded", errInvalidStatement, name)
+		}
+		mapping, ok = custom[name]
+	}
+	if !ok {
+		return csvMapping{}, fmt.Errorf("%w: unknown CSV preset %q", errInvalidStatement, name)
+	}
+
+	columns := map[string]*string{
+		"date_column": &mapping.Date, "name_column": &mapping.Name, "amount_column": &mapping.Amount, "debit_column": &mapping.Debit,
+		"credit_column": &mapping.Credit, "merchant_column": &mapping.Merchant, "category_column": &mapping.Category, "date_format": &mapping.DateFormat,
+	}
+	flags := map[string]*bool{"negate_amount": &mapping.NegateAmount, "decimal_comma": &mapping.DecimalComma}
+	for _, field := range csvMappingOverrides {
+		v := get(field)
+		if v == "" {
+			continue
+		}
+		if dst, ok := columns[field]; ok {
+			*dst = v
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return csvMapping{}, fmt.Errorf("%w: %s must be true or false", errInvalidStatement, field)
+		}
+		*flags[field] = b
+	}
+
+	return mapping, nil
+}
+
+// importedRow is one transaction read from a statement. Amount already
+// follows the Plaid convention of positive outflows.
+type importedRow struct {
+	Line     int           `json:"line"`
+	Date     time.Time     `json:"date"`
+	Name     string        `json:"name"`
+	Merchant string        `json:"merchant,omitempty"`
+	Category string        `json:"category,omitempty"`
+	Amount   money.Decimal `json:"amount"`
+	Currency string        `json:"currency,omitempty"`
+	// FITID is the bank's own transaction id, present in OFX files.
+	FITID string `json:"fitid,omitempty"`
+}
+
+// parseImportAmount accepts currency symbols, thousands separators and
+// accounting-style parentheses for negative amounts.
+func parseImportAmount(s string, decimalComma bool) (money.Decimal, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")")
+	s = strings.Trim(s, "()")
+	s = strings.NewReplacer("$", "", "€", "", "£", "", " ", "", "\u00a0", "").Replace(s)
+	if decimalComma {
+		s = strings.ReplaceAll(s, ".", "")
+		s = strings.ReplaceAll(s, ",", ".")
+	} else {
+		s = strings.ReplaceAll(s, ",", "")
+	}
+	amount, err := money.ParseAmount(s)
+	if err != nil {
+		return money.Zero, err
+	}
+	if negative {
+		amount = amount.Neg()
+	}
+	return amount, nil
+}
+
+func parseCSVStatement(r io.Reader, mapping csvMapping) ([]importedRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing header row", errInvalidStatement)
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		name = strings.TrimPrefix(name, "\ufeff")
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	column := func(name string) int {
+		if name == "" {
+			return -1
+		}
+		if i, ok := index[strings.ToLower(name)]; ok {
+			return i
+		}
+		return -1
+	}
+
+	dateCol, nameCol, amountCol := column(mapping.Date), column(mapping.Name), column(mapping.Amount)
+	debitCol, creditCol := column(mapping.Debit), column(mapping.Credit)
+	merchantCol, categoryCol := column(mapping.Merchant), column(mapping.Category)
+	if dateCol < 0 || nameCol < 0 {
+		return nil, fmt.Errorf("%w: columns %q and %q are required", errInvalidStatement, mapping.Date, mapping.Name)
+	}
+	if amountCol < 0 && debitCol < 0 && creditCol < 0 {
+		return nil, fmt.Errorf("%w: an amount column or debit and credit columns are required", errInvalidStatement)
+	}
+
+	field := func(record []string, i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	rows := []importedRow{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidStatement, err)
+		}
+		line, _ := reader.FieldPos(0)
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+
+		row := importedRow{Line: line, Name: field(record, nameCol), Merchant: field(record, merchantCol), Category: field(record, categoryCol)}
+		row.Date, err = time.Parse(mapping.DateFormat, field(record, dateCol))
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: date %q does not match %q", errInvalidStatement, line, field(record, dateCol), mapping.DateFormat)
+		}
+
+		if amountCol >= 0 {
+			amount, err := parseImportAmount(field(record, amountCol), mapping.DecimalComma)
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: invalid amount %q", errInvalidStatement, line, field(record, amountCol))
+			}
+			if mapping.NegateAmount {
+				amount = amount.Neg()
+			}
+			row.Amount = amount
+		} else {
+			// Debits leave the account, so they are positive like Plaid outflows.
+			for _, side := range []struct {
+				col  int
+				sign int64
+			}{{debitCol, 1}, {creditCol, -1}} {
+				v := field(record, side.col)
+				if v == "" {
+					continue
+				}
+				amount, err := parseImportAmount(v, mapping.DecimalComma)
+				if err != nil {
+					return nil, fmt.Errorf("%w: line %d: invalid amount %q", errInvalidStatement, line, v)
+				}
+				row.Amount = row.Amount.Add(amount.Abs().MulInt(side.sign))
+			}
+		}
+
+		if row.Name == "" {
+			return nil, fmt.Errorf("%w: line %d: description is empty", errInvalidStatement, line)
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// ofxTag matches an OFX element in both the SGML (OFX 1.x, no closing tags on
+// values) and XML (OFX 2.x) dialects.
+var ofxTag = regexp.MustCompile(`<(/?)([A-Za-z0-9.]+)>([^<]*)`)
+
+func parseOFXStatement(r io.Reader) ([]importedRow, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []importedRow{}
+	currency := ""
+	var current *importedRow
+	var memo string
+	finish := func() error {
+		if current == nil {
+			return nil
+		}
+		if current.Name == "" {
+			current.Name = memo
+		}
+		if current.Name == "" {
+			return fmt.Errorf("%w: transaction %d has no name or memo", errInvalidStatement, len(rows)+1)
+		}
+		if current.Date.IsZero() {
+			return fmt.Errorf("%w: transaction %d has no DTPOSTED", errInvalidStatement, len(rows)+1)
+		}
+		current.Currency = currency
+		current.Line = len(rows) + 1
+		rows = append(rows, *current)
+		current = nil
+		return nil
+	}
+
+	for _, match := range ofxTag.FindAllStringSubmatch(string(data), -1) {
+		closing, tag, value := match[1] == "/", strings.ToUpper(match[2]), html.UnescapeString(strings.TrimSpace(match[3]))
+		if tag == "STMTTRN" {
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			if !closing {
+				current = &importedRow{}
+				memo = ""
+			}
+			continue
+		}
+		if closing {
+			continue
+		}
+		if tag == "CURDEF" {
+			currency = value
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		switch tag {
+		case "DTPOSTED":
+			if len(value) < 8 {
+				return nil, fmt.Errorf("%w: invalid DTPOSTED %q", errInvalidStatement, value)
+			}
+			current.Date, err = time.Parse("20060102", value[:8])
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid DTPOSTED %q", errInvalidStatement, value)
+			}
+		case "TRNAMT":
+			amount, err := parseImportAmount(value, false)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid TRNAMT %q", errInvalidStatement, value)
+			}
+			// OFX debits are negative.
+			current.Amount = amount.Neg()
+		case "FITID":
+			current.FITID = value
+		case "NAME":
+			current.Name = value
+		case "PAYEE":
+			if current.Name == "" {
+				current.Name = value
+			}
+		case "MEMO":
+			memo = value
+		}
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%w: no STMTTRN records found", errInvalidStatement)
+	}
+
+	return rows, nil
+}
+
+// qifDateLayouts cover the spellings Quicken and its imitators write, after
+// parseQIFDate has turned the apostrophe of 1/5'24 into a slash.
+var qifDateLayouts = []string{"1/2/2006", "1/2/06", "2006-01-02", "1-2-2006", "1-2-06", "1.2.2006", "1.2.06"}
+
+func parseQIFDate(s string) (time.Time, error) {
+	s = strings.ReplaceAll(strings.ReplaceAll(s, " ", ""), "'", "/")
+	for _, layout := range qifDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised date %q", s)
+}
+
+func parseQIFStatement(r io.Reader) ([]importedRow, error) {
+	scanner := bufio.NewScanner(r)
+	rows := []importedRow{}
+	var current importedRow
+	var memo string
+	started, skipping := false, false
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "!") {
+			// Only transaction lists are imported; account and category
+			// lists are skipped up to the next header.
+			header := strings.ToLower(text)
+			skipping = strings.HasPrefix(header, "!account") || strings.HasPrefix(header, "!type:cat") || strings.HasPrefix(header, "!type:class") || strings.HasPrefix(header, "!type:memorized")
+			continue
+		}
+		if skipping {
+			continue
+		}
+
+		code, value := text[0], strings.TrimSpace(text[1:])
+		if !started {
+			current = importedRow{Line: line}
+			memo = ""
+			started = true
+		}
+		switch code {
+		case 'D':
+			t, err := parseQIFDate(value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: %v", errInvalidStatement, line, err)
+			}
+			current.Date = t
+		case 'T', 'U':
+			amount, err := parseImportAmount(value, false)
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: invalid amount %q", errInvalidStatement, line, value)
+			}
+			// QIF withdrawals are negative.
+			current.Amount = amount.Neg()
+		case 'P':
+			current.Name = value
+		case 'M':
+			memo = value
+		case 'L':
+			// Transfers are written as [Account] and are not categories.
+			if !strings.HasPrefix(value, "[") {
+				current.Category = value
+			}
+		case '^':
+			if current.Name == "" {
+				current.Name = memo
+			}
+			if current.Date.IsZero() || current.Name == "" {
+				return nil, fmt.Errorf("%w: line %d: record needs a date and a payee or memo", errInvalidStatement, line)
+			}
+			rows = append(rows, current)
+			started = false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if started && !current.Date.IsZero() {
+		return nil, fmt.Errorf("%w: line %d: last record is not terminated with ^", errInvalidStatement, line)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%w: no transactions found", errInvalidStatement)
+	}
+
+	return rows, nil
+}
+
+// importFormatFor returns the explicit format, or guesses it from the file name.
+func importFormatFor(format string, filename string) (string, error) {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	}
+	switch strings.ToLower(format) {
+	case "csv":
+		return importFormatCSV, nil
+	case "ofx", "qfx":
+		return importFormatOFX, nil
+	case "qif":
+		return importFormatQIF, nil
+	}
+	return "", fmt.Errorf("%w: format must be csv, ofx, qfx or qif", errInvalidStatement)
+}
+
+// importFingerprint identifies a row across imports of overlapping files.
+// occurrence tells apart identical rows within one file, such as two equal
+// coffees on the same day.
+func importFingerprint(account string, row importedRow, occurrence int) string {
+	var key string
+	if row.FITID != "" {
+		key = fmt.Sprintf("%s|fitid|%s", strings.ToLower(account), row.FITID)
+	} else {
+		key = fmt.Sprintf("%s|%s|%s|%s|%d", strings.ToLower(account), row.Date.Format(time.DateOnly), row.Amount.StringFixed(2), strings.ToLower(row.Name), occurrence)
+	}
+	sum := sha256.Sum256([]byte(key))
+	return importTransactionPrefix + hex.EncodeToString(sum[:20])
+}
+
+type importResult struct {
+	ItemID      uuid.UUID `json:"item_id"`
+	Format      string    `json:"format"`
+	Parsed      int       `json:"parsed"`
+	Imported    int       `json:"imported"`
+	Duplicates  int       `json:"duplicates"`
+	Categorized int       `json:"categorized"`
+}
+
+// importStatement stores the transactions of a statement under the user's
+// manual item for account. Rows already imported are skipped, and new ones are
+// categorized like synced transactions.
+func importStatement(ctx context.Context, userid string, account string, format string, r io.Reader, mapping csvMapping) (importResult, error) {
+	result := importResult{Format: format}
+	account = strings.TrimSpace(account)
+	if account == "" {
+		return result, fmt.Errorf("%w: account is required", errInvalidStatement)
+	}
+
+	var rows []importedRow
+	var err error
+	switch format {
+	case importFormatCSV:
+		rows, err = parseCSVStatement(r, mapping)
+	case importFormatOFX:
+		rows, err = parseOFXStatement(r)
+	case importFormatQIF:
+		rows, err = parseQIFStatement(r)
+	default:
+		err = fmt.Errorf("%w: unknown format %q", errInvalidStatement, format)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	item, err := repos.PlaidItems.LinkManual(ctx, userid, account)
+	if err != nil {
+		return result, err
+	}
+	result.ItemID = item.ID
+
+	seen := map[string]int{}
+	transactions := make([]repository.Transaction, 0, len(rows))
+	for _, row := range rows {
+		key := fmt.Sprintf("%s|%s|%s", row.Date.Format(time.DateOnly), row.Amount.StringFixed(2), strings.ToLower(row.Name))
+		seen[key]++
+		raw, err := json.Marshal(struct {
+			Format string `json:"format"`
+			importedRow
+		}{format, row})
+		if err != nil {
+			return result, err
+		}
+
+		t := repository.Transaction{
+			UserID:             userid,
+			ItemID:             item.ID,
+			PlaidTransactionID: importFingerprint(account, row, seen[key]),
+			PlaidAccountID:     item.PlaidItemID,
+			Name:               row.Name,
+			MerchantName:       row.Merchant,
+			Amount:             row.Amount,
+			ISOCurrencyCode:    row.Currency,
+			Date:               row.Date,
+			CategoryDetailed:   row.Category,
+			Raw:                raw,
+		}
+		// Hierarchical categories such as Food:Groceries fall back to their parent.
+		if primary, _, ok := strings.Cut(row.Category, ":"); ok {
+			t.CategoryPrimary = primary
+		} else {
+			t.CategoryPrimary = row.Category
+		}
+		transactions = append(transactions, t)
+	}
+	result.Parsed = len(transactions)
+
+	inserted, err := repos.Transactions.InsertNew(ctx, transactions)
+	if err != nil {
+		return result, err
+	}
+	result.Imported = len(inserted)
+	result.Duplicates = result.Parsed - result.Imported
+	transactionsProcessed.WithLabelValues(transactionSourceImport, "added").Add(float64(result.Imported))
+
+	result.Categorized, err = categorizeTransactions(ctx, userid, transactionSourceImport, inserted)
+	if err != nil {
+		return result, err
+	}
+	checkAlerts(ctx, userid)
+	return result, nil
+}
+
+// importForm lists the multipart fields importTransactionsHandler reads,
+// for the API description.
+type importForm struct {
+	File           apiFile `form:"file" binding:"required"`
+	Account        string  `form:"account" binding:"required"`
+	Format         string  `form:"format" binding:"oneof=csv ofx qfx qif"`
+	Preset         string  `form:"preset"`
+	DateColumn     string  `form:"date_column"`
+	NameColumn     string  `form:"name_column"`
+	AmountColumn   string  `form:"amount_column"`
+	DebitColumn    string  `form:"debit_column"`
+	CreditColumn   string  `form:"credit_column"`
+	MerchantColumn string  `form:"merchant_column"`
+	CategoryColumn string  `form:"category_column"`
+	DateFormat     string  `form:"date_format"`
+	NegateAmount   bool    `form:"negate_amount"`
+	DecimalComma   bool    `form:"decimal_comma"`
+}
+
+// importTransactionsHandler imports a statement uploaded as the multipart
+// field "file". The "account" field names the manual account it belongs to;
+// "format" defaults to the file extension and CSV files take a "preset" plus
+// single-column overrides.
+func importTransactionsHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, importMaxFileBytes)
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "A statement file is required")
+		return
+	}
+	defer file.Close()
+
+	format, err := importFormatFor(c.PostForm("format"), header.Filename)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	var mapping csvMapping
+	if format == importFormatCSV {
+		mapping, err = csvPreset(c.PostForm("preset"), c.PostForm)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	result, err := importStatement(c.Request.Context(), c.GetString("userid"), c.PostForm("account"), format, file, mapping)
+	if errors.Is(err, errInvalidStatement) {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not import statement")
+		return
+	}
+
+	recordAudit(c, repository.AuditEntry{
+		Action:       AuditTransactionsImported,
+		ResourceType: "plaid_item",
+		ResourceID:   result.ItemID.String(),
+		Metadata:     auditMetadata(gin.H{"file": header.Filename, "format": format, "imported": result.Imported, "duplicates": result.Duplicates}),
+	})
+
+	c.JSON(http.StatusOK, result)
+}
+
+// runImportCommand implements `import -user NAME -account NAME [-format F]
+// [-preset P] [-date_column ...] FILE`.
+func runImportCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	username := flags.String("user", "", "username to import for")
+	account := flags.String("account", "", "manual account the statement belongs to")
+	format := flags.String("format", "", "csv, ofx, qfx or qif; defaults to the file extension")
+	preset := flags.String("preset", "generic", "CSV column mapping preset")
+	overrides := map[string]*string{}
+	for _, field := range csvMappingOverrides {
+		overrides[field] = flags.String(field, "", "override the preset's "+strings.ReplaceAll(field, "_", " "))
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || *username == "" {
+		return errors.New("usage: import -user NAME -account NAME [-format F] [-preset P] FILE")
+	}
+	path := flags.Arg(0)
+
+	user, err := repos.Users.ByUsername(ctx, *username)
+	if err != nil {
+		return fmt.Errorf("user %s: %w", *username, err)
+	}
+	importFormat, err := importFormatFor(*format, path)
+	if err != nil {
+		return err
+	}
+	var mapping csvMapping
+	if importFormat == importFormatCSV {
+		mapping, err = csvPreset(*preset, func(field string) string { return *overrides[field] })
+		if err != nil {
+			return err
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	result, err := importStatement(ctx, user.ID, *account, importFormat, file, mapping)
+	if err != nil {
+		return err
+	}
+
+	writeAudit(ctx, repository.AuditEntry{
+		SubjectID:    user.ID,
+		Action:       AuditTransactionsImported,
+		ResourceType: "plaid_item",
+		ResourceID:   result.ItemID.String(),
+		Metadata:     auditMetadata(gin.H{"file": filepath.Base(path), "format": importFormat, "imported": result.Imported, "duplicates": result.Duplicates}),
+	})
+	logger("import").InfoContext(ctx, "imported transactions", "file", path, "parsed", result.Parsed, "imported", result.Imported, "duplicates", result.Duplicates, "categorized", result.Categorized)
+	return nil
+}
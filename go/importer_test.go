@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/plaid/quickstart/money"
+	"github.com/plaid/quickstart/repository"
+)
+
+func TestImportFingerprint(t *testing.T) {
+	day := time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC)
+	coffee := importedRow{Line: 2, Date: day, Name: "Coffee", Amount: money.MustParse("3.50")}
+
+	tests := []struct {
+		name       string
+		account    string
+		row        importedRow
+		occurrence int
+		same       bool
+	}{
+		{"same row on another line", "Checking", importedRow{Line: 9, Date: day, Name: "Coffee", Amount: money.MustParse("3.50")}, 1, true},
+		{"account and name case", "CHECKING", importedRow{Date: day, Name: "COFFEE", Amount: money.MustParse("3.5")}, 1, true},
+		{"second equal row", "Checking", coffee, 2, false},
+		{"other account", "Savings", coffee, 1, false},
+		{"other day", "Checking", importedRow{Date: day.AddDate(0, 0, 1), Name: "Coffee", Amount: money.MustParse("3.50")}, 1, false},
+		{"other amount", "Checking", importedRow{Date: day, Name: "Coffee", Amount: money.MustParse("3.51")}, 1, false},
+		{"other name", "Checking", importedRow{Date: day, Name: "Tea", Amount: money.MustParse("3.50")}, 1, false},
+		{"FITID wins over the row", "Checking", importedRow{Date: day, Name: "Coffee", Amount: money.MustParse("3.50"), FITID: "abc"}, 1, false},
+	}
+	base := importFingerprint("Checking", coffee, 1)
+	if !strings.HasPrefix(base, importTransactionPrefix) {
+		t.Fatalf("fingerprint %q lacks prefix %q", base, importTransactionPrefix)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := importFingerprint(tt.account, tt.row, tt.occurrence)
+			if (got == base) != tt.same {
+				t.Errorf("fingerprint equal = %v, want %v", got == base, tt.same)
+			}
+		})
+	}
+
+	// Rows with a FITID match on it alone, whatever the bank later changed.
+	a := importFingerprint("Checking", importedRow{Date: day, Name: "Coffee", Amount: money.MustParse("3.50"), FITID: "abc"}, 1)
+	b := importFingerprint("checking", importedRow{Date: day.AddDate(0, 0, 1), Name: "COFFEE SHOP", Amount: money.MustParse("4"), FITID: "abc"}, 3)
+	if a != b {
+		t.Error("rows with the same FITID got different fingerprints")
+	}
+}
+
+func TestImportStatementSkipsOverlap(t *testing.T) {
+	ctx := context.Background()
+	db := openTestSQLite(t)
+	if err := runMigrateCommand(ctx, db, driverSQLite, []string{"up"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Seed(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	saved := repos
+	repos = repository.New(db)
+	t.Cleanup(func() { repos = saved })
+
+	header := "Date,Description,Amount,Category\n"
+	tests := []struct {
+		name           string
+		csv            string
+		wantImported   int
+		wantDuplicates int
+	}{
+		{"first file", header +
+			"2024-05-01,Rent,-1200.00,Housing\n" +
+			"2024-05-03,Coffee,-3.50,Food\n" +
+			"2024-05-03,Coffee,-3.50,Food\n", 3, 0},
+		{"same file again", header +
+			"2024-05-01,Rent,-1200.00,Housing\n" +
+			"2024-05-03,Coffee,-3.50,Food\n" +
+			"2024-05-03,Coffee,-3.50,Food\n", 0, 3},
+		{"overlapping file with a third coffee", header +
+			"2024-05-03,Coffee,-3.50,Food\n" +
+			"2024-05-03,Coffee,-3.50,Food\n" +
+			"2024-05-03,Coffee,-3.50,Food\n" +
+			"2024-05-04,Groceries,-42.10,Food\n", 2, 2},
+	}
+	for _, tt := range tests {
+		result, err := importStatement(ctx, seedUserID, "Checking", importFormatCSV, strings.NewReader(tt.csv), csvPresets["generic"])
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if result.Imported != tt.wantImported || result.Duplicates != tt.wantDuplicates {
+			t.Errorf("%s: imported %d with %d duplicates, want %d with %d", tt.name, result.Imported, result.Duplicates, tt.wantImported, tt.wantDuplicates)
+		}
+	}
+}
+
+func TestCSVPreset(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "presets.json")
+	if err := os.WriteFile(valid, []byte(`{"mybank": {"date": "Posted", "name": "Payee", "amount": "Value"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	broken := filepath.Join(dir, "broken.json")
+	if err := os.WriteFile(broken, []byte(`{`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		presets   string
+		preset    string
+		overrides map[string]string
+		wantDate  string
+		wantErr   string
+	}{
+		{"generic by default", "", "", nil, csvPresets["generic"].Date, ""},
+		{"column override", "", "chase", map[string]string{"date_column": "Day"}, "Day", ""},
+		{"custom preset", valid, "mybank", nil, "Posted", ""},
+		{"unknown preset", valid, "nobank", nil, "", `unknown CSV preset "nobank"`},
+		{"bad flag", "", "generic", map[string]string{"negate_amount": "maybe"}, "", "negate_amount must be true or false"},
+		{"missing presets file", filepath.Join(dir, "missing.json"), "mybank", nil, "", `CSV preset "mybank" could not be loaded`},
+		{"broken presets file", broken, "mybank", nil, "", `CSV preset "mybank" could not be loaded`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("IMPORT_CSV_PRESETS", tt.presets)
+			mapping, err := csvPreset(tt.preset, func(field string) string { return tt.overrides[field] })
+			if tt.wantErr != "" {
+				if !errors.Is(err, errInvalidStatement) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want an invalid statement error containing %q", err, tt.wantErr)
+				}
+				if strings.Contains(err.Error(), dir) {
+					t.Errorf("err = %v reveals the presets path", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mapping.Date != tt.wantDate {
+				t.Errorf("date column = %q, want %q", mapping.Date, tt.wantDate)
+			}
+		})
+	}
+}
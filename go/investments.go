@@ -0,0 +1,509 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	plaid "github.com/plaid/plaid-go/v31/plaid"
+	"github.com/plaid/quickstart/money"
+	"github.com/plaid/quickstart/repository"
+)
+
+const (
+	// investmentSyncInterval is how often items are checked for a sync due
+	// today. Each item is synced at most once a day, whether or not it has
+	// investments.
+	investmentSyncInterval = time.Hour
+	// investmentHistoryMonths is how far back the first sync of an item
+	// reaches; Plaid keeps 24 months of investment transactions.
+	investmentHistoryMonths = 24
+	// investmentRefetchDays is how far before the newest stored transaction
+	// later syncs start, to pick up late postings and corrections.
+	investmentRefetchDays = 30
+	investmentPageSize    = 500
+
+	portfolioDefaultDays = 365
+)
+
+const (
+	// savingsAllocationName is the bucket investment contributions count
+	// toward when no allocation is for savings.
+	savingsAllocationName       = "Savings"
+	investmentContributionsLine = "Investment contributions"
+)
+
+// isExternalFlow reports whether an investment transaction moves money into
+// or out of the account, rather than between its holdings. Such flows are
+// contributions and are left out of returns.
+func isExternalFlow(t repository.InvestmentTransaction) bool {
+	switch plaid.InvestmentTransactionType(t.Type) {
+	case plaid.INVESTMENTTRANSACTIONTYPE_TRANSFER:
+		return true
+	case plaid.INVESTMENTTRANSACTIONTYPE_CASH:
+		switch plaid.InvestmentTransactionSubtype(t.Subtype) {
+		case plaid.INVESTMENTTRANSACTIONSUBTYPE_CONTRIBUTION, plaid.INVESTMENTTRANSACTIONSUBTYPE_DEPOSIT, plaid.INVESTMENTTRANSACTIONSUBTYPE_WITHDRAWAL:
+			return true
+		}
+	}
+	return false
+}
+
+// externalFlow is the money a transaction brought into the account. Plaid
+// amounts are positive when cash leaves the account.
+func externalFlow(t repository.InvestmentTransaction) money.Decimal {
+	if !isExternalFlow(t) {
+		return money.Zero
+	}
+	return t.Amount.Neg()
+}
+
+// investmentContributions sums what the user paid into their investment
+// accounts within [from, to], net of withdrawals, in fx's currency.
+func investmentContributions(ctx context.Context, userid string, from time.Time, to time.Time, fx *fxConverter) (money.Decimal, error) {
+	transactions, err := repos.Investments.Transactions(ctx, userid, from, to)
+	if err != nil {
+		return money.Zero, err
+	}
+	total := money.Zero
+	for _, t := range transactions {
+		total = total.Add(fx.Convert(externalFlow(t), t.ISOCurrencyCode, t.Date))
+	}
+	return total.Round(money.MinorUnits(fx.Currency)), nil
+}
+
+func storedSecurities(securities []plaid.Security) []repository.Security {
+	stored := make([]repository.Security, 0, len(securities))
+	for _, s := range securities {
+		security := repository.Security{
+			ID:              s.GetSecurityId(),
+			Name:            s.GetName(),
+			TickerSymbol:    s.GetTickerSymbol(),
+			Type:            s.GetType(),
+			CashEquivalent:  s.GetIsCashEquivalent(),
+			ISOCurrencyCode: s.GetIsoCurrencyCode(),
+		}
+		if security.ISOCurrencyCode == "" {
+			security.ISOCurrencyCode = s.GetUnofficialCurrencyCode()
+		}
+		if v, ok := s.GetClosePriceOk(); ok {
+			security.ClosePrice = decimalPtr(v)
+		}
+		if v := s.GetClosePriceAsOf(); v != "" {
+			if date, err := time.Parse(time.DateOnly, v); err == nil {
+				security.ClosePriceAsOf = &date
+			}
+		}
+		stored = append(stored, security)
+	}
+	return stored
+}
+
+// storeHoldings saves a /investments/holdings/get response as the item's
+// holdings snapshot for date.
+func storeHoldings(ctx context.Context, item repository.PlaidItem, resp plaid.InvestmentsHoldingsGetResponse, date time.Time) error {
+	accounts := []repository.InvestmentAccount{}
+	for _, account := range resp.GetAccounts() {
+		if account.GetType() != plaid.ACCOUNTTYPE_INVESTMENT {
+			continue
+		}
+		balances := account.GetBalances()
+		accounts = append(accounts, repository.InvestmentAccount{
+			PlaidAccountID:  account.GetAccountId(),
+			Name:            account.GetName(),
+			Subtype:         string(account.GetSubtype()),
+			ISOCurrencyCode: balances.GetIsoCurrencyCode(),
+		})
+	}
+
+	holdings := []repository.Holding{}
+	for _, h := range resp.GetHoldings() {
+		holding := repository.Holding{
+			PlaidAccountID:  h.GetAccountId(),
+			SecurityID:      h.GetSecurityId(),
+			Quantity:        money.PreciseFromFloat(h.GetQuantity()),
+			Price:           money.FromFloat(h.GetInstitutionPrice()),
+			Value:           money.FromFloat(h.GetInstitutionValue()),
+			ISOCurrencyCode: h.GetIsoCurrencyCode(),
+		}
+		if holding.ISOCurrencyCode == "" {
+			holding.ISOCurrencyCode = h.GetUnofficialCurrencyCode()
+		}
+		if v, ok := h.GetCostBasisOk(); ok {
+			holding.CostBasis = decimalPtr(v)
+		}
+		holdings = append(holdings, holding)
+	}
+
+	return repos.Investments.SaveHoldings(ctx, item.UserID, item.ID, accounts, storedSecurities(resp.GetSecurities()), holdings, date)
+}
+
+// storeInvestmentTransactions saves a page of /investments/transactions/get.
+func storeInvestmentTransactions(ctx context.Context, item repository.PlaidItem, resp plaid.InvestmentsTransactionsGetResponse) error {
+	transactions := []repository.InvestmentTransaction{}
+	for _, t := range resp.GetInvestmentTransactions() {
+		date, err := time.Parse(time.DateOnly, t.GetDate())
+		if err != nil {
+			return err
+		}
+		transaction := repository.InvestmentTransaction{
+			ItemID:                       item.ID,
+			PlaidAccountID:               t.GetAccountId(),
+			PlaidInvestmentTransactionID: t.GetInvestmentTransactionId(),
+			SecurityID:                   t.GetSecurityId(),
+			Date:                         date,
+			Name:                         t.GetName(),
+			Type:                         string(t.GetType()),
+			Subtype:                      string(t.GetSubtype()),
+			Quantity:                     money.PreciseFromFloat(t.GetQuantity()),
+			Price:                        money.FromFloat(t.GetPrice()),
+			Amount:                       money.FromFloat(t.GetAmount()),
+			Fees:                         money.FromFloat(t.GetFees()),
+			ISOCurrencyCode:              t.GetIsoCurrencyCode(),
+		}
+		if transaction.ISOCurrencyCode == "" {
+			transaction.ISOCurrencyCode = t.GetUnofficialCurrencyCode()
+		}
+		transactions = append(transactions, transaction)
+	}
+
+	return repos.Investments.SaveTransactions(ctx, item.UserID, storedSecurities(resp.GetSecurities()), transactions)
+}
+
+// storeLiveInvestments saves what the investments endpoints fetched for the
+// signed-in user's current item. Failures are logged; the live response is
+// still served.
+func storeLiveInvestments(ctx context.Context, userid string, accessToken string, store func(repository.PlaidItem) error) {
+	item, err := currentPlaidItem(ctx, userid, accessToken)
+	if err == repository.ErrNotFound {
+		return
+	}
+	if err == nil {
+		err = store(item)
+	}
+	if err != nil {
+		logger("investments").ErrorContext(ctx, "could not store investments", "err", err)
+	}
+}
+
+// syncInvestments stores today's holdings of the item and the investment
+// transactions Plaid has for it since the last sync.
+func syncInvestments(ctx context.Context, item repository.PlaidItem, accessToken string, now time.Time) error {
+	holdingsResp, _, err := client.PlaidApi.InvestmentsHoldingsGet(ctx).InvestmentsHoldingsGetRequest(
+		*plaid.NewInvestmentsHoldingsGetRequest(accessToken),
+	).Execute()
+	if err != nil {
+		return err
+	}
+	if err := storeHoldings(ctx, item, holdingsResp, now); err != nil {
+		return err
+	}
+
+	start := now.AddDate(0, -investmentHistoryMonths, 0)
+	last, err := repos.Investments.LastTransactionDate(ctx, item.ID)
+	if err != nil {
+		return err
+	}
+	if !last.IsZero() {
+		start = last.AddDate(0, 0, -investmentRefetchDays)
+	}
+
+	for offset := 0; ; {
+		options := plaid.NewInvestmentsTransactionsGetRequestOptions()
+		options.SetCount(investmentPageSize)
+		options.SetOffset(int32(offset))
+		request := plaid.NewInvestmentsTransactionsGetRequest(accessToken, start.Format(time.DateOnly), now.Format(time.DateOnly))
+		request.SetOptions(*options)
+
+		resp, _, err := client.PlaidApi.InvestmentsTransactionsGet(ctx).InvestmentsTransactionsGetRequest(*request).Execute()
+		if err != nil {
+			return err
+		}
+		if err := storeInvestmentTransactions(ctx, item, resp); err != nil {
+			return err
+		}
+		offset += len(resp.GetInvestmentTransactions())
+		if len(resp.GetInvestmentTransactions()) == 0 || offset >= int(resp.GetTotalInvestmentTransactions()) {
+			return nil
+		}
+	}
+}
+
+// syncAllInvestments syncs every linked item not yet synced today.
+func syncAllInvestments(ctx context.Context, now time.Time) {
+	items, err := repos.Investments.ItemsToSync(ctx, now)
+	if err != nil {
+		logger("investments").ErrorContext(ctx, "could not load items to sync", "err", err)
+		return
+	}
+	for _, item := range items {
+		syncErr := ""
+		accessToken, err := tokenCipher.Decrypt(ctx, item.AccessToken, repository.ItemAccessToken, item.ID.String())
+		if err == nil {
+			err = syncInvestments(ctx, item, accessToken, now)
+			recordItemLogin(ctx, item.ID, err)
+		}
+		if err != nil {
+			// Items without the investments product fail here every day.
+			syncErr = err.Error()
+			logger("investments").WarnContext(ctx, "investment sync failed", "item_id", item.ID, "err", err)
+		}
+		if err := repos.Investments.MarkSynced(ctx, item.ID, now, syncErr); err != nil {
+			logger("investments").ErrorContext(ctx, "could not record investment sync", "item_id", item.ID, "err", err)
+		}
+	}
+}
+
+// runInvestmentSync syncs investments now and every investmentSyncInterval
+// until ctx is done. INVESTMENT_SYNC=false turns the job off.
+func runInvestmentSync(ctx context.Context) {
+	if os.Getenv("INVESTMENT_SYNC") == "false" {
+		return
+	}
+	ticker := time.NewTicker(investmentSyncInterval)
+	defer ticker.Stop()
+	for {
+		runJob(ctx, "investments", func() { syncAllInvestments(ctx, time.Now().UTC()) })
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// assetClass groups a security for the allocation breakdown.
+func assetClass(security repository.Security) string {
+	if security.CashEquivalent {
+		return "cash"
+	}
+	if security.Type == "" {
+		return "other"
+	}
+	return strings.ReplaceAll(strings.ToLower(security.Type), " ", "_")
+}
+
+type portfolioHolding struct {
+	SecurityID     string         `json:"security_id"`
+	Name           string         `json:"name"`
+	TickerSymbol   string         `json:"ticker_symbol"`
+	AssetClass     string         `json:"asset_class"`
+	Quantity       money.Precise  `json:"quantity"`
+	Price          money.Decimal  `json:"price"`
+	Value          money.Decimal  `json:"value"`
+	CostBasis      *money.Decimal `json:"cost_basis"`
+	UnrealizedGain *money.Decimal `json:"unrealized_gain"`
+}
+
+type portfolioAllocation struct {
+	AssetClass string        `json:"asset_class"`
+	Value      money.Decimal `json:"value"`
+	Share      float64       `json:"share"`
+}
+
+// portfolioSummary totals a set of holdings. Cost basis and unrealized
+// gain cover only the holdings whose cost basis is known.
+type portfolioSummary struct {
+	Value          money.Decimal         `json:"value"`
+	CostBasis      money.Decimal         `json:"cost_basis"`
+	UnrealizedGain money.Decimal         `json:"unrealized_gain"`
+	Contributions  money.Decimal         `json:"contributions"`
+	Allocation     []portfolioAllocation `json:"allocation"`
+}
+
+type portfolioAccount struct {
+	repository.InvestmentAccount
+	portfolioSummary
+	AsOf *string `json:"as_of"`
+	// TimeWeightedReturn is the return over the requested range with
+	// contributions and withdrawals taken out, or nil without two snapshots.
+	TimeWeightedReturn *float64           `json:"time_weighted_return"`
+	Holdings           []portfolioHolding `json:"holdings"`
+}
+
+// summarize totals holdings whose amounts are already rounded to places
+// fractional digits.
+func summarize(holdings []portfolioHolding, contributions money.Decimal, places int) portfolioSummary {
+	summary := portfolioSummary{Contributions: contributions.Round(places), Allocation: []portfolioAllocation{}}
+	byClass := map[string]money.Decimal{}
+	for _, h := range holdings {
+		summary.Value = summary.Value.Add(h.Value)
+		byClass[h.AssetClass] = byClass[h.AssetClass].Add(h.Value)
+		if h.CostBasis != nil {
+			summary.CostBasis = summary.CostBasis.Add(*h.CostBasis)
+			summary.UnrealizedGain = summary.UnrealizedGain.Add(*h.UnrealizedGain)
+		}
+	}
+	for class, value := range byClass {
+		allocation := portfolioAllocation{AssetClass: class, Value: value}
+		if !summary.Value.IsZero() {
+			allocation.Share = value.Div(summary.Value).Float64()
+		}
+		summary.Allocation = append(summary.Allocation, allocation)
+	}
+	sort.Slice(summary.Allocation, func(i, j int) bool {
+		return summary.Allocation[i].Value.GreaterThan(summary.Allocation[j].Value)
+	})
+	return summary
+}
+
+// timeWeightedReturn chains the growth of each period between snapshots,
+// treating the flows within a period as made at its start. values must be
+// ordered by date.
+func timeWeightedReturn(values []repository.AccountValue, transactions []repository.InvestmentTransaction) *float64 {
+	if len(values) < 2 {
+		return nil
+	}
+	growth := 1.0
+	for i := 1; i < len(values); i++ {
+		flow := money.Zero
+		for _, t := range transactions {
+			if t.Date.After(values[i-1].Date) && !t.Date.After(values[i].Date) {
+				flow = flow.Add(externalFlow(t))
+			}
+		}
+		if start := values[i-1].Value.Add(flow); start.Sign() > 0 {
+			growth *= values[i].Value.Float64() / start.Float64()
+		}
+	}
+	twr := math.Round((growth-1)*1000000) / 1000000
+	return &twr
+}
+
+type portfolioResponse struct {
+	From         string             `json:"from"`
+	To           string             `json:"to"`
+	Currency     string             `json:"currency"`
+	MissingRates []string           `json:"missing_rates"`
+	Totals       portfolioSummary   `json:"totals"`
+	Accounts     []portfolioAccount `json:"accounts"`
+}
+
+// getPortfolioHandler reports each investment account's holdings as of ?to=
+// (default today), with allocation by asset class, cost basis, unrealized
+// gain or loss, and contributions and time-weighted return over [from, to]
+// (default the last year). Amounts are in the caller's reporting currency.
+func getPortfolioHandler(c *gin.Context) {
+	from, to, ok := dateRangeParams(c)
+	if !ok {
+		return
+	}
+	if to.IsZero() {
+		now := time.Now().UTC()
+		to = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	if from.IsZero() {
+		from = to.AddDate(0, 0, 1-portfolioDefaultDays)
+	}
+	if to.Before(from) {
+		respondError(c, http.StatusBadRequest, "to must not be before from")
+		return
+	}
+
+	ctx := c.Request.Context()
+	userid := c.GetString("userid")
+	accounts, err := repos.Investments.Accounts(ctx, userid)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load investment accounts")
+		return
+	}
+	securityList, err := repos.Investments.Securities(ctx, userid)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load securities")
+		return
+	}
+	holdings, err := repos.Investments.Holdings(ctx, userid, to)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load holdings")
+		return
+	}
+	values, err := repos.Investments.AccountValues(ctx, userid, from, to)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load holdings")
+		return
+	}
+	transactions, err := repos.Investments.Transactions(ctx, userid, from, to)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load investment transactions")
+		return
+	}
+	fx, err := newFXConverter(ctx, userid, from, to)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load exchange rates")
+		return
+	}
+
+	securities := map[string]repository.Security{}
+	for _, s := range securityList {
+		securities[s.ID] = s
+	}
+	key := func(item string, account string) string { return item + "/" + account }
+
+	allHoldings := []portfolioHolding{}
+	totalContributions := money.Zero
+	places := money.MinorUnits(fx.Currency)
+	result := make([]portfolioAccount, 0, len(accounts))
+	for _, account := range accounts {
+		accountKey := key(account.ItemID.String(), account.PlaidAccountID)
+		entry := portfolioAccount{InvestmentAccount: account, Holdings: []portfolioHolding{}}
+
+		for _, h := range holdings {
+			if key(h.ItemID.String(), h.PlaidAccountID) != accountKey {
+				continue
+			}
+			asOf := h.Date.Format(time.DateOnly)
+			entry.AsOf = &asOf
+			security := securities[h.SecurityID]
+			holding := portfolioHolding{
+				SecurityID:   h.SecurityID,
+				Name:         security.Name,
+				TickerSymbol: security.TickerSymbol,
+				AssetClass:   assetClass(security),
+				Quantity:     h.Quantity,
+				Price:        fx.Convert(h.Price, h.ISOCurrencyCode, h.Date),
+				Value:        fx.Convert(h.Value, h.ISOCurrencyCode, h.Date).Round(places),
+			}
+			if h.CostBasis != nil {
+				costBasis := fx.Convert(*h.CostBasis, h.ISOCurrencyCode, h.Date).Round(places)
+				gain := holding.Value.Sub(costBasis)
+				holding.CostBasis, holding.UnrealizedGain = &costBasis, &gain
+			}
+			entry.Holdings = append(entry.Holdings, holding)
+		}
+
+		accountValues := []repository.AccountValue{}
+		for _, v := range values {
+			if key(v.ItemID.String(), v.PlaidAccountID) == accountKey {
+				accountValues = append(accountValues, v)
+			}
+		}
+		accountTransactions := []repository.InvestmentTransaction{}
+		contributions := money.Zero
+		for _, t := range transactions {
+			if key(t.ItemID.String(), t.PlaidAccountID) == accountKey {
+				accountTransactions = append(accountTransactions, t)
+				contributions = contributions.Add(fx.Convert(externalFlow(t), t.ISOCurrencyCode, t.Date))
+			}
+		}
+
+		entry.portfolioSummary = summarize(entry.Holdings, contributions, places)
+		entry.TimeWeightedReturn = timeWeightedReturn(accountValues, accountTransactions)
+		allHoldings = append(allHoldings, entry.Holdings...)
+		totalContributions = totalContributions.Add(contributions)
+		result = append(result, entry)
+	}
+
+	c.JSON(http.StatusOK, portfolioResponse{
+		From:         from.Format(time.DateOnly),
+		To:           to.Format(time.DateOnly),
+		Currency:     fx.Currency,
+		MissingRates: fx.Missing(),
+		Totals:       summarize(allHoldings, totalContributions, places),
+		Accounts:     result,
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/plaid/quickstart/money"
+	"github.com/plaid/quickstart/repository"
+)
+
+func TestTimeWeightedReturn(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	value := func(d int, v string) repository.AccountValue {
+		return repository.AccountValue{Date: day(d), Value: money.MustParse(v)}
+	}
+	flow := func(d int, kind string, subtype string, amount string) repository.InvestmentTransaction {
+		return repository.InvestmentTransaction{Date: day(d), Type: kind, Subtype: subtype, Amount: money.MustParse(amount)}
+	}
+	ptr := func(f float64) *float64 { return &f }
+
+	tests := []struct {
+		name         string
+		values       []repository.AccountValue
+		transactions []repository.InvestmentTransaction
+		want         *float64
+	}{
+		{"no snapshots", nil, nil, nil},
+		{"one snapshot", []repository.AccountValue{value(1, "100")}, nil, nil},
+		{"growth", []repository.AccountValue{value(1, "100"), value(10, "110")}, nil, ptr(0.1)},
+		{"chained periods", []repository.AccountValue{value(1, "100"), value(10, "110"), value(20, "121")}, nil, ptr(0.21)},
+		{"deposit is not growth", []repository.AccountValue{value(1, "100"), value(10, "250")},
+			[]repository.InvestmentTransaction{flow(5, "cash", "deposit", "-100")}, ptr(0.25)},
+		{"withdrawal is not a loss", []repository.AccountValue{value(1, "100"), value(10, "60")},
+			[]repository.InvestmentTransaction{flow(5, "cash", "withdrawal", "50")}, ptr(0.2)},
+		{"transfer in", []repository.AccountValue{value(1, "100"), value(10, "200")},
+			[]repository.InvestmentTransaction{flow(10, "transfer", "", "-100")}, ptr(0)},
+		{"trades are not flows", []repository.AccountValue{value(1, "100"), value(10, "90")},
+			[]repository.InvestmentTransaction{flow(5, "buy", "buy", "40"), flow(6, "cash", "dividend", "-5")}, ptr(-0.1)},
+		{"flow on the first day belongs to the period before", []repository.AccountValue{value(1, "200"), value(10, "220")},
+			[]repository.InvestmentTransaction{flow(1, "cash", "deposit", "-100")}, ptr(0.1)},
+		{"empty account is skipped", []repository.AccountValue{value(1, "0"), value(10, "100"), value(20, "150")}, nil, ptr(0.5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := timeWeightedReturn(tt.values, tt.transactions)
+			if got == nil || tt.want == nil {
+				if got != tt.want {
+					t.Errorf("timeWeightedReturn = %v, want %v", got, tt.want)
+				}
+				return
+			}
+			if *got != *tt.want {
+				t.Errorf("timeWeightedReturn = %v, want %v", *got, *tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// logSubsystems are the parts of the server with a logger of their own,
+// whose level LOG_LEVELS can set apart from LOG_LEVEL.
+var logSubsystems = []string{
+	"server", "http", "db", "migrate", "plaid", "auth", "audit", "events", "alerts", "webhooks",
+	"statements", "investments", "networth", "fx", "export", "backup", "import", "metrics",
+}
+
+// loggers holds each subsystem's logger once configureLogging has run.
+var loggers map[string]*slog.Logger
+
+// logger returns the subsystem's logger. Its records carry the subsystem
+// and, when logged with a request's context, the request and user ids.
+func logger(subsystem string) *slog.Logger {
+	if l, ok := loggers[subsystem]; ok {
+		return l
+	}
+	return slog.Default().With("subsystem", subsystem)
+}
+
+// configureLogging sets up the loggers from LOG_FORMAT, json or text, and
+// LOG_LEVEL, plus LOG_LEVELS for single subsystems, e.g.
+// LOG_LEVELS=db=debug,plaid=warn. Records go to w, as do those of the
+// standard library's log package.
+func configureLogging(w io.Writer) error {
+	level, err := parseLogLevel(envOrDefault("LOG_LEVEL", "info"))
+	if err != nil {
+		return fmt.Errorf("LOG_LEVEL: %w", err)
+	}
+	levels := map[string]slog.Level{}
+	for _, subsystem := range logSubsystems {
+		levels[subsystem] = level
+	}
+	if v := os.Getenv("LOG_LEVELS"); v != "" {
+		for _, setting := range strings.Split(v, ",") {
+			subsystem, name, ok := strings.Cut(strings.TrimSpace(setting), "=")
+			if _, known := levels[subsystem]; !ok || !known {
+				return fmt.Errorf("LOG_LEVELS: %q is not subsystem=level for one of %s", setting, strings.Join(logSubsystems, ", "))
+			}
+			if levels[subsystem], err = parseLogLevel(name); err != nil {
+				return fmt.Errorf("LOG_LEVELS: %s: %w", subsystem, err)
+			}
+		}
+	}
+
+	// Levels are filtered per subsystem, so the handler itself lets all through.
+	options := &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: redactLogAttr}
+	var base slog.Handler
+	switch format := envOrDefault("LOG_FORMAT", "json"); format {
+	case "json":
+		base = slog.NewJSONHandler(w, options)
+	case "text":
+		base = slog.NewTextHandler(w, options)
+	default:
+		return fmt.Errorf("LOG_FORMAT: unsupported format %q", format)
+	}
+
+	loggers = map[string]*slog.Logger{}
+	for subsystem, level := range levels {
+		loggers[subsystem] = slog.New(contextHandler{
+			Handler: base.WithAttrs([]slog.Attr{slog.String("subsystem", subsystem)}),
+			level:   level,
+		})
+	}
+	slog.SetDefault(slog.New(contextHandler{Handler: base, level: level}))
+	return nil
+}
+
+func parseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return level, fmt.Errorf("unknown level %q, use debug, info, warn or error", name)
+	}
+	return level, nil
+}
+
+// durationMillis is d in milliseconds, the unit durations are logged in.
+func durationMillis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// fatal logs a startup error and exits.
+func fatal(err error) {
+	logger("server").Error(err.Error())
+	os.Exit(1)
+}
+
+// contextHandler filters records below its level and adds the request and
+// user ids a request's context carries.
+type contextHandler struct {
+	slog.Handler
+	level slog.Level
+}
+
+func (h contextHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if fields, ok := ctx.Value(logContextKey{}).(logFields); ok {
+		r = r.Clone()
+		r.AddAttrs(slog.String("request_id", fields.requestID))
+		if fields.userID != "" && !hasLogAttr(r, "user_id") {
+			r.AddAttrs(slog.String("user_id", fields.userID))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func hasLogAttr(r slog.Record, key string) bool {
+	found := false
+	r.Attrs(func(a slog.Attr) bool {
+		found = a.Key == key
+		return !found
+	})
+	return found
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithGroup(name), level: h.level}
+}
+
+type logContextKey struct{}
+
+// logFields are what a request's context tells its log records.
+type logFields struct {
+	requestID string
+	userID    string
+}
+
+// withRequestID starts a request's log fields.
+func withRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, logContextKey{}, logFields{requestID: requestID})
+}
+
+// withLogUser adds the authenticated user to a request's log fields.
+func withLogUser(ctx context.Context, userid string) context.Context {
+	fields, _ := ctx.Value(logContextKey{}).(logFields)
+	fields.userID = userid
+	return context.WithValue(ctx, logContextKey{}, fields)
+}
+
+// requestIDFrom is the id of the request ctx belongs to, if any.
+func requestIDFrom(ctx context.Context) string {
+	fields, _ := ctx.Value(logContextKey{}).(logFields)
+	return fields.requestID
+}
+
+// secretLogKeys are attribute names whose values are never logged.
+var secretLogKeys = map[string]bool{
+	"password": true, "passwd": true, "secret": true, "token": true, "authorization": true,
+	"cookie": true, "code": true, "recovery_codes": true, "dsn": true,
+}
+
+// secretLogPattern matches secrets in free text: Plaid access, public and
+// link tokens, and JWTs as used for sessions and challenges.
+var secretLogPattern = regexp.MustCompile(`\b(access|public|link|user)-(sandbox|development|production)-[0-9a-zA-Z-]+|\beyJ[0-9a-zA-Z_-]+\.[0-9a-zA-Z_-]+\.[0-9a-zA-Z_-]*`)
+
+const redactedLogValue = "[REDACTED]"
+
+// redactLogAttr drops the values of secret attributes, by name, and scrubs
+// tokens out of strings and errors, including the message.
+func redactLogAttr(_ []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	if secretLogKeys[key] || strings.HasSuffix(key, "_token") || strings.HasSuffix(key, "_secret") || strings.HasSuffix(key, "password") {
+		return slog.String(a.Key, redactedLogValue)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redactSecrets(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, redactSecrets(err.Error()))
+		}
+	}
+	return a
+}
+
+func redactSecrets(s string) string {
+	return secretLogPattern.ReplaceAllString(s, redactedLogValue)
+}
+
+// AccessLogMiddleware logs each request once it is answered. The query
+// string is left out, as the event stream takes its token there.
+func AccessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logger("http").Log(c.Request.Context(), level, "request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", status,
+			"duration_ms", durationMillis(time.Since(start)),
+			"bytes", c.Writer.Size(),
+			"client_ip", c.ClientIP(),
+		)
+	}
+}
+
+// RecoveryMiddleware answers a panicking handler with a 500 and logs the
+// panic with its stack.
+func RecoveryMiddleware() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered interface{}) {
+		logger("http").ErrorContext(c.Request.Context(), "panic",
+			"err", fmt.Sprint(recovered),
+			"stack", string(debug.Stack()),
+		)
+		abortError(c, http.StatusInternalServerError, "Internal server error")
+	})
+}
+
+// runJob runs one pass of a background job. A panic, such as
+// money.ErrOverflow from one user's data, is logged like RecoveryMiddleware
+// does rather than allowed to stop the server.
+func runJob(ctx context.Context, job string, pass func()) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			logger(job).ErrorContext(ctx, "panic",
+				"err", fmt.Sprint(recovered),
+				"stack", string(debug.Stack()),
+			)
+		}
+	}()
+	pass()
+}
+
+// plaidTransport logs and measures each call to the Plaid API, logging it
+// with the id of the request that made it. Bodies carry credentials and are
+// never logged.
+type plaidTransport struct {
+	base http.RoundTripper
+}
+
+func (t plaidTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	elapsed := time.Since(start)
+
+	ctx := req.Context()
+	endpoint := req.URL.Path
+	plaidRequestDuration.WithLabelValues(endpoint).Observe(elapsed.Seconds())
+	args := []any{"method", req.Method, "path", endpoint, "duration_ms", durationMillis(elapsed)}
+	switch {
+	case err != nil && errors.Is(err, context.Canceled):
+		plaidErrors.WithLabelValues(endpoint, "CANCELED").Inc()
+	case err != nil:
+		plaidErrors.WithLabelValues(endpoint, "TRANSPORT_ERROR").Inc()
+		logger("plaid").ErrorContext(ctx, "plaid call failed", append(args, "err", err)...)
+	case resp.StatusCode >= http.StatusBadRequest:
+		code := plaidResponseErrorCode(resp)
+		plaidErrors.WithLabelValues(endpoint, code).Inc()
+		logger("plaid").WarnContext(ctx, "plaid call", append(args, "status", resp.StatusCode, "error_code", code)...)
+	default:
+		logger("plaid").DebugContext(ctx, "plaid call", append(args, "status", resp.StatusCode)...)
+	}
+	return resp, err
+}
+
+// plaidResponseErrorCode reads the error_code of a Plaid error response,
+// leaving the body for the client to read again. Responses without one
+// are told apart by their status.
+func plaidResponseErrorCode(resp *http.Response) string {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+
+	var plaidErr struct {
+		ErrorCode string `json:"error_code"`
+	}
+	if err != nil || json.Unmarshal(body, &plaidErr) != nil || plaidErr.ErrorCode == "" {
+		return "HTTP_" + strconv.Itoa(resp.StatusCode)
+	}
+	return plaidErr.ErrorCode
+}
+
+// requestContext is the context for a handler's Plaid and database calls:
+// it carries the request's log fields but not its cancellation, so what a
+// handler stores from Plaid is not lost when the client goes away.
+func requestContext(c *gin.Context) context.Context {
+	return context.WithoutCancel(c.Request.Context())
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Sources of stored transactions, for the metrics.
+const (
+	transactionSourceSync   = "sync"
+	transactionSourceImport = "import"
+)
+
+// metricsRegistry holds everything /metrics serves. It is not the default
+// registry, so libraries cannot add series behind our back.
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	httpRequestDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "smartsplit_http_request_duration_seconds",
+		Help:    "Time to answer HTTP requests, by route and status.",
+		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"method", "route", "status"})
+	plaidRequestDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "smartsplit_plaid_request_duration_seconds",
+		Help:    "Latency of Plaid API calls, by endpoint.",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"endpoint"})
+	plaidErrors = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "smartsplit_plaid_errors_total",
+		Help: "Failed Plaid API calls, by endpoint and Plaid error_code.",
+	}, []string{"endpoint", "error_code"})
+	itemSyncLag = promauto.With(metricsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "smartsplit_item_sync_lag_seconds",
+		Help: "Time since each linked item's transactions were last synced, or since it was linked if never.",
+	}, []string{"item_id"})
+	transactionsProcessed = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "smartsplit_transactions_processed_total",
+		Help: "Transactions stored or removed, by source and change.",
+	}, []string{"source", "change"})
+	categorizations = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "smartsplit_categorizations_total",
+		Help: "Uncategorized outflows given to automatic categorization, by source and whether an expense matched.",
+	}, []string{"source", "result"})
+	jobQueueDepth = promauto.With(metricsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "smartsplit_job_queue_depth",
+		Help: "Deliveries queued for the background jobs, due or not.",
+	}, []string{"queue"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(dbStatsCollector{})
+}
+
+// dbStatsCollector reports the database pool's statistics as they are at
+// each scrape. The pool keeps its own counters, so they are reported as
+// constant metrics rather than counted here.
+type dbStatsCollector struct{}
+
+var (
+	dbConnectionsDesc = prometheus.NewDesc("smartsplit_db_connections",
+		"Connections in the database pool, by state.", []string{"state"}, nil)
+	dbMaxOpenConnectionsDesc = prometheus.NewDesc("smartsplit_db_max_open_connections",
+		"The pool's limit on open connections; 0 is unlimited.", nil, nil)
+	dbWaitsDesc = prometheus.NewDesc("smartsplit_db_waits_total",
+		"Times a query waited for a free connection.", nil, nil)
+	dbWaitDurationDesc = prometheus.NewDesc("smartsplit_db_wait_seconds_total",
+		"Time queries spent waiting for a free connection.", nil, nil)
+	dbClosedConnectionsDesc = prometheus.NewDesc("smartsplit_db_closed_connections_total",
+		"Connections the pool closed, by reason.", []string{"reason"}, nil)
+)
+
+func (dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dbConnectionsDesc
+	ch <- dbMaxOpenConnectionsDesc
+	ch <- dbWaitsDesc
+	ch <- dbWaitDurationDesc
+	ch <- dbClosedConnectionsDesc
+}
+
+func (dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	if DB == nil {
+		return
+	}
+	stats := DB.Stats()
+	ch <- prometheus.MustNewConstMetric(dbConnectionsDesc, prometheus.GaugeValue, float64(stats.InUse), "in_use")
+	ch <- prometheus.MustNewConstMetric(dbConnectionsDesc, prometheus.GaugeValue, float64(stats.Idle), "idle")
+	ch <- prometheus.MustNewConstMetric(dbMaxOpenConnectionsDesc, prometheus.GaugeValue, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(dbWaitsDesc, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(dbWaitDurationDesc, prometheus.CounterValue, stats.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(dbClosedConnectionsDesc, prometheus.CounterValue, float64(stats.MaxIdleClosed), "max_idle")
+	ch <- prometheus.MustNewConstMetric(dbClosedConnectionsDesc, prometheus.CounterValue, float64(stats.MaxIdleTimeClosed), "max_idle_time")
+	ch <- prometheus.MustNewConstMetric(dbClosedConnectionsDesc, prometheus.CounterValue, float64(stats.MaxLifetimeClosed), "max_lifetime")
+}
+
+// collectMetrics sets the metrics read from the database rather than
+// counted as things happen. A failed query leaves its metric as the last
+// scrape set it.
+func collectMetrics(ctx context.Context, now time.Time) {
+	if items, err := repos.PlaidItems.Linked(ctx); err != nil {
+		logger("metrics").WarnContext(ctx, "could not collect sync lag", "err", err)
+	} else {
+		itemSyncLag.Reset()
+		for _, item := range items {
+			synced := item.CreatedAt
+			if item.LastSyncedAt != nil {
+				synced = *item.LastSyncedAt
+			}
+			itemSyncLag.WithLabelValues(item.ID.String()).Set(now.Sub(synced).Seconds())
+		}
+	}
+
+	if n, err := repos.Alerts.QueuedDeliveries(ctx, alertMaxAttempts); err != nil {
+		logger("metrics").WarnContext(ctx, "could not collect alert queue depth", "err", err)
+	} else {
+		jobQueueDepth.WithLabelValues("alert_deliveries").Set(float64(n))
+	}
+	if n, err := repos.Webhooks.QueuedDeliveries(ctx); err != nil {
+		logger("metrics").WarnContext(ctx, "could not collect webhook queue depth", "err", err)
+	} else {
+		jobQueueDepth.WithLabelValues("webhook_deliveries").Set(float64(n))
+	}
+}
+
+// loadMetricsToken reads METRICS_TOKEN. The metrics name every linked item
+// and route, so only the sandbox may leave them public.
+func loadMetricsToken(plaidEnv string) (string, error) {
+	token := os.Getenv("METRICS_TOKEN")
+	if token == "" && plaidEnv != "sandbox" {
+		return "", fmt.Errorf("METRICS_TOKEN is not set: /metrics may only be public with PLAID_ENV=sandbox, not %s", plaidEnv)
+	}
+	return token, nil
+}
+
+// metricsHandler serves the metrics to Prometheus. With a token, scrapes
+// must send it as a bearer token.
+func metricsHandler(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token != "" {
+			given := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				respondError(c, http.StatusUnauthorized, "Invalid metrics token")
+				return
+			}
+		}
+
+		collectMetrics(c.Request.Context(), time.Now().UTC())
+		metricsExposition.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// metricsExposition writes metricsRegistry in whichever format the scraper
+// asks for.
+var metricsExposition = promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+
+// MetricsMiddleware times each request by its route. Requests matching no
+// route share one label so stray paths cannot add series.
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequestDuration.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Observe(time.Since(start).Seconds())
+	}
+}
+
+// countCategorizations records how many of the eligible transactions from
+// source matched an expense.
+func countCategorizations(source string, eligible int, matched int) {
+	if matched > 0 {
+		categorizations.WithLabelValues(source, "hit").Add(float64(matched))
+	}
+	if eligible > matched {
+		categorizations.WithLabelValues(source, "miss").Add(float64(eligible - matched))
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/plaid/quickstart/repository"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLoadMetricsToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		plaidEnv string
+		token    string
+		wantErr  bool
+	}{
+		{"sandbox without token", "sandbox", "", false},
+		{"sandbox with token", "sandbox", "scrape", false},
+		{"production without token", "production", "", true},
+		{"production with token", "production", "scrape", false},
+		{"development without token", "development", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("METRICS_TOKEN", tt.token)
+			token, err := loadMetricsToken(tt.plaidEnv)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && token != tt.token {
+				t.Errorf("token = %q, want %q", token, tt.token)
+			}
+		})
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := openTestSQLite(t)
+	if err := runMigrateCommand(context.Background(), db, driverSQLite, []string{"up"}); err != nil {
+		t.Fatal(err)
+	}
+	savedDB, savedRepos := DB, repos
+	DB, repos = db, repository.New(db)
+	t.Cleanup(func() { DB, repos = savedDB, savedRepos })
+
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		want   int
+	}{
+		{"no token configured", "", "", http.StatusOK},
+		{"missing bearer", "scrape", "", http.StatusUnauthorized},
+		{"wrong bearer", "scrape", "Bearer other", http.StatusUnauthorized},
+		{"right bearer", "scrape", "Bearer scrape", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/metrics", metricsHandler(tt.token))
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if w.Code == http.StatusOK && !strings.Contains(w.Body.String(), `smartsplit_db_connections{state="idle"}`) {
+				t.Errorf("body lacks the pool metrics:\n%s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCountCategorizations(t *testing.T) {
+	hits, misses := categorizations.WithLabelValues("test", "hit"), categorizations.WithLabelValues("test", "miss")
+	before := [2]float64{testutil.ToFloat64(hits), testutil.ToFloat64(misses)}
+	countCategorizations("test", 5, 2)
+	countCategorizations("test", 3, 3)
+	if got := testutil.ToFloat64(hits) - before[0]; got != 5 {
+		t.Errorf("hits = %v, want 5", got)
+	}
+	if got := testutil.ToFloat64(misses) - before[1]; got != 3 {
+		t.Errorf("misses = %v, want 3", got)
+	}
+}
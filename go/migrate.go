@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/plaid/quickstart/repository"
+)
+
+// Each database driver has its own directory of migrations; they must keep
+// the same versions and names so the schemas stay equivalent.
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+//go:embed seeds/*.sql
+var seedFiles embed.FS
+
+// migrationLockID is the pg_advisory_lock key that keeps two instances
+// starting at once from running migrations concurrently.
+const migrationLockID = 727361
+
+// Migration is a pair of NNNN_name.up.sql / NNNN_name.down.sql files.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// AppliedMigration is a row of schema_migrations.
+type AppliedMigration struct {
+	Version   int64
+	Name      string
+	AppliedAt time.Time
+}
+
+// loadMigrations reads the migrations in dir ordered by version.
+func loadMigrations(files fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		versionPart, label, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s must be named NNNN_name.%s.sql", name, direction)
+		}
+		version, err := strconv.ParseInt(versionPart, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has an invalid version", name)
+		}
+
+		contents, err := fs.ReadFile(files, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		}
+		if m.Name != label {
+			return nil, fmt.Errorf("migration %d has mismatched names %q and %q", version, m.Name, label)
+		}
+		if direction == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Migrator applies embedded migrations and records them in schema_migrations.
+type Migrator struct {
+	db         *sql.DB
+	driver     string
+	migrations []Migration
+}
+
+func NewMigrator(db *sql.DB, driver string) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFiles, path.Join("migrations", driver))
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, driver: driver, migrations: migrations}, nil
+}
+
+func (m *Migrator) ensureTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name varchar(255) NOT NULL,
+		applied_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	return err
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) ([]AppliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, name, applied_at FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := []AppliedMigration{}
+	for rows.Next() {
+		var a AppliedMigration
+		if err := rows.Scan(&a.Version, &a.Name, &a.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied = append(applied, a)
+	}
+	return applied, rows.Err()
+}
+
+// withLock runs fn on a dedicated connection holding the migration lock.
+// SQLite needs no lock: its write transactions already exclude each other.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.driver == driverPostgres {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+			return err
+		}
+		defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+	}
+
+	if err := m.ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// Up applies pending migrations in order. limit <= 0 applies all of them.
+func (m *Migrator) Up(ctx context.Context, limit int) ([]Migration, error) {
+	var ran []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		done := map[int64]bool{}
+		for _, a := range applied {
+			done[a.Version] = true
+		}
+
+		for _, migration := range m.migrations {
+			if done[migration.Version] {
+				continue
+			}
+			if limit > 0 && len(ran) == limit {
+				break
+			}
+			if err := m.run(ctx, conn, migration, migration.Up, true); err != nil {
+				return err
+			}
+			ran = append(ran, migration)
+		}
+		return nil
+	})
+
+	return ran, err
+}
+
+// Down reverts the most recently applied migrations. limit <= 0 reverts one.
+func (m *Migrator) Down(ctx context.Context, limit int) ([]Migration, error) {
+	if limit <= 0 {
+		limit = 1
+	}
+
+	var ran []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		known := map[int64]Migration{}
+		for _, migration := range m.migrations {
+			known[migration.Version] = migration
+		}
+
+		for i := len(applied) - 1; i >= 0 && len(ran) < limit; i-- {
+			migration, ok := known[applied[i].Version]
+			if !ok {
+				return fmt.Errorf("migration %d is applied but not embedded in this binary", applied[i].Version)
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down file", migration.Version, migration.Name)
+			}
+			if err := m.run(ctx, conn, migration, migration.Down, false); err != nil {
+				return err
+			}
+			ran = append(ran, migration)
+		}
+		return nil
+	})
+
+	return ran, err
+}
+
+// run executes one migration and its schema_migrations bookkeeping in a single transaction.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, migration Migration, script string, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+	if up {
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+	} else {
+		_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Status lists every embedded migration with the time it was applied, if it was.
+func (m *Migrator) Status(ctx context.Context) ([]Migration, map[int64]time.Time, error) {
+	appliedAt := map[int64]time.Time{}
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, a := range applied {
+			appliedAt[a.Version] = a.AppliedAt
+		}
+		return nil
+	})
+
+	return m.migrations, appliedAt, err
+}
+
+// Seed loads the embedded development data. Every statement must be idempotent.
+func Seed(ctx context.Context, db *sql.DB) error {
+	script, err := fs.ReadFile(seedFiles, "seeds/seed.sql")
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Seeded rows hold no access token, as it can only be encrypted with the
+// deployment's keys; seedAccessTokens stores this sandbox token for them.
+const (
+	seedAccessToken = "access-sandbox-5423b0c9-2019-4f5e-bddd-2b41e52e5651"
+	seedUserID      = "ed1bec4c-0a1b-4783-b47f-16ba0650b821"
+	seedItemID      = "5d3c1f0e-7b7a-4c53-9a8e-2f4b6c1d9e01"
+)
+
+// seedAccessTokens encrypts the sandbox token into the seeded user and
+// item. Rows that already hold a token are left alone.
+func seedAccessTokens(ctx context.Context, cipher *TokenCipher, secrets repository.SecretRepo) error {
+	for _, row := range []repository.StoredSecret{
+		{Column: repository.UserAccessToken, ID: seedUserID},
+		{Column: repository.ItemAccessToken, ID: seedItemID},
+	} {
+		encrypted, err := cipher.Encrypt(ctx, seedAccessToken, row.Column, row.ID)
+		if err != nil {
+			return err
+		}
+		if err := secrets.Replace(ctx, row, encrypted); err != nil && err != repository.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// runMigrateCommand implements `migrate up [n]`, `migrate down [n]` and `migrate status`.
+func runMigrateCommand(ctx context.Context, db *sql.DB, driver string, args []string) error {
+	migrator, err := NewMigrator(db, driver)
+	if err != nil {
+		return err
+	}
+
+	direction := "up"
+	if len(args) > 0 {
+		direction = args[0]
+	}
+	limit := 0
+	if len(args) > 1 {
+		limit, err = strconv.Atoi(args[1])
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("migrate %s: step count must be a positive integer", direction)
+		}
+	}
+
+	switch direction {
+	case "up":
+		ran, err := migrator.Up(ctx, limit)
+		for _, migration := range ran {
+			logger("migrate").InfoContext(ctx, "applied migration", "version", migration.Version, "name", migration.Name)
+		}
+		if err == nil && len(ran) == 0 {
+			logger("migrate").InfoContext(ctx, "database schema is up to date")
+		}
+		return err
+	case "down":
+		ran, err := migrator.Down(ctx, limit)
+		for _, migration := range ran {
+			logger("migrate").InfoContext(ctx, "reverted migration", "version", migration.Version, "name", migration.Name)
+		}
+		return err
+	case "status":
+		migrations, appliedAt, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			status := "pending"
+			if at, ok := appliedAt[migration.Version]; ok {
+				status = "applied " + at.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", migration.Version, migration.Name, status)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q: use up, down or status", direction)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/plaid/quickstart/repository"
+)
+
+// openTestSQLite opens an empty in-memory SQLite database. Every connection
+// to :memory: is a database of its own, so the pool is kept to one.
+func openTestSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open(driverSQLite, sqliteDSN(":memory:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// sqliteSchema is the definition of every table, index and trigger, less
+// the migrations' own bookkeeping.
+func sqliteSchema(t *testing.T, db *sql.DB) string {
+	t.Helper()
+	rows, err := db.Query(`SELECT type, name, COALESCE(sql, '') FROM sqlite_master
+		WHERE name NOT LIKE 'sqlite_%' AND tbl_name <> 'schema_migrations' ORDER BY type, name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var schema []string
+	for rows.Next() {
+		var kind, name, definition string
+		if err := rows.Scan(&kind, &name, &definition); err != nil {
+			t.Fatal(err)
+		}
+		schema = append(schema, kind+" "+name+": "+definition)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(schema, "\n")
+}
+
+func TestSQLiteMigrationsRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	db := openTestSQLite(t)
+	migrator, err := NewMigrator(db, driverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each migration is applied, reverted and applied again: the revert must
+	// restore the schema before it and the reapply the schema after it.
+	for _, migration := range migrator.migrations {
+		before := sqliteSchema(t, db)
+		if ran, err := migrator.Up(ctx, 1); err != nil || len(ran) != 1 || ran[0].Version != migration.Version {
+			t.Fatalf("up %d_%s: ran %v, err %v", migration.Version, migration.Name, ran, err)
+		}
+		after := sqliteSchema(t, db)
+
+		if _, err := migrator.Down(ctx, 1); err != nil {
+			t.Fatalf("down %d_%s: %v", migration.Version, migration.Name, err)
+		}
+		if got := sqliteSchema(t, db); got != before {
+			t.Errorf("down %d_%s left\n%s\nwant\n%s", migration.Version, migration.Name, got, before)
+		}
+
+		if _, err := migrator.Up(ctx, 1); err != nil {
+			t.Fatalf("reapply %d_%s: %v", migration.Version, migration.Name, err)
+		}
+		if got := sqliteSchema(t, db); got != after {
+			t.Errorf("reapply %d_%s gave\n%s\nwant\n%s", migration.Version, migration.Name, got, after)
+		}
+	}
+
+	if err := Seed(ctx, db); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	if _, err := migrator.Down(ctx, len(migrator.migrations)); err != nil {
+		t.Fatalf("down all: %v", err)
+	}
+	if got := sqliteSchema(t, db); got != "" {
+		t.Errorf("down all left\n%s", got)
+	}
+}
+
+func TestMigrationsMatchBetweenDrivers(t *testing.T) {
+	postgres, err := loadMigrations(migrationFiles, "migrations/"+driverPostgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlite, err := loadMigrations(migrationFiles, "migrations/"+driverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(postgres) != len(sqlite) {
+		t.Fatalf("postgres has %d migrations, sqlite %d", len(postgres), len(sqlite))
+	}
+	for i := range postgres {
+		if postgres[i].Version != sqlite[i].Version || postgres[i].Name != sqlite[i].Name {
+			t.Errorf("migration %d: postgres %d_%s, sqlite %d_%s", i, postgres[i].Version, postgres[i].Name, sqlite[i].Version, sqlite[i].Name)
+		}
+		if postgres[i].Down == "" || sqlite[i].Down == "" {
+			t.Errorf("migration %d_%s has no down file", postgres[i].Version, postgres[i].Name)
+		}
+	}
+}
+
+func TestSeedEncryptsAccessTokens(t *testing.T) {
+	ctx := context.Background()
+	db := openTestSQLite(t)
+	if err := runMigrateCommand(ctx, db, driverSQLite, []string{"up"}); err != nil {
+		t.Fatal(err)
+	}
+	cipher := &TokenCipher{keys: testKeyProvider("k1", "k1")}
+	secrets := repository.New(db).Secrets
+
+	// Seeding twice keeps the tokens of the first run.
+	var first map[string]string
+	for run := 0; run < 2; run++ {
+		if err := Seed(ctx, db); err != nil {
+			t.Fatal(err)
+		}
+		if err := seedAccessTokens(ctx, cipher, secrets); err != nil {
+			t.Fatal(err)
+		}
+
+		stored := map[string]string{}
+		for _, column := range []repository.SecretColumn{repository.UserAccessToken, repository.ItemAccessToken} {
+			rows, err := secrets.Stored(ctx, column)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != 1 {
+				t.Fatalf("%s.%s has %d tokens, want 1", column.Table, column.Column, len(rows))
+			}
+			token, err := cipher.Decrypt(ctx, rows[0].Value, column, rows[0].ID)
+			if err != nil || token != seedAccessToken {
+				t.Errorf("%s.%s decrypts to %q, %v", column.Table, column.Column, token, err)
+			}
+			stored[column.Table] = rows[0].Value
+		}
+		if first == nil {
+			first = stored
+		} else if stored["Users"] != first["Users"] || stored["PlaidItem"] != first["PlaidItem"] {
+			t.Errorf("seeding again replaced the tokens")
+		}
+	}
+}
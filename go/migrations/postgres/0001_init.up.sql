@@ -0,0 +1,89 @@
+CREATE EXTENSION IF NOT EXISTS "pgcrypto";
+
+CREATE TABLE IF NOT EXISTS "Users" (
+  "user_id" UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  "username" varchar(255) NOT NULL,
+  "email" varchar(255) NOT NULL,
+  "password_hash" varchar(255) NOT NULL,
+  "plaid_access_token" varchar(255) NULL,
+  "created_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "updated_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS "PlaidItem" (
+  "item_id" UUID PRIMARY KEY DEFAULT gen_random_uuid(), --  
+  "user_id" UUID NOT NULL REFERENCES "Users"("user_id") ON DELETE CASCADE,
+  "plaid_item_id" varchar(255) NOT NULL,
+  "plaid_access_token" varchar(255) NOT NULL,
+  "institution_name" varchar(255),
+  "created_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "updated_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "sync_cursor" varchar(255),
+  "last_synced_at" timestamp
+);
+
+CREATE TABLE IF NOT EXISTS "TransactionRaw" (
+  "transaction_id" UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  "user_id" UUID NOT NULL REFERENCES "Users"("user_id") ON DELETE CASCADE,
+  "item_id" UUID NOT NULL REFERENCES "PlaidItem"("item_id") ON DELETE CASCADE,
+  "plaid_transaction_id" varchar(255) NOT NULL,
+  "plaid_account_id" varchar(255) NOT NULL,
+  UNIQUE("user_id", "plaid_transaction_id"),   
+  "name" varchar(255) NOT NULL,                
+  "amount" decimal NOT NULL,                  
+  "iso_currency_code" varchar(10),
+  "date" date NOT NULL,
+  "pending" boolean DEFAULT false,
+  "plaid_category" jsonb,
+  "personal_finance_category" jsonb,
+  "raw" jsonb,
+  "created_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "updated_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+;
+
+CREATE TABLE IF NOT EXISTS "Allocations" (
+  "allocation_type" UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  "allocation_description" varchar(255) NOT NULL,
+  "allocation_factor" decimal NOT NULL,
+  "user_id" UUID NOT NULL REFERENCES "Users"("user_id")
+);
+
+CREATE TABLE IF NOT EXISTS "Category" (
+  "category_id" UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  "plaid_category_primary_descriptor" varchar(255) NOT NULL,
+  "plaid_category_detailed_descriptor" varchar(255) NOT NULL,
+  "category_name" varchar(255) NOT NULL,
+  "category_description" varchar(255) NOT NULL,
+  "created_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "updated_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS "Expenses" (
+  "expense_id" UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  "expense_description" varchar(255) NOT NULL,
+  "expense_amount" decimal NOT NULL,
+  "expense_category" UUID NOT NULL REFERENCES "Category"("category_id"),
+  "user_id" UUID NOT NULL REFERENCES "Users"("user_id"),
+  "allocation_type" UUID NOT NULL REFERENCES "Allocations"("allocation_type"),
+  "created_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "updated_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+
+CREATE TABLE IF NOT EXISTS "TransactionExpense" (
+   "transcation_id" UUID PRIMARY KEY REFERENCES "TransactionRaw"("transaction_id") ON DELETE CASCADE,
+   "expense_id" UUID NOT NULL REFERENCES "Expenses"("expense_id") ON DELETE CASCADE,
+   "confidence" decimal,
+   "source" varchar(50) NOT NULL -- 'rule', 'manual', 'pfcat'
+);
+
+CREATE TABLE IF NOT EXISTS "Income" (
+  "income_id" UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  "income_description" varchar(255) NOT NULL,
+  "income_amount" decimal NOT NULL,
+  "income_frequency" varchar(255) NOT NULL,
+  "user_id" UUID NOT NULL REFERENCES "Users"("user_id"),
+  "created_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "updated_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
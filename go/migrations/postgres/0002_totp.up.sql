@@ -0,0 +1,14 @@
+-- Optional TOTP two-factor authentication and its single-use recovery codes.
+-- totp_last_step is the time step of the last code accepted, so that each
+-- code is accepted once.
+ALTER TABLE "Users" ADD COLUMN IF NOT EXISTS "totp_secret" varchar(255) NULL;
+ALTER TABLE "Users" ADD COLUMN IF NOT EXISTS "totp_enabled" boolean NOT NULL DEFAULT false;
+ALTER TABLE "Users" ADD COLUMN IF NOT EXISTS "totp_last_step" bigint;
+
+CREATE TABLE IF NOT EXISTS "RecoveryCode" (
+  "recovery_code_id" UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  "user_id" UUID NOT NULL REFERENCES "Users"("user_id") ON DELETE CASCADE,
+  "code_hash" varchar(255) NOT NULL,
+  "used_at" timestamp,
+  "created_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS "LoginFailure";
+DROP TABLE IF EXISTS "RateLimitBucket";
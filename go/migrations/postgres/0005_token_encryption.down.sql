@@ -0,0 +1,4 @@
+-- Fails if encrypted tokens are still stored; decrypt them first.
+ALTER TABLE "Users" ALTER COLUMN "totp_secret" TYPE varchar(255);
+ALTER TABLE "PlaidItem" ALTER COLUMN "plaid_access_token" TYPE varchar(255);
+ALTER TABLE "Users" ALTER COLUMN "plaid_access_token" TYPE varchar(255);
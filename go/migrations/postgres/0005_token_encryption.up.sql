@@ -0,0 +1,5 @@
+-- Encrypted access tokens and TOTP secrets are longer than the plaintext
+-- ones. Encrypt existing ones with `rotate-token-keys -migrate-legacy`.
+ALTER TABLE "Users" ALTER COLUMN "plaid_access_token" TYPE text;
+ALTER TABLE "PlaidItem" ALTER COLUMN "plaid_access_token" TYPE text;
+ALTER TABLE "Users" ALTER COLUMN "totp_secret" TYPE text;
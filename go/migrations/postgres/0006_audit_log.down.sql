@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS "AuditLog_append_only" ON "AuditLog";
+DROP FUNCTION IF EXISTS audit_log_append_only();
+DROP TABLE IF EXISTS "AuditLog";
@@ -0,0 +1,29 @@
+-- Append-only: rows reference users by id without a foreign key so that
+-- deleting a user never rewrites their history.
+CREATE TABLE IF NOT EXISTS "AuditLog" (
+  "audit_id" UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  "occurred_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "actor_user_id" UUID,
+  "subject_user_id" UUID,
+  "action" varchar(100) NOT NULL,
+  "resource_type" varchar(100),
+  "resource_id" varchar(255),
+  "ip" varchar(64),
+  "request_id" varchar(64),
+  "before" jsonb,
+  "after" jsonb,
+  "metadata" jsonb
+);
+
+CREATE INDEX IF NOT EXISTS "AuditLog_actor_idx" ON "AuditLog" ("actor_user_id", "occurred_at");
+CREATE INDEX IF NOT EXISTS "AuditLog_subject_idx" ON "AuditLog" ("subject_user_id", "occurred_at");
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'AuditLog is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER "AuditLog_append_only"
+  BEFORE UPDATE OR DELETE ON "AuditLog"
+  FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
@@ -0,0 +1,31 @@
+-- Savings goals and per-period notes shown on monthly statements.
+CREATE TABLE IF NOT EXISTS "Goal" (
+  "goal_id" UUID PRIMARY KEY,
+  "user_id" UUID NOT NULL REFERENCES "Users"("user_id") ON DELETE CASCADE,
+  "name" varchar(255) NOT NULL,
+  "target_amount" decimal NOT NULL,
+  "saved_amount" decimal NOT NULL DEFAULT 0,
+  "target_date" date,
+  "created_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "updated_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS "StatementNote" (
+  "user_id" UUID NOT NULL REFERENCES "Users"("user_id") ON DELETE CASCADE,
+  "period" varchar(7) NOT NULL, -- YYYY-MM
+  "body" text NOT NULL,
+  "updated_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY ("user_id", "period")
+);
+
+-- One row per statement emailed at period close, so each is sent once.
+CREATE TABLE IF NOT EXISTS "StatementDelivery" (
+  "user_id" UUID NOT NULL REFERENCES "Users"("user_id") ON DELETE CASCADE,
+  "period" varchar(7) NOT NULL,
+  "sent_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY ("user_id", "period")
+);
+
+ALTER TABLE "Users" ADD COLUMN IF NOT EXISTS "statement_email" boolean NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS "Goal_user_idx" ON "Goal" ("user_id");
@@ -0,0 +1,3 @@
+ALTER TABLE "Users" DROP COLUMN IF EXISTS "reporting_currency";
+
+DROP TABLE IF EXISTS "FXRate";
@@ -0,0 +1,14 @@
+-- Exchange rates as units of currency per one unit of base_currency, from
+-- rate_date until the pair's next row.
+CREATE TABLE IF NOT EXISTS "FXRate" (
+  "base_currency" varchar(10) NOT NULL,
+  "currency" varchar(10) NOT NULL,
+  "rate_date" date NOT NULL,
+  "rate" decimal NOT NULL,
+  "source" varchar(50) NOT NULL,
+  "created_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY ("base_currency", "currency", "rate_date")
+);
+
+-- The currency totals are reported in; NULL uses the server default.
+ALTER TABLE "Users" ADD COLUMN IF NOT EXISTS "reporting_currency" varchar(10);
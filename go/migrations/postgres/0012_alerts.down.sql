@@ -0,0 +1,6 @@
+ALTER TABLE "PlaidItem" DROP COLUMN IF EXISTS "login_required_at";
+
+DROP TABLE IF EXISTS "AlertSettings";
+DROP TABLE IF EXISTS "AlertDelivery";
+DROP TABLE IF EXISTS "Alert";
+DROP TABLE IF EXISTS "AlertRule";
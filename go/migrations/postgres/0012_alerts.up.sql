@@ -0,0 +1,58 @@
+-- User-defined alert rules, the alerts they fire, which double as the in-app
+-- inbox, and the deliveries of those alerts to email and webhooks.
+CREATE TABLE IF NOT EXISTS "AlertRule" (
+  "rule_id" UUID PRIMARY KEY,
+  "user_id" UUID NOT NULL REFERENCES "Users"("user_id") ON DELETE CASCADE,
+  "kind" varchar(50) NOT NULL,
+  "name" varchar(255) NOT NULL,
+  "target" varchar(255), -- expense id or allocation type of percent-of-plan rules
+  "threshold" decimal NOT NULL DEFAULT 0,
+  "channels" varchar(255) NOT NULL, -- comma-separated
+  "enabled" boolean NOT NULL DEFAULT true,
+  "created_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "updated_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- dedupe_key names the condition an alert reports, so each fires once.
+CREATE TABLE IF NOT EXISTS "Alert" (
+  "alert_id" UUID PRIMARY KEY,
+  "user_id" UUID NOT NULL REFERENCES "Users"("user_id") ON DELETE CASCADE,
+  "rule_id" UUID REFERENCES "AlertRule"("rule_id") ON DELETE SET NULL,
+  "kind" varchar(50) NOT NULL,
+  "dedupe_key" varchar(255) NOT NULL,
+  "title" varchar(255) NOT NULL,
+  "body" text NOT NULL,
+  "created_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "read_at" timestamp,
+  UNIQUE ("user_id", "dedupe_key")
+);
+
+CREATE TABLE IF NOT EXISTS "AlertDelivery" (
+  "alert_id" UUID NOT NULL REFERENCES "Alert"("alert_id") ON DELETE CASCADE,
+  "channel" varchar(50) NOT NULL,
+  "attempts" integer NOT NULL DEFAULT 0,
+  "next_attempt_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "last_error" text,
+  "delivered_at" timestamp,
+  "created_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY ("alert_id", "channel")
+);
+
+-- Quiet hours are HH:MM in timezone; the webhook secret is encrypted like
+-- access tokens.
+CREATE TABLE IF NOT EXISTS "AlertSettings" (
+  "user_id" UUID PRIMARY KEY REFERENCES "Users"("user_id") ON DELETE CASCADE,
+  "quiet_start" varchar(5),
+  "quiet_end" varchar(5),
+  "timezone" varchar(64) NOT NULL DEFAULT 'UTC',
+  "webhook_url" text,
+  "webhook_secret" text,
+  "updated_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Set while Plaid reports ITEM_LOGIN_REQUIRED for the item.
+ALTER TABLE "PlaidItem" ADD COLUMN IF NOT EXISTS "login_required_at" timestamp;
+
+CREATE INDEX IF NOT EXISTS "AlertRule_user_idx" ON "AlertRule" ("user_id");
+CREATE INDEX IF NOT EXISTS "Alert_user_created_idx" ON "Alert" ("user_id", "created_at");
+CREATE INDEX IF NOT EXISTS "AlertDelivery_pending_idx" ON "AlertDelivery" ("next_attempt_at") WHERE "delivered_at" IS NULL;
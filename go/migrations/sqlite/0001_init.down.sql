@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS "TransactionExpense";
+DROP TABLE IF EXISTS "Expenses";
+DROP TABLE IF EXISTS "Income";
+DROP TABLE IF EXISTS "Category";
+DROP TABLE IF EXISTS "Allocations";
+DROP TABLE IF EXISTS "TransactionRaw";
+DROP TABLE IF EXISTS "PlaidItem";
+DROP TABLE IF EXISTS "Users";
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS "RecoveryCode";
+ALTER TABLE "Users" DROP COLUMN "totp_last_step";
+ALTER TABLE "Users" DROP COLUMN "totp_enabled";
+ALTER TABLE "Users" DROP COLUMN "totp_secret";
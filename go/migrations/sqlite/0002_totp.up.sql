@@ -0,0 +1,14 @@
+-- Optional TOTP two-factor authentication and its single-use recovery codes.
+-- totp_last_step is the time step of the last code accepted, so that each
+-- code is accepted once.
+ALTER TABLE "Users" ADD COLUMN "totp_secret" varchar(255) NULL;
+ALTER TABLE "Users" ADD COLUMN "totp_enabled" boolean NOT NULL DEFAULT false;
+ALTER TABLE "Users" ADD COLUMN "totp_last_step" bigint;
+
+CREATE TABLE IF NOT EXISTS "RecoveryCode" (
+  "recovery_code_id" TEXT PRIMARY KEY,
+  "user_id" TEXT NOT NULL REFERENCES "Users"("user_id") ON DELETE CASCADE,
+  "code_hash" varchar(255) NOT NULL,
+  "used_at" timestamp,
+  "created_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
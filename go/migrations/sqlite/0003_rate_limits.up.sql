@@ -0,0 +1,14 @@
+-- Token buckets and login failures shared between server instances by
+-- RATE_LIMIT_STORE=postgres.
+CREATE TABLE IF NOT EXISTS "RateLimitBucket" (
+  "bucket_key" varchar(255) PRIMARY KEY,
+  "tokens" double precision NOT NULL,
+  "updated_at" timestamp NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS "LoginFailure" (
+  "lockout_key" varchar(255) PRIMARY KEY,
+  "failures" integer NOT NULL DEFAULT 0,
+  "locked_until" timestamp,
+  "updated_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
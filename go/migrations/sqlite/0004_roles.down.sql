@@ -0,0 +1,2 @@
+ALTER TABLE "Users" DROP COLUMN "disabled";
+ALTER TABLE "Users" DROP COLUMN "role";
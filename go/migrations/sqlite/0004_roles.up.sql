@@ -0,0 +1,2 @@
+ALTER TABLE "Users" ADD COLUMN "role" varchar(50) NOT NULL DEFAULT 'user';
+ALTER TABLE "Users" ADD COLUMN "disabled" boolean NOT NULL DEFAULT false;
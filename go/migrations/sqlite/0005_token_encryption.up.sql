@@ -0,0 +1,3 @@
+-- SQLite does not enforce varchar lengths, so encrypted access tokens and TOTP
+-- secrets fit the existing columns. Kept to match the Postgres versions.
+SELECT 1;
@@ -0,0 +1,31 @@
+-- Append-only: rows reference users by id without a foreign key so that
+-- deleting a user never rewrites their history.
+CREATE TABLE IF NOT EXISTS "AuditLog" (
+  "audit_id" TEXT PRIMARY KEY,
+  "occurred_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "actor_user_id" TEXT,
+  "subject_user_id" TEXT,
+  "action" varchar(100) NOT NULL,
+  "resource_type" varchar(100),
+  "resource_id" varchar(255),
+  "ip" varchar(64),
+  "request_id" varchar(64),
+  "before" text,
+  "after" text,
+  "metadata" text
+);
+
+CREATE INDEX IF NOT EXISTS "AuditLog_actor_idx" ON "AuditLog" ("actor_user_id", "occurred_at");
+CREATE INDEX IF NOT EXISTS "AuditLog_subject_idx" ON "AuditLog" ("subject_user_id", "occurred_at");
+
+CREATE TRIGGER IF NOT EXISTS "AuditLog_no_update"
+  BEFORE UPDATE ON "AuditLog"
+BEGIN
+  SELECT RAISE(ABORT, 'AuditLog is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS "AuditLog_no_delete"
+  BEFORE DELETE ON "AuditLog"
+BEGIN
+  SELECT RAISE(ABORT, 'AuditLog is append-only');
+END;
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS "TransactionExpense_expense_idx";
+DROP INDEX IF EXISTS "TransactionRaw_user_amount_idx";
+DROP INDEX IF EXISTS "TransactionRaw_user_date_idx";
+
+ALTER TABLE "TransactionRaw" DROP COLUMN "category_detailed";
+ALTER TABLE "TransactionRaw" DROP COLUMN "category_primary";
+ALTER TABLE "TransactionRaw" DROP COLUMN "merchant_name";
@@ -0,0 +1,13 @@
+-- Searchable copies of values otherwise buried in the JSON columns.
+ALTER TABLE "TransactionRaw" ADD COLUMN "merchant_name" varchar(255);
+ALTER TABLE "TransactionRaw" ADD COLUMN "category_primary" varchar(255);
+ALTER TABLE "TransactionRaw" ADD COLUMN "category_detailed" varchar(255);
+
+UPDATE "TransactionRaw" SET
+  "merchant_name" = json_extract("raw", '$.merchant_name'),
+  "category_primary" = json_extract("personal_finance_category", '$.primary'),
+  "category_detailed" = json_extract("personal_finance_category", '$.detailed');
+
+CREATE INDEX IF NOT EXISTS "TransactionRaw_user_date_idx" ON "TransactionRaw" ("user_id", "date", "transaction_id");
+CREATE INDEX IF NOT EXISTS "TransactionRaw_user_amount_idx" ON "TransactionRaw" ("user_id", "amount", "transaction_id");
+CREATE INDEX IF NOT EXISTS "TransactionExpense_expense_idx" ON "TransactionExpense" ("expense_id");
@@ -0,0 +1,5 @@
+ALTER TABLE "Users" DROP COLUMN "statement_email";
+
+DROP TABLE IF EXISTS "StatementDelivery";
+DROP TABLE IF EXISTS "StatementNote";
+DROP TABLE IF EXISTS "Goal";
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS "ManualAssetValue";
+DROP TABLE IF EXISTS "ManualAsset";
+DROP TABLE IF EXISTS "BalanceSnapshot";
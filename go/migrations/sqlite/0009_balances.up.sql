@@ -0,0 +1,41 @@
+-- One row per account per day, taken by the balance snapshot job and
+-- whenever live balances are fetched.
+CREATE TABLE IF NOT EXISTS "BalanceSnapshot" (
+  "snapshot_id" TEXT PRIMARY KEY,
+  "user_id" TEXT NOT NULL REFERENCES "Users"("user_id") ON DELETE CASCADE,
+  "item_id" TEXT NOT NULL REFERENCES "PlaidItem"("item_id") ON DELETE CASCADE,
+  "plaid_account_id" varchar(255) NOT NULL,
+  "account_name" varchar(255) NOT NULL,
+  "account_type" varchar(50) NOT NULL,
+  "account_subtype" varchar(50),
+  "current_balance" decimal,
+  "available_balance" decimal,
+  "credit_limit" decimal,
+  "iso_currency_code" varchar(10),
+  "snapshot_date" date NOT NULL,
+  "created_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE ("item_id", "plaid_account_id", "snapshot_date")
+);
+
+-- Assets and liabilities Plaid does not hold, such as a house or a private loan.
+CREATE TABLE IF NOT EXISTS "ManualAsset" (
+  "asset_id" TEXT PRIMARY KEY,
+  "user_id" TEXT NOT NULL REFERENCES "Users"("user_id") ON DELETE CASCADE,
+  "name" varchar(255) NOT NULL,
+  "kind" varchar(20) NOT NULL, -- 'asset', 'liability'
+  "asset_type" varchar(50) NOT NULL,
+  "iso_currency_code" varchar(10),
+  "created_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "updated_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- The value of a manual asset from as_of until its next row.
+CREATE TABLE IF NOT EXISTS "ManualAssetValue" (
+  "asset_id" TEXT NOT NULL REFERENCES "ManualAsset"("asset_id") ON DELETE CASCADE,
+  "as_of" date NOT NULL,
+  "value" decimal NOT NULL,
+  PRIMARY KEY ("asset_id", "as_of")
+);
+
+CREATE INDEX IF NOT EXISTS "BalanceSnapshot_user_date_idx" ON "BalanceSnapshot" ("user_id", "snapshot_date");
+CREATE INDEX IF NOT EXISTS "ManualAsset_user_idx" ON "ManualAsset" ("user_id");
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS "InvestmentSync";
+DROP TABLE IF EXISTS "InvestmentTransaction";
+DROP TABLE IF EXISTS "HoldingSnapshot";
+DROP TABLE IF EXISTS "Security";
+DROP TABLE IF EXISTS "InvestmentAccount";
@@ -0,0 +1,70 @@
+-- Investment data synced daily from /investments/holdings/get and
+-- /investments/transactions/get.
+CREATE TABLE IF NOT EXISTS "InvestmentAccount" (
+  "item_id" TEXT NOT NULL REFERENCES "PlaidItem"("item_id") ON DELETE CASCADE,
+  "plaid_account_id" varchar(255) NOT NULL,
+  "user_id" TEXT NOT NULL REFERENCES "Users"("user_id") ON DELETE CASCADE,
+  "name" varchar(255) NOT NULL,
+  "subtype" varchar(50),
+  "iso_currency_code" varchar(10),
+  "updated_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY ("item_id", "plaid_account_id")
+);
+
+-- Plaid security ids are the same for every item, so securities are shared.
+CREATE TABLE IF NOT EXISTS "Security" (
+  "security_id" varchar(255) PRIMARY KEY,
+  "name" varchar(255),
+  "ticker_symbol" varchar(50),
+  "security_type" varchar(50),
+  "is_cash_equivalent" boolean NOT NULL DEFAULT false,
+  "close_price" decimal,
+  "close_price_as_of" date,
+  "iso_currency_code" varchar(10),
+  "updated_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS "HoldingSnapshot" (
+  "snapshot_id" TEXT PRIMARY KEY,
+  "user_id" TEXT NOT NULL REFERENCES "Users"("user_id") ON DELETE CASCADE,
+  "item_id" TEXT NOT NULL REFERENCES "PlaidItem"("item_id") ON DELETE CASCADE,
+  "plaid_account_id" varchar(255) NOT NULL,
+  "security_id" varchar(255) NOT NULL REFERENCES "Security"("security_id"),
+  "snapshot_date" date NOT NULL,
+  "quantity" decimal NOT NULL,
+  "institution_price" decimal NOT NULL,
+  "institution_value" decimal NOT NULL,
+  "cost_basis" decimal,
+  "iso_currency_code" varchar(10),
+  UNIQUE ("item_id", "plaid_account_id", "security_id", "snapshot_date")
+);
+
+CREATE TABLE IF NOT EXISTS "InvestmentTransaction" (
+  "investment_transaction_id" TEXT PRIMARY KEY,
+  "user_id" TEXT NOT NULL REFERENCES "Users"("user_id") ON DELETE CASCADE,
+  "item_id" TEXT NOT NULL REFERENCES "PlaidItem"("item_id") ON DELETE CASCADE,
+  "plaid_investment_transaction_id" varchar(255) NOT NULL,
+  "plaid_account_id" varchar(255) NOT NULL,
+  "security_id" varchar(255) REFERENCES "Security"("security_id"),
+  "date" date NOT NULL,
+  "name" varchar(255) NOT NULL,
+  "type" varchar(50) NOT NULL,
+  "subtype" varchar(50),
+  "quantity" decimal NOT NULL,
+  "price" decimal NOT NULL,
+  "amount" decimal NOT NULL,
+  "fees" decimal,
+  "iso_currency_code" varchar(10),
+  UNIQUE ("item_id", "plaid_investment_transaction_id")
+);
+
+-- The day each item's investments were last synced, successfully or not,
+-- so that items without investments are tried once a day only.
+CREATE TABLE IF NOT EXISTS "InvestmentSync" (
+  "item_id" TEXT PRIMARY KEY REFERENCES "PlaidItem"("item_id") ON DELETE CASCADE,
+  "synced_on" date NOT NULL,
+  "error" text
+);
+
+CREATE INDEX IF NOT EXISTS "HoldingSnapshot_user_date_idx" ON "HoldingSnapshot" ("user_id", "snapshot_date");
+CREATE INDEX IF NOT EXISTS "InvestmentTransaction_user_date_idx" ON "InvestmentTransaction" ("user_id", "date");
@@ -0,0 +1,3 @@
+ALTER TABLE "Users" DROP COLUMN "reporting_currency";
+
+DROP TABLE IF EXISTS "FXRate";
@@ -0,0 +1,37 @@
+-- Outbound webhook endpoints registered by users, and one delivery per event
+-- sent to an endpoint. The secret is encrypted like access tokens.
+CREATE TABLE IF NOT EXISTS "WebhookEndpoint" (
+  "endpoint_id" TEXT PRIMARY KEY,
+  "user_id" TEXT NOT NULL REFERENCES "Users"("user_id") ON DELETE CASCADE,
+  "url" text NOT NULL,
+  "description" varchar(255),
+  "events" text NOT NULL, -- comma-separated event types
+  "secret" text NOT NULL,
+  "enabled" boolean NOT NULL DEFAULT true,
+  "created_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "updated_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- status is pending, delivered or dead once retries run out. A manual
+-- redelivery is a new row pointing at the one it repeats.
+CREATE TABLE IF NOT EXISTS "WebhookDelivery" (
+  "delivery_id" TEXT PRIMARY KEY,
+  "endpoint_id" TEXT NOT NULL REFERENCES "WebhookEndpoint"("endpoint_id") ON DELETE CASCADE,
+  "event_id" TEXT NOT NULL,
+  "event_type" varchar(50) NOT NULL,
+  "payload" text NOT NULL,
+  "status" varchar(20) NOT NULL DEFAULT 'pending',
+  "attempts" integer NOT NULL DEFAULT 0,
+  "next_attempt_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "response_status" integer,
+  "last_error" text,
+  "delivered_at" timestamp,
+  "redelivery_of" TEXT REFERENCES "WebhookDelivery"("delivery_id") ON DELETE SET NULL,
+  "created_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS "WebhookEndpoint_user_idx" ON "WebhookEndpoint" ("user_id");
+-- Each event reaches an endpoint once, besides manual redeliveries.
+CREATE UNIQUE INDEX IF NOT EXISTS "WebhookDelivery_event_idx" ON "WebhookDelivery" ("endpoint_id", "event_id") WHERE "redelivery_of" IS NULL;
+CREATE INDEX IF NOT EXISTS "WebhookDelivery_endpoint_created_idx" ON "WebhookDelivery" ("endpoint_id", "created_at");
+CREATE INDEX IF NOT EXISTS "WebhookDelivery_pending_idx" ON "WebhookDelivery" ("next_attempt_at") WHERE "status" = 'pending';
@@ -0,0 +1,64 @@
+package money
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrCurrencyMismatch is returned when amounts in different currencies are combined.
+var ErrCurrencyMismatch = errors.New("money: currencies differ")
+
+// minorUnits lists the ISO 4217 currencies whose minor unit is not a hundredth.
+var minorUnits = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLF": 4, "UYW": 4,
+}
+
+// MinorUnits returns the number of fractional digits amounts in currency
+// are written with. Unknown currencies use two.
+func MinorUnits(currency string) int {
+	if places, ok := minorUnits[strings.ToUpper(currency)]; ok {
+		return places
+	}
+	return 2
+}
+
+// Money is an amount in a currency. Currency is an ISO 4217 code, or "" when
+// the source did not say.
+type Money struct {
+	Amount   Decimal `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+// Add returns m + other, or ErrCurrencyMismatch.
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return m, ErrCurrencyMismatch
+	}
+	return Money{Amount: m.Amount.Add(other.Amount), Currency: m.Currency}, nil
+}
+
+// Round rounds m to its currency's minor unit.
+func (m Money) Round() Money {
+	return Money{Amount: m.Amount.Round(MinorUnits(m.Currency)), Currency: m.Currency}
+}
+
+// Split divides m into parts proportional to weights, in whole minor units
+// of its currency, that add up to m rounded to that unit.
+func (m Money) Split(weights []Decimal) []Money {
+	parts := []Money{}
+	for _, amount := range Split(m.Amount, weights, MinorUnits(m.Currency)) {
+		parts = append(parts, Money{Amount: amount, Currency: m.Currency})
+	}
+	return parts
+}
+
+func (m Money) String() string {
+	amount := m.Amount.StringFixed(MinorUnits(m.Currency))
+	if m.Currency == "" {
+		return amount
+	}
+	return amount + " " + m.Currency
+}
@@ -0,0 +1,449 @@
+// Package money represents amounts exactly. A Decimal keeps a fixed number
+// of fractional digits in an integer, so sums never pick up the rounding
+// errors of float64, and Split divides an amount into parts that always add
+// back up to it.
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Scale is the number of fractional digits a Decimal keeps: enough for the
+// minor unit of every ISO 4217 currency and for allocation factors given to
+// the hundredth of a percent.
+const Scale = 4
+
+const unit = 10000 // 10^Scale
+
+// Decimal is an exact decimal number with Scale fractional digits. The zero
+// value is 0.
+type Decimal struct {
+	units int64
+}
+
+var (
+	Zero = Decimal{}
+	One  = Decimal{units: unit}
+)
+
+var errSyntax = errors.New("not a decimal number")
+
+// ErrOverflow is what arithmetic panics with when its result does not fit a
+// Decimal, about ±922 trillion. Parse returns it for such input, and
+// ParseAmount for anything past MaxAmount.
+var ErrOverflow = errors.New("money: amount out of range")
+
+// MaxAmount is the largest magnitude ParseAmount and UnmarshalJSON accept,
+// a hundred billion. Keeping what users enter this far inside the range of
+// a Decimal lets sums of their amounts and products with percentages be
+// computed without overflow.
+var MaxAmount = FromInt(100_000_000_000)
+
+// fromBig returns n units, panicking with ErrOverflow if they do not fit.
+func fromBig(n *big.Int) Decimal {
+	if !n.IsInt64() {
+		panic(ErrOverflow)
+	}
+	return Decimal{units: n.Int64()}
+}
+
+// fromRat returns r units rounded half away from zero, panicking with
+// ErrOverflow if they do not fit.
+func fromRat(r *big.Rat) Decimal {
+	units, ok := roundRat(r)
+	if !ok {
+		panic(ErrOverflow)
+	}
+	return Decimal{units: units}
+}
+
+// New returns value × 10^-places, rounded half away from zero if places is
+// more than Scale.
+func New(value int64, places int) Decimal {
+	r := new(big.Rat).SetInt64(value)
+	for ; places < Scale; places++ {
+		r.Mul(r, big.NewRat(10, 1))
+	}
+	for ; places > Scale; places-- {
+		r.Quo(r, big.NewRat(10, 1))
+	}
+	return fromRat(r)
+}
+
+// FromInt returns n.
+func FromInt(n int64) Decimal {
+	return fromBig(new(big.Int).Mul(big.NewInt(n), big.NewInt(unit)))
+}
+
+// FromFloat returns f rounded half away from zero to Scale digits. Use it
+// only where amounts arrive as float64, such as Plaid responses.
+func FromFloat(f float64) Decimal {
+	// Going through the shortest decimal form keeps 0.1 as 0.1 rather than
+	// the binary value closest to it.
+	d, err := Parse(strconv.FormatFloat(f, 'f', -1, 64))
+	if err != nil {
+		return Decimal{units: int64(math.Round(f * unit))}
+	}
+	return d
+}
+
+// Parse reads a decimal such as "-1234.5", "12" or "1e3". Digits past Scale
+// are rounded half away from zero.
+func Parse(s string) (Decimal, error) {
+	units, err := parseUnits(s, unit)
+	return Decimal{units: units}, err
+}
+
+// ParseAmount is Parse for amounts entered by users, rejecting those larger
+// in magnitude than MaxAmount with ErrOverflow.
+func ParseAmount(s string) (Decimal, error) {
+	d, err := Parse(s)
+	if err != nil {
+		return Zero, err
+	}
+	if d.GreaterThan(MaxAmount) || d.LessThan(MaxAmount.Neg()) {
+		return Zero, fmt.Errorf("%q: %w", strings.TrimSpace(s), ErrOverflow)
+	}
+	return d, nil
+}
+
+// parseUnits reads s as a count of 1/perOne units, rounded half away from zero.
+func parseUnits(s string, perOne int64) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.ContainsRune(s, '/') {
+		return 0, fmt.Errorf("%q: %w", s, errSyntax)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("%q: %w", s, errSyntax)
+	}
+	r.Mul(r, big.NewRat(perOne, 1))
+	units, ok := roundRat(r)
+	if !ok {
+		return 0, fmt.Errorf("%q: %w", s, ErrOverflow)
+	}
+	return units, nil
+}
+
+// formatUnits formats units of 1/10^places exactly, with at least two
+// fractional digits and no trailing zeros beyond them.
+func formatUnits(units int64, places int) string {
+	digits := new(big.Int).Abs(big.NewInt(units)).String()
+	if len(digits) <= places {
+		digits = strings.Repeat("0", places-len(digits)+1) + digits
+	}
+	whole, fraction := digits[:len(digits)-places], digits[len(digits)-places:]
+	for len(fraction) > 2 && strings.HasSuffix(fraction, "0") {
+		fraction = fraction[:len(fraction)-1]
+	}
+	if units < 0 {
+		whole = "-" + whole
+	}
+	return whole + "." + fraction
+}
+
+// MustParse is Parse for constants; it panics on error.
+func MustParse(s string) Decimal {
+	d, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// roundRat rounds r to the nearest integer, half away from zero.
+func roundRat(r *big.Rat) (int64, bool) {
+	num, den := new(big.Int).Set(r.Num()), r.Denom()
+	negative := num.Sign() < 0
+	num.Abs(num)
+	q, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Lsh(rem, 1).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if negative {
+		q.Neg(q)
+	}
+	return q.Int64(), q.IsInt64()
+}
+
+// roundDiv divides a by b, rounding half away from zero.
+func roundDiv(a int64, b int64) int64 {
+	q, r := a/b, a%b
+	if 2*abs(r) >= abs(b) {
+		if (a < 0) != (b < 0) {
+			q--
+		} else {
+			q++
+		}
+	}
+	return q
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Arithmetic panics with ErrOverflow rather than wrap around when a result
+// does not fit a Decimal.
+
+func (d Decimal) Add(other Decimal) Decimal {
+	sum := d.units + other.units
+	if (other.units > 0 && sum < d.units) || (other.units < 0 && sum > d.units) {
+		panic(ErrOverflow)
+	}
+	return Decimal{units: sum}
+}
+
+func (d Decimal) Sub(other Decimal) Decimal {
+	difference := d.units - other.units
+	if (other.units > 0 && difference > d.units) || (other.units < 0 && difference < d.units) {
+		panic(ErrOverflow)
+	}
+	return Decimal{units: difference}
+}
+
+func (d Decimal) Neg() Decimal {
+	if d.units == math.MinInt64 {
+		panic(ErrOverflow)
+	}
+	return Decimal{units: -d.units}
+}
+
+func (d Decimal) Abs() Decimal {
+	if d.units < 0 {
+		return d.Neg()
+	}
+	return d
+}
+
+// Mul returns d × other rounded half away from zero to Scale digits.
+func (d Decimal) Mul(other Decimal) Decimal {
+	return fromRat(new(big.Rat).SetFrac(new(big.Int).Mul(big.NewInt(d.units), big.NewInt(other.units)), big.NewInt(unit)))
+}
+
+// MulInt returns d × n.
+func (d Decimal) MulInt(n int64) Decimal {
+	return fromBig(new(big.Int).Mul(big.NewInt(d.units), big.NewInt(n)))
+}
+
+// MulFloat returns d × f rounded to Scale digits, for factors that are
+// inexact by nature. A non-finite f gives Zero.
+func (d Decimal) MulFloat(f float64) Decimal {
+	product := new(big.Rat).SetFloat64(f)
+	if product == nil {
+		return Zero
+	}
+	return fromRat(product.Mul(product, big.NewRat(d.units, 1)))
+}
+
+// Div returns d / other rounded half away from zero to Scale digits. It
+// panics if other is zero.
+func (d Decimal) Div(other Decimal) Decimal {
+	if other.units == 0 {
+		panic("money: division by zero")
+	}
+	return fromRat(new(big.Rat).SetFrac(new(big.Int).Mul(big.NewInt(d.units), big.NewInt(unit)), big.NewInt(other.units)))
+}
+
+// Round rounds d half away from zero to places fractional digits.
+func (d Decimal) Round(places int) Decimal {
+	if places >= Scale {
+		return d
+	}
+	if places < 0 {
+		places = 0
+	}
+	step := big.NewInt(int64(math.Pow10(Scale - places)))
+	return fromBig(new(big.Int).Mul(big.NewInt(roundDiv(d.units, step.Int64())), step))
+}
+
+// Cmp returns -1, 0 or +1 as d is less than, equal to or greater than other.
+func (d Decimal) Cmp(other Decimal) int {
+	switch {
+	case d.units < other.units:
+		return -1
+	case d.units > other.units:
+		return 1
+	}
+	return 0
+}
+
+func (d Decimal) Sign() int                      { return d.Cmp(Zero) }
+func (d Decimal) IsZero() bool                   { return d.units == 0 }
+func (d Decimal) LessThan(other Decimal) bool    { return d.units < other.units }
+func (d Decimal) GreaterThan(other Decimal) bool { return d.units > other.units }
+
+// Float64 returns the nearest float64, for display math such as percentages.
+func (d Decimal) Float64() float64 {
+	return float64(d.units) / unit
+}
+
+// StringFixed formats d rounded to places fractional digits, e.g. "-12.50".
+func (d Decimal) StringFixed(places int) string {
+	if places > Scale {
+		places = Scale
+	}
+	if places < 0 {
+		places = 0
+	}
+	rounded := d.Round(places).units
+	sign, magnitude := "", uint64(rounded)
+	if rounded < 0 {
+		sign, magnitude = "-", -magnitude
+	}
+	whole := strconv.FormatUint(magnitude/unit, 10)
+	if places == 0 {
+		return sign + whole
+	}
+	fraction := fmt.Sprintf("%04d", magnitude%unit)[:places]
+	return sign + whole + "." + fraction
+}
+
+// String formats d with at least two fractional digits and no trailing
+// zeros beyond them: "12.50", "0.3333".
+func (d Decimal) String() string {
+	return formatUnits(d.units, Scale)
+}
+
+// MarshalJSON encodes d as a string, so that clients do not read it into a
+// binary float.
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + d.String() + `"`), nil
+}
+
+// UnmarshalJSON accepts a string or a JSON number, read exactly, of at most
+// MaxAmount.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		*d = Zero
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	parsed, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// Scan reads a decimal column, which drivers return as text, bytes or a number.
+func (d *Decimal) Scan(src interface{}) error {
+	var err error
+	switch v := src.(type) {
+	case nil:
+		*d = Zero
+	case int64:
+		*d = FromInt(v)
+	case float64:
+		*d = FromFloat(v)
+	case []byte:
+		*d, err = Parse(string(v))
+	case string:
+		*d, err = Parse(v)
+	default:
+		err = fmt.Errorf("money: cannot scan %T into Decimal", src)
+	}
+	return err
+}
+
+// Value stores d as text, which decimal columns take without loss.
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+// Sum adds values.
+func Sum(values ...Decimal) Decimal {
+	total := Zero
+	for _, v := range values {
+		total = total.Add(v)
+	}
+	return total
+}
+
+// Split divides total, rounded to places fractional digits, into parts
+// proportional to weights. Parts are rounded down to places digits and the
+// units left over go one each to the parts with the largest remainders, so
+// the parts always add up to the rounded total. Negative weights count as
+// zero; if every weight is zero the total is split evenly.
+func Split(total Decimal, weights []Decimal, places int) []Decimal {
+	parts := make([]Decimal, len(weights))
+	if len(weights) == 0 {
+		return parts
+	}
+	if places > Scale {
+		places = Scale
+	}
+	if places < 0 {
+		places = 0
+	}
+	step := int64(math.Pow10(Scale - places))
+	target := big.NewInt(total.Round(places).units / step)
+	negative := target.Sign() < 0
+	target.Abs(target)
+
+	w := make([]*big.Int, len(weights))
+	sum := new(big.Int)
+	for i, weight := range weights {
+		w[i] = big.NewInt(0)
+		if weight.units > 0 {
+			w[i].SetInt64(weight.units)
+		}
+		sum.Add(sum, w[i])
+	}
+	if sum.Sign() == 0 {
+		for i := range w {
+			w[i].SetInt64(1)
+		}
+		sum.SetInt64(int64(len(w)))
+	}
+
+	type remainder struct {
+		index int
+		rem   *big.Int
+	}
+	remainders := make([]remainder, len(w))
+	assigned := new(big.Int)
+	floors := make([]int64, len(w))
+	for i := range w {
+		q, r := new(big.Int).QuoRem(new(big.Int).Mul(target, w[i]), sum, new(big.Int))
+		floors[i] = q.Int64()
+		assigned.Add(assigned, q)
+		remainders[i] = remainder{index: i, rem: r}
+	}
+	// Ties go to the earlier part, so the result does not depend on sort
+	// stability.
+	for left := new(big.Int).Sub(target, assigned).Int64(); left > 0; left-- {
+		best := -1
+		for i, r := range remainders {
+			if r.rem == nil {
+				continue
+			}
+			if best < 0 || r.rem.Cmp(remainders[best].rem) > 0 {
+				best = i
+			}
+		}
+		floors[remainders[best].index]++
+		remainders[best].rem = nil
+	}
+
+	for i, units := range floors {
+		if negative {
+			units = -units
+		}
+		parts[i] = Decimal{units: units * step}
+	}
+	return parts
+}
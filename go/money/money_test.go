@@ -0,0 +1,324 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func decimals(values ...string) []Decimal {
+	out := make([]Decimal, len(values))
+	for i, v := range values {
+		out[i] = MustParse(v)
+	}
+	return out
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name    string
+		total   string
+		weights []string
+		places  int
+		want    []string
+	}{
+		{"no parts", "10", nil, 2, nil},
+		{"even thirds", "10", []string{"1", "1", "1"}, 2, []string{"3.34", "3.33", "3.33"}},
+		{"ties go to the earlier part", "0.02", []string{"1", "1", "1"}, 2, []string{"0.01", "0.01", "0.00"}},
+		{"largest remainder wins", "1", []string{"1", "2", "4"}, 2, []string{"0.14", "0.29", "0.57"}},
+		{"all weights zero split evenly", "1", []string{"0", "0"}, 2, []string{"0.50", "0.50"}},
+		{"negative weights count as zero", "5", []string{"-1", "1"}, 2, []string{"0.00", "5.00"}},
+		{"only negative weights split evenly", "5", []string{"-1", "-3"}, 2, []string{"2.50", "2.50"}},
+		{"negative total", "-10", []string{"1", "1", "1"}, 2, []string{"-3.34", "-3.33", "-3.33"}},
+		{"total rounded to places first", "10.005", []string{"1", "1"}, 2, []string{"5.01", "5.00"}},
+		{"whole units", "100", []string{"1", "1", "1"}, 0, []string{"34.00", "33.00", "33.00"}},
+		{"places below zero act as zero", "10", []string{"1", "2"}, -1, []string{"3.00", "7.00"}},
+		{"places above scale act as scale", "1", []string{"1", "1", "1"}, 9, []string{"0.3334", "0.3333", "0.3333"}},
+		{"zero total", "0", []string{"1", "2"}, 2, []string{"0.00", "0.00"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := Split(MustParse(tt.total), decimals(tt.weights...), tt.places)
+			if len(parts) != len(tt.want) {
+				t.Fatalf("got %d parts, want %d", len(parts), len(tt.want))
+			}
+			for i, part := range parts {
+				if part.String() != tt.want[i] {
+					t.Errorf("part %d = %s, want %s", i, part, tt.want[i])
+				}
+			}
+			if len(parts) > 0 {
+				places := min(max(tt.places, 0), Scale)
+				if sum, want := Sum(parts...), MustParse(tt.total).Round(places); sum != want {
+					t.Errorf("parts add up to %s, want %s", sum, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDiv(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"10", "4", "2.50"},
+		{"1", "3", "0.3333"},
+		{"2", "3", "0.6667"},
+		{"-2", "3", "-0.6667"},
+		{"2", "-3", "-0.6667"},
+		{"-1", "-8", "0.125"},
+		{"0.00005", "1", "0.0001"},
+		{"0", "7", "0.00"},
+		// Scaling the dividend overflows int64 on its own; the result fits.
+		{"900000000000000", "1000", "900000000000.00"},
+		{"900000000000000", "0.5", "overflow"},
+	}
+	for _, tt := range tests {
+		got := func() (s string) {
+			defer func() {
+				if r := recover(); r != nil {
+					if err, ok := r.(error); !ok || !errors.Is(err, ErrOverflow) {
+						panic(r)
+					}
+					s = "overflow"
+				}
+			}()
+			return MustParse(tt.a).Div(MustParse(tt.b)).String()
+		}()
+		if got != tt.want {
+			t.Errorf("%s / %s = %s, want %s", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestDivByZeroPanics(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("division by zero did not panic")
+		}
+	}()
+	One.Div(Zero)
+}
+
+func TestOverflowPanics(t *testing.T) {
+	largest := Decimal{units: math.MaxInt64}
+	smallest := Decimal{units: math.MinInt64}
+
+	tests := []struct {
+		name string
+		op   func() Decimal
+	}{
+		{"add", func() Decimal { return largest.Add(Decimal{units: 1}) }},
+		{"add negative", func() Decimal { return smallest.Add(Decimal{units: -1}) }},
+		{"sub", func() Decimal { return smallest.Sub(Decimal{units: 1}) }},
+		{"sub negative", func() Decimal { return largest.Sub(Decimal{units: -1}) }},
+		{"neg", func() Decimal { return smallest.Neg() }},
+		{"abs", func() Decimal { return smallest.Abs() }},
+		{"mul int", func() Decimal { return largest.MulInt(2) }},
+		{"mul int negative", func() Decimal { return FromInt(1 << 50).MulInt(-1 << 20) }},
+		{"mul", func() Decimal { return FromInt(1 << 40).Mul(FromInt(1 << 40)) }},
+		{"from int", func() Decimal { return FromInt(math.MaxInt64 / 1000) }},
+		{"round", func() Decimal { return largest.Round(0) }},
+		{"sum", func() Decimal { return Sum(largest, One) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				r := recover()
+				if err, ok := r.(error); !ok || !errors.Is(err, ErrOverflow) {
+					t.Errorf("recovered %v, want ErrOverflow", r)
+				}
+			}()
+			got := tt.op()
+			t.Errorf("got %s, want a panic", got)
+		})
+	}
+}
+
+func TestArithmeticNearLimits(t *testing.T) {
+	largest := Decimal{units: math.MaxInt64}
+	smallest := Decimal{units: math.MinInt64}
+
+	tests := []struct {
+		name string
+		got  Decimal
+		want Decimal
+	}{
+		{"add to max", largest.Sub(One).Add(One), largest},
+		{"sub to min", smallest.Add(One).Sub(One), smallest},
+		{"add opposite signs", largest.Add(smallest), Decimal{units: -1}},
+		{"mul int by one", smallest.MulInt(1), smallest},
+		{"mul int by minus one", largest.MulInt(-1), Decimal{units: -math.MaxInt64}},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %d units, want %d", tt.name, tt.got.units, tt.want.units)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in       string
+		want     string
+		overflow bool
+		invalid  bool
+	}{
+		{in: "12", want: "12.00"},
+		{in: " -1234.5 ", want: "-1234.50"},
+		{in: "0.00005", want: "0.0001"},
+		{in: "-0.00005", want: "-0.0001"},
+		{in: "1e3", want: "1000.00"},
+		{in: "922337203685477.5807", want: "922337203685477.5807"},
+		{in: "922337203685477.5808", overflow: true},
+		{in: "1e30", overflow: true},
+		{in: "", invalid: true},
+		{in: "1/3", invalid: true},
+		{in: "abc", invalid: true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		switch {
+		case tt.overflow:
+			if !errors.Is(err, ErrOverflow) {
+				t.Errorf("Parse(%q) error = %v, want ErrOverflow", tt.in, err)
+			}
+		case tt.invalid:
+			if err == nil || errors.Is(err, ErrOverflow) {
+				t.Errorf("Parse(%q) error = %v, want a syntax error", tt.in, err)
+			}
+		case err != nil || got.String() != tt.want:
+			t.Errorf("Parse(%q) = %s, %v, want %s", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in       string
+		json     string
+		want     string
+		overflow bool
+	}{
+		{in: "100000000000", json: `"100000000000"`, want: "100000000000.00"},
+		{in: "-100000000000", json: `-100000000000`, want: "-100000000000.00"},
+		{in: "100000000000.0001", json: `"100000000000.0001"`, overflow: true},
+		{in: "-1e12", json: `-1e12`, overflow: true},
+		{in: "9e14", json: `9e14`, overflow: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmount(tt.in)
+		var decoded Decimal
+		jsonErr := json.Unmarshal([]byte(tt.json), &decoded)
+		if tt.overflow {
+			if !errors.Is(err, ErrOverflow) || !errors.Is(jsonErr, ErrOverflow) {
+				t.Errorf("%s: errors %v and %v, want ErrOverflow", tt.in, err, jsonErr)
+			}
+			continue
+		}
+		if err != nil || jsonErr != nil || got.String() != tt.want || decoded != got {
+			t.Errorf("%s = %s, %v and %s, %v, want %s", tt.in, got, err, decoded, jsonErr, tt.want)
+		}
+	}
+}
+
+// TestMaxAmountArithmetic checks that amounts within MaxAmount leave room for
+// what the app computes with them: sums, months of plan and percentages.
+func TestMaxAmountArithmetic(t *testing.T) {
+	largest, smallest := MaxAmount, MaxAmount.Neg()
+	tests := []struct {
+		name string
+		op   func() Decimal
+	}{
+		{"sum of a thousand", func() Decimal { return largest.MulInt(1000) }},
+		{"negative sum of a thousand", func() Decimal { return smallest.MulInt(1000) }},
+		{"twelve months", func() Decimal { return largest.MulInt(12) }},
+		{"thousand percent", func() Decimal { return largest.Mul(FromInt(1000)) }},
+		{"times a hundred", func() Decimal { return largest.MulInt(100).Add(smallest.MulInt(100)) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("panicked with %v", r)
+				}
+			}()
+			tt.op()
+		})
+	}
+}
+
+func TestStringAtLimits(t *testing.T) {
+	tests := []struct {
+		d    Decimal
+		want string
+	}{
+		{Decimal{units: math.MinInt64}, "-922337203685477.5808"},
+		{Decimal{units: math.MaxInt64}, "922337203685477.5807"},
+		{Decimal{units: -5}, "-0.0005"},
+	}
+	for _, tt := range tests {
+		if got := tt.d.String(); got != tt.want {
+			t.Errorf("String() = %s, want %s", got, tt.want)
+		}
+		if got := tt.d.StringFixed(Scale); got != tt.want {
+			t.Errorf("StringFixed(Scale) = %s, want %s", got, tt.want)
+		}
+	}
+}
+
+func TestPrecise(t *testing.T) {
+	tests := []struct {
+		name string
+		got  func() string
+		want string
+	}{
+		{"parse keeps ten digits", func() string { return MustParsePrecise("0.00671234567").String() }, "0.0067123457"},
+		{"inverse", func() string { return MustParsePrecise("1.25").Inverse().String() }, "0.80"},
+		{"inverse rounds", func() string { return MustParsePrecise("3").Inverse().String() }, "0.3333333333"},
+		{"cross rate", func() string { return MustParsePrecise("0.85").Div(MustParsePrecise("1.1")).String() }, "0.7727272727"},
+		{"mul", func() string { return MustParsePrecise("1.5").Mul(MustParsePrecise("0.5")).String() }, "0.75"},
+		{"from float", func() string { return PreciseFromFloat(0.1).String() }, "0.10"},
+		{"convert amount", func() string { return MustParse("100").MulPrecise(MustParsePrecise("0.0067123")).String() }, "0.6712"},
+		{"convert rounds half away from zero", func() string { return MustParse("-1").MulPrecise(MustParsePrecise("0.00005")).String() }, "-0.0001"},
+		{"convert overflow", func() (s string) {
+			defer func() {
+				if r := recover(); r != nil {
+					s = "overflow"
+				}
+			}()
+			return Decimal{units: math.MaxInt64}.MulPrecise(MustParsePrecise("2")).String()
+		}, "overflow"},
+	}
+	for _, tt := range tests {
+		if got := tt.got(); got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPreciseScan(t *testing.T) {
+	tests := []struct {
+		src  interface{}
+		want string
+	}{
+		{nil, "0.00"},
+		{int64(3), "3.00"},
+		{0.5, "0.50"},
+		{[]byte("1.0837"), "1.0837"},
+		{"161.47", "161.47"},
+	}
+	for _, tt := range tests {
+		var p Precise
+		if err := p.Scan(tt.src); err != nil || p.String() != tt.want {
+			t.Errorf("Scan(%v) = %s, %v, want %s", tt.src, p, err, tt.want)
+		}
+	}
+	var p Precise
+	if err := p.Scan(true); err == nil || !strings.Contains(err.Error(), "Precise") {
+		t.Errorf("Scan(true) error = %v", err)
+	}
+}
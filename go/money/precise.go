@@ -0,0 +1,162 @@
+package money
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// PreciseScale is the number of fractional digits a Precise keeps.
+const PreciseScale = 10
+
+const preciseUnit = 10000000000 // 10^PreciseScale
+
+// Precise is an exact decimal with PreciseScale fractional digits, for the
+// numbers that need more digits than an amount: exchange rates and share
+// quantities. It holds values up to about ±922 million. The zero value is 0.
+type Precise struct {
+	units int64
+}
+
+var PreciseOne = Precise{units: preciseUnit}
+
+// ParsePrecise reads a decimal such as "0.0067123" or "1e-3". Digits past
+// PreciseScale are rounded half away from zero.
+func ParsePrecise(s string) (Precise, error) {
+	units, err := parseUnits(s, preciseUnit)
+	return Precise{units: units}, err
+}
+
+// MustParsePrecise is ParsePrecise for constants; it panics on error.
+func MustParsePrecise(s string) Precise {
+	p, err := ParsePrecise(s)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// PreciseFromFloat returns f rounded to PreciseScale digits, going through
+// its shortest decimal form like FromFloat.
+func PreciseFromFloat(f float64) Precise {
+	p, err := ParsePrecise(strconv.FormatFloat(f, 'f', -1, 64))
+	if err != nil {
+		return Precise{units: int64(math.Round(f * preciseUnit))}
+	}
+	return p
+}
+
+func preciseFromRat(r *big.Rat) Precise {
+	units, ok := roundRat(r)
+	if !ok {
+		panic(ErrOverflow)
+	}
+	return Precise{units: units}
+}
+
+func (p Precise) rat() *big.Rat {
+	return big.NewRat(p.units, preciseUnit)
+}
+
+// Mul returns p × other rounded to PreciseScale digits.
+func (p Precise) Mul(other Precise) Precise {
+	r := p.rat()
+	return preciseFromRat(r.Mul(r, other.rat()).Mul(r, big.NewRat(preciseUnit, 1)))
+}
+
+// Div returns p / other rounded to PreciseScale digits. It panics if other
+// is zero.
+func (p Precise) Div(other Precise) Precise {
+	if other.units == 0 {
+		panic("money: division by zero")
+	}
+	r := p.rat()
+	return preciseFromRat(r.Quo(r, other.rat()).Mul(r, big.NewRat(preciseUnit, 1)))
+}
+
+// Inverse returns 1 / p rounded to PreciseScale digits, e.g. the rate of
+// the opposite currency pair. It panics if p is zero.
+func (p Precise) Inverse() Precise {
+	return PreciseOne.Div(p)
+}
+
+// MulPrecise returns d × p rounded half away from zero to Scale digits,
+// e.g. an amount converted at an exchange rate or a price times a quantity.
+func (d Decimal) MulPrecise(p Precise) Decimal {
+	return fromRat(new(big.Rat).SetFrac(new(big.Int).Mul(big.NewInt(d.units), big.NewInt(p.units)), big.NewInt(preciseUnit)))
+}
+
+// Cmp returns -1, 0 or +1 as p is less than, equal to or greater than other.
+func (p Precise) Cmp(other Precise) int {
+	switch {
+	case p.units < other.units:
+		return -1
+	case p.units > other.units:
+		return 1
+	}
+	return 0
+}
+
+func (p Precise) Sign() int    { return p.Cmp(Precise{}) }
+func (p Precise) IsZero() bool { return p.units == 0 }
+
+// Float64 returns the nearest float64, for display math.
+func (p Precise) Float64() float64 {
+	return float64(p.units) / preciseUnit
+}
+
+// String formats p exactly with at least two fractional digits: "1.25",
+// "0.0067123".
+func (p Precise) String() string {
+	return formatUnits(p.units, PreciseScale)
+}
+
+// MarshalJSON encodes p as a string, like Decimal.
+func (p Precise) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + p.String() + `"`), nil
+}
+
+// UnmarshalJSON accepts a string or a JSON number, read exactly.
+func (p *Precise) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		*p = Precise{}
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	parsed, err := ParsePrecise(s)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// Scan reads a decimal column like Decimal.Scan.
+func (p *Precise) Scan(src interface{}) error {
+	var err error
+	switch v := src.(type) {
+	case nil:
+		*p = Precise{}
+	case int64:
+		*p = preciseFromRat(new(big.Rat).Mul(new(big.Rat).SetInt64(v), big.NewRat(preciseUnit, 1)))
+	case float64:
+		*p = PreciseFromFloat(v)
+	case []byte:
+		*p, err = ParsePrecise(string(v))
+	case string:
+		*p, err = ParsePrecise(v)
+	default:
+		err = fmt.Errorf("money: cannot scan %T into Precise", src)
+	}
+	return err
+}
+
+// Value stores p as text.
+func (p Precise) Value() (driver.Value, error) {
+	return p.String(), nil
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	plaid "github.com/plaid/plaid-go/v31/plaid"
+	"github.com/plaid/quickstart/money"
+	"github.com/plaid/quickstart/repository"
+)
+
+const (
+	// balanceSnapshotInterval is how often linked items are checked for a
+	// missing snapshot of the day. Each item is fetched from Plaid at most
+	// once a day unless fetching fails.
+	balanceSnapshotInterval = time.Hour
+
+	networthDefaultDays = 90
+	networthMaxPoints   = 1000
+)
+
+// Net worth intervals accepted by ?interval=.
+const (
+	networthDaily   = "day"
+	networthWeekly  = "week"
+	networthMonthly = "month"
+)
+
+// isLiability reports whether a Plaid account's current balance is owed
+// rather than held.
+func isLiability(accountType string) bool {
+	return accountType == string(plaid.ACCOUNTTYPE_CREDIT) || accountType == string(plaid.ACCOUNTTYPE_LOAN)
+}
+
+// decimalPtr converts an optional amount Plaid reports as float64.
+func decimalPtr(v *float64) *money.Decimal {
+	if v == nil {
+		return nil
+	}
+	d := money.FromFloat(*v)
+	return &d
+}
+
+func balanceSnapshots(itemid uuid.UUID, accounts []plaid.AccountBase, date time.Time) []repository.BalanceSnapshot {
+	snapshots := make([]repository.BalanceSnapshot, 0, len(accounts))
+	for _, account := range accounts {
+		balances := account.GetBalances()
+		snapshot := repository.BalanceSnapshot{
+			ItemID:          itemid,
+			PlaidAccountID:  account.GetAccountId(),
+			Name:            account.GetName(),
+			Type:            string(account.GetType()),
+			Subtype:         string(account.GetSubtype()),
+			ISOCurrencyCode: balances.GetIsoCurrencyCode(),
+			Date:            date,
+		}
+		if snapshot.ISOCurrencyCode == "" {
+			snapshot.ISOCurrencyCode = balances.GetUnofficialCurrencyCode()
+		}
+		if v, ok := balances.GetCurrentOk(); ok {
+			snapshot.Current = decimalPtr(v)
+		}
+		if v, ok := balances.GetAvailableOk(); ok {
+			snapshot.Available = decimalPtr(v)
+		}
+		if v, ok := balances.GetLimitOk(); ok {
+			snapshot.Limit = decimalPtr(v)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots
+}
+
+// storeLiveBalances keeps the balances a live /api/v1/plaid/balance or
+// /api/v1/plaid/accounts call returned as today's snapshot. Failing to store
+// them does not fail the call.
+func storeLiveBalances(ctx context.Context, userid string, accessToken string, accounts []plaid.AccountBase) {
+	item, err := currentPlaidItem(ctx, userid, accessToken)
+	if err == repository.ErrNotFound {
+		return
+	}
+	if err == nil {
+		err = repos.Balances.SaveSnapshots(ctx, userid, balanceSnapshots(item.ID, accounts, time.Now().UTC()))
+	}
+	if err != nil {
+		logger("networth").ErrorContext(ctx, "could not store balance snapshot", "err", err)
+	}
+}
+
+// snapshotBalances fetches and stores today's balances of every linked item
+// that has no snapshot yet today.
+func snapshotBalances(ctx context.Context, now time.Time) {
+	items, err := repos.Balances.ItemsWithoutSnapshot(ctx, now)
+	if err != nil {
+		logger("networth").ErrorContext(ctx, "could not load items to snapshot", "err", err)
+		return
+	}
+	for _, item := range items {
+		accessToken, err := tokenCipher.Decrypt(ctx, item.AccessToken, repository.ItemAccessToken, item.ID.String())
+		if err != nil {
+			logger("networth").ErrorContext(ctx, "could not decrypt access token", "item_id", item.ID, "err", err)
+			continue
+		}
+		resp, _, err := client.PlaidApi.AccountsBalanceGet(ctx).AccountsBalanceGetRequest(
+			*plaid.NewAccountsBalanceGetRequest(accessToken),
+		).Execute()
+		recordItemLogin(ctx, item.ID, err)
+		if err != nil {
+			logger("networth").WarnContext(ctx, "could not fetch balances", "item_id", item.ID, "err", err)
+			checkAlerts(ctx, item.UserID)
+			continue
+		}
+		if err := repos.Balances.SaveSnapshots(ctx, item.UserID, balanceSnapshots(item.ID, resp.GetAccounts(), now)); err != nil {
+			logger("networth").ErrorContext(ctx, "could not store balance snapshot", "item_id", item.ID, "err", err)
+		}
+		checkAlerts(ctx, item.UserID)
+	}
+}
+
+// runBalanceSnapshots takes the daily balance snapshots now and every
+// balanceSnapshotInterval until ctx is done. BALANCE_SNAPSHOTS=false turns
+// the job off, for deployments that run it elsewhere or not at all.
+func runBalanceSnapshots(ctx context.Context) {
+	if os.Getenv("BALANCE_SNAPSHOTS") == "false" {
+		return
+	}
+	ticker := time.NewTicker(balanceSnapshotInterval)
+	defer ticker.Stop()
+	for {
+		runJob(ctx, "networth", func() { snapshotBalances(ctx, time.Now().UTC()) })
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// networthPoint is net worth at the end of one day. ByType breaks it down
+// by Plaid account type or manual asset type, with liabilities negative.
+type networthPoint struct {
+	Date        string                   `json:"date"`
+	Assets      money.Decimal            `json:"assets"`
+	Liabilities money.Decimal            `json:"liabilities"`
+	NetWorth    money.Decimal            `json:"net_worth"`
+	ByType      map[string]money.Decimal `json:"by_type"`
+}
+
+// networthDates lists the last day of each interval within [from, to],
+// ending with to itself.
+func networthDates(from time.Time, to time.Time, interval string) []time.Time {
+	dates := []time.Time{}
+	for date := from; !date.After(to); {
+		var end time.Time
+		switch interval {
+		case networthWeekly:
+			end = date.AddDate(0, 0, 6)
+		case networthMonthly:
+			end = time.Date(date.Year(), date.Month()+1, 0, 0, 0, 0, 0, time.UTC)
+		default:
+			end = date
+		}
+		if end.After(to) {
+			end = to
+		}
+		dates = append(dates, end)
+		date = end.AddDate(0, 0, 1)
+	}
+	return dates
+}
+
+// networthSeries carries each account's and manual asset's latest value
+// forward to every date, converted at that date's rate. snapshots and values
+// must be ordered by date.
+func networthSeries(fx *fxConverter, dates []time.Time, snapshots []repository.BalanceSnapshot, assets []repository.ManualAsset, values []repository.ManualAssetValue) []networthPoint {
+	manualAssets := map[uuid.UUID]repository.ManualAsset{}
+	for _, asset := range assets {
+		manualAssets[asset.ID] = asset
+	}
+
+	latest := map[string]repository.BalanceSnapshot{}
+	manual := map[uuid.UUID]money.Decimal{}
+	points := make([]networthPoint, 0, len(dates))
+	for _, date := range dates {
+		for len(snapshots) > 0 && !snapshots[0].Date.After(date) {
+			latest[snapshots[0].ItemID.String()+"/"+snapshots[0].PlaidAccountID] = snapshots[0]
+			snapshots = snapshots[1:]
+		}
+		for len(values) > 0 && !values[0].Date.After(date) {
+			manual[values[0].AssetID] = values[0].Value
+			values = values[1:]
+		}
+
+		point := networthPoint{Date: date.Format(time.DateOnly), ByType: map[string]money.Decimal{}}
+		add := func(accountType string, value money.Decimal, liability bool) {
+			if liability {
+				point.Liabilities = point.Liabilities.Add(value)
+				point.ByType[accountType] = point.ByType[accountType].Sub(value)
+			} else {
+				point.Assets = point.Assets.Add(value)
+				point.ByType[accountType] = point.ByType[accountType].Add(value)
+			}
+		}
+		for _, s := range latest {
+			balance := s.Current
+			if balance == nil {
+				balance = s.Available
+			}
+			if balance != nil {
+				add(s.Type, fx.Convert(*balance, s.ISOCurrencyCode, date), isLiability(s.Type))
+			}
+		}
+		for id, value := range manual {
+			asset := manualAssets[id]
+			add(asset.Type, fx.Convert(value, asset.ISOCurrencyCode, date), asset.Kind == repository.ManualAssetKindLiability)
+		}
+
+		places := money.MinorUnits(fx.Currency)
+		point.Assets = point.Assets.Round(places)
+		point.Liabilities = point.Liabilities.Round(places)
+		point.NetWorth = point.Assets.Sub(point.Liabilities)
+		for accountType, value := range point.ByType {
+			point.ByType[accountType] = value.Round(places)
+		}
+		points = append(points, point)
+	}
+	return points
+}
+
+type networthResponse struct {
+	From         string          `json:"from"`
+	To           string          `json:"to"`
+	Interval     string          `json:"interval"`
+	Currency     string          `json:"currency"`
+	MissingRates []string        `json:"missing_rates"`
+	Points       []networthPoint `json:"points"`
+}
+
+// getNetworthHandler returns net worth over time from the stored balance
+// snapshots and manual assets. ?from= and ?to= default to the last 90 days
+// and ?interval= is day, week or month.
+func getNetworthHandler(c *gin.Context) {
+	from, to, ok := dateRangeParams(c)
+	if !ok {
+		return
+	}
+	if to.IsZero() {
+		now := time.Now().UTC()
+		to = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	if from.IsZero() {
+		from = to.AddDate(0, 0, 1-networthDefaultDays)
+	}
+	if to.Before(from) {
+		respondError(c, http.StatusBadRequest, "to must not be before from")
+		return
+	}
+	interval := c.DefaultQuery("interval", networthDaily)
+	if interval != networthDaily && interval != networthWeekly && interval != networthMonthly {
+		respondError(c, http.StatusBadRequest, "interval must be day, week or month")
+		return
+	}
+	dates := networthDates(from, to, interval)
+	if len(dates) > networthMaxPoints {
+		respondError(c, http.StatusBadRequest, "Range is too long for this interval")
+		return
+	}
+
+	ctx := c.Request.Context()
+	userid := c.GetString("userid")
+	snapshots, err := repos.Balances.History(ctx, userid, from, to)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load balances")
+		return
+	}
+	assets, err := repos.Balances.ListManual(ctx, userid)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load manual assets")
+		return
+	}
+	values, err := repos.Balances.ManualHistory(ctx, userid, from, to)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load manual assets")
+		return
+	}
+	fx, err := newFXConverter(ctx, userid, from, to)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load exchange rates")
+		return
+	}
+
+	points := networthSeries(fx, dates, snapshots, assets, values)
+	c.JSON(http.StatusOK, networthResponse{
+		From:         from.Format(time.DateOnly),
+		To:           to.Format(time.DateOnly),
+		Interval:     interval,
+		Currency:     fx.Currency,
+		MissingRates: fx.Missing(),
+		Points:       points,
+	})
+}
+
+type manualAssetRequest struct {
+	Name string `json:"name" binding:"required"`
+	// Kind is asset or liability.
+	Kind string `json:"kind" binding:"required"`
+	// Type groups the asset in the net worth breakdown, e.g. property or vehicle.
+	Type            string        `json:"type"`
+	Value           money.Decimal `json:"value"`
+	ISOCurrencyCode string        `json:"iso_currency_code"`
+	// AsOf dates the value, in YYYY-MM-DD format. It defaults to today.
+	AsOf string `json:"as_of"`
+}
+
+type manualAssetsResponse struct {
+	Assets []repository.ManualAsset `json:"assets"`
+}
+
+func getManualAssetsHandler(c *gin.Context) {
+	assets, err := repos.Balances.ListManual(c.Request.Context(), c.GetString("userid"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load manual assets")
+		return
+	}
+	c.JSON(http.StatusOK, manualAssetsResponse{Assets: assets})
+}
+
+// saveManualAssetHandler creates a manual asset, or updates the one named by
+// :id. Each save records the value as of its date, so earlier net worth is
+// unchanged.
+func saveManualAssetHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	userid := c.GetString("userid")
+
+	id := uuid.Nil
+	var previous interface{}
+	if param := c.Param("id"); param != "" {
+		var err error
+		if id, err = uuid.Parse(param); err != nil {
+			respondError(c, http.StatusBadRequest, "Invalid asset id")
+			return
+		}
+		assets, err := repos.Balances.ListManual(ctx, userid)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "Could not load manual assets")
+			return
+		}
+		for _, asset := range assets {
+			if asset.ID == id {
+				previous = asset
+			}
+		}
+		if previous == nil {
+			respondError(c, http.StatusNotFound, "Asset not found")
+			return
+		}
+	}
+
+	var request manualAssetRequest
+	if err := c.ShouldBindJSON(&request); err != nil || request.Value.Sign() < 0 {
+		respondError(c, http.StatusBadRequest, "A name, a kind and a non-negative value are required")
+		return
+	}
+	if request.Kind != repository.ManualAssetKindAsset && request.Kind != repository.ManualAssetKindLiability {
+		respondError(c, http.StatusBadRequest, "kind must be asset or liability")
+		return
+	}
+	asOf := time.Now().UTC()
+	if request.AsOf != "" {
+		var err error
+		if asOf, err = time.Parse(time.DateOnly, request.AsOf); err != nil {
+			respondError(c, http.StatusBadRequest, "as_of must be a date in YYYY-MM-DD format")
+			return
+		}
+	}
+	assetType := strings.ToLower(strings.TrimSpace(request.Type))
+	if assetType == "" {
+		assetType = "other"
+	}
+
+	saved, err := repos.Balances.SaveManual(ctx, userid, repository.ManualAsset{
+		ID:              id,
+		Name:            request.Name,
+		Kind:            request.Kind,
+		Type:            assetType,
+		Value:           request.Value,
+		ISOCurrencyCode: strings.ToUpper(request.ISOCurrencyCode),
+	}, asOf)
+	if err == repository.ErrNotFound {
+		respondError(c, http.StatusNotFound, "Asset not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not save asset")
+		return
+	}
+
+	before, after := auditDiff(previous, saved)
+	recordAudit(c, repository.AuditEntry{
+		Action:       AuditManualAssetSaved,
+		ResourceType: "manual_asset",
+		ResourceID:   saved.ID.String(),
+		Before:       before,
+		After:        after,
+	})
+
+	c.JSON(http.StatusOK, saved)
+}
+
+func deleteManualAssetHandler(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid asset id")
+		return
+	}
+
+	err = repos.Balances.DeleteManual(c.Request.Context(), c.GetString("userid"), id)
+	if err == repository.ErrNotFound {
+		respondError(c, http.StatusNotFound, "Asset not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not delete asset")
+		return
+	}
+
+	recordAudit(c, repository.AuditEntry{
+		Action:       AuditManualAssetDeleted,
+		ResourceType: "manual_asset",
+		ResourceID:   id.String(),
+	})
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/plaid/quickstart/money"
+	"github.com/plaid/quickstart/repository"
+)
+
+func TestNetworthDates(t *testing.T) {
+	date := func(s string) time.Time {
+		d, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	tests := []struct {
+		name     string
+		from     string
+		to       string
+		interval string
+		want     string
+	}{
+		{"daily", "2024-01-30", "2024-02-02", networthDaily, "2024-01-30 2024-01-31 2024-02-01 2024-02-02"},
+		{"weekly ends with to", "2024-01-01", "2024-01-17", networthWeekly, "2024-01-07 2024-01-14 2024-01-17"},
+		{"monthly from mid-month", "2024-01-15", "2024-03-10", networthMonthly, "2024-01-31 2024-02-29 2024-03-10"},
+		{"single day", "2024-01-01", "2024-01-01", networthMonthly, "2024-01-01"},
+		{"to before from", "2024-01-02", "2024-01-01", networthDaily, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, d := range networthDates(date(tt.from), date(tt.to), tt.interval) {
+				got = append(got, d.Format(time.DateOnly))
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("networthDates = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNetworthSeries(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	amount := func(s string) *money.Decimal {
+		d := money.MustParse(s)
+		return &d
+	}
+	item := uuid.New()
+	house, loan := uuid.New(), uuid.New()
+	fx := newFXConverterFromRates("USD", []repository.FXRate{
+		{BaseCurrency: "EUR", Currency: "USD", Date: day(1), Rate: money.MustParsePrecise("1.1")},
+	})
+
+	snapshots := []repository.BalanceSnapshot{
+		{ItemID: item, PlaidAccountID: "checking", Type: "depository", Current: amount("100"), ISOCurrencyCode: "USD", Date: day(1)},
+		{ItemID: item, PlaidAccountID: "card", Type: "credit", Current: amount("30"), ISOCurrencyCode: "USD", Date: day(2)},
+		{ItemID: item, PlaidAccountID: "checking", Type: "depository", Available: amount("150"), ISOCurrencyCode: "USD", Date: day(3)},
+	}
+	assets := []repository.ManualAsset{
+		{ID: house, Kind: repository.ManualAssetKindAsset, Type: "property", ISOCurrencyCode: "EUR"},
+		{ID: loan, Kind: repository.ManualAssetKindLiability, Type: "loan", ISOCurrencyCode: "USD"},
+	}
+	values := []repository.ManualAssetValue{
+		{AssetID: house, Date: day(1), Value: money.MustParse("1000")},
+		{AssetID: loan, Date: day(2), Value: money.MustParse("500")},
+		{AssetID: house, Date: day(3), Value: money.MustParse("1100")},
+	}
+
+	points := networthSeries(fx, []time.Time{day(1), day(2), day(3), day(4)}, snapshots, assets, values)
+	want := []struct {
+		assets, liabilities, netWorth string
+		byType                        map[string]string
+	}{
+		{"1200.00", "0.00", "1200.00", map[string]string{"depository": "100.00", "property": "1100.00"}},
+		{"1200.00", "530.00", "670.00", map[string]string{"depository": "100.00", "property": "1100.00", "credit": "-30.00", "loan": "-500.00"}},
+		{"1360.00", "530.00", "830.00", map[string]string{"depository": "150.00", "property": "1210.00", "credit": "-30.00", "loan": "-500.00"}},
+		{"1360.00", "530.00", "830.00", map[string]string{"depository": "150.00", "property": "1210.00", "credit": "-30.00", "loan": "-500.00"}},
+	}
+	if len(points) != len(want) {
+		t.Fatalf("%d points, want %d", len(points), len(want))
+	}
+	for i, p := range points {
+		w := want[i]
+		if p.Assets.String() != w.assets || p.Liabilities.String() != w.liabilities || p.NetWorth.String() != w.netWorth {
+			t.Errorf("%s: assets %s, liabilities %s, net worth %s; want %s, %s, %s", p.Date, p.Assets, p.Liabilities, p.NetWorth, w.assets, w.liabilities, w.netWorth)
+		}
+		if len(p.ByType) != len(w.byType) {
+			t.Errorf("%s: by type %v, want %v", p.Date, p.ByType, w.byType)
+		}
+		for accountType, value := range w.byType {
+			if p.ByType[accountType].String() != value {
+				t.Errorf("%s: %s = %s, want %s", p.Date, accountType, p.ByType[accountType], value)
+			}
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/plaid/quickstart/money"
+)
+
+// openAPIDocument is the part of OpenAPI 3.0 the API description uses.
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Security   []map[string][]string                   `json:"security"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*openAPISchema    `json:"schemas"`
+	SecuritySchemes map[string]openAPIAuthScheme `json:"securitySchemes"`
+}
+
+type openAPIAuthScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme"`
+	BearerFormat string `json:"bearerFormat"`
+}
+
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary"`
+	Tags        []string                   `json:"tags"`
+	Deprecated  bool                       `json:"deprecated,omitempty"`
+	Security    *[]map[string][]string     `json:"security,omitempty"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Required    bool           `json:"required"`
+	Description string         `json:"description,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+// openAPISchema is a schema object. Only the keywords requestValidator
+// checks, plus descriptive ones, are supported.
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Description          string                    `json:"description,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	Enum                 []string                  `json:"enum,omitempty"`
+	Pattern              string                    `json:"pattern,omitempty"`
+	MinLength            *int                      `json:"minLength,omitempty"`
+	Minimum              *float64                  `json:"minimum,omitempty"`
+	Maximum              *float64                  `json:"maximum,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+	OneOf                []*openAPISchema          `json:"oneOf,omitempty"`
+}
+
+// schemaRef is the name of the component a $ref points to.
+func (s *openAPISchema) schemaRef() string {
+	return strings.TrimPrefix(s.Ref, "#/components/schemas/")
+}
+
+// apiFile is a file in a multipart form.
+type apiFile struct{}
+
+// apiOneOf documents a body that is one of several types.
+type apiOneOf []interface{}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	uuidType    = reflect.TypeOf(uuid.UUID{})
+	decimalType = reflect.TypeOf(money.Decimal{})
+	rawJSONType = reflect.TypeOf(json.RawMessage{})
+	apiFileType = reflect.TypeOf(apiFile{})
+
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// schemaBuilder derives schemas from Go types the way encoding/json and
+// gin's binding read them, collecting named structs as components.
+type schemaBuilder struct {
+	components map[string]*openAPISchema
+	names      map[reflect.Type]string
+}
+
+func newSchemaBuilder() *schemaBuilder {
+	return &schemaBuilder{components: map[string]*openAPISchema{}, names: map[reflect.Type]string{}}
+}
+
+// componentName is the exported Go name of t. Types of other packages
+// whose name is taken get their package name in front.
+func (b *schemaBuilder) componentName(t reflect.Type) string {
+	if name, ok := b.names[t]; ok {
+		return name
+	}
+	runes := []rune(t.Name())
+	runes[0] = unicode.ToUpper(runes[0])
+	name := string(runes)
+	pkg := t.PkgPath()
+	if strings.Contains(pkg, "plaid-go") {
+		name = "Plaid" + name
+	}
+	if _, taken := b.components[name]; taken {
+		pkg = pkg[strings.LastIndex(pkg, "/")+1:]
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	b.names[t] = name
+	return name
+}
+
+func (b *schemaBuilder) ref(t reflect.Type, build func() *openAPISchema) *openAPISchema {
+	name := b.componentName(t)
+	if _, ok := b.components[name]; !ok {
+		// Reserve the name first, for types that refer to themselves.
+		b.components[name] = &openAPISchema{}
+		*b.components[name] = *build()
+	}
+	return &openAPISchema{Ref: "#/components/schemas/" + name}
+}
+
+// schema describes a value of type t as JSON. tag names struct fields:
+// "json" for JSON bodies, "form" for forms.
+func (b *schemaBuilder) schema(t reflect.Type, tag string) *openAPISchema {
+	switch t {
+	case timeType:
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	case uuidType:
+		return &openAPISchema{Type: "string", Format: "uuid"}
+	case decimalType:
+		return b.ref(t, func() *openAPISchema {
+			return &openAPISchema{Type: "string", Format: "decimal",
+				Description: "An exact decimal amount such as \"12.50\". Requests may also send it as a JSON number."}
+		})
+	case rawJSONType:
+		return &openAPISchema{Description: "Any JSON value."}
+	case apiFileType:
+		return &openAPISchema{Type: "string", Format: "binary"}
+	}
+
+	if t.Kind() != reflect.Pointer && reflect.PointerTo(t).Implements(jsonMarshalerType) && !strings.Contains(t.PkgPath(), "plaid-go") {
+		return &openAPISchema{Description: "Any JSON value."}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := b.schema(t.Elem(), tag)
+		if s.Ref != "" {
+			return &openAPISchema{OneOf: []*openAPISchema{s}, Nullable: true}
+		}
+		s.Nullable = true
+		return s
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &openAPISchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &openAPISchema{Type: "array", Items: b.schema(t.Elem(), tag)}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: b.schema(t.Elem(), tag)}
+	case reflect.Interface:
+		return &openAPISchema{Description: "Any JSON value."}
+	case reflect.Struct:
+		// Plaid's models marshal themselves; their schemas are Plaid's.
+		if strings.Contains(t.PkgPath(), "plaid-go") {
+			return b.ref(t, func() *openAPISchema {
+				return &openAPISchema{Type: "object", Description: "Plaid's " + t.Name() + " object, see https://plaid.com/docs/api/."}
+			})
+		}
+		if t.Name() == "" || tag == "form" {
+			return b.object(t, tag)
+		}
+		return b.ref(t, func() *openAPISchema { return b.object(t, tag) })
+	}
+	panic(fmt.Sprintf("openapi: cannot describe %s", t))
+}
+
+// object describes a struct's fields, including those of embedded structs.
+func (b *schemaBuilder) object(t reflect.Type, tag string) *openAPISchema {
+	s := &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{}}
+	b.addFields(s, t, tag)
+	return s
+}
+
+func (b *schemaBuilder) addFields(s *openAPISchema, t reflect.Type, tag string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			b.addFields(s, field.Type, tag)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := b.schema(field.Type, tag)
+		if bindingRules(property, field.Tag.Get("binding")) {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = property
+	}
+}
+
+// bindingRules adds the validator rules of a binding tag that schemas can
+// express, and reports whether the field is required.
+func bindingRules(s *openAPISchema, binding string) bool {
+	required := false
+	for _, rule := range strings.Split(binding, ",") {
+		name, arg, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			// The validator rejects empty strings as missing.
+			required = true
+			if s.Type == "string" && s.MinLength == nil {
+				length := 1
+				s.MinLength = &length
+			}
+		case "oneof":
+			s.Enum = strings.Fields(arg)
+		case "gte", "min", "gt":
+			if n, err := strconv.ParseFloat(arg, 64); err == nil {
+				if s.Type == "string" {
+					length := int(n)
+					s.MinLength = &length
+				} else {
+					s.Minimum = &n
+				}
+			}
+		case "lte", "max":
+			if n, err := strconv.ParseFloat(arg, 64); err == nil && s.Type != "string" {
+				s.Maximum = &n
+			}
+		}
+	}
+	return required
+}
+
+// openAPIPath turns gin's /goals/:id into OpenAPI's /goals/{id}.
+func openAPIPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") || strings.HasPrefix(part, "*") {
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// pathParamSchemas are the formats of path parameters, by name.
+var pathParamSchemas = map[string]*openAPISchema{
+	"id":       {Type: "string", Format: "uuid"},
+	"item_id":  {Type: "string", Format: "uuid"},
+	"delivery": {Type: "string", Format: "uuid"},
+	"period":   {Type: "string", Pattern: `^[0-9]{4}-[0-9]{2}$`, Description: "A month, YYYY-MM."},
+}
+
+// pathParams lists the parameters in a gin path.
+func pathParams(path string) []openAPIParameter {
+	var params []openAPIParameter
+	for _, part := range strings.Split(path, "/") {
+		if !strings.HasPrefix(part, ":") {
+			continue
+		}
+		schema, ok := pathParamSchemas[part[1:]]
+		if !ok {
+			schema = &openAPISchema{Type: "string"}
+		}
+		params = append(params, openAPIParameter{Name: part[1:], In: "path", Required: true, Schema: schema})
+	}
+	return params
+}
+
+// describe builds the OpenAPI operation of op, served at path.
+func (b *schemaBuilder) describe(op apiOperation, path string) *openAPIOperation {
+	o := &openAPIOperation{
+		OperationID: op.ID,
+		Summary:     op.Summary,
+		Tags:        []string{op.Tag},
+		Parameters:  pathParams(path),
+		Responses:   map[string]openAPIResponse{},
+	}
+	if op.Public {
+		o.Security = &[]map[string][]string{}
+	}
+	for _, p := range op.Query {
+		o.Parameters = append(o.Parameters, openAPIParameter{Name: p.Name, In: "query", Required: p.Required, Description: p.Description, Schema: p.Schema})
+	}
+
+	if op.Request != nil {
+		o.RequestBody = &openAPIRequestBody{Required: true, Content: map[string]openAPIMediaType{
+			"application/json": {Schema: b.body(op.Request, "json")},
+		}}
+		if op.Form {
+			form := openAPIMediaType{Schema: b.body(op.Request, "form")}
+			o.RequestBody.Content["application/x-www-form-urlencoded"] = form
+			o.RequestBody.Content["multipart/form-data"] = form
+		}
+	}
+	if op.Multipart != nil {
+		o.RequestBody = &openAPIRequestBody{Required: true, Content: map[string]openAPIMediaType{
+			"multipart/form-data": {Schema: b.body(op.Multipart, "form")},
+		}}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	response := openAPIResponse{Description: http.StatusText(status)}
+	switch {
+	case len(op.Produces) > 0:
+		response.Content = map[string]openAPIMediaType{}
+		for _, contentType := range op.Produces {
+			response.Content[contentType] = openAPIMediaType{Schema: &openAPISchema{Type: "string", Format: "binary"}}
+		}
+	case op.Response != nil:
+		response.Content = map[string]openAPIMediaType{"application/json": {Schema: b.body(op.Response, "json")}}
+	}
+	o.Responses[strconv.Itoa(status)] = response
+	o.Responses["default"] = openAPIResponse{
+		Description: "An error.",
+		Content:     map[string]openAPIMediaType{"application/json": {Schema: b.schema(reflect.TypeOf(apiErrorResponse{}), "json")}},
+	}
+	return o
+}
+
+// body is the schema of a request or response body given by example.
+func (b *schemaBuilder) body(example interface{}, tag string) *openAPISchema {
+	if oneOf, ok := example.(apiOneOf); ok {
+		s := &openAPISchema{}
+		for _, alternative := range oneOf {
+			s.OneOf = append(s.OneOf, b.schema(reflect.TypeOf(alternative), tag))
+		}
+		return s
+	}
+	return b.schema(reflect.TypeOf(example), tag)
+}
+
+// apiSpec is the OpenAPI document of the running server, with the schemas
+// requestValidator checks requests against.
+type apiSpec struct {
+	document openAPIDocument
+	// bodies holds, by method and route path, the schemas of request bodies
+	// by content type, and limits the size of those bodies.
+	bodies map[string]map[string]*openAPISchema
+	limits map[string]int64
+}
+
+var openAPI *apiSpec
+
+// buildAPISpec describes every /api route. A route without an entry in
+// apiOperations, or an entry without a route, is a bug caught at start.
+func buildAPISpec(routes gin.RoutesInfo) (*apiSpec, error) {
+	b := newSchemaBuilder()
+	spec := &apiSpec{
+		document: openAPIDocument{
+			OpenAPI: "3.0.3",
+			Info: openAPIInfo{
+				Title:   "SmartSplit API",
+				Version: "1",
+				Description: "Budgeting on top of Plaid. Errors share one envelope; routes outside /api/v1 are " +
+					"deprecated aliases of the ones there.",
+			},
+			Security: []map[string][]string{{"bearerAuth": {}}},
+			Paths:    map[string]map[string]*openAPIOperation{},
+			Components: openAPIComponents{
+				Schemas:         b.components,
+				SecuritySchemes: map[string]openAPIAuthScheme{"bearerAuth": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"}},
+			},
+		},
+		bodies: map[string]map[string]*openAPISchema{},
+		limits: map[string]int64{},
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Path+routes[i].Method < routes[j].Path+routes[j].Method
+	})
+	described := map[string]bool{}
+	operationIDs := map[string]bool{}
+	for _, route := range routes {
+		if !strings.HasPrefix(route.Path, "/api/") {
+			continue
+		}
+		key := route.Method + " " + route.Path
+		deprecated := false
+		if successor, ok := legacySuccessors[key]; ok {
+			key, deprecated = route.Method+" "+successor, true
+		}
+		op, ok := apiOperations[key]
+		if !ok {
+			return nil, fmt.Errorf("openapi: %s %s is not described", route.Method, route.Path)
+		}
+		described[key] = true
+
+		o := b.describe(op, route.Path)
+		if deprecated {
+			o.Deprecated = true
+			o.OperationID += "Legacy"
+		}
+		if operationIDs[o.OperationID] {
+			return nil, fmt.Errorf("openapi: operation id %s is used twice", o.OperationID)
+		}
+		operationIDs[o.OperationID] = true
+		path := openAPIPath(route.Path)
+		if spec.document.Paths[path] == nil {
+			spec.document.Paths[path] = map[string]*openAPIOperation{}
+		}
+		spec.document.Paths[path][strings.ToLower(route.Method)] = o
+
+		if o.RequestBody != nil {
+			bodies := map[string]*openAPISchema{}
+			for contentType, media := range o.RequestBody.Content {
+				bodies[contentType] = media.Schema
+			}
+			spec.bodies[route.Method+" "+route.Path] = bodies
+			spec.limits[route.Method+" "+route.Path] = op.MaxBytes
+		}
+	}
+	for key := range apiOperations {
+		if !described[key] {
+			return nil, fmt.Errorf("openapi: %s is described but not routed", key)
+		}
+	}
+
+	return spec, nil
+}
+
+// resolve follows a $ref to its component.
+func (spec *apiSpec) resolve(s *openAPISchema) *openAPISchema {
+	for s.Ref != "" {
+		s = spec.document.Components.Schemas[s.schemaRef()]
+	}
+	return s
+}
+
+func openAPIHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, openAPI.document)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	plaid "github.com/plaid/plaid-go/v31/plaid"
+	"github.com/plaid/quickstart/repository"
+)
+
+// apiOperation describes a route for the OpenAPI document. Request and
+// Response are values of the body types, Request also accepted as a form
+// when Form is set; Multipart lists the fields of an upload. Routes that
+// answer with a file list its content types in Produces instead.
+type apiOperation struct {
+	ID        string
+	Summary   string
+	Tag       string
+	Public    bool
+	Query     []apiParam
+	Request   interface{}
+	Form      bool
+	Multipart interface{}
+	Response  interface{}
+	// Status defaults to 200.
+	Status   int
+	Produces []string
+	// MaxBytes bounds the request body, validateMaxBodyBytes by default.
+	MaxBytes int64
+}
+
+type apiParam struct {
+	Name        string
+	Description string
+	Required    bool
+	Schema      *openAPISchema
+}
+
+func stringParam(name string, description string) apiParam {
+	return apiParam{Name: name, Description: description, Schema: &openAPISchema{Type: "string"}}
+}
+
+func dateParam(name string, description string) apiParam {
+	return apiParam{Name: name, Description: description, Schema: &openAPISchema{Type: "string", Format: "date"}}
+}
+
+func uuidParam(name string, description string) apiParam {
+	return apiParam{Name: name, Description: description, Schema: &openAPISchema{Type: "string", Format: "uuid"}}
+}
+
+func boolParam(name string, description string) apiParam {
+	return apiParam{Name: name, Description: description, Schema: &openAPISchema{Type: "boolean"}}
+}
+
+func enumParam(name string, description string, values ...string) apiParam {
+	return apiParam{Name: name, Description: description, Schema: &openAPISchema{Type: "string", Enum: values}}
+}
+
+// limitParam is a page size; handlers cap it at their own maximum.
+func limitParam() apiParam {
+	one := 1.0
+	return apiParam{Name: "limit", Description: "Page size, capped by the server.", Schema: &openAPISchema{Type: "integer", Minimum: &one}}
+}
+
+var (
+	dateRangeQuery = []apiParam{
+		dateParam("from", "First day, YYYY-MM-DD."),
+		dateParam("to", "Last day, YYYY-MM-DD."),
+	}
+	exportFormatParam = enumParam("format", "Defaults to csv.", exportFormatCSV, exportFormatJSON, exportFormatXLSX)
+	exportContent     = []string{"text/csv", "application/json", exportContentTypes[exportFormatXLSX]}
+	auditParams       = []apiParam{
+		stringParam("action", "Only entries with this action."),
+		{Name: "since", Description: "Only entries from this RFC 3339 time on.", Schema: &openAPISchema{Type: "string", Format: "date-time"}},
+		limitParam(),
+		{Name: "offset", Schema: &openAPISchema{Type: "integer", Minimum: new(float64)}},
+	}
+)
+
+// apiOperations describes every /api/v1 route, by method and gin path.
+// buildAPISpec refuses to start the server when a route is missing here.
+var apiOperations = map[string]apiOperation{
+	"GET /api/openapi.json": {ID: "getOpenAPI", Summary: "This OpenAPI document", Tag: "meta", Public: true, Response: json.RawMessage{}},
+	"POST /api/v1/info":     {ID: "getInfo", Summary: "The linked item and enabled Plaid products", Tag: "meta", Public: true, Response: infoResponse{}},
+
+	"POST /api/v1/auth/login": {ID: "login", Summary: "Log in with a username and password", Tag: "auth", Public: true,
+		Request: loginRequest{}, Form: true, Response: apiOneOf{loginResponse{}, totpChallengeResponse{}}},
+	"POST /api/v1/auth/login/totp": {ID: "loginTOTP", Summary: "Finish a two-factor login", Tag: "auth", Public: true,
+		Request: totpLoginRequest{}, Form: true, Response: loginResponse{}},
+	"POST /api/v1/auth/totp/enroll":  {ID: "enrollTOTP", Summary: "Start two-factor enrollment", Tag: "auth", Response: totpEnrollResponse{}},
+	"POST /api/v1/auth/totp/confirm": {ID: "confirmTOTP", Summary: "Enable two-factor authentication", Tag: "auth", Request: totpCodeRequest{}, Form: true, Response: totpConfirmResponse{}},
+
+	"GET /api/v1/events": {ID: "streamEvents", Summary: "Server-sent events for the caller", Tag: "events", Produces: []string{"text/event-stream"},
+		Query: []apiParam{
+			stringParam("last_event_id", "Resume after this event, like the Last-Event-ID header."),
+			stringParam("access_token", "The session token, for clients that cannot send headers."),
+		}},
+	"GET /api/v1/audit": {ID: "getAudit", Summary: "The caller's audit log", Tag: "audit", Query: auditParams, Response: auditEntriesResponse{}},
+
+	"GET /api/v1/categories":         {ID: "getCategories", Summary: "Budget categories", Tag: "budget", Response: categoriesResponse{}},
+	"GET /api/v1/budget":             {ID: "getBudget", Summary: "The caller's budget", Tag: "budget", Response: budgetResponse{}},
+	"POST /api/v1/budget":            {ID: "saveBudget", Summary: "Replace the caller's budget", Tag: "budget", Request: getBudgetResponse{}, Response: budgetResponse{}},
+	"GET /api/v1/dummy/transactions": {ID: "getDummyTransactions", Summary: "Sample transactions", Tag: "transactions", Response: getDummyTransactionsResponse{}},
+
+	"GET /api/v1/transactions": {ID: "getTransactions", Summary: "Search the caller's transactions", Tag: "transactions", Response: repository.TransactionPage{},
+		Query: append([]apiParam{
+			stringParam("account_id", "Only this Plaid account."),
+			stringParam("q", "Search names and merchants."),
+			stringParam("category", "Only this category."),
+			{Name: "min_amount", Schema: &openAPISchema{Type: "number"}},
+			{Name: "max_amount", Schema: &openAPISchema{Type: "number"}},
+			uuidParam("expense_id", "Only transactions assigned to this expense."),
+			boolParam("pending", "Only pending, or only posted, transactions."),
+			boolParam("uncategorized", "Only transactions without a category."),
+			enumParam("sort", "Defaults to date.", repository.SortByDate, repository.SortByAmount, repository.SortByName),
+			enumParam("order", "Defaults to desc.", "asc", "desc"),
+			stringParam("cursor", "next_cursor of the previous page."),
+			limitParam(),
+		}, dateRangeQuery...)},
+	"PUT /api/v1/transactions/:id/expense": {ID: "setTransactionExpense", Summary: "Assign a transaction to an expense, or clear its assignment", Tag: "transactions",
+		Request: transactionExpenseRequest{}, Response: transactionExpenseRequest{}},
+	"POST /api/v1/transactions/import": {ID: "importTransactions", Summary: "Import a CSV, OFX or QIF statement", Tag: "transactions",
+		Multipart: importForm{}, Response: importResult{}, MaxBytes: importMaxFileBytes},
+
+	"GET /api/v1/export/transactions": {ID: "exportTransactions", Summary: "Export transactions", Tag: "export",
+		Query: append([]apiParam{exportFormatParam}, dateRangeQuery...), Produces: exportContent},
+	"GET /api/v1/export/budget": {ID: "exportBudget", Summary: "Export the budget", Tag: "export",
+		Query: []apiParam{exportFormatParam}, Produces: exportContent},
+	"GET /api/v1/export/budget-vs-actual": {ID: "exportBudgetVsActual", Summary: "Export budget against actual spending", Tag: "export",
+		Query: append([]apiParam{exportFormatParam}, dateRangeQuery...), Produces: exportContent},
+	"GET /api/v1/backup":   {ID: "getBackup", Summary: "Download a backup archive", Tag: "backup", Produces: []string{"application/zip"}},
+	"POST /api/v1/restore": {ID: "restoreBackup", Summary: "Replace the caller's data from a backup archive", Tag: "backup", Multipart: restoreForm{}, Response: backupRestoreResult{}, MaxBytes: backupMaxBytes},
+
+	"GET /api/v1/statements": {ID: "getStatement", Summary: "A statement as PDF", Tag: "statements", Produces: []string{"application/pdf"},
+		Query: append([]apiParam{{Name: "period", Description: "A month, YYYY-MM, instead of from and to.", Schema: pathParamSchemas["period"]}}, dateRangeQuery...)},
+	"GET /api/v1/statements/settings":      {ID: "getStatementSettings", Summary: "Statement email settings", Tag: "statements", Response: statementSettingsResponse{}},
+	"PUT /api/v1/statements/settings":      {ID: "putStatementSettings", Summary: "Set statement email settings", Tag: "statements", Request: statementSettings{}, Response: statementSettingsResponse{}},
+	"GET /api/v1/statements/notes/:period": {ID: "getStatementNote", Summary: "A statement's note", Tag: "statements", Response: statementNoteResponse{}},
+	"PUT /api/v1/statements/notes/:period": {ID: "putStatementNote", Summary: "Set a statement's note", Tag: "statements", Request: statementNoteRequest{}, Response: statementNoteResponse{}},
+	"GET /api/v1/settings/currency":        {ID: "getCurrencySettings", Summary: "The reporting currency", Tag: "settings", Response: currencySettingsResponse{}},
+	"PUT /api/v1/settings/currency":        {ID: "putCurrencySettings", Summary: "Set the reporting currency", Tag: "settings", Request: currencySettings{}, Response: currencySettingsResponse{}},
+	"GET /api/v1/goals":                    {ID: "getGoals", Summary: "Savings goals", Tag: "goals", Response: goalsResponse{}},
+	"POST /api/v1/goals":                   {ID: "createGoal", Summary: "Create a savings goal", Tag: "goals", Request: goalRequest{}, Response: repository.Goal{}},
+	"PUT /api/v1/goals/:id":                {ID: "updateGoal", Summary: "Update a savings goal", Tag: "goals", Request: goalRequest{}, Response: repository.Goal{}},
+	"DELETE /api/v1/goals/:id":             {ID: "deleteGoal", Summary: "Delete a savings goal", Tag: "goals", Status: http.StatusNoContent},
+	"POST /api/v1/alerts/:id/read":         {ID: "markAlertRead", Summary: "Mark an alert read", Tag: "alerts", Status: http.StatusNoContent},
+	"GET /api/v1/alerts/rules":             {ID: "getAlertRules", Summary: "Alert rules", Tag: "alerts", Response: alertRulesResponse{}},
+	"POST /api/v1/alerts/rules":            {ID: "createAlertRule", Summary: "Create an alert rule", Tag: "alerts", Request: alertRuleRequest{}, Response: repository.AlertRule{}},
+	"PUT /api/v1/alerts/rules/:id":         {ID: "updateAlertRule", Summary: "Update an alert rule", Tag: "alerts", Request: alertRuleRequest{}, Response: repository.AlertRule{}},
+	"DELETE /api/v1/alerts/rules/:id":      {ID: "deleteAlertRule", Summary: "Delete an alert rule", Tag: "alerts", Status: http.StatusNoContent},
+	"GET /api/v1/alerts/settings":          {ID: "getAlertSettings", Summary: "Alert delivery settings", Tag: "alerts", Response: alertSettingsResponse{}},
+	"PUT /api/v1/alerts/settings":          {ID: "putAlertSettings", Summary: "Set alert delivery settings", Tag: "alerts", Request: alertSettingsRequest{}, Response: alertSettingsResponse{}},
+	"GET /api/v1/webhooks":                 {ID: "getWebhooks", Summary: "Webhook endpoints", Tag: "webhooks", Response: webhooksResponse{}},
+	"POST /api/v1/webhooks":                {ID: "createWebhook", Summary: "Create a webhook endpoint", Tag: "webhooks", Request: webhookEndpointRequest{}, Response: webhookEndpointResponse{}},
+	"PUT /api/v1/webhooks/:id":             {ID: "updateWebhook", Summary: "Update a webhook endpoint", Tag: "webhooks", Request: webhookEndpointRequest{}, Response: webhookEndpointResponse{}},
+	"DELETE /api/v1/webhooks/:id":          {ID: "deleteWebhook", Summary: "Delete a webhook endpoint", Tag: "webhooks", Status: http.StatusNoContent},
+	"GET /api/v1/networth/manual":          {ID: "getManualAssets", Summary: "Manual assets and liabilities", Tag: "networth", Response: manualAssetsResponse{}},
+	"POST /api/v1/networth/manual":         {ID: "createManualAsset", Summary: "Add a manual asset or liability", Tag: "networth", Request: manualAssetRequest{}, Response: repository.ManualAsset{}},
+	"PUT /api/v1/networth/manual/:id":      {ID: "updateManualAsset", Summary: "Update a manual asset or liability", Tag: "networth", Request: manualAssetRequest{}, Response: repository.ManualAsset{}},
+	"DELETE /api/v1/networth/manual/:id":   {ID: "deleteManualAsset", Summary: "Delete a manual asset or liability", Tag: "networth", Status: http.StatusNoContent},
+	"GET /api/v1/investments/portfolio":    {ID: "getPortfolio", Summary: "Investment holdings and returns", Tag: "investments", Query: dateRangeQuery, Response: portfolioResponse{}},
+	"POST /api/v1/webhooks/:id/deliveries/:delivery/redeliver": {ID: "redeliverWebhook", Summary: "Queue a delivery again", Tag: "webhooks",
+		Response: repository.WebhookDelivery{}, Status: http.StatusAccepted},
+
+	"GET /api/v1/alerts": {ID: "getAlerts", Summary: "The alert inbox", Tag: "alerts", Response: alertsResponse{},
+		Query: []apiParam{boolParam("unread", "Only unread alerts."), limitParam()}},
+	"GET /api/v1/webhooks/:id/deliveries": {ID: "getWebhookDeliveries", Summary: "An endpoint's delivery log", Tag: "webhooks", Response: webhookDeliveriesResponse{},
+		Query: []apiParam{enumParam("status", "Only deliveries in this state.", repository.WebhookPending, repository.WebhookDelivered, repository.WebhookDead), limitParam()}},
+	"GET /api/v1/networth": {ID: "getNetworth", Summary: "Net worth over time", Tag: "networth", Response: networthResponse{},
+		Query: append([]apiParam{enumParam("interval", "Defaults to day.", networthDaily, networthWeekly, networthMonthly)}, dateRangeQuery...)},
+
+	"GET /api/v1/admin/categories":                  {ID: "adminListCategories", Summary: "The category catalog", Tag: "admin", Response: adminCategoriesResponse{}},
+	"POST /api/v1/admin/categories":                 {ID: "adminCreateCategory", Summary: "Add a category", Tag: "admin", Request: categoryRequest{}, Response: idResponse{}, Status: http.StatusCreated},
+	"PUT /api/v1/admin/categories/:id":              {ID: "adminUpdateCategory", Summary: "Update a category", Tag: "admin", Request: categoryRequest{}, Response: idResponse{}},
+	"DELETE /api/v1/admin/categories/:id":           {ID: "adminDeleteCategory", Summary: "Delete an unused category", Tag: "admin", Status: http.StatusNoContent},
+	"GET /api/v1/admin/users":                       {ID: "adminListUsers", Summary: "All users", Tag: "admin", Response: adminUsersResponse{}},
+	"PUT /api/v1/admin/users/:id/role":              {ID: "adminSetUserRole", Summary: "Set a user's role", Tag: "admin", Request: roleRequest{}, Response: idResponse{}},
+	"POST /api/v1/admin/users/:id/disable":          {ID: "adminDisableUser", Summary: "Disable a user", Tag: "admin", Response: idResponse{}},
+	"POST /api/v1/admin/users/:id/enable":           {ID: "adminEnableUser", Summary: "Enable a user", Tag: "admin", Response: idResponse{}},
+	"GET /api/v1/admin/users/:id/items":             {ID: "adminListUserItems", Summary: "A user's Plaid items", Tag: "admin", Response: adminItemsResponse{}},
+	"GET /api/v1/admin/users/:id/budget":            {ID: "adminGetUserBudget", Summary: "A user's budget", Tag: "admin", Response: budgetResponse{}},
+	"DELETE /api/v1/admin/users/:id/items/:item_id": {ID: "adminUnlinkItem", Summary: "Unlink a user's Plaid item", Tag: "admin", Status: http.StatusNoContent},
+	"GET /api/v1/admin/audit": {ID: "adminGetAudit", Summary: "Everyone's audit log", Tag: "admin", Response: auditEntriesResponse{},
+		Query: append([]apiParam{uuidParam("user_id", "Only entries about this user.")}, auditParams...)},
+
+	"POST /api/v1/plaid/set_access_token":              {ID: "setAccessToken", Summary: "Exchange a Link public token", Tag: "plaid", Request: publicTokenRequest{}, Form: true, Response: itemIDResponse{}},
+	"POST /api/v1/plaid/create_link_token_for_payment": {ID: "createLinkTokenForPayment", Summary: "Create a Link token for a payment", Tag: "plaid", Response: linkTokenResponse{}},
+	"GET /api/v1/plaid/auth":                           {ID: "getAuth", Summary: "Account and routing numbers", Tag: "plaid", Response: authResponse{}},
+	"GET /api/v1/plaid/accounts":                       {ID: "getAccounts", Summary: "Accounts", Tag: "plaid", Response: accountsResponse{}},
+	"GET /api/v1/plaid/balance":                        {ID: "getBalance", Summary: "Live balances", Tag: "plaid", Response: accountsResponse{}},
+	"GET /api/v1/plaid/plaid_categories":               {ID: "getPlaidCategories", Summary: "Plaid's categories", Tag: "plaid", Response: plaidCategoriesResponse{}},
+	"GET /api/v1/plaid/item":                           {ID: "getItem", Summary: "The item and its institution", Tag: "plaid", Response: itemResponse{}},
+	"POST /api/v1/plaid/item":                          {ID: "postItem", Summary: "The item and its institution", Tag: "plaid", Response: itemResponse{}},
+	"GET /api/v1/plaid/identity":                       {ID: "getIdentity", Summary: "Account holders", Tag: "plaid", Response: identityResponse{}},
+	"GET /api/v1/plaid/transactions":                   {ID: "syncTransactions", Summary: "Sync transactions from Plaid", Tag: "plaid", Response: latestTransactionsResponse{}},
+	"POST /api/v1/plaid/transactions":                  {ID: "postSyncTransactions", Summary: "Sync transactions from Plaid", Tag: "plaid", Response: latestTransactionsResponse{}},
+	"GET /api/v1/plaid/payment":                        {ID: "getPayment", Summary: "The last payment", Tag: "plaid", Response: paymentResponse{}},
+	"GET /api/v1/plaid/create_public_token":            {ID: "createPublicToken", Summary: "A public token for Link update mode", Tag: "plaid", Response: publicTokenResponse{}},
+	"POST /api/v1/plaid/create_link_token":             {ID: "createLinkToken", Summary: "Create a Link token", Tag: "plaid", Response: linkTokenResponse{}},
+	"POST /api/v1/plaid/create_user_token":             {ID: "createUserToken", Summary: "Create a Plaid user token", Tag: "plaid", Response: userTokenResponse{}},
+	"GET /api/v1/plaid/holdings":                       {ID: "getHoldings", Summary: "Investment holdings", Tag: "plaid", Response: holdingsResponse{}},
+	"GET /api/v1/plaid/assets":                         {ID: "getAssetReport", Summary: "An asset report", Tag: "plaid", Response: assetsResponse{}},
+	"GET /api/v1/plaid/transfer_authorize":             {ID: "authorizeTransfer", Summary: "Authorize a transfer", Tag: "plaid", Response: plaid.TransferAuthorizationCreateResponse{}},
+	"GET /api/v1/plaid/transfer_create":                {ID: "createTransfer", Summary: "Create the authorized transfer", Tag: "plaid", Response: plaid.TransferCreateResponse{}},
+	"GET /api/v1/plaid/signal_evaluate":                {ID: "evaluateSignal", Summary: "Evaluate a transfer's risk", Tag: "plaid", Response: plaid.SignalEvaluateResponse{}},
+	"GET /api/v1/plaid/statements":                     {ID: "getPlaidStatements", Summary: "Bank statements", Tag: "plaid", Response: plaidStatementsResponse{}},
+	"GET /api/v1/plaid/cra/get_base_report":            {ID: "getCraBaseReport", Summary: "A Consumer Report base report", Tag: "plaid", Response: craBaseReportResponse{}},
+	"GET /api/v1/plaid/cra/get_income_insights":        {ID: "getCraIncomeInsights", Summary: "Consumer Report income insights", Tag: "plaid", Response: craIncomeInsightsResponse{}},
+	"GET /api/v1/plaid/cra/get_partner_insights":       {ID: "getCraPartnerInsights", Summary: "Consumer Report partner insights", Tag: "plaid", Response: craPartnerInsightsResponse{}},
+	"GET /api/v1/plaid/investments_transactions": {ID: "getInvestmentTransactions", Summary: "Investment transactions", Tag: "plaid", Response: investmentTransactionsResponse{},
+		Query: []apiParam{dateParam("start_date", "Defaults to 30 days ago."), dateParam("end_date", "Defaults to today.")}},
+}
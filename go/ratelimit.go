@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/plaid/quickstart/repository"
+)
+
+// RateLimit allows Requests per Period with bursts of up to Requests.
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+func (l RateLimit) ratePerSecond() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// parseRateLimit reads limits written as "<requests>/<duration>", e.g. "10/1m".
+func parseRateLimit(spec string) (RateLimit, error) {
+	requests, period, ok := strings.Cut(strings.TrimSpace(spec), "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("rate limit %q must look like <requests>/<duration>", spec)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q has an invalid request count", spec)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q has an invalid period", spec)
+	}
+
+	return RateLimit{Requests: n, Period: d}, nil
+}
+
+// tokenBucket is the state shared by every RateLimitStore implementation.
+type tokenBucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// take refills the bucket for the time elapsed since its last update and
+// consumes one token if available.
+func (b *tokenBucket) take(limit RateLimit, now time.Time) (bool, int, time.Duration) {
+	rate := limit.ratePerSecond()
+	burst := float64(limit.Requests)
+
+	elapsed := now.Sub(b.UpdatedAt).Seconds()
+	if elapsed > 0 {
+		b.Tokens = math.Min(burst, b.Tokens+elapsed*rate)
+	}
+	b.UpdatedAt = now
+
+	if b.Tokens >= 1 {
+		b.Tokens--
+		return true, int(b.Tokens), 0
+	}
+
+	wait := time.Duration((1 - b.Tokens) / rate * float64(time.Second))
+	return false, 0, wait
+}
+
+// RateLimitStore keeps token buckets keyed by client identity.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit RateLimit) (allowed bool, remaining int, retryAfter time.Duration, err error)
+}
+
+type memoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+// memoryBucket remembers the period of the limit it was taken under, as
+// each route group shares the store with its own limit.
+type memoryBucket struct {
+	tokenBucket
+	period time.Duration
+}
+
+func newMemoryRateLimitStore() *memoryRateLimitStore {
+	return &memoryRateLimitStore{buckets: map[string]*memoryBucket{}}
+}
+
+func (s *memoryRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (bool, int, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokenBucket: tokenBucket{Tokens: float64(limit.Requests), UpdatedAt: now}}
+		s.buckets[key] = bucket
+	}
+	bucket.period = limit.Period
+
+	allowed, remaining, retryAfter := bucket.take(limit, now)
+	return allowed, remaining, retryAfter, nil
+}
+
+// sweep drops buckets that have been idle long enough to be full again.
+func (s *memoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, bucket := range s.buckets {
+		if now.Sub(bucket.UpdatedAt) > bucket.period {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// postgresRateLimitStore shares buckets between server instances through
+// the database.
+type postgresRateLimitStore struct {
+	repo repository.RateLimitRepo
+}
+
+func (s *postgresRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (bool, int, time.Duration, error) {
+	now := time.Now()
+	var allowed bool
+	var remaining int
+	var retryAfter time.Duration
+	initial := repository.RateLimitBucket{Tokens: float64(limit.Requests), UpdatedAt: now}
+	err := s.repo.TakeBucket(ctx, key, initial, func(stored repository.RateLimitBucket) repository.RateLimitBucket {
+		bucket := tokenBucket(stored)
+		allowed, remaining, retryAfter = bucket.take(limit, now)
+		return repository.RateLimitBucket(bucket)
+	})
+	if err != nil {
+		return false, 0, 0, err
+	}
+	return allowed, remaining, retryAfter, nil
+}
+
+// RateLimitKeyFunc derives the bucket key for a request. An empty key skips limiting.
+type RateLimitKeyFunc func(c *gin.Context) string
+
+// trustedProxies reads TRUSTED_PROXIES, the comma-separated addresses or
+// CIDRs of the proxies in front of the server. Only they may set the client
+// IP with X-Forwarded-For; with none, the default, the peer address is used,
+// so clients cannot pick the IP they are limited and audited by.
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+func rateLimitByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+func rateLimitByUser(c *gin.Context) string {
+	if userid := c.GetString("userid"); userid != "" {
+		return "user:" + userid
+	}
+	return ""
+}
+
+// RateLimitMiddleware applies limit to every key produced by keyFuncs and
+// rejects the request with 429 as soon as one bucket is empty.
+func RateLimitMiddleware(store RateLimitStore, name string, limit RateLimit, keyFuncs ...RateLimitKeyFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		remaining := limit.Requests
+		for _, keyFunc := range keyFuncs {
+			key := keyFunc(c)
+			if key == "" {
+				continue
+			}
+
+			allowed, left, retryAfter, err := store.Take(c.Request.Context(), name+":"+key, limit)
+			if err != nil {
+				// Fail open: an unavailable store should not take the API down.
+				logger("ratelimit").WarnContext(c.Request.Context(), "rate limit store unavailable, failing open", "limit", name, "err", err)
+				continue
+			}
+			if left < remaining {
+				remaining = left
+			}
+			if !allowed {
+				setRateLimitHeaders(c, limit, 0, retryAfter)
+				c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				abortError(c, http.StatusTooManyRequests, "Too many requests")
+				return
+			}
+		}
+
+		setRateLimitHeaders(c, limit, remaining, 0)
+		c.Next()
+	}
+}
+
+// setRateLimitHeaders writes the IETF draft RateLimit-* response headers.
+func setRateLimitHeaders(c *gin.Context, limit RateLimit, remaining int, reset time.Duration) {
+	if reset == 0 && remaining < limit.Requests {
+		reset = time.Duration(float64(limit.Requests-remaining) / limit.ratePerSecond() * float64(time.Second))
+	}
+	c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, int(limit.Period.Seconds())))
+	c.Header("RateLimit-Limit", strconv.Itoa(limit.Requests))
+	c.Header("RateLimit-Remaining", strconv.Itoa(remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+}
+
+// LoginLockoutStore tracks consecutive failed logins per key.
+type LoginLockoutStore interface {
+	// LockedFor returns how long the key remains locked, or zero.
+	LockedFor(ctx context.Context, key string) (time.Duration, error)
+	// Fail records a failed attempt and returns the resulting lockout, if any.
+	Fail(ctx context.Context, key string) (time.Duration, error)
+	Reset(ctx context.Context, key string) error
+}
+
+// LockoutPolicy locks an account after MaxFailures consecutive failures,
+// doubling the cooldown with every further failure up to MaxCooldown.
+type LockoutPolicy struct {
+	MaxFailures  int
+	BaseCooldown time.Duration
+	MaxCooldown  time.Duration
+}
+
+func (p LockoutPolicy) cooldown(failures int) time.Duration {
+	if failures < p.MaxFailures {
+		return 0
+	}
+	cooldown := p.BaseCooldown
+	for i := p.MaxFailures; i < failures && cooldown < p.MaxCooldown; i++ {
+		cooldown *= 2
+	}
+	if cooldown > p.MaxCooldown {
+		cooldown = p.MaxCooldown
+	}
+	return cooldown
+}
+
+// forgetAfter is how long failures are remembered once an account is no
+// longer locked: a key idle that long starts counting from zero again.
+func (p LockoutPolicy) forgetAfter() time.Duration {
+	return p.MaxCooldown
+}
+
+type loginFailure struct {
+	failures    int
+	lockedUntil time.Time
+	updatedAt   time.Time
+}
+
+type memoryLoginLockoutStore struct {
+	mu        sync.Mutex
+	policy    LockoutPolicy
+	failures  map[string]*loginFailure
+	lastSweep time.Time
+}
+
+func newMemoryLoginLockoutStore(policy LockoutPolicy) *memoryLoginLockoutStore {
+	return &memoryLoginLockoutStore{policy: policy, failures: map[string]*loginFailure{}}
+}
+
+func (s *memoryLoginLockoutStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f, ok := s.failures[key]; ok {
+		if wait := time.Until(f.lockedUntil); wait > 0 {
+			return wait, nil
+		}
+	}
+	return 0, nil
+}
+
+func (s *memoryLoginLockoutStore) Fail(ctx context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	f, ok := s.failures[key]
+	if !ok {
+		f = &loginFailure{}
+		s.failures[key] = f
+	}
+	f.failures++
+	f.updatedAt = now
+	cooldown := s.policy.cooldown(f.failures)
+	f.lockedUntil = now.Add(cooldown)
+	return cooldown, nil
+}
+
+// sweep drops keys whose lockout has ended and that have not failed since
+// for the policy's forgetAfter, so failures from many accounts or guessed
+// usernames do not pile up.
+func (s *memoryLoginLockoutStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, f := range s.failures {
+		if now.After(f.lockedUntil) && now.Sub(f.updatedAt) > s.policy.forgetAfter() {
+			delete(s.failures, key)
+		}
+	}
+}
+
+func (s *memoryLoginLockoutStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.failures, key)
+	return nil
+}
+
+type postgresLoginLockoutStore struct {
+	repo   repository.RateLimitRepo
+	policy LockoutPolicy
+}
+
+func (s *postgresLoginLockoutStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	lockedUntil, err := s.repo.LockedUntil(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if wait := time.Until(lockedUntil); wait > 0 {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+func (s *postgresLoginLockoutStore) Fail(ctx context.Context, key string) (time.Duration, error) {
+	// Failures are forgotten like the memory store's once the key has been
+	// unlocked and idle for forgetAfter.
+	now := time.Now()
+	failures, err := s.repo.RecordLoginFailure(ctx, key, now, now.Add(-s.policy.forgetAfter()))
+	if err != nil {
+		return 0, err
+	}
+
+	cooldown := s.policy.cooldown(failures)
+	if cooldown > 0 {
+		if err := s.repo.LockLogin(ctx, key, now.Add(cooldown)); err != nil {
+			return 0, err
+		}
+	}
+	return cooldown, nil
+}
+
+func (s *postgresLoginLockoutStore) Reset(ctx context.Context, key string) error {
+	return s.repo.ResetLoginFailures(ctx, key)
+}
+
+// rateLimitSettings holds the limits applied to each route group.
+type rateLimitSettings struct {
+	Store   RateLimitStore
+	Lockout LoginLockoutStore
+	Auth    RateLimit
+	API     RateLimit
+	Plaid   RateLimit
+}
+
+var loginLockout LoginLockoutStore
+
+// loadRateLimitSettings reads RATE_LIMIT_* and LOGIN_LOCKOUT_* from the
+// environment. RATE_LIMIT_STORE=postgres shares state between instances.
+func loadRateLimitSettings(repo repository.RateLimitRepo) (rateLimitSettings, error) {
+	settings := rateLimitSettings{}
+
+	limits := []struct {
+		env      string
+		fallback string
+		target   *RateLimit
+	}{
+		{"RATE_LIMIT_AUTH", "10/1m", &settings.Auth},
+		{"RATE_LIMIT_API", "120/1m", &settings.API},
+		{"RATE_LIMIT_PLAID", "30/1m", &settings.Plaid},
+	}
+	for _, l := range limits {
+		spec := os.Getenv(l.env)
+		if spec == "" {
+			spec = l.fallback
+		}
+		limit, err := parseRateLimit(spec)
+		if err != nil {
+			return settings, fmt.Errorf("%s: %w", l.env, err)
+		}
+		*l.target = limit
+	}
+
+	policy := LockoutPolicy{MaxFailures: 5, BaseCooldown: 30 * time.Second, MaxCooldown: time.Hour}
+	if v := os.Getenv("LOGIN_LOCKOUT_MAX_FAILURES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return settings, fmt.Errorf("LOGIN_LOCKOUT_MAX_FAILURES: invalid value %q", v)
+		}
+		policy.MaxFailures = n
+	}
+	for env, target := range map[string]*time.Duration{
+		"LOGIN_LOCKOUT_BASE_COOLDOWN": &policy.BaseCooldown,
+		"LOGIN_LOCKOUT_MAX_COOLDOWN":  &policy.MaxCooldown,
+	} {
+		if v := os.Getenv(env); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return settings, fmt.Errorf("%s: invalid duration %q", env, v)
+			}
+			*target = d
+		}
+	}
+
+	switch store := os.Getenv("RATE_LIMIT_STORE"); store {
+	case "", "memory":
+		settings.Store = newMemoryRateLimitStore()
+		settings.Lockout = newMemoryLoginLockoutStore(policy)
+	case "postgres":
+		if dbDriver != driverPostgres {
+			return settings, fmt.Errorf("RATE_LIMIT_STORE=postgres shares limits through a Postgres database, but DB_DRIVER is %s: set DB_DRIVER=postgres or RATE_LIMIT_STORE=memory", dbDriver)
+		}
+		settings.Store = &postgresRateLimitStore{repo: repo}
+		settings.Lockout = &postgresLoginLockoutStore{repo: repo, policy: policy}
+	default:
+		return settings, fmt.Errorf("RATE_LIMIT_STORE: unknown store %q", store)
+	}
+
+	return settings, nil
+}
+
+// tooManyAttempts responds to a locked-out login attempt.
+func tooManyAttempts(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	respondError(c, http.StatusTooManyRequests, "Too many failed login attempts, try again later")
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/plaid/quickstart/repository"
+)
+
+func TestLockoutPolicyCooldown(t *testing.T) {
+	policy := LockoutPolicy{MaxFailures: 3, BaseCooldown: 30 * time.Second, MaxCooldown: 5 * time.Minute}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{2, 0},
+		{3, 30 * time.Second},
+		{4, time.Minute},
+		{5, 2 * time.Minute},
+		{6, 4 * time.Minute},
+		{7, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := policy.cooldown(tt.failures); got != tt.want {
+			t.Errorf("cooldown(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryRateLimitStoreSweepsByBucketPeriod(t *testing.T) {
+	now := time.Now()
+	store := newMemoryRateLimitStore()
+	store.buckets = map[string]*memoryBucket{
+		"auth:short-idle":  {tokenBucket: tokenBucket{UpdatedAt: now.Add(-2 * time.Minute)}, period: time.Minute},
+		"auth:short-fresh": {tokenBucket: tokenBucket{UpdatedAt: now.Add(-30 * time.Second)}, period: time.Minute},
+		"api:long":         {tokenBucket: tokenBucket{UpdatedAt: now.Add(-2 * time.Minute)}, period: time.Hour},
+	}
+
+	store.sweep(now)
+
+	for key, want := range map[string]bool{"auth:short-idle": false, "auth:short-fresh": true, "api:long": true} {
+		if _, kept := store.buckets[key]; kept != want {
+			t.Errorf("bucket %s kept = %v, want %v", key, kept, want)
+		}
+	}
+}
+
+func TestMemoryRateLimitStoreTake(t *testing.T) {
+	store := newMemoryRateLimitStore()
+	limit := RateLimit{Requests: 2, Period: time.Hour}
+
+	tests := []struct {
+		key       string
+		allowed   bool
+		remaining int
+	}{
+		{"a", true, 1},
+		{"a", true, 0},
+		{"a", false, 0},
+		{"b", true, 1},
+	}
+	for i, tt := range tests {
+		allowed, remaining, retryAfter, err := store.Take(context.Background(), tt.key, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != tt.allowed || remaining != tt.remaining {
+			t.Errorf("take %d on %s = %v, %d, want %v, %d", i, tt.key, allowed, remaining, tt.allowed, tt.remaining)
+		}
+		if !allowed && retryAfter <= 0 {
+			t.Errorf("take %d on %s was refused without a retry delay", i, tt.key)
+		}
+	}
+}
+
+func TestMemoryLoginLockoutStoreSweep(t *testing.T) {
+	now := time.Now()
+	policy := LockoutPolicy{MaxFailures: 3, BaseCooldown: time.Minute, MaxCooldown: 10 * time.Minute}
+
+	tests := []struct {
+		name    string
+		failure loginFailure
+		kept    bool
+	}{
+		{"recent failure", loginFailure{failures: 1, updatedAt: now.Add(-time.Minute)}, true},
+		{"idle failure", loginFailure{failures: 1, updatedAt: now.Add(-11 * time.Minute)}, false},
+		{"still locked", loginFailure{failures: 9, updatedAt: now.Add(-11 * time.Minute), lockedUntil: now.Add(time.Minute)}, true},
+		{"lock ended long ago", loginFailure{failures: 9, updatedAt: now.Add(-time.Hour), lockedUntil: now.Add(-50 * time.Minute)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryLoginLockoutStore(policy)
+			failure := tt.failure
+			store.failures["key"] = &failure
+
+			store.sweep(now)
+
+			if _, kept := store.failures["key"]; kept != tt.kept {
+				t.Errorf("kept = %v, want %v", kept, tt.kept)
+			}
+		})
+	}
+}
+
+func TestRateLimitByIPIgnoresUntrustedForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name    string
+		proxies string
+		want    string
+	}{
+		{"no trusted proxies", "", "ip:192.0.2.1"},
+		{"peer is a trusted proxy", "192.0.2.0/24", "ip:203.0.113.7"},
+		{"peer is not a trusted proxy", "10.0.0.0/8", "ip:192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", tt.proxies)
+			r := gin.New()
+			if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			r.GET("/", func(c *gin.Context) { got = rateLimitByIP(c) })
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			req.Header.Set("X-Forwarded-For", "203.0.113.7")
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// sharedRateLimitRepo runs the shared stores' queries on a migrated SQLite
+// database, which takes them unchanged.
+func sharedRateLimitRepo(t *testing.T) repository.RateLimitRepo {
+	t.Helper()
+	db := openTestSQLite(t)
+	if err := runMigrateCommand(context.Background(), db, driverSQLite, []string{"up"}); err != nil {
+		t.Fatal(err)
+	}
+	return repository.New(db).RateLimits
+}
+
+func TestPostgresRateLimitStoreTake(t *testing.T) {
+	store := &postgresRateLimitStore{repo: sharedRateLimitRepo(t)}
+	limit := RateLimit{Requests: 2, Period: time.Hour}
+
+	tests := []struct {
+		key       string
+		allowed   bool
+		remaining int
+	}{
+		{"a", true, 1},
+		{"a", true, 0},
+		{"a", false, 0},
+		{"b", true, 1},
+	}
+	for i, tt := range tests {
+		allowed, remaining, _, err := store.Take(context.Background(), tt.key, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != tt.allowed || remaining != tt.remaining {
+			t.Errorf("take %d on %s = %v, %d, want %v, %d", i, tt.key, allowed, remaining, tt.allowed, tt.remaining)
+		}
+	}
+}
+
+func TestPostgresLoginLockoutStore(t *testing.T) {
+	ctx := context.Background()
+	policy := LockoutPolicy{MaxFailures: 2, BaseCooldown: time.Minute, MaxCooldown: 10 * time.Minute}
+	store := &postgresLoginLockoutStore{repo: sharedRateLimitRepo(t), policy: policy}
+
+	tests := []struct {
+		step       string
+		wantLocked bool
+	}{
+		{"fail", false},
+		{"fail", true},
+		{"reset", false},
+		{"fail", false},
+	}
+	for i, tt := range tests {
+		switch tt.step {
+		case "fail":
+			if _, err := store.Fail(ctx, "user:a"); err != nil {
+				t.Fatal(err)
+			}
+		case "reset":
+			if err := store.Reset(ctx, "user:a"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		wait, err := store.LockedFor(ctx, "user:a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked := wait > 0; locked != tt.wantLocked {
+			t.Errorf("step %d (%s): locked = %v, want %v", i, tt.step, locked, tt.wantLocked)
+		}
+	}
+}
+
+func TestLoadRateLimitSettingsStore(t *testing.T) {
+	tests := []struct {
+		driver  string
+		store   string
+		wantErr bool
+	}{
+		{driverSQLite, "", false},
+		{driverSQLite, "memory", false},
+		{driverSQLite, "postgres", true},
+		{driverPostgres, "postgres", false},
+		{driverPostgres, "redis", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.driver+"/"+tt.store, func(t *testing.T) {
+			saved := dbDriver
+			dbDriver = tt.driver
+			t.Cleanup(func() { dbDriver = saved })
+			t.Setenv("RATE_LIMIT_STORE", tt.store)
+
+			_, err := loadRateLimitSettings(nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// unknownUserRepo knows no usernames.
+type unknownUserRepo struct{ fakeUserRepo }
+
+func (r *unknownUserRepo) ByUsername(ctx context.Context, username string) (repository.User, error) {
+	return repository.User{}, repository.ErrNotFound
+}
+
+func TestLoginLockoutIsPerAddress(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useFakeAudit(t)
+	savedLockout := loginLockout
+	repos.Users = &unknownUserRepo{}
+	loginLockout = newMemoryLoginLockoutStore(LockoutPolicy{MaxFailures: 2, BaseCooldown: time.Minute, MaxCooldown: time.Hour})
+	t.Cleanup(func() { loginLockout = savedLockout })
+
+	r := gin.New()
+	r.POST("/login", loginHandler)
+	login := func(remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"username": "alice", "password": "guess"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	steps := []struct {
+		remoteAddr string
+		want       int
+	}{
+		{"198.51.100.1:1000", http.StatusUnauthorized},
+		{"198.51.100.1:1001", http.StatusTooManyRequests},
+		{"198.51.100.1:1002", http.StatusTooManyRequests},
+		{"203.0.113.7:2000", http.StatusUnauthorized},
+	}
+	for i, step := range steps {
+		if got := login(step.remoteAddr); got != step.want {
+			t.Errorf("attempt %d from %s: status %d, want %d", i+1, step.remoteAddr, got, step.want)
+		}
+	}
+}
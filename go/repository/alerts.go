@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type sqlAlertRepo struct {
+	db *sql.DB
+}
+
+const alertRuleColumns = `rule_id, kind, name, target, threshold, channels, enabled, created_at`
+
+func scanAlertRule(row rowScanner) (AlertRule, error) {
+	var rule AlertRule
+	var target sql.NullString
+	var channels string
+	if err := row.Scan(&rule.ID, &rule.Kind, &rule.Name, &target, &rule.Threshold, &channels, &rule.Enabled, &rule.CreatedAt); err != nil {
+		return AlertRule{}, err
+	}
+	rule.Target = target.String
+	rule.Channels = []string{}
+	if channels != "" {
+		rule.Channels = strings.Split(channels, ",")
+	}
+	return rule, nil
+}
+
+func (r *sqlAlertRepo) Rules(ctx context.Context, userid string) ([]AlertRule, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+alertRuleColumns+` FROM "AlertRule" WHERE user_id = $1 ORDER BY created_at, rule_id`, userid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []AlertRule{}
+	for rows.Next() {
+		rule, err := scanAlertRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+func (r *sqlAlertRepo) SaveRule(ctx context.Context, userid string, rule AlertRule) (AlertRule, error) {
+	channels := strings.Join(rule.Channels, ",")
+	if rule.ID == uuid.Nil {
+		rule.ID = uuid.New()
+		_, err := r.db.ExecContext(ctx, `INSERT INTO "AlertRule" (rule_id, user_id, kind, name, target, threshold, channels, enabled) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			rule.ID, userid, rule.Kind, rule.Name, nullString(rule.Target), rule.Threshold, channels, rule.Enabled)
+		if err != nil {
+			return AlertRule{}, err
+		}
+	} else {
+		err := affectedOne(r.db.ExecContext(ctx, `UPDATE "AlertRule" SET kind = $1, name = $2, target = $3, threshold = $4, channels = $5, enabled = $6, updated_at = CURRENT_TIMESTAMP
+			WHERE rule_id = $7 AND user_id = $8`,
+			rule.Kind, rule.Name, nullString(rule.Target), rule.Threshold, channels, rule.Enabled, rule.ID, userid))
+		if err != nil {
+			return AlertRule{}, err
+		}
+	}
+
+	saved, err := scanAlertRule(r.db.QueryRowContext(ctx, `SELECT `+alertRuleColumns+` FROM "AlertRule" WHERE rule_id = $1`, rule.ID))
+	return saved, notFound(err)
+}
+
+func (r *sqlAlertRepo) DeleteRule(ctx context.Context, userid string, id uuid.UUID) error {
+	return affectedOne(r.db.ExecContext(ctx, `DELETE FROM "AlertRule" WHERE rule_id = $1 AND user_id = $2`, id, userid))
+}
+
+func (r *sqlAlertRepo) Fire(ctx context.Context, alert Alert, channels []string) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if alert.ID == uuid.Nil {
+		alert.ID = uuid.New()
+	}
+	if alert.CreatedAt.IsZero() {
+		alert.CreatedAt = time.Now().UTC()
+	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO "Alert" (alert_id, user_id, rule_id, kind, dedupe_key, title, body, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (user_id, dedupe_key) DO NOTHING`,
+		alert.ID, alert.UserID, alert.RuleID, alert.Kind, alert.DedupeKey, alert.Title, alert.Body, alert.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	for _, channel := range channels {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO "AlertDelivery" (alert_id, channel) VALUES ($1, $2) ON CONFLICT DO NOTHING`, alert.ID, channel); err != nil {
+			return false, err
+		}
+	}
+
+	return true, tx.Commit()
+}
+
+const alertColumns = `a.alert_id, a.user_id, a.rule_id, a.kind, a.dedupe_key, a.title, a.body, a.created_at, a.read_at`
+
+func scanAlert(row rowScanner, extra ...interface{}) (Alert, error) {
+	var alert Alert
+	var ruleID uuid.NullUUID
+	var readAt sql.NullTime
+	dest := append([]interface{}{&alert.ID, &alert.UserID, &ruleID, &alert.Kind, &alert.DedupeKey, &alert.Title, &alert.Body, &alert.CreatedAt, &readAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return Alert{}, err
+	}
+	if ruleID.Valid {
+		alert.RuleID = &ruleID.UUID
+	}
+	if readAt.Valid {
+		alert.ReadAt = &readAt.Time
+	}
+	return alert, nil
+}
+
+func (r *sqlAlertRepo) List(ctx context.Context, userid string, unreadOnly bool, limit int) ([]Alert, error) {
+	query := `SELECT ` + alertColumns + ` FROM "Alert" a WHERE a.user_id = $1`
+	if unreadOnly {
+		query += ` AND a.read_at IS NULL`
+	}
+	rows, err := r.db.QueryContext(ctx, query+` ORDER BY a.created_at DESC, a.alert_id LIMIT $2`, userid, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []Alert{}
+	for rows.Next() {
+		alert, err := scanAlert(rows)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, alert)
+	}
+
+	return alerts, rows.Err()
+}
+
+func (r *sqlAlertRepo) MarkRead(ctx context.Context, userid string, id uuid.UUID) error {
+	return affectedOne(r.db.ExecContext(ctx, `UPDATE "Alert" SET read_at = COALESCE(read_at, CURRENT_TIMESTAMP) WHERE alert_id = $1 AND user_id = $2`, id, userid))
+}
+
+func (r *sqlAlertRepo) PendingDeliveries(ctx context.Context, now time.Time, maxAttempts int, limit int) ([]AlertDelivery, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+alertColumns+`, d.channel, d.attempts, u.email FROM "AlertDelivery" d
+		JOIN "Alert" a ON a.alert_id = d.alert_id
+		JOIN "Users" u ON u.user_id = a.user_id
+		WHERE d.delivered_at IS NULL AND d.next_attempt_at <= $1 AND d.attempts < $2 AND NOT u.disabled
+		ORDER BY d.next_attempt_at, d.alert_id LIMIT $3`, now, maxAttempts, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []AlertDelivery{}
+	for rows.Next() {
+		var delivery AlertDelivery
+		delivery.Alert, err = scanAlert(rows, &delivery.Channel, &delivery.Attempts, &delivery.Email)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (r *sqlAlertRepo) MarkAttempted(ctx context.Context, alertID uuid.UUID, channel string, deliveryErr error, retryAt time.Time) error {
+	if deliveryErr == nil {
+		return affectedOne(r.db.ExecContext(ctx, `UPDATE "AlertDelivery" SET attempts = attempts + 1, last_error = NULL, delivered_at = CURRENT_TIMESTAMP
+			WHERE alert_id = $1 AND channel = $2`, alertID, channel))
+	}
+	return affectedOne(r.db.ExecContext(ctx, `UPDATE "AlertDelivery" SET attempts = attempts + 1, last_error = $1, next_attempt_at = $2
+		WHERE alert_id = $3 AND channel = $4`, deliveryErr.Error(), retryAt, alertID, channel))
+}
+
+func (r *sqlAlertRepo) QueuedDeliveries(ctx context.Context, maxAttempts int) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM "AlertDelivery" WHERE delivered_at IS NULL AND attempts < $1`, maxAttempts).Scan(&n)
+	return n, err
+}
+
+func (r *sqlAlertRepo) Settings(ctx context.Context, userid string) (AlertSettings, error) {
+	settings := AlertSettings{Timezone: "UTC"}
+	var quietStart, quietEnd, webhookURL, webhookSecret sql.NullString
+	err := r.db.QueryRowContext(ctx, `SELECT quiet_start, quiet_end, timezone, webhook_url, webhook_secret FROM "AlertSettings" WHERE user_id = $1`, userid).
+		Scan(&quietStart, &quietEnd, &settings.Timezone, &webhookURL, &webhookSecret)
+	if err == sql.ErrNoRows {
+		return settings, nil
+	}
+	settings.QuietStart, settings.QuietEnd = quietStart.String, quietEnd.String
+	settings.WebhookURL, settings.WebhookSecret = webhookURL.String, webhookSecret.String
+	return settings, err
+}
+
+func (r *sqlAlertRepo) SaveSettings(ctx context.Context, userid string, settings AlertSettings) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO "AlertSettings" (user_id, quiet_start, quiet_end, timezone, webhook_url, webhook_secret) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id) DO UPDATE SET quiet_start = excluded.quiet_start, quiet_end = excluded.quiet_end, timezone = excluded.timezone,
+		webhook_url = excluded.webhook_url, webhook_secret = excluded.webhook_secret, updated_at = CURRENT_TIMESTAMP`,
+		userid, nullString(settings.QuietStart), nullString(settings.QuietEnd), settings.Timezone, nullString(settings.WebhookURL), nullString(settings.WebhookSecret))
+	return err
+}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// auditIdentifierMaxBytes is the width of "AuditLog".resource_id.
+const auditIdentifierMaxBytes = 255
+
+type sqlAuditRepo struct {
+	db *sql.DB
+}
+
+func (r *sqlAuditRepo) Append(ctx context.Context, entry AuditEntry) error {
+	if entry.ID == uuid.Nil {
+		entry.ID = uuid.New()
+	}
+	if entry.OccurredAt.IsZero() {
+		entry.OccurredAt = time.Now().UTC()
+	}
+	if len(entry.ResourceID) > auditIdentifierMaxBytes {
+		entry.ResourceID = entry.ResourceID[:auditIdentifierMaxBytes]
+	}
+
+	_, err := r.db.ExecContext(ctx, `INSERT INTO "AuditLog" (audit_id, occurred_at, actor_user_id, subject_user_id, action, resource_type, resource_id, ip, request_id, before, after, metadata)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		entry.ID, entry.OccurredAt, nullUUID(entry.ActorID), nullUUID(entry.SubjectID), entry.Action,
+		entry.ResourceType, entry.ResourceID, entry.IP, entry.RequestID,
+		nullJSON(entry.Before), nullJSON(entry.After), nullJSON(entry.Metadata))
+	return err
+}
+
+func (r *sqlAuditRepo) List(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	query := `SELECT audit_id, occurred_at, actor_user_id, subject_user_id, action, resource_type, resource_id, ip, request_id, before, after, metadata FROM "AuditLog" WHERE 1 = 1`
+	args := []interface{}{}
+
+	if q.UserID != "" {
+		args = append(args, q.UserID)
+		query += ` AND (actor_user_id = $` + strconv.Itoa(len(args)) + ` OR subject_user_id = $` + strconv.Itoa(len(args)) + `)`
+	}
+	if q.Action != "" {
+		args = append(args, q.Action)
+		query += ` AND action = $` + strconv.Itoa(len(args))
+	}
+	if !q.Since.IsZero() {
+		args = append(args, q.Since)
+		query += ` AND occurred_at >= $` + strconv.Itoa(len(args))
+	}
+	args = append(args, q.Limit, q.Offset)
+	query += ` ORDER BY occurred_at DESC, audit_id LIMIT $` + strconv.Itoa(len(args)-1) + ` OFFSET $` + strconv.Itoa(len(args))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var actor, subject, resourceType, resourceID, ip, requestID sql.NullString
+		var before, after, metadata []byte
+		err := rows.Scan(&entry.ID, &entry.OccurredAt, &actor, &subject, &entry.Action, &resourceType, &resourceID, &ip, &requestID, &before, &after, &metadata)
+		if err != nil {
+			return nil, err
+		}
+		entry.ActorID = actor.String
+		entry.SubjectID = subject.String
+		entry.ResourceType = resourceType.String
+		entry.ResourceID = resourceID.String
+		entry.IP = ip.String
+		entry.RequestID = requestID.String
+		entry.Before = before
+		entry.After = after
+		entry.Metadata = metadata
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// nullUUID stores ids that are not UUIDs, such as a failed login's unknown
+// user, as NULL.
+func nullUUID(s string) interface{} {
+	if _, err := uuid.Parse(s); err != nil {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+type sqlBackupRepo struct {
+	db *sql.DB
+}
+
+func (r *sqlBackupRepo) Restore(ctx context.Context, userid string, snapshot Snapshot) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Assignments go with their transactions and expenses; expenses must go
+	// before the allocations they reference.
+	for _, table := range []string{"TransactionRaw", "Expenses", "Income", "Allocations"} {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM "`+table+`" WHERE user_id = $1`, userid); err != nil {
+			return err
+		}
+	}
+
+	for _, item := range snapshot.Items {
+		_, err := tx.ExecContext(ctx, `INSERT INTO "PlaidItem" (item_id, user_id, plaid_item_id, plaid_access_token, institution_name, created_at, last_synced_at) VALUES ($1, $2, $3, '', $4, $5, $6)`,
+			item.ID, userid, item.PlaidItemID, nullString(item.InstitutionName), item.CreatedAt.UTC(), item.LastSyncedAt)
+		if err != nil {
+			return err
+		}
+	}
+	for _, allocation := range snapshot.Budget.Allocations {
+		_, err := tx.ExecContext(ctx, `INSERT INTO "Allocations" ("allocation_type", "allocation_description", "allocation_factor", "user_id") VALUES ($1, $2, $3, $4)`,
+			allocation.AllocationType, allocation.AllocationDescription, allocation.AllocationFactor, userid)
+		if err != nil {
+			return err
+		}
+	}
+	for _, expense := range snapshot.Budget.Expenses {
+		_, err := tx.ExecContext(ctx, `INSERT INTO "Expenses" ("expense_id", "expense_description", "expense_amount", "expense_category", "user_id", "allocation_type") VALUES ($1, $2, $3, $4, $5, $6)`,
+			expense.Id, expense.Description, expense.Amount, expense.Category, userid, expense.AllocationType)
+		if err != nil {
+			return err
+		}
+	}
+	for _, income := range snapshot.Budget.Incomes {
+		_, err := tx.ExecContext(ctx, `INSERT INTO "Income" ("income_id", "income_description", "income_amount", "income_frequency", "user_id") VALUES ($1, $2, $3, $4, $5)`,
+			income.Id, income.Description, income.Amount, income.Frequency, userid)
+		if err != nil {
+			return err
+		}
+	}
+	for _, t := range snapshot.Transactions {
+		t.UserID = userid
+		if _, err := tx.ExecContext(ctx, insertTransaction, insertTransactionArgs(t)...); err != nil {
+			return err
+		}
+	}
+	for _, a := range snapshot.Assignments {
+		_, err := tx.ExecContext(ctx, `INSERT INTO "TransactionExpense" (transcation_id, expense_id, confidence, source) VALUES ($1, $2, $3, $4)`,
+			a.TransactionID, a.ExpenseID, a.Confidence, a.Source)
+		if err != nil {
+			return err
+		}
+	}
+
+	if snapshot.Goals != nil {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM "Goal" WHERE user_id = $1`, userid); err != nil {
+			return err
+		}
+		for _, goal := range snapshot.Goals {
+			_, err := tx.ExecContext(ctx, `INSERT INTO "Goal" (goal_id, user_id, name, target_amount, saved_amount, target_date, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+				goal.ID, userid, goal.Name, goal.TargetAmount, goal.SavedAmount, goal.TargetDate, goal.CreatedAt.UTC())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if snapshot.Notes != nil {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM "StatementNote" WHERE user_id = $1`, userid); err != nil {
+			return err
+		}
+		for _, note := range snapshot.Notes {
+			_, err := tx.ExecContext(ctx, `INSERT INTO "StatementNote" (user_id, period, body) VALUES ($1, $2, $3)`, userid, note.Period, note.Body)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if snapshot.ManualAssets != nil {
+		// Values go with their assets.
+		if _, err := tx.ExecContext(ctx, `DELETE FROM "ManualAsset" WHERE user_id = $1`, userid); err != nil {
+			return err
+		}
+		for _, asset := range snapshot.ManualAssets {
+			_, err := tx.ExecContext(ctx, `INSERT INTO "ManualAsset" (asset_id, user_id, name, kind, asset_type, iso_currency_code, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+				asset.ID, userid, asset.Name, asset.Kind, asset.Type, nullString(asset.ISOCurrencyCode), asset.CreatedAt.UTC())
+			if err != nil {
+				return err
+			}
+		}
+		for _, value := range snapshot.ManualAssetValues {
+			_, err := tx.ExecContext(ctx, `INSERT INTO "ManualAssetValue" (asset_id, as_of, value) VALUES ($1, $2, $3)`, value.AssetID, dateOnly(value.Date), value.Value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if snapshot.ReportingCurrency != nil {
+		_, err := tx.ExecContext(ctx, `UPDATE "Users" SET reporting_currency = $1, updated_at = CURRENT_TIMESTAMP WHERE user_id = $2`, nullString(*snapshot.ReportingCurrency), userid)
+		if err != nil {
+			return err
+		}
+	}
+	if snapshot.AlertRules != nil {
+		// Fired alerts stay in the inbox without their rule.
+		if _, err := tx.ExecContext(ctx, `DELETE FROM "AlertRule" WHERE user_id = $1`, userid); err != nil {
+			return err
+		}
+		for _, rule := range snapshot.AlertRules {
+			_, err := tx.ExecContext(ctx, `INSERT INTO "AlertRule" (rule_id, user_id, kind, name, target, threshold, channels, enabled, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+				rule.ID, userid, rule.Kind, rule.Name, nullString(rule.Target), rule.Threshold, strings.Join(rule.Channels, ","), rule.Enabled, rule.CreatedAt.UTC())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if settings := snapshot.AlertSettings; settings != nil {
+		_, err := tx.ExecContext(ctx, `INSERT INTO "AlertSettings" (user_id, quiet_start, quiet_end, timezone, webhook_url, webhook_secret) VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (user_id) DO UPDATE SET quiet_start = excluded.quiet_start, quiet_end = excluded.quiet_end, timezone = excluded.timezone,
+			webhook_url = excluded.webhook_url, webhook_secret = excluded.webhook_secret, updated_at = CURRENT_TIMESTAMP`,
+			userid, nullString(settings.QuietStart), nullString(settings.QuietEnd), settings.Timezone, nullString(settings.WebhookURL), nullString(settings.WebhookSecret))
+		if err != nil {
+			return err
+		}
+	}
+	if snapshot.WebhookEndpoints != nil {
+		// Deliveries go with their endpoints.
+		if _, err := tx.ExecContext(ctx, `DELETE FROM "WebhookEndpoint" WHERE user_id = $1`, userid); err != nil {
+			return err
+		}
+		for _, endpoint := range snapshot.WebhookEndpoints {
+			_, err := tx.ExecContext(ctx, `INSERT INTO "WebhookEndpoint" (endpoint_id, user_id, url, description, events, secret, enabled, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+				endpoint.ID, userid, endpoint.URL, nullString(endpoint.Description), strings.Join(endpoint.Events, ","), endpoint.Secret, endpoint.Enabled, endpoint.CreatedAt.UTC())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,195 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type sqlBalanceRepo struct {
+	db *sql.DB
+}
+
+const balanceSnapshotColumns = `s.item_id, s.plaid_account_id, s.account_name, s.account_type, s.account_subtype, s.current_balance, s.available_balance, s.credit_limit, s.iso_currency_code, s.snapshot_date`
+
+// dateOnly drops the time of day, so that dates compare equal however they
+// were produced. SQLite compares dates as the text they are stored as.
+func dateOnly(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func (r *sqlBalanceRepo) SaveSnapshots(ctx context.Context, userid string, snapshots []BalanceSnapshot) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, s := range snapshots {
+		_, err := tx.ExecContext(ctx, `INSERT INTO "BalanceSnapshot" (snapshot_id, user_id, item_id, plaid_account_id, account_name, account_type, account_subtype, current_balance, available_balance, credit_limit, iso_currency_code, snapshot_date)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			ON CONFLICT (item_id, plaid_account_id, snapshot_date) DO UPDATE SET
+				account_name = excluded.account_name,
+				account_type = excluded.account_type,
+				account_subtype = excluded.account_subtype,
+				current_balance = excluded.current_balance,
+				available_balance = excluded.available_balance,
+				credit_limit = excluded.credit_limit,
+				iso_currency_code = excluded.iso_currency_code`,
+			uuid.New(), userid, s.ItemID, s.PlaidAccountID, s.Name, s.Type, nullString(s.Subtype),
+			s.Current, s.Available, s.Limit, nullString(s.ISOCurrencyCode), dateOnly(s.Date))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *sqlBalanceRepo) ItemsWithoutSnapshot(ctx context.Context, date time.Time) ([]PlaidItem, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+plaidItemColumns+` FROM "PlaidItem" i
+		WHERE i.plaid_access_token <> ''
+		AND NOT EXISTS (SELECT 1 FROM "BalanceSnapshot" s WHERE s.item_id = i.item_id AND s.snapshot_date = $1)
+		ORDER BY i.created_at`, dateOnly(date))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []PlaidItem{}
+	for rows.Next() {
+		item, err := scanPlaidItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+func (r *sqlBalanceRepo) History(ctx context.Context, userid string, from time.Time, to time.Time) ([]BalanceSnapshot, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+balanceSnapshotColumns+` FROM "BalanceSnapshot" s
+		WHERE s.user_id = $1 AND s.snapshot_date <= $3
+		AND (s.snapshot_date >= $2 OR s.snapshot_date = (
+			SELECT MAX(p.snapshot_date) FROM "BalanceSnapshot" p
+			WHERE p.item_id = s.item_id AND p.plaid_account_id = s.plaid_account_id AND p.snapshot_date < $2))
+		ORDER BY s.snapshot_date, s.item_id, s.plaid_account_id`, userid, dateOnly(from), dateOnly(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := []BalanceSnapshot{}
+	for rows.Next() {
+		var s BalanceSnapshot
+		var subtype, currency sql.NullString
+		err := rows.Scan(&s.ItemID, &s.PlaidAccountID, &s.Name, &s.Type, &subtype, &s.Current, &s.Available, &s.Limit, &currency, &s.Date)
+		if err != nil {
+			return nil, err
+		}
+		s.Subtype = subtype.String
+		s.ISOCurrencyCode = currency.String
+		snapshots = append(snapshots, s)
+	}
+
+	return snapshots, rows.Err()
+}
+
+const manualAssetColumns = `a.asset_id, a.name, a.kind, a.asset_type, a.iso_currency_code, a.created_at, v.value, v.as_of`
+
+// manualAssetFrom joins each asset to its latest value.
+const manualAssetFrom = `"ManualAsset" a JOIN "ManualAssetValue" v ON v.asset_id = a.asset_id
+	AND v.as_of = (SELECT MAX(l.as_of) FROM "ManualAssetValue" l WHERE l.asset_id = a.asset_id)`
+
+func scanManualAsset(row rowScanner) (ManualAsset, error) {
+	var asset ManualAsset
+	var currency sql.NullString
+	err := row.Scan(&asset.ID, &asset.Name, &asset.Kind, &asset.Type, &currency, &asset.CreatedAt, &asset.Value, &asset.ValueAsOf)
+	asset.ISOCurrencyCode = currency.String
+	return asset, err
+}
+
+func (r *sqlBalanceRepo) ListManual(ctx context.Context, userid string) ([]ManualAsset, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+manualAssetColumns+` FROM `+manualAssetFrom+`
+		WHERE a.user_id = $1 ORDER BY a.kind, a.name, a.asset_id`, userid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assets := []ManualAsset{}
+	for rows.Next() {
+		asset, err := scanManualAsset(rows)
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+
+	return assets, rows.Err()
+}
+
+func (r *sqlBalanceRepo) SaveManual(ctx context.Context, userid string, asset ManualAsset, asOf time.Time) (ManualAsset, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ManualAsset{}, err
+	}
+	defer tx.Rollback()
+
+	if asset.ID == uuid.Nil {
+		asset.ID = uuid.New()
+		_, err := tx.ExecContext(ctx, `INSERT INTO "ManualAsset" (asset_id, user_id, name, kind, asset_type, iso_currency_code) VALUES ($1, $2, $3, $4, $5, $6)`,
+			asset.ID, userid, asset.Name, asset.Kind, asset.Type, nullString(asset.ISOCurrencyCode))
+		if err != nil {
+			return ManualAsset{}, err
+		}
+	} else {
+		res, err := tx.ExecContext(ctx, `UPDATE "ManualAsset" SET name = $1, kind = $2, asset_type = $3, iso_currency_code = $4, updated_at = CURRENT_TIMESTAMP WHERE asset_id = $5 AND user_id = $6`,
+			asset.Name, asset.Kind, asset.Type, nullString(asset.ISOCurrencyCode), asset.ID, userid)
+		if err := affectedOne(res, err); err != nil {
+			return ManualAsset{}, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO "ManualAssetValue" (asset_id, as_of, value) VALUES ($1, $2, $3)
+		ON CONFLICT (asset_id, as_of) DO UPDATE SET value = excluded.value`, asset.ID, dateOnly(asOf), asset.Value)
+	if err != nil {
+		return ManualAsset{}, err
+	}
+
+	saved, err := scanManualAsset(tx.QueryRowContext(ctx, `SELECT `+manualAssetColumns+` FROM `+manualAssetFrom+` WHERE a.asset_id = $1`, asset.ID))
+	if err != nil {
+		return ManualAsset{}, err
+	}
+	return saved, tx.Commit()
+}
+
+func (r *sqlBalanceRepo) DeleteManual(ctx context.Context, userid string, id uuid.UUID) error {
+	return affectedOne(r.db.ExecContext(ctx, `DELETE FROM "ManualAsset" WHERE asset_id = $1 AND user_id = $2`, id, userid))
+}
+
+func (r *sqlBalanceRepo) ManualHistory(ctx context.Context, userid string, from time.Time, to time.Time) ([]ManualAssetValue, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT v.asset_id, v.as_of, v.value FROM "ManualAssetValue" v
+		JOIN "ManualAsset" a ON a.asset_id = v.asset_id
+		WHERE a.user_id = $1 AND v.as_of <= $3
+		AND (v.as_of >= $2 OR v.as_of = (SELECT MAX(p.as_of) FROM "ManualAssetValue" p WHERE p.asset_id = v.asset_id AND p.as_of < $2))
+		ORDER BY v.as_of, v.asset_id`, userid, dateOnly(from), dateOnly(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []ManualAssetValue{}
+	for rows.Next() {
+		var value ManualAssetValue
+		if err := rows.Scan(&value.AssetID, &value.Date, &value.Value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
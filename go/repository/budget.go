@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+type sqlBudgetRepo struct {
+	db *sql.DB
+}
+
+func (r *sqlBudgetRepo) Load(ctx context.Context, userid string) (Budget, error) {
+	return loadBudget(ctx, r.db, userid)
+}
+
+func loadBudget(ctx context.Context, q queryer, userid string) (Budget, error) {
+	budget := Budget{
+		Incomes:     []Income{},
+		Expenses:    []Expense{},
+		Allocations: []Allocation{},
+	}
+
+	incomeRows, err := q.QueryContext(ctx, `SELECT "income_id", "income_description", "income_amount", "income_frequency" FROM "Income" WHERE "user_id" = $1 ORDER BY "created_at", "income_id"`, userid)
+	if err != nil {
+		return budget, err
+	}
+	defer incomeRows.Close()
+	for incomeRows.Next() {
+		var income Income
+		if err := incomeRows.Scan(&income.Id, &income.Description, &income.Amount, &income.Frequency); err != nil {
+			return budget, err
+		}
+		budget.Incomes = append(budget.Incomes, income)
+	}
+	if err := incomeRows.Err(); err != nil {
+		return budget, err
+	}
+
+	expenseRows, err := q.QueryContext(ctx, `SELECT "expense_id", "expense_description", "expense_amount", "expense_category", "allocation_type" FROM "Expenses" WHERE "user_id" = $1 ORDER BY "created_at", "expense_id"`, userid)
+	if err != nil {
+		return budget, err
+	}
+	defer expenseRows.Close()
+	for expenseRows.Next() {
+		var expense Expense
+		if err := expenseRows.Scan(&expense.Id, &expense.Description, &expense.Amount, &expense.Category, &expense.AllocationType); err != nil {
+			return budget, err
+		}
+		budget.Expenses = append(budget.Expenses, expense)
+	}
+	if err := expenseRows.Err(); err != nil {
+		return budget, err
+	}
+
+	allocationRows, err := q.QueryContext(ctx, `SELECT "allocation_type", "allocation_description", "allocation_factor" FROM "Allocations" WHERE "user_id" = $1 ORDER BY "allocation_description"`, userid)
+	if err != nil {
+		return budget, err
+	}
+	defer allocationRows.Close()
+	for allocationRows.Next() {
+		var allocation Allocation
+		if err := allocationRows.Scan(&allocation.AllocationType, &allocation.AllocationDescription, &allocation.AllocationFactor); err != nil {
+			return budget, err
+		}
+		budget.Allocations = append(budget.Allocations, allocation)
+	}
+
+	return budget, allocationRows.Err()
+}
+
+func (r *sqlBudgetRepo) Save(ctx context.Context, userid string, budget Budget) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	previous, err := loadBudget(ctx, tx, userid)
+	if err != nil {
+		return err
+	}
+
+	keepAllocations := map[string]bool{}
+	for _, allocation := range budget.Allocations {
+		if allocation.AllocationType == "" {
+			allocation.AllocationType = uuid.NewString()
+		}
+		keepAllocations[allocation.AllocationType] = true
+		_, err := tx.ExecContext(ctx, `INSERT INTO "Allocations" ("allocation_type", "allocation_description", "allocation_factor", "user_id") VALUES ($1, $2, $3, $4)
+			ON CONFLICT ("allocation_type") DO UPDATE SET "allocation_description" = EXCLUDED."allocation_description", "allocation_factor" = EXCLUDED."allocation_factor"
+			WHERE "Allocations"."user_id" = EXCLUDED."user_id"`,
+			allocation.AllocationType, allocation.AllocationDescription, allocation.AllocationFactor, userid)
+		if err != nil {
+			return err
+		}
+	}
+
+	keepIncomes := map[uuid.UUID]bool{}
+	for _, income := range budget.Incomes {
+		if income.Id == uuid.Nil {
+			income.Id = uuid.New()
+		}
+		keepIncomes[income.Id] = true
+		_, err := tx.ExecContext(ctx, `INSERT INTO "Income" ("income_id", "income_description", "income_amount", "income_frequency", "user_id") VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT ("income_id") DO UPDATE SET "income_description" = EXCLUDED."income_description", "income_amount" = EXCLUDED."income_amount", "income_frequency" = EXCLUDED."income_frequency", "updated_at" = CURRENT_TIMESTAMP
+			WHERE "Income"."user_id" = EXCLUDED."user_id"`,
+			income.Id, income.Description, income.Amount, income.Frequency, userid)
+		if err != nil {
+			return err
+		}
+	}
+
+	keepExpenses := map[uuid.UUID]bool{}
+	for _, expense := range budget.Expenses {
+		if expense.Id == uuid.Nil {
+			expense.Id = uuid.New()
+		}
+		keepExpenses[expense.Id] = true
+		_, err := tx.ExecContext(ctx, `INSERT INTO "Expenses" ("expense_id", "expense_description", "expense_amount", "expense_category", "user_id", "allocation_type") VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT ("expense_id") DO UPDATE SET "expense_description" = EXCLUDED."expense_description", "expense_amount" = EXCLUDED."expense_amount", "expense_category" = EXCLUDED."expense_category", "allocation_type" = EXCLUDED."allocation_type", "updated_at" = CURRENT_TIMESTAMP
+			WHERE "Expenses"."user_id" = EXCLUDED."user_id"`,
+			expense.Id, expense.Description, expense.Amount, expense.Category, userid, expense.AllocationType)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Expenses go first since they reference allocations.
+	for _, expense := range previous.Expenses {
+		if !keepExpenses[expense.Id] {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM "Expenses" WHERE "expense_id" = $1 AND "user_id" = $2`, expense.Id, userid); err != nil {
+				return err
+			}
+		}
+	}
+	for _, allocation := range previous.Allocations {
+		if !keepAllocations[allocation.AllocationType] {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM "Allocations" WHERE "allocation_type" = $1 AND "user_id" = $2`, allocation.AllocationType, userid); err != nil {
+				return err
+			}
+		}
+	}
+	for _, income := range previous.Incomes {
+		if !keepIncomes[income.Id] {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM "Income" WHERE "income_id" = $1 AND "user_id" = $2`, income.Id, userid); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
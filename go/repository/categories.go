@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+type sqlCategoryRepo struct {
+	db *sql.DB
+}
+
+const categoryColumns = `category_id, plaid_category_primary_descriptor, plaid_category_detailed_descriptor, category_name, category_description, created_at, updated_at`
+
+func (r *sqlCategoryRepo) List(ctx context.Context) ([]Category, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+categoryColumns+` FROM "Category" ORDER BY category_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []Category{}
+	for rows.Next() {
+		var category Category
+		err := rows.Scan(&category.ID, &category.PlaidPrimary, &category.PlaidDetailed, &category.Name, &category.Description, &category.CreatedAt, &category.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+
+	return categories, rows.Err()
+}
+
+func (r *sqlCategoryRepo) Get(ctx context.Context, id uuid.UUID) (Category, error) {
+	var category Category
+	err := r.db.QueryRowContext(ctx, `SELECT `+categoryColumns+` FROM "Category" WHERE category_id = $1`, id).
+		Scan(&category.ID, &category.PlaidPrimary, &category.PlaidDetailed, &category.Name, &category.Description, &category.CreatedAt, &category.UpdatedAt)
+	if err != nil {
+		return Category{}, notFound(err)
+	}
+	return category, nil
+}
+
+func (r *sqlCategoryRepo) Create(ctx context.Context, category Category) (uuid.UUID, error) {
+	id := uuid.New()
+	_, err := r.db.ExecContext(ctx, `INSERT INTO "Category" (category_id, plaid_category_primary_descriptor, plaid_category_detailed_descriptor, category_name, category_description) VALUES ($1, $2, $3, $4, $5)`,
+		id, category.PlaidPrimary, category.PlaidDetailed, category.Name, category.Description)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return id, nil
+}
+
+func (r *sqlCategoryRepo) Update(ctx context.Context, category Category) error {
+	return affectedOne(r.db.ExecContext(ctx, `UPDATE "Category" SET plaid_category_primary_descriptor = $1, plaid_category_detailed_descriptor = $2, category_name = $3, category_description = $4, updated_at = CURRENT_TIMESTAMP WHERE category_id = $5`,
+		category.PlaidPrimary, category.PlaidDetailed, category.Name, category.Description, category.ID))
+}
+
+func (r *sqlCategoryRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	err := affectedOne(r.db.ExecContext(ctx, `DELETE FROM "Category" WHERE category_id = $1`, id))
+	// Expenses reference categories without ON DELETE CASCADE.
+	if isForeignKeyViolation(err) {
+		return ErrConflict
+	}
+	return err
+}
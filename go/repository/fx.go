@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type sqlFXRepo struct {
+	db *sql.DB
+}
+
+func (r *sqlFXRepo) SaveRates(ctx context.Context, rates []FXRate) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, rate := range rates {
+		_, err := tx.ExecContext(ctx, `INSERT INTO "FXRate" (base_currency, currency, rate_date, rate, source) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (base_currency, currency, rate_date) DO UPDATE SET rate = excluded.rate, source = excluded.source`,
+			rate.BaseCurrency, rate.Currency, dateOnly(rate.Date), rate.Rate, rate.Source)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *sqlFXRepo) Rates(ctx context.Context, from time.Time, to time.Time) ([]FXRate, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT f.base_currency, f.currency, f.rate_date, f.rate, f.source FROM "FXRate" f
+		WHERE f.rate_date <= $2
+		AND (f.rate_date >= $1 OR f.rate_date = (
+			SELECT MAX(p.rate_date) FROM "FXRate" p
+			WHERE p.base_currency = f.base_currency AND p.currency = f.currency AND p.rate_date < $1))
+		ORDER BY f.rate_date, f.base_currency, f.currency`, dateOnly(from), dateOnly(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []FXRate{}
+	for rows.Next() {
+		var rate FXRate
+		if err := rows.Scan(&rate.BaseCurrency, &rate.Currency, &rate.Date, &rate.Rate, &rate.Source); err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+
+	return rates, rows.Err()
+}
+
+func (r *sqlFXRepo) LatestDate(ctx context.Context, source string) (time.Time, error) {
+	// Selecting the column rather than MAX() keeps its type on SQLite.
+	var date time.Time
+	err := r.db.QueryRowContext(ctx, `SELECT rate_date FROM "FXRate" WHERE source = $1 ORDER BY rate_date DESC LIMIT 1`, source).Scan(&date)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return date, err
+}
+
+func (r *sqlFXRepo) ReportingCurrency(ctx context.Context, userid string) (string, error) {
+	var currency sql.NullString
+	err := r.db.QueryRowContext(ctx, `SELECT reporting_currency FROM "Users" WHERE user_id = $1`, userid).Scan(&currency)
+	return currency.String, notFound(err)
+}
+
+func (r *sqlFXRepo) SetReportingCurrency(ctx context.Context, userid string, currency string) error {
+	return affectedOne(r.db.ExecContext(ctx, `UPDATE "Users" SET reporting_currency = $1, updated_at = CURRENT_TIMESTAMP WHERE user_id = $2`, nullString(currency), userid))
+}
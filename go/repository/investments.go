@@ -0,0 +1,291 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type sqlInvestmentRepo struct {
+	db *sql.DB
+}
+
+// saveSecurities upserts securities and makes sure every id in referenced
+// exists, so that holdings and transactions can point at securities Plaid
+// did not describe.
+func saveSecurities(ctx context.Context, tx *sql.Tx, securities []Security, referenced []string) error {
+	for _, s := range securities {
+		var closePriceAsOf interface{}
+		if s.ClosePriceAsOf != nil {
+			closePriceAsOf = dateOnly(*s.ClosePriceAsOf)
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO "Security" (security_id, name, ticker_symbol, security_type, is_cash_equivalent, close_price, close_price_as_of, iso_currency_code)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT (security_id) DO UPDATE SET
+				name = excluded.name,
+				ticker_symbol = excluded.ticker_symbol,
+				security_type = excluded.security_type,
+				is_cash_equivalent = excluded.is_cash_equivalent,
+				close_price = excluded.close_price,
+				close_price_as_of = excluded.close_price_as_of,
+				iso_currency_code = excluded.iso_currency_code,
+				updated_at = CURRENT_TIMESTAMP`,
+			s.ID, nullString(s.Name), nullString(s.TickerSymbol), nullString(s.Type), s.CashEquivalent, s.ClosePrice, closePriceAsOf, nullString(s.ISOCurrencyCode))
+		if err != nil {
+			return err
+		}
+	}
+	for _, id := range referenced {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO "Security" (security_id) VALUES ($1) ON CONFLICT DO NOTHING`, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *sqlInvestmentRepo) SaveHoldings(ctx context.Context, userid string, itemid uuid.UUID, accounts []InvestmentAccount, securities []Security, holdings []Holding, date time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, a := range accounts {
+		_, err := tx.ExecContext(ctx, `INSERT INTO "InvestmentAccount" (item_id, plaid_account_id, user_id, name, subtype, iso_currency_code) VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (item_id, plaid_account_id) DO UPDATE SET name = excluded.name, subtype = excluded.subtype, iso_currency_code = excluded.iso_currency_code, updated_at = CURRENT_TIMESTAMP`,
+			itemid, a.PlaidAccountID, userid, a.Name, nullString(a.Subtype), nullString(a.ISOCurrencyCode))
+		if err != nil {
+			return err
+		}
+	}
+
+	referenced := make([]string, 0, len(holdings))
+	for _, h := range holdings {
+		referenced = append(referenced, h.SecurityID)
+	}
+	if err := saveSecurities(ctx, tx, securities, referenced); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM "HoldingSnapshot" WHERE item_id = $1 AND snapshot_date = $2`, itemid, dateOnly(date)); err != nil {
+		return err
+	}
+	for _, h := range holdings {
+		_, err := tx.ExecContext(ctx, `INSERT INTO "HoldingSnapshot" (snapshot_id, user_id, item_id, plaid_account_id, security_id, snapshot_date, quantity, institution_price, institution_value, cost_basis, iso_currency_code)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+			uuid.New(), userid, itemid, h.PlaidAccountID, h.SecurityID, dateOnly(date), h.Quantity, h.Price, h.Value, h.CostBasis, nullString(h.ISOCurrencyCode))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *sqlInvestmentRepo) SaveTransactions(ctx context.Context, userid string, securities []Security, transactions []InvestmentTransaction) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	referenced := []string{}
+	for _, t := range transactions {
+		if t.SecurityID != "" {
+			referenced = append(referenced, t.SecurityID)
+		}
+	}
+	if err := saveSecurities(ctx, tx, securities, referenced); err != nil {
+		return err
+	}
+
+	for _, t := range transactions {
+		_, err := tx.ExecContext(ctx, `INSERT INTO "InvestmentTransaction" (investment_transaction_id, user_id, item_id, plaid_investment_transaction_id, plaid_account_id, security_id, date, name, type, subtype, quantity, price, amount, fees, iso_currency_code)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+			ON CONFLICT (item_id, plaid_investment_transaction_id) DO UPDATE SET
+				plaid_account_id = excluded.plaid_account_id,
+				security_id = excluded.security_id,
+				date = excluded.date,
+				name = excluded.name,
+				type = excluded.type,
+				subtype = excluded.subtype,
+				quantity = excluded.quantity,
+				price = excluded.price,
+				amount = excluded.amount,
+				fees = excluded.fees,
+				iso_currency_code = excluded.iso_currency_code`,
+			uuid.New(), userid, t.ItemID, t.PlaidInvestmentTransactionID, t.PlaidAccountID, nullString(t.SecurityID), dateOnly(t.Date),
+			t.Name, t.Type, nullString(t.Subtype), t.Quantity, t.Price, t.Amount, t.Fees, nullString(t.ISOCurrencyCode))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *sqlInvestmentRepo) ItemsToSync(ctx context.Context, date time.Time) ([]PlaidItem, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+plaidItemColumns+` FROM "PlaidItem" i
+		WHERE i.plaid_access_token <> ''
+		AND NOT EXISTS (SELECT 1 FROM "InvestmentSync" s WHERE s.item_id = i.item_id AND s.synced_on >= $1)
+		ORDER BY i.created_at`, dateOnly(date))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []PlaidItem{}
+	for rows.Next() {
+		item, err := scanPlaidItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+func (r *sqlInvestmentRepo) MarkSynced(ctx context.Context, itemid uuid.UUID, date time.Time, syncErr string) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO "InvestmentSync" (item_id, synced_on, error) VALUES ($1, $2, $3)
+		ON CONFLICT (item_id) DO UPDATE SET synced_on = excluded.synced_on, error = excluded.error`, itemid, dateOnly(date), nullString(syncErr))
+	return err
+}
+
+func (r *sqlInvestmentRepo) LastTransactionDate(ctx context.Context, itemid uuid.UUID) (time.Time, error) {
+	// Selecting the column rather than MAX() keeps its type on SQLite.
+	var date time.Time
+	err := r.db.QueryRowContext(ctx, `SELECT date FROM "InvestmentTransaction" WHERE item_id = $1 ORDER BY date DESC LIMIT 1`, itemid).Scan(&date)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return date, err
+}
+
+func (r *sqlInvestmentRepo) Accounts(ctx context.Context, userid string) ([]InvestmentAccount, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT item_id, plaid_account_id, name, subtype, iso_currency_code FROM "InvestmentAccount"
+		WHERE user_id = $1 ORDER BY name, plaid_account_id`, userid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []InvestmentAccount{}
+	for rows.Next() {
+		var a InvestmentAccount
+		var subtype, currency sql.NullString
+		if err := rows.Scan(&a.ItemID, &a.PlaidAccountID, &a.Name, &subtype, &currency); err != nil {
+			return nil, err
+		}
+		a.Subtype, a.ISOCurrencyCode = subtype.String, currency.String
+		accounts = append(accounts, a)
+	}
+
+	return accounts, rows.Err()
+}
+
+func (r *sqlInvestmentRepo) Securities(ctx context.Context, userid string) ([]Security, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT security_id, name, ticker_symbol, security_type, is_cash_equivalent, close_price, close_price_as_of, iso_currency_code FROM "Security"
+		WHERE security_id IN (SELECT security_id FROM "HoldingSnapshot" WHERE user_id = $1)
+		OR security_id IN (SELECT security_id FROM "InvestmentTransaction" WHERE user_id = $1)
+		ORDER BY security_id`, userid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	securities := []Security{}
+	for rows.Next() {
+		var s Security
+		var name, ticker, securityType, currency sql.NullString
+		var closePriceAsOf sql.NullTime
+		if err := rows.Scan(&s.ID, &name, &ticker, &securityType, &s.CashEquivalent, &s.ClosePrice, &closePriceAsOf, &currency); err != nil {
+			return nil, err
+		}
+		s.Name, s.TickerSymbol, s.Type, s.ISOCurrencyCode = name.String, ticker.String, securityType.String, currency.String
+		if closePriceAsOf.Valid {
+			s.ClosePriceAsOf = &closePriceAsOf.Time
+		}
+		securities = append(securities, s)
+	}
+
+	return securities, rows.Err()
+}
+
+func (r *sqlInvestmentRepo) Holdings(ctx context.Context, userid string, date time.Time) ([]Holding, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT h.item_id, h.plaid_account_id, h.security_id, h.snapshot_date, h.quantity, h.institution_price, h.institution_value, h.cost_basis, h.iso_currency_code
+		FROM "HoldingSnapshot" h
+		WHERE h.user_id = $1 AND h.snapshot_date = (
+			SELECT MAX(p.snapshot_date) FROM "HoldingSnapshot" p
+			WHERE p.item_id = h.item_id AND p.plaid_account_id = h.plaid_account_id AND p.snapshot_date <= $2)
+		ORDER BY h.item_id, h.plaid_account_id, h.institution_value DESC`, userid, dateOnly(date))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holdings := []Holding{}
+	for rows.Next() {
+		var h Holding
+		var currency sql.NullString
+		if err := rows.Scan(&h.ItemID, &h.PlaidAccountID, &h.SecurityID, &h.Date, &h.Quantity, &h.Price, &h.Value, &h.CostBasis, &currency); err != nil {
+			return nil, err
+		}
+		h.ISOCurrencyCode = currency.String
+		holdings = append(holdings, h)
+	}
+
+	return holdings, rows.Err()
+}
+
+func (r *sqlInvestmentRepo) AccountValues(ctx context.Context, userid string, from time.Time, to time.Time) ([]AccountValue, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT h.item_id, h.plaid_account_id, h.snapshot_date, SUM(h.institution_value) FROM "HoldingSnapshot" h
+		WHERE h.user_id = $1 AND h.snapshot_date <= $3
+		AND (h.snapshot_date >= $2 OR h.snapshot_date = (
+			SELECT MAX(p.snapshot_date) FROM "HoldingSnapshot" p
+			WHERE p.item_id = h.item_id AND p.plaid_account_id = h.plaid_account_id AND p.snapshot_date < $2))
+		GROUP BY h.item_id, h.plaid_account_id, h.snapshot_date
+		ORDER BY h.snapshot_date, h.item_id, h.plaid_account_id`, userid, dateOnly(from), dateOnly(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []AccountValue{}
+	for rows.Next() {
+		var v AccountValue
+		if err := rows.Scan(&v.ItemID, &v.PlaidAccountID, &v.Date, &v.Value); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+
+	return values, rows.Err()
+}
+
+func (r *sqlInvestmentRepo) Transactions(ctx context.Context, userid string, from time.Time, to time.Time) ([]InvestmentTransaction, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT item_id, plaid_account_id, plaid_investment_transaction_id, security_id, date, name, type, subtype, quantity, price, amount, fees, iso_currency_code
+		FROM "InvestmentTransaction" WHERE user_id = $1 AND date >= $2 AND date <= $3
+		ORDER BY date, plaid_investment_transaction_id`, userid, dateOnly(from), dateOnly(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []InvestmentTransaction{}
+	for rows.Next() {
+		var t InvestmentTransaction
+		var securityID, subtype, currency sql.NullString
+		err := rows.Scan(&t.ItemID, &t.PlaidAccountID, &t.PlaidInvestmentTransactionID, &securityID, &t.Date, &t.Name, &t.Type, &subtype,
+			&t.Quantity, &t.Price, &t.Amount, &t.Fees, &currency)
+		if err != nil {
+			return nil, err
+		}
+		t.SecurityID, t.Subtype, t.ISOCurrencyCode = securityID.String, subtype.String, currency.String
+		transactions = append(transactions, t)
+	}
+
+	return transactions, rows.Err()
+}
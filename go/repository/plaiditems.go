@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+type sqlPlaidItemRepo struct {
+	db *sql.DB
+}
+
+const plaidItemColumns = `item_id, user_id, plaid_item_id, plaid_access_token, institution_name, sync_cursor, last_synced_at, login_required_at, created_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPlaidItem(row rowScanner) (PlaidItem, error) {
+	var item PlaidItem
+	var institution, cursor sql.NullString
+	var lastSynced, loginRequired sql.NullTime
+	err := row.Scan(&item.ID, &item.UserID, &item.PlaidItemID, &item.AccessToken, &institution, &cursor, &lastSynced, &loginRequired, &item.CreatedAt)
+	if err != nil {
+		return PlaidItem{}, err
+	}
+	item.InstitutionName = institution.String
+	item.SyncCursor = cursor.String
+	if lastSynced.Valid {
+		item.LastSyncedAt = &lastSynced.Time
+	}
+	if loginRequired.Valid {
+		item.LoginRequiredAt = &loginRequired.Time
+	}
+	return item, nil
+}
+
+func (r *sqlPlaidItemRepo) Link(ctx context.Context, userid string, itemid uuid.UUID, plaidItemID string, userAccessToken string, itemAccessToken string) (PlaidItem, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return PlaidItem{}, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE "Users" SET plaid_access_token = $1, updated_at = CURRENT_TIMESTAMP WHERE user_id = $2`, userAccessToken, userid)
+	if err := affectedOne(res, err); err != nil {
+		return PlaidItem{}, err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO "PlaidItem" (item_id, user_id, plaid_item_id, plaid_access_token) VALUES ($1, $2, $3, $4)`,
+		itemid, userid, plaidItemID, itemAccessToken)
+	if err != nil {
+		return PlaidItem{}, err
+	}
+	item, err := scanPlaidItem(tx.QueryRowContext(ctx, `SELECT `+plaidItemColumns+` FROM "PlaidItem" WHERE item_id = $1`, itemid))
+	if err != nil {
+		return PlaidItem{}, err
+	}
+
+	return item, tx.Commit()
+}
+
+func (r *sqlPlaidItemRepo) LinkManual(ctx context.Context, userid string, account string) (PlaidItem, error) {
+	plaidItemID := ManualItemPrefix + account
+	item, err := scanPlaidItem(r.db.QueryRowContext(ctx, `SELECT `+plaidItemColumns+` FROM "PlaidItem" WHERE user_id = $1 AND plaid_item_id = $2`, userid, plaidItemID))
+	if err != sql.ErrNoRows {
+		return item, err
+	}
+
+	itemid := uuid.New()
+	_, err = r.db.ExecContext(ctx, `INSERT INTO "PlaidItem" (item_id, user_id, plaid_item_id, plaid_access_token, institution_name) VALUES ($1, $2, $3, '', $4)`,
+		itemid, userid, plaidItemID, account)
+	if err != nil {
+		return PlaidItem{}, err
+	}
+	return r.Get(ctx, userid, itemid)
+}
+
+func (r *sqlPlaidItemRepo) Get(ctx context.Context, userid string, itemid uuid.UUID) (PlaidItem, error) {
+	item, err := scanPlaidItem(r.db.QueryRowContext(ctx, `SELECT `+plaidItemColumns+` FROM "PlaidItem" WHERE item_id = $1 AND user_id = $2`, itemid, userid))
+	return item, notFound(err)
+}
+
+func (r *sqlPlaidItemRepo) ListByUser(ctx context.Context, userid string) ([]PlaidItem, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+plaidItemColumns+` FROM "PlaidItem" WHERE user_id = $1 ORDER BY created_at`, userid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []PlaidItem{}
+	for rows.Next() {
+		item, err := scanPlaidItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+func (r *sqlPlaidItemRepo) Linked(ctx context.Context) ([]PlaidItem, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+plaidItemColumns+` FROM "PlaidItem" WHERE plaid_access_token <> '' ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []PlaidItem{}
+	for rows.Next() {
+		item, err := scanPlaidItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+func (r *sqlPlaidItemRepo) SetSyncCursor(ctx context.Context, itemid uuid.UUID, cursor string) error {
+	return affectedOne(r.db.ExecContext(ctx, `UPDATE "PlaidItem" SET sync_cursor = $1, last_synced_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE item_id = $2`, cursor, itemid))
+}
+
+func (r *sqlPlaidItemRepo) SetLoginRequired(ctx context.Context, itemid uuid.UUID, required bool) error {
+	if !required {
+		_, err := r.db.ExecContext(ctx, `UPDATE "PlaidItem" SET login_required_at = NULL WHERE item_id = $1 AND login_required_at IS NOT NULL`, itemid)
+		return err
+	}
+	_, err := r.db.ExecContext(ctx, `UPDATE "PlaidItem" SET login_required_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE item_id = $1 AND login_required_at IS NULL`, itemid)
+	return err
+}
+
+func (r *sqlPlaidItemRepo) Delete(ctx context.Context, userid string, itemid uuid.UUID, clearCurrent bool) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = affectedOne(tx.ExecContext(ctx, `DELETE FROM "PlaidItem" WHERE item_id = $1 AND user_id = $2`, itemid, userid))
+	if err != nil {
+		return err
+	}
+	if clearCurrent {
+		if _, err := tx.ExecContext(ctx, `UPDATE "Users" SET plaid_access_token = NULL, updated_at = CURRENT_TIMESTAMP WHERE user_id = $1`, userid); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type sqlRateLimitRepo struct {
+	db *sql.DB
+}
+
+func (r *sqlRateLimitRepo) TakeBucket(ctx context.Context, key string, initial RateLimitBucket, take func(RateLimitBucket) RateLimitBucket) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The no-op update locks an existing row on Postgres until the
+	// transaction ends, where SQLite locks the whole database anyway.
+	var bucket RateLimitBucket
+	err = tx.QueryRowContext(ctx, `INSERT INTO "RateLimitBucket" (bucket_key, tokens, updated_at) VALUES ($1, $2, $3)
+		ON CONFLICT (bucket_key) DO UPDATE SET bucket_key = excluded.bucket_key
+		RETURNING tokens, updated_at`, key, initial.Tokens, initial.UpdatedAt).Scan(&bucket.Tokens, &bucket.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	bucket = take(bucket)
+	_, err = tx.ExecContext(ctx, `UPDATE "RateLimitBucket" SET tokens = $1, updated_at = $2 WHERE bucket_key = $3`, bucket.Tokens, bucket.UpdatedAt, key)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *sqlRateLimitRepo) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	var lockedUntil sql.NullTime
+	err := r.db.QueryRowContext(ctx, `SELECT locked_until FROM "LoginFailure" WHERE lockout_key = $1`, key).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return lockedUntil.Time, err
+}
+
+func (r *sqlRateLimitRepo) RecordLoginFailure(ctx context.Context, key string, now time.Time, forgetBefore time.Time) (int, error) {
+	var failures int
+	err := r.db.QueryRowContext(ctx, `INSERT INTO "LoginFailure" (lockout_key, failures, updated_at) VALUES ($1, 1, $2)
+		ON CONFLICT (lockout_key) DO UPDATE SET failures = CASE
+			WHEN "LoginFailure".updated_at < $3 AND COALESCE("LoginFailure".locked_until < $2, TRUE) THEN 1
+			ELSE "LoginFailure".failures + 1 END,
+			updated_at = $2
+		RETURNING failures`, key, now, forgetBefore).Scan(&failures)
+	return failures, err
+}
+
+func (r *sqlRateLimitRepo) LockLogin(ctx context.Context, key string, until time.Time) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE "LoginFailure" SET locked_until = $1 WHERE lockout_key = $2`, until, key)
+	return err
+}
+
+func (r *sqlRateLimitRepo) ResetLoginFailures(ctx context.Context, key string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM "LoginFailure" WHERE lockout_key = $1`, key)
+	return err
+}
@@ -0,0 +1,901 @@
+// Package repository is the data access layer. Handlers depend on the
+// interfaces declared here rather than on *sql.DB, so they can be exercised
+// against in-memory fakes.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/plaid/quickstart/money"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var (
+	// ErrNotFound is returned when the requested row does not exist, or does
+	// not belong to the user it was looked up for.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a change would break a reference from another row.
+	ErrConflict = errors.New("conflict")
+	// ErrInvalidCursor is returned for a page cursor that was not issued for
+	// the requested sort.
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+type User struct {
+	ID           string
+	Username     string
+	Email        string
+	PasswordHash string
+	// PlaidAccessToken is the stored, encrypted token of the user's current item.
+	PlaidAccessToken string
+	// TOTPSecret is stored encrypted like PlaidAccessToken.
+	TOTPSecret  string
+	TOTPEnabled bool
+	Role        string
+	Disabled    bool
+	CreatedAt   time.Time
+}
+
+// UserSummary is the admin listing view of a user.
+type UserSummary struct {
+	ID          uuid.UUID `json:"id"`
+	Username    string    `json:"username"`
+	Email       string    `json:"email"`
+	Role        string    `json:"role"`
+	Disabled    bool      `json:"disabled"`
+	TOTPEnabled bool      `json:"totp_enabled"`
+	PlaidItems  int       `json:"plaid_items"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type RecoveryCode struct {
+	ID       string
+	CodeHash string
+}
+
+type Allocation struct {
+	Id                    uuid.UUID     `json:"id"`
+	AllocationType        string        `json:"allocation_type"`
+	AllocationDescription string        `json:"allocation_description"`
+	AllocationFactor      money.Decimal `json:"allocation_factor"`
+}
+
+type Expense struct {
+	Id             uuid.UUID     `json:"id"`
+	Description    string        `json:"description"`
+	Amount         money.Decimal `json:"amount"`
+	Category       string        `json:"category"`
+	AllocationType string        `json:"allocation_type"`
+}
+
+type Income struct {
+	Id          uuid.UUID     `json:"id"`
+	Description string        `json:"description"`
+	Amount      money.Decimal `json:"amount"`
+	Frequency   string        `json:"frequency"`
+}
+
+// Budget is everything a user has planned: incomes, expenses and the
+// allocations expenses are split by.
+type Budget struct {
+	Expenses    []Expense    `json:"expenses"`
+	Incomes     []Income     `json:"incomes"`
+	Allocations []Allocation `json:"allocations"`
+}
+
+// Category is a row of the global category catalog.
+type Category struct {
+	ID            uuid.UUID `json:"id"`
+	PlaidPrimary  string    `json:"plaid_category_primary_descriptor"`
+	PlaidDetailed string    `json:"plaid_category_detailed_descriptor"`
+	Name          string    `json:"name"`
+	Description   string    `json:"description"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// ManualItemPrefix starts the plaid_item_id of items that hold imported
+// statements rather than a Plaid link. Manual items have no access token.
+const ManualItemPrefix = "manual:"
+
+type PlaidItem struct {
+	ID              uuid.UUID  `json:"id"`
+	UserID          string     `json:"-"`
+	PlaidItemID     string     `json:"plaid_item_id"`
+	AccessToken     string     `json:"-"`
+	InstitutionName string     `json:"institution_name"`
+	SyncCursor      string     `json:"-"`
+	LastSyncedAt    *time.Time `json:"last_synced_at"`
+	// LoginRequiredAt is when Plaid started asking the user to sign in to
+	// the institution again, or nil.
+	LoginRequiredAt *time.Time `json:"login_required_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// Manual reports whether the item holds imported statements.
+func (item PlaidItem) Manual() bool {
+	return strings.HasPrefix(item.PlaidItemID, ManualItemPrefix)
+}
+
+// Transaction is a row of "TransactionRaw" as synced from Plaid.
+type Transaction struct {
+	ID                      uuid.UUID       `json:"id"`
+	UserID                  string          `json:"-"`
+	ItemID                  uuid.UUID       `json:"item_id"`
+	PlaidTransactionID      string          `json:"plaid_transaction_id"`
+	PlaidAccountID          string          `json:"plaid_account_id"`
+	Name                    string          `json:"name"`
+	MerchantName            string          `json:"merchant_name"`
+	Amount                  money.Decimal   `json:"amount"`
+	ISOCurrencyCode         string          `json:"iso_currency_code"`
+	Date                    time.Time       `json:"date"`
+	Pending                 bool            `json:"pending"`
+	CategoryPrimary         string          `json:"category_primary"`
+	CategoryDetailed        string          `json:"category_detailed"`
+	PlaidCategory           json.RawMessage `json:"plaid_category,omitempty"`
+	PersonalFinanceCategory json.RawMessage `json:"personal_finance_category,omitempty"`
+	Raw                     json.RawMessage `json:"-"`
+	// ExpenseID is the budget expense the transaction is assigned to, if any.
+	ExpenseID *uuid.UUID `json:"expense_id"`
+}
+
+// Sources of a TransactionExpense assignment.
+const (
+	CategorizedByRule          = "rule"
+	CategorizedManually        = "manual"
+	CategorizedByPlaidCategory = "pfcat"
+)
+
+// TransactionExpense assigns a transaction to a budget expense.
+type TransactionExpense struct {
+	TransactionID uuid.UUID
+	ExpenseID     uuid.UUID
+	Confidence    float64
+	Source        string
+}
+
+// Transaction sort keys accepted by TransactionQuery.Sort.
+const (
+	SortByDate   = "date"
+	SortByAmount = "amount"
+	SortByName   = "name"
+)
+
+// TransactionQuery narrows and orders TransactionRepo.Search. Zero values
+// disable a filter.
+type TransactionQuery struct {
+	From      time.Time
+	To        time.Time
+	AccountID string
+	MinAmount *money.Decimal
+	MaxAmount *money.Decimal
+	// Search matches name or merchant name, case-insensitively.
+	Search string
+	// Category matches the Plaid personal finance category, primary or detailed.
+	Category      string
+	ExpenseID     uuid.UUID
+	Pending       *bool
+	Uncategorized bool
+
+	Sort string
+	Desc bool
+	// After is the NextCursor of the previous page.
+	After string
+	Limit int
+}
+
+type TransactionPage struct {
+	Transactions []Transaction `json:"transactions"`
+	// NextCursor is empty on the last page.
+	NextCursor string `json:"next_cursor"`
+}
+
+type UserRepo interface {
+	ByID(ctx context.Context, id string) (User, error)
+	ByUsername(ctx context.Context, username string) (User, error)
+	List(ctx context.Context) ([]UserSummary, error)
+	// SetRole and SetDisabled return ErrConflict, and change nothing, when
+	// the change would leave no enabled admin.
+	SetRole(ctx context.Context, id string, role string) error
+	SetDisabled(ctx context.Context, id string, disabled bool) error
+	// SetTOTPSecret stores the user's secret, encrypted by the caller.
+	SetTOTPSecret(ctx context.Context, id string, secret string) error
+	EnableTOTP(ctx context.Context, id string) error
+	// UseTOTPStep records step as the time step of the user's latest
+	// accepted TOTP code. It returns ErrNotFound, and records nothing, if a
+	// code of the same or a later step was already accepted.
+	UseTOTPStep(ctx context.Context, id string, step int64) error
+	// ReplaceRecoveryCodes swaps every recovery code of the user for the given hashes.
+	ReplaceRecoveryCodes(ctx context.Context, id string, hashes []string) error
+	UnusedRecoveryCodes(ctx context.Context, id string) ([]RecoveryCode, error)
+	MarkRecoveryCodeUsed(ctx context.Context, codeID string) error
+}
+
+type BudgetRepo interface {
+	Load(ctx context.Context, userid string) (Budget, error)
+	// Save replaces the user's budget. Rows without an id are created and
+	// stored rows missing from budget are deleted.
+	Save(ctx context.Context, userid string, budget Budget) error
+}
+
+type TransactionRepo interface {
+	ListByUser(ctx context.Context, userid string, limit int) ([]Transaction, error)
+	Search(ctx context.Context, userid string, query TransactionQuery) (TransactionPage, error)
+	// Upsert inserts transactions or updates them by (user, Plaid transaction
+	// id), and sets each ID to that of the stored row.
+	Upsert(ctx context.Context, transactions []Transaction) error
+	// InsertNew inserts the transactions not stored yet and returns them.
+	InsertNew(ctx context.Context, transactions []Transaction) ([]Transaction, error)
+	Delete(ctx context.Context, userid string, plaidTransactionIDs []string) error
+	// ExpenseTotals sums the user's transactions dated within [from, to] by
+	// assigned expense, currency and day. Unassigned outflows are summed under uuid.Nil.
+	ExpenseTotals(ctx context.Context, userid string, from time.Time, to time.Time) ([]ExpenseTotal, error)
+	// Inflows sums the money that came into the user's accounts within
+	// [from, to] by currency and day.
+	Inflows(ctx context.Context, userid string, from time.Time, to time.Time) ([]CurrencyTotal, error)
+	// MerchantTotals sums the user's outflows within [from, to] by merchant,
+	// currency and day. Transactions without a merchant count under their name.
+	MerchantTotals(ctx context.Context, userid string, from time.Time, to time.Time) ([]MerchantAmount, error)
+	// Assignments lists every expense assignment of the user's transactions.
+	Assignments(ctx context.Context, userid string) ([]TransactionExpense, error)
+	// Assign stores assignments for transactions that have none yet and
+	// returns those it stored.
+	Assign(ctx context.Context, assignments []TransactionExpense) ([]TransactionExpense, error)
+	// SetExpense assigns the user's transaction to one of their expenses by
+	// hand, or clears its assignment when expenseID is nil, and returns the
+	// expense it was assigned to before. It returns ErrNotFound if the
+	// transaction or the expense is not the user's.
+	SetExpense(ctx context.Context, userid string, transactionID uuid.UUID, expenseID *uuid.UUID) (*uuid.UUID, error)
+}
+
+type PlaidItemRepo interface {
+	// Link stores a new item with id itemid and makes its token the user's
+	// current one. The token is encrypted separately for the user's row and
+	// the item's.
+	Link(ctx context.Context, userid string, itemid uuid.UUID, plaidItemID string, userAccessToken string, itemAccessToken string) (PlaidItem, error)
+	// LinkManual returns the user's manual item for account, creating it if needed.
+	LinkManual(ctx context.Context, userid string, account string) (PlaidItem, error)
+	Get(ctx context.Context, userid string, itemid uuid.UUID) (PlaidItem, error)
+	ListByUser(ctx context.Context, userid string) ([]PlaidItem, error)
+	// Linked lists the items of every user that have a Plaid link.
+	Linked(ctx context.Context) ([]PlaidItem, error)
+	// SetSyncCursor records how far the item's transactions have been synced.
+	SetSyncCursor(ctx context.Context, itemid uuid.UUID, cursor string) error
+	// SetLoginRequired records whether the item needs the user to sign in
+	// again. LoginRequiredAt keeps the time it was first set.
+	SetLoginRequired(ctx context.Context, itemid uuid.UUID, required bool) error
+	// Delete removes the item and, if clearCurrent is set, the user's current token.
+	Delete(ctx context.Context, userid string, itemid uuid.UUID, clearCurrent bool) error
+}
+
+type CategoryRepo interface {
+	List(ctx context.Context) ([]Category, error)
+	Get(ctx context.Context, id uuid.UUID) (Category, error)
+	Create(ctx context.Context, category Category) (uuid.UUID, error)
+	Update(ctx context.Context, category Category) error
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// MerchantTotal is a merchant's share of a period's outflows.
+type MerchantTotal struct {
+	Name         string        `json:"name"`
+	Total        money.Decimal `json:"total"`
+	Transactions int           `json:"transactions"`
+}
+
+// CurrencyTotal is a sum of amounts in one currency on one day, so that it
+// can be converted at that day's rate. ISOCurrencyCode is "" when the
+// transactions did not say.
+type CurrencyTotal struct {
+	ISOCurrencyCode string
+	Date            time.Time
+	Total           money.Decimal
+}
+
+// ExpenseTotal is the part of an expense's spending in one currency on one day.
+type ExpenseTotal struct {
+	ExpenseID uuid.UUID
+	CurrencyTotal
+}
+
+// MerchantAmount is the part of a merchant's outflows in one currency on one day.
+type MerchantAmount struct {
+	Name         string
+	Transactions int
+	CurrencyTotal
+}
+
+// Goal is a savings target the user tracks on their statements.
+type Goal struct {
+	ID           uuid.UUID     `json:"id"`
+	Name         string        `json:"name"`
+	TargetAmount money.Decimal `json:"target_amount"`
+	SavedAmount  money.Decimal `json:"saved_amount"`
+	TargetDate   *time.Time    `json:"target_date"`
+	CreatedAt    time.Time     `json:"created_at"`
+}
+
+// StatementNote is the user's note for one period.
+type StatementNote struct {
+	Period string `json:"period"`
+	Body   string `json:"body"`
+}
+
+// StatementRecipient is a user who asked for statements by email.
+type StatementRecipient struct {
+	UserID   string
+	Username string
+	Email    string
+}
+
+type GoalRepo interface {
+	List(ctx context.Context, userid string) ([]Goal, error)
+	// Save creates the goal if it has no id and updates it otherwise.
+	Save(ctx context.Context, userid string, goal Goal) (Goal, error)
+	Delete(ctx context.Context, userid string, id uuid.UUID) error
+}
+
+// StatementRepo stores what monthly statements need beyond the budget and
+// transactions. Periods are months written as YYYY-MM.
+type StatementRepo interface {
+	// Note returns the user's note for the period, or "" if there is none.
+	Note(ctx context.Context, userid string, period string) (string, error)
+	// SetNote stores the note, or removes it if body is empty.
+	SetNote(ctx context.Context, userid string, period string, body string) error
+	// Notes lists all of the user's notes, oldest period first.
+	Notes(ctx context.Context, userid string) ([]StatementNote, error)
+	EmailEnabled(ctx context.Context, userid string) (bool, error)
+	SetEmailEnabled(ctx context.Context, userid string, enabled bool) error
+	// PendingRecipients lists enabled users whose statement for the period
+	// has not been delivered.
+	PendingRecipients(ctx context.Context, period string) ([]StatementRecipient, error)
+	// ClaimDelivery records that the period's statement is being sent to the
+	// user. It returns false if it already was, so concurrent senders send once.
+	ClaimDelivery(ctx context.Context, userid string, period string) (bool, error)
+	// ReleaseDelivery undoes a claim whose delivery failed.
+	ReleaseDelivery(ctx context.Context, userid string, period string) error
+}
+
+// BalanceSnapshot is one account's balances on one day. Balances Plaid
+// does not report are nil.
+type BalanceSnapshot struct {
+	ItemID          uuid.UUID      `json:"item_id"`
+	PlaidAccountID  string         `json:"plaid_account_id"`
+	Name            string         `json:"name"`
+	Type            string         `json:"type"`
+	Subtype         string         `json:"subtype"`
+	Current         *money.Decimal `json:"current"`
+	Available       *money.Decimal `json:"available"`
+	Limit           *money.Decimal `json:"limit"`
+	ISOCurrencyCode string         `json:"iso_currency_code"`
+	Date            time.Time      `json:"date"`
+}
+
+// Kinds of ManualAsset.
+const (
+	ManualAssetKindAsset     = "asset"
+	ManualAssetKindLiability = "liability"
+)
+
+// ManualAsset is an asset or liability the user tracks by hand. Value is
+// the latest recorded value.
+type ManualAsset struct {
+	ID              uuid.UUID     `json:"id"`
+	Name            string        `json:"name"`
+	Kind            string        `json:"kind"`
+	Type            string        `json:"type"`
+	Value           money.Decimal `json:"value"`
+	ISOCurrencyCode string        `json:"iso_currency_code"`
+	ValueAsOf       time.Time     `json:"value_as_of"`
+	CreatedAt       time.Time     `json:"created_at"`
+}
+
+// ManualAssetValue is the value of a manual asset from Date on.
+type ManualAssetValue struct {
+	AssetID uuid.UUID
+	Date    time.Time
+	Value   money.Decimal
+}
+
+type BalanceRepo interface {
+	// SaveSnapshots stores snapshots, replacing any taken the same day for
+	// the same account.
+	SaveSnapshots(ctx context.Context, userid string, snapshots []BalanceSnapshot) error
+	// ItemsWithoutSnapshot lists linked items of every user that have no
+	// snapshot dated date.
+	ItemsWithoutSnapshot(ctx context.Context, date time.Time) ([]PlaidItem, error)
+	// History returns the user's snapshots dated within [from, to], plus the
+	// latest snapshot before from of each account, ordered by date.
+	History(ctx context.Context, userid string, from time.Time, to time.Time) ([]BalanceSnapshot, error)
+
+	ListManual(ctx context.Context, userid string) ([]ManualAsset, error)
+	// SaveManual creates the asset if it has no id and updates it
+	// otherwise, recording its value as of asOf.
+	SaveManual(ctx context.Context, userid string, asset ManualAsset, asOf time.Time) (ManualAsset, error)
+	DeleteManual(ctx context.Context, userid string, id uuid.UUID) error
+	// ManualHistory is History for the values of manual assets.
+	ManualHistory(ctx context.Context, userid string, from time.Time, to time.Time) ([]ManualAssetValue, error)
+}
+
+// InvestmentAccount is a Plaid account of type investment.
+type InvestmentAccount struct {
+	ItemID          uuid.UUID `json:"item_id"`
+	PlaidAccountID  string    `json:"plaid_account_id"`
+	Name            string    `json:"name"`
+	Subtype         string    `json:"subtype"`
+	ISOCurrencyCode string    `json:"iso_currency_code"`
+}
+
+// Security is a row of the shared security catalog, keyed by Plaid security id.
+type Security struct {
+	ID              string         `json:"security_id"`
+	Name            string         `json:"name"`
+	TickerSymbol    string         `json:"ticker_symbol"`
+	Type            string         `json:"type"`
+	CashEquivalent  bool           `json:"is_cash_equivalent"`
+	ClosePrice      *money.Decimal `json:"close_price"`
+	ClosePriceAsOf  *time.Time     `json:"close_price_as_of"`
+	ISOCurrencyCode string         `json:"iso_currency_code"`
+}
+
+// Holding is a position in one account on one day. CostBasis is nil when
+// the institution does not report it.
+type Holding struct {
+	ItemID          uuid.UUID      `json:"item_id"`
+	PlaidAccountID  string         `json:"plaid_account_id"`
+	SecurityID      string         `json:"security_id"`
+	Date            time.Time      `json:"date"`
+	Quantity        money.Precise  `json:"quantity"`
+	Price           money.Decimal  `json:"price"`
+	Value           money.Decimal  `json:"value"`
+	CostBasis       *money.Decimal `json:"cost_basis"`
+	ISOCurrencyCode string         `json:"iso_currency_code"`
+}
+
+// InvestmentTransaction is a buy, sell, dividend, fee or cash movement.
+// Amount is positive when cash leaves the account, as Plaid reports it.
+type InvestmentTransaction struct {
+	ItemID                       uuid.UUID     `json:"item_id"`
+	PlaidAccountID               string        `json:"plaid_account_id"`
+	PlaidInvestmentTransactionID string        `json:"plaid_investment_transaction_id"`
+	SecurityID                   string        `json:"security_id"`
+	Date                         time.Time     `json:"date"`
+	Name                         string        `json:"name"`
+	Type                         string        `json:"type"`
+	Subtype                      string        `json:"subtype"`
+	Quantity                     money.Precise `json:"quantity"`
+	Price                        money.Decimal `json:"price"`
+	Amount                       money.Decimal `json:"amount"`
+	Fees                         money.Decimal `json:"fees"`
+	ISOCurrencyCode              string        `json:"iso_currency_code"`
+}
+
+// AccountValue is the total value of an investment account's holdings on a day.
+type AccountValue struct {
+	ItemID         uuid.UUID
+	PlaidAccountID string
+	Date           time.Time
+	Value          money.Decimal
+}
+
+type InvestmentRepo interface {
+	// SaveHoldings stores the item's accounts and securities and replaces its
+	// holdings snapshot dated date.
+	SaveHoldings(ctx context.Context, userid string, itemid uuid.UUID, accounts []InvestmentAccount, securities []Security, holdings []Holding, date time.Time) error
+	// SaveTransactions stores securities and inserts or updates transactions
+	// by (item, Plaid investment transaction id).
+	SaveTransactions(ctx context.Context, userid string, securities []Security, transactions []InvestmentTransaction) error
+	// ItemsToSync lists linked items of every user not synced on date.
+	ItemsToSync(ctx context.Context, date time.Time) ([]PlaidItem, error)
+	// MarkSynced records a sync attempt and its error, if any.
+	MarkSynced(ctx context.Context, itemid uuid.UUID, date time.Time, syncErr string) error
+	// LastTransactionDate returns the date of the item's newest stored
+	// transaction, or the zero time.
+	LastTransactionDate(ctx context.Context, itemid uuid.UUID) (time.Time, error)
+
+	Accounts(ctx context.Context, userid string) ([]InvestmentAccount, error)
+	// Securities lists the securities the user's holdings and transactions reference.
+	Securities(ctx context.Context, userid string) ([]Security, error)
+	// Holdings returns the latest snapshot of each account dated on or before date.
+	Holdings(ctx context.Context, userid string, date time.Time) ([]Holding, error)
+	// AccountValues returns each account's daily value within [from, to],
+	// plus its latest value before from, ordered by date.
+	AccountValues(ctx context.Context, userid string, from time.Time, to time.Time) ([]AccountValue, error)
+	// Transactions lists the user's transactions dated within [from, to], oldest first.
+	Transactions(ctx context.Context, userid string, from time.Time, to time.Time) ([]InvestmentTransaction, error)
+}
+
+// AlertRule is a condition a user asked to be alerted about. What Target
+// and Threshold mean depends on Kind.
+type AlertRule struct {
+	ID        uuid.UUID     `json:"id"`
+	Kind      string        `json:"kind"`
+	Name      string        `json:"name"`
+	Target    string        `json:"target"`
+	Threshold money.Decimal `json:"threshold"`
+	// Channels name where alerts are delivered besides the inbox.
+	Channels  []string  `json:"channels"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Alert is a fired alert as shown in the user's inbox.
+type Alert struct {
+	ID     uuid.UUID  `json:"id"`
+	UserID string     `json:"-"`
+	RuleID *uuid.UUID `json:"rule_id"`
+	Kind   string     `json:"kind"`
+	// DedupeKey names the condition reported; an alert with a key the user
+	// already has is not fired again.
+	DedupeKey string     `json:"-"`
+	Title     string     `json:"title"`
+	Body      string     `json:"body"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at"`
+}
+
+// AlertDelivery is an alert waiting to be sent through a channel.
+type AlertDelivery struct {
+	Alert    Alert
+	Channel  string
+	Attempts int
+	// Email is the address of the alert's user.
+	Email string
+}
+
+// AlertSettings are a user's quiet hours, as HH:MM in Timezone, and
+// webhook endpoint. Empty quiet hours mean none.
+type AlertSettings struct {
+	QuietStart string `json:"quiet_start"`
+	QuietEnd   string `json:"quiet_end"`
+	Timezone   string `json:"timezone"`
+	WebhookURL string `json:"webhook_url"`
+	// WebhookSecret is stored as given; callers encrypt it.
+	WebhookSecret string `json:"-"`
+}
+
+type AlertRepo interface {
+	Rules(ctx context.Context, userid string) ([]AlertRule, error)
+	// SaveRule creates the rule if it has no id and updates it otherwise.
+	SaveRule(ctx context.Context, userid string, rule AlertRule) (AlertRule, error)
+	DeleteRule(ctx context.Context, userid string, id uuid.UUID) error
+
+	// Fire stores the alert, with a new ID and CreatedAt now unless they are
+	// set, and queues its delivery through channels. It returns false, and
+	// stores nothing, if the user already has an alert with the same
+	// DedupeKey.
+	Fire(ctx context.Context, alert Alert, channels []string) (bool, error)
+	// List returns the user's newest alerts first.
+	List(ctx context.Context, userid string, unreadOnly bool, limit int) ([]Alert, error)
+	MarkRead(ctx context.Context, userid string, id uuid.UUID) error
+
+	// PendingDeliveries lists undelivered deliveries of every user due at
+	// now that have been attempted fewer than maxAttempts times.
+	PendingDeliveries(ctx context.Context, now time.Time, maxAttempts int, limit int) ([]AlertDelivery, error)
+	// MarkAttempted records a delivery attempt. A nil deliveryErr marks the
+	// delivery done; otherwise it is retried from retryAt.
+	MarkAttempted(ctx context.Context, alertID uuid.UUID, channel string, deliveryErr error, retryAt time.Time) error
+	// QueuedDeliveries counts the undelivered deliveries, due or not, that
+	// have been attempted fewer than maxAttempts times.
+	QueuedDeliveries(ctx context.Context, maxAttempts int) (int, error)
+
+	// Settings returns the user's settings, with Timezone UTC if they have
+	// none stored.
+	Settings(ctx context.Context, userid string) (AlertSettings, error)
+	SaveSettings(ctx context.Context, userid string, settings AlertSettings) error
+}
+
+// WebhookEndpoint is a URL a user has registered to receive the events
+// named in Events.
+type WebhookEndpoint struct {
+	ID          uuid.UUID `json:"id"`
+	UserID      string    `json:"-"`
+	URL         string    `json:"url"`
+	Description string    `json:"description"`
+	Events      []string  `json:"events"`
+	Enabled     bool      `json:"enabled"`
+	// Secret signs deliveries. It is stored as given; callers encrypt it.
+	Secret    string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// States of a WebhookDelivery.
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	// WebhookDead deliveries ran out of retries; only a manual redelivery
+	// sends them again.
+	WebhookDead = "dead"
+)
+
+// WebhookDelivery is one event sent, or to be sent, to one endpoint.
+type WebhookDelivery struct {
+	ID         uuid.UUID `json:"id"`
+	EndpointID uuid.UUID `json:"endpoint_id"`
+	// EventID is the same for every delivery of an event, including
+	// redeliveries, so receivers can drop repeats.
+	EventID        uuid.UUID       `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	ResponseStatus *int            `json:"response_status"`
+	LastError      string          `json:"last_error"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+	RedeliveryOf   *uuid.UUID      `json:"redelivery_of"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// PendingWebhookDelivery is a delivery due to be sent and where to.
+type PendingWebhookDelivery struct {
+	WebhookDelivery
+	URL    string
+	Secret string
+}
+
+type WebhookRepo interface {
+	Endpoints(ctx context.Context, userid string) ([]WebhookEndpoint, error)
+	// CreateEndpoint stores a new endpoint under the id the caller chose,
+	// which its encrypted secret is bound to.
+	CreateEndpoint(ctx context.Context, userid string, endpoint WebhookEndpoint) (WebhookEndpoint, error)
+	UpdateEndpoint(ctx context.Context, userid string, endpoint WebhookEndpoint) (WebhookEndpoint, error)
+	DeleteEndpoint(ctx context.Context, userid string, id uuid.UUID) error
+	// Subscribed lists the enabled endpoints of every user that receive
+	// eventType and were created before createdBefore.
+	Subscribed(ctx context.Context, eventType string, createdBefore time.Time) ([]WebhookEndpoint, error)
+
+	// Enqueue queues deliveries, skipping events an endpoint already has
+	// queued or delivered, and returns how many it queued.
+	Enqueue(ctx context.Context, deliveries []WebhookDelivery) (int, error)
+	// ClaimDeliveries takes up to limit pending deliveries due at now to
+	// enabled endpoints of enabled users, oldest first. Claimed deliveries
+	// are not due again before leaseUntil, so other instances skip them
+	// while they are sent, and a crashed sender's claims expire.
+	ClaimDeliveries(ctx context.Context, now time.Time, leaseUntil time.Time, limit int) ([]PendingWebhookDelivery, error)
+	// MarkAttempted records an attempt and the delivery's new Status,
+	// ResponseStatus, LastError and NextAttemptAt.
+	MarkAttempted(ctx context.Context, delivery WebhookDelivery) error
+	// QueuedDeliveries counts the pending deliveries, due or not.
+	QueuedDeliveries(ctx context.Context) (int, error)
+	// Deliveries lists an endpoint's deliveries, newest first, optionally
+	// only those with status.
+	Deliveries(ctx context.Context, userid string, endpointID uuid.UUID, status string, limit int) ([]WebhookDelivery, error)
+	// Redeliver queues a new delivery of the same event and payload.
+	Redeliver(ctx context.Context, userid string, endpointID uuid.UUID, deliveryID uuid.UUID) (WebhookDelivery, error)
+}
+
+// Snapshot is the data of one user that a restore writes back.
+type Snapshot struct {
+	// Items are created without an access token; items the user still has
+	// are not repeated here.
+	Items        []PlaidItem
+	Transactions []Transaction
+	Budget       Budget
+	Assignments  []TransactionExpense
+	// Goals and Notes replace the user's unless nil, which keeps theirs, for
+	// archives from before they were backed up.
+	Goals []Goal
+	Notes []StatementNote
+	// ManualAssets and their values replace the user's unless nil, likewise.
+	// The assets' own Value is ignored.
+	ManualAssets      []ManualAsset
+	ManualAssetValues []ManualAssetValue
+	// ReportingCurrency replaces the user's unless nil; "" is the default.
+	ReportingCurrency *string
+	// AlertRules replace the user's unless nil, and AlertSettings likewise.
+	AlertRules    []AlertRule
+	AlertSettings *AlertSettings
+	// WebhookEndpoints replace the user's, and their deliveries, unless nil.
+	WebhookEndpoints []WebhookEndpoint
+}
+
+// FXRate is the number of units of Currency one unit of BaseCurrency
+// bought on Date, as published by Source.
+type FXRate struct {
+	BaseCurrency string        `json:"base_currency"`
+	Currency     string        `json:"currency"`
+	Date         time.Time     `json:"date"`
+	Rate         money.Precise `json:"rate"`
+	Source       string        `json:"source"`
+}
+
+type FXRepo interface {
+	// SaveRates stores rates, replacing those already stored for the same
+	// pair and day.
+	SaveRates(ctx context.Context, rates []FXRate) error
+	// Rates returns the rates dated within [from, to] and, for each pair, the
+	// latest one before from, ordered by date.
+	Rates(ctx context.Context, from time.Time, to time.Time) ([]FXRate, error)
+	// LatestDate returns the date of the newest rate from source, or the zero
+	// time if there is none.
+	LatestDate(ctx context.Context, source string) (time.Time, error)
+	// ReportingCurrency returns the user's reporting currency, or "" if they
+	// have not chosen one.
+	ReportingCurrency(ctx context.Context, userid string) (string, error)
+	SetReportingCurrency(ctx context.Context, userid string, currency string) error
+}
+
+type BackupRepo interface {
+	// Restore replaces the user's budget, transactions and assignments with
+	// the snapshot's in one transaction. Existing items are kept.
+	Restore(ctx context.Context, userid string, snapshot Snapshot) error
+}
+
+// SecretColumn is a column that holds values encrypted by the caller.
+type SecretColumn struct {
+	Table  string
+	Column string
+}
+
+var (
+	UserAccessToken       = SecretColumn{"Users", "plaid_access_token"}
+	ItemAccessToken       = SecretColumn{"PlaidItem", "plaid_access_token"}
+	AlertWebhookSecret    = SecretColumn{"AlertSettings", "webhook_secret"}
+	WebhookEndpointSecret = SecretColumn{"WebhookEndpoint", "secret"}
+	UserTOTPSecret        = SecretColumn{"Users", "totp_secret"}
+)
+
+// SecretColumns are every SecretColumn, for key rotation.
+var SecretColumns = []SecretColumn{UserAccessToken, ItemAccessToken, AlertWebhookSecret, WebhookEndpointSecret, UserTOTPSecret}
+
+// StoredSecret is the value of a SecretColumn in the row with primary key ID.
+type StoredSecret struct {
+	Column SecretColumn
+	ID     string
+	Value  string
+}
+
+type SecretRepo interface {
+	// Stored lists the rows with a value in column.
+	Stored(ctx context.Context, column SecretColumn) ([]StoredSecret, error)
+	// Replace sets the secret's row to value if it still holds
+	// secret.Value, and returns ErrNotFound otherwise.
+	Replace(ctx context.Context, secret StoredSecret, value string) error
+}
+
+// AuditEntry is one row of the append-only "AuditLog" table. ActorID is who
+// acted; SubjectID is whose data was affected, which differs for admin actions.
+type AuditEntry struct {
+	ID           uuid.UUID       `json:"id"`
+	OccurredAt   time.Time       `json:"occurred_at"`
+	ActorID      string          `json:"actor_user_id,omitempty"`
+	SubjectID    string          `json:"subject_user_id,omitempty"`
+	Action       string          `json:"action"`
+	ResourceType string          `json:"resource_type,omitempty"`
+	ResourceID   string          `json:"resource_id,omitempty"`
+	IP           string          `json:"ip,omitempty"`
+	RequestID    string          `json:"request_id,omitempty"`
+	Before       json.RawMessage `json:"before,omitempty"`
+	After        json.RawMessage `json:"after,omitempty"`
+	Metadata     json.RawMessage `json:"metadata,omitempty"`
+}
+
+// AuditQuery narrows AuditRepo.List. Zero values disable a filter.
+type AuditQuery struct {
+	// UserID matches entries the user took or that affected them.
+	UserID string
+	Action string
+	Since  time.Time
+	Limit  int
+	Offset int
+}
+
+type AuditRepo interface {
+	// Append stores entry, giving it an id and the current time if it has
+	// none. Entries are never updated or deleted.
+	Append(ctx context.Context, entry AuditEntry) error
+	// List returns entries newest first.
+	List(ctx context.Context, query AuditQuery) ([]AuditEntry, error)
+}
+
+// RateLimitBucket is the stored state of a token bucket.
+type RateLimitBucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// RateLimitRepo keeps rate limit buckets and failed login counts where
+// every server instance sees them.
+type RateLimitRepo interface {
+	// TakeBucket locks the bucket stored under key, creating it as initial
+	// if there is none, and stores what take makes of it. Callers sharing
+	// the database take from a bucket one at a time.
+	TakeBucket(ctx context.Context, key string, initial RateLimitBucket, take func(RateLimitBucket) RateLimitBucket) error
+	// LockedUntil returns when key's login lockout ends, or the zero time.
+	LockedUntil(ctx context.Context, key string) (time.Time, error)
+	// RecordLoginFailure counts a failed login against key at now and
+	// returns the count. A key whose lockout has ended and that has not
+	// failed since forgetBefore starts again from one.
+	RecordLoginFailure(ctx context.Context, key string, now time.Time, forgetBefore time.Time) (int, error)
+	// LockLogin locks key until until.
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	ResetLoginFailures(ctx context.Context, key string) error
+}
+
+// Repositories bundles one implementation of every repository.
+type Repositories struct {
+	Users        UserRepo
+	Budgets      BudgetRepo
+	Transactions TransactionRepo
+	PlaidItems   PlaidItemRepo
+	Categories   CategoryRepo
+	Backups      BackupRepo
+	Goals        GoalRepo
+	Statements   StatementRepo
+	Balances     BalanceRepo
+	Investments  InvestmentRepo
+	FX           FXRepo
+	Alerts       AlertRepo
+	Webhooks     WebhookRepo
+	Secrets      SecretRepo
+	Audit        AuditRepo
+	RateLimits   RateLimitRepo
+}
+
+// New returns repositories for a database migrated with go/migrations. The
+// queries run unchanged on Postgres and SQLite: ids are generated here rather
+// than by column defaults, and JSON is passed as text.
+func New(db *sql.DB) Repositories {
+	return Repositories{
+		Users:        &sqlUserRepo{db: db},
+		Budgets:      &sqlBudgetRepo{db: db},
+		Transactions: &sqlTransactionRepo{db: db},
+		PlaidItems:   &sqlPlaidItemRepo{db: db},
+		Categories:   &sqlCategoryRepo{db: db},
+		Backups:      &sqlBackupRepo{db: db},
+		Goals:        &sqlGoalRepo{db: db},
+		Statements:   &sqlStatementRepo{db: db},
+		Balances:     &sqlBalanceRepo{db: db},
+		Investments:  &sqlInvestmentRepo{db: db},
+		FX:           &sqlFXRepo{db: db},
+		Alerts:       &sqlAlertRepo{db: db},
+		Webhooks:     &sqlWebhookRepo{db: db},
+		Secrets:      &sqlSecretRepo{db: db},
+		Audit:        &sqlAuditRepo{db: db},
+		RateLimits:   &sqlRateLimitRepo{db: db},
+	}
+}
+
+// notFound maps sql.ErrNoRows to ErrNotFound.
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+// isForeignKeyViolation recognises the errors lib/pq and modernc.org/sqlite
+// return when a row is still referenced.
+func isForeignKeyViolation(err error) bool {
+	if pqErr, ok := err.(*pq.Error); ok {
+		return pqErr.Code == "23503"
+	}
+	if sqliteErr, ok := err.(*sqlite.Error); ok {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY
+	}
+	return false
+}
+
+// affectedOne returns ErrNotFound when an update or delete matched no rows.
+func affectedOne(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
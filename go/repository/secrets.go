@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+type sqlSecretRepo struct {
+	db *sql.DB
+}
+
+// secretQueries are the statements for each SecretColumn, written out so no
+// SQL is assembled from names.
+var secretQueries = map[SecretColumn]struct {
+	stored  string
+	replace string
+}{
+	UserAccessToken: {
+		`SELECT user_id, plaid_access_token FROM "Users" WHERE plaid_access_token IS NOT NULL AND plaid_access_token <> ''`,
+		`UPDATE "Users" SET plaid_access_token = $1 WHERE user_id = $2 AND COALESCE(plaid_access_token, '') = $3`,
+	},
+	ItemAccessToken: {
+		`SELECT item_id, plaid_access_token FROM "PlaidItem" WHERE plaid_access_token <> ''`,
+		`UPDATE "PlaidItem" SET plaid_access_token = $1 WHERE item_id = $2 AND plaid_access_token = $3`,
+	},
+	AlertWebhookSecret: {
+		`SELECT user_id, webhook_secret FROM "AlertSettings" WHERE webhook_secret IS NOT NULL AND webhook_secret <> ''`,
+		`UPDATE "AlertSettings" SET webhook_secret = $1 WHERE user_id = $2 AND COALESCE(webhook_secret, '') = $3`,
+	},
+	WebhookEndpointSecret: {
+		`SELECT endpoint_id, secret FROM "WebhookEndpoint" WHERE secret <> ''`,
+		`UPDATE "WebhookEndpoint" SET secret = $1 WHERE endpoint_id = $2 AND secret = $3`,
+	},
+	UserTOTPSecret: {
+		`SELECT user_id, totp_secret FROM "Users" WHERE totp_secret IS NOT NULL AND totp_secret <> ''`,
+		`UPDATE "Users" SET totp_secret = $1 WHERE user_id = $2 AND COALESCE(totp_secret, '') = $3`,
+	},
+}
+
+func (r *sqlSecretRepo) Stored(ctx context.Context, column SecretColumn) ([]StoredSecret, error) {
+	queries, ok := secretQueries[column]
+	if !ok {
+		return nil, fmt.Errorf("unknown secret column %s.%s", column.Table, column.Column)
+	}
+	rows, err := r.db.QueryContext(ctx, queries.stored)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	secrets := []StoredSecret{}
+	for rows.Next() {
+		secret := StoredSecret{Column: column}
+		if err := rows.Scan(&secret.ID, &secret.Value); err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, rows.Err()
+}
+
+func (r *sqlSecretRepo) Replace(ctx context.Context, secret StoredSecret, value string) error {
+	queries, ok := secretQueries[secret.Column]
+	if !ok {
+		return fmt.Errorf("unknown secret column %s.%s", secret.Column.Table, secret.Column.Column)
+	}
+	return affectedOne(r.db.ExecContext(ctx, queries.replace, value, secret.ID, secret.Value))
+}
@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+type sqlGoalRepo struct {
+	db *sql.DB
+}
+
+const goalColumns = `goal_id, name, target_amount, saved_amount, target_date, created_at`
+
+func (r *sqlGoalRepo) List(ctx context.Context, userid string) ([]Goal, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+goalColumns+` FROM "Goal" WHERE user_id = $1 ORDER BY created_at, goal_id`, userid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	goals := []Goal{}
+	for rows.Next() {
+		var goal Goal
+		var targetDate sql.NullTime
+		if err := rows.Scan(&goal.ID, &goal.Name, &goal.TargetAmount, &goal.SavedAmount, &targetDate, &goal.CreatedAt); err != nil {
+			return nil, err
+		}
+		if targetDate.Valid {
+			goal.TargetDate = &targetDate.Time
+		}
+		goals = append(goals, goal)
+	}
+
+	return goals, rows.Err()
+}
+
+func (r *sqlGoalRepo) Save(ctx context.Context, userid string, goal Goal) (Goal, error) {
+	if goal.ID == uuid.Nil {
+		goal.ID = uuid.New()
+		_, err := r.db.ExecContext(ctx, `INSERT INTO "Goal" (goal_id, user_id, name, target_amount, saved_amount, target_date) VALUES ($1, $2, $3, $4, $5, $6)`,
+			goal.ID, userid, goal.Name, goal.TargetAmount, goal.SavedAmount, goal.TargetDate)
+		if err != nil {
+			return Goal{}, err
+		}
+	} else {
+		err := affectedOne(r.db.ExecContext(ctx, `UPDATE "Goal" SET name = $1, target_amount = $2, saved_amount = $3, target_date = $4, updated_at = CURRENT_TIMESTAMP WHERE goal_id = $5 AND user_id = $6`,
+			goal.Name, goal.TargetAmount, goal.SavedAmount, goal.TargetDate, goal.ID, userid))
+		if err != nil {
+			return Goal{}, err
+		}
+	}
+
+	var targetDate sql.NullTime
+	err := r.db.QueryRowContext(ctx, `SELECT `+goalColumns+` FROM "Goal" WHERE goal_id = $1`, goal.ID).
+		Scan(&goal.ID, &goal.Name, &goal.TargetAmount, &goal.SavedAmount, &targetDate, &goal.CreatedAt)
+	if err != nil {
+		return Goal{}, notFound(err)
+	}
+	goal.TargetDate = nil
+	if targetDate.Valid {
+		goal.TargetDate = &targetDate.Time
+	}
+	return goal, nil
+}
+
+func (r *sqlGoalRepo) Delete(ctx context.Context, userid string, id uuid.UUID) error {
+	return affectedOne(r.db.ExecContext(ctx, `DELETE FROM "Goal" WHERE goal_id = $1 AND user_id = $2`, id, userid))
+}
+
+type sqlStatementRepo struct {
+	db *sql.DB
+}
+
+func (r *sqlStatementRepo) Note(ctx context.Context, userid string, period string) (string, error) {
+	var body string
+	err := r.db.QueryRowContext(ctx, `SELECT body FROM "StatementNote" WHERE user_id = $1 AND period = $2`, userid, period).Scan(&body)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return body, err
+}
+
+func (r *sqlStatementRepo) SetNote(ctx context.Context, userid string, period string, body string) error {
+	if body == "" {
+		_, err := r.db.ExecContext(ctx, `DELETE FROM "StatementNote" WHERE user_id = $1 AND period = $2`, userid, period)
+		return err
+	}
+	_, err := r.db.ExecContext(ctx, `INSERT INTO "StatementNote" (user_id, period, body) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, period) DO UPDATE SET body = excluded.body, updated_at = CURRENT_TIMESTAMP`, userid, period, body)
+	return err
+}
+
+func (r *sqlStatementRepo) Notes(ctx context.Context, userid string) ([]StatementNote, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT period, body FROM "StatementNote" WHERE user_id = $1 ORDER BY period`, userid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := []StatementNote{}
+	for rows.Next() {
+		var note StatementNote
+		if err := rows.Scan(&note.Period, &note.Body); err != nil {
+			return nil, err
+		}
+		notes = append(notes, note)
+	}
+	return notes, rows.Err()
+}
+
+func (r *sqlStatementRepo) EmailEnabled(ctx context.Context, userid string) (bool, error) {
+	var enabled bool
+	err := r.db.QueryRowContext(ctx, `SELECT statement_email FROM "Users" WHERE user_id = $1`, userid).Scan(&enabled)
+	return enabled, notFound(err)
+}
+
+func (r *sqlStatementRepo) SetEmailEnabled(ctx context.Context, userid string, enabled bool) error {
+	return affectedOne(r.db.ExecContext(ctx, `UPDATE "Users" SET statement_email = $1, updated_at = CURRENT_TIMESTAMP WHERE user_id = $2`, enabled, userid))
+}
+
+func (r *sqlStatementRepo) PendingRecipients(ctx context.Context, period string) ([]StatementRecipient, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT u.user_id, u.username, u.email FROM "Users" u
+		WHERE u.statement_email AND NOT u.disabled AND u.email <> ''
+		AND NOT EXISTS (SELECT 1 FROM "StatementDelivery" d WHERE d.user_id = u.user_id AND d.period = $1)
+		ORDER BY u.username`, period)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recipients := []StatementRecipient{}
+	for rows.Next() {
+		var recipient StatementRecipient
+		if err := rows.Scan(&recipient.UserID, &recipient.Username, &recipient.Email); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	return recipients, rows.Err()
+}
+
+func (r *sqlStatementRepo) ClaimDelivery(ctx context.Context, userid string, period string) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `INSERT INTO "StatementDelivery" (user_id, period) VALUES ($1, $2) ON CONFLICT DO NOTHING`, userid, period)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (r *sqlStatementRepo) ReleaseDelivery(ctx context.Context, userid string, period string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM "StatementDelivery" WHERE user_id = $1 AND period = $2`, userid, period)
+	return err
+}
@@ -0,0 +1,485 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/plaid/quickstart/money"
+)
+
+type sqlTransactionRepo struct {
+	db *sql.DB
+}
+
+const transactionColumns = `t.transaction_id, t.user_id, t.item_id, t.plaid_transaction_id, t.plaid_account_id, t.name, t.merchant_name, t.amount, t.iso_currency_code, t.date, t.pending,
+	t.category_primary, t.category_detailed, t.plaid_category, t.personal_finance_category, t.raw, te.expense_id`
+
+const transactionFrom = `"TransactionRaw" t LEFT JOIN "TransactionExpense" te ON te.transcation_id = t.transaction_id`
+
+// DefaultTransactionPageSize is used when TransactionQuery.Limit is not set.
+const DefaultTransactionPageSize = 50
+
+// transactionSortColumns maps TransactionQuery.Sort to the column it orders by.
+var transactionSortColumns = map[string]string{
+	SortByDate:   "t.date",
+	SortByAmount: "t.amount",
+	SortByName:   "t.name",
+}
+
+func scanTransaction(row rowScanner) (Transaction, error) {
+	var t Transaction
+	var merchant, currency, primary, detailed sql.NullString
+	var pending sql.NullBool
+	var plaidCategory, pfc, raw []byte
+	var expenseID uuid.NullUUID
+	err := row.Scan(&t.ID, &t.UserID, &t.ItemID, &t.PlaidTransactionID, &t.PlaidAccountID, &t.Name, &merchant, &t.Amount, &currency, &t.Date, &pending,
+		&primary, &detailed, &plaidCategory, &pfc, &raw, &expenseID)
+	if err != nil {
+		return Transaction{}, err
+	}
+	t.MerchantName = merchant.String
+	t.ISOCurrencyCode = currency.String
+	t.Pending = pending.Bool
+	t.CategoryPrimary = primary.String
+	t.CategoryDetailed = detailed.String
+	t.PlaidCategory = plaidCategory
+	t.PersonalFinanceCategory = pfc
+	t.Raw = raw
+	if expenseID.Valid {
+		t.ExpenseID = &expenseID.UUID
+	}
+	return t, nil
+}
+
+func (r *sqlTransactionRepo) ListByUser(ctx context.Context, userid string, limit int) ([]Transaction, error) {
+	page, err := r.Search(ctx, userid, TransactionQuery{Sort: SortByDate, Desc: true, Limit: limit})
+	return page.Transactions, err
+}
+
+// transactionCursor is the position after the last row of a page, in the
+// sort the page was produced with.
+type transactionCursor struct {
+	Sort  string    `json:"s"`
+	Desc  bool      `json:"d"`
+	Value string    `json:"v"`
+	ID    uuid.UUID `json:"id"`
+}
+
+func encodeTransactionCursor(query TransactionQuery, t Transaction) string {
+	cursor := transactionCursor{Sort: query.Sort, Desc: query.Desc, ID: t.ID}
+	switch query.Sort {
+	case SortByAmount:
+		cursor.Value = t.Amount.String()
+	case SortByName:
+		cursor.Value = t.Name
+	default:
+		cursor.Value = t.Date.Format(time.DateOnly)
+	}
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeTransactionCursor returns the sort value and row id the next page
+// starts after.
+func decodeTransactionCursor(query TransactionQuery) (interface{}, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(query.After)
+	if err != nil {
+		return nil, uuid.Nil, ErrInvalidCursor
+	}
+	var cursor transactionCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return nil, uuid.Nil, ErrInvalidCursor
+	}
+	if cursor.Sort != query.Sort || cursor.Desc != query.Desc {
+		return nil, uuid.Nil, ErrInvalidCursor
+	}
+
+	switch query.Sort {
+	case SortByAmount:
+		amount, err := money.Parse(cursor.Value)
+		if err != nil {
+			return nil, uuid.Nil, ErrInvalidCursor
+		}
+		return amount, cursor.ID, nil
+	case SortByName:
+		return cursor.Value, cursor.ID, nil
+	default:
+		date, err := time.Parse(time.DateOnly, cursor.Value)
+		if err != nil {
+			return nil, uuid.Nil, ErrInvalidCursor
+		}
+		return date, cursor.ID, nil
+	}
+}
+
+// likePattern escapes LIKE wildcards in s and matches it anywhere.
+func likePattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(s))
+	return "%" + s + "%"
+}
+
+func (r *sqlTransactionRepo) Search(ctx context.Context, userid string, query TransactionQuery) (TransactionPage, error) {
+	if query.Sort == "" {
+		query.Sort = SortByDate
+	}
+	sortColumn, ok := transactionSortColumns[query.Sort]
+	if !ok {
+		return TransactionPage{}, fmt.Errorf("unknown transaction sort %q", query.Sort)
+	}
+	if query.Limit <= 0 {
+		query.Limit = DefaultTransactionPageSize
+	}
+
+	args := []interface{}{userid}
+	where := []string{`t.user_id = $1`}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if !query.From.IsZero() {
+		where = append(where, `t.date >= `+arg(query.From))
+	}
+	if !query.To.IsZero() {
+		where = append(where, `t.date <= `+arg(query.To))
+	}
+	if query.AccountID != "" {
+		where = append(where, `t.plaid_account_id = `+arg(query.AccountID))
+	}
+	if query.MinAmount != nil {
+		where = append(where, `t.amount >= `+arg(*query.MinAmount))
+	}
+	if query.MaxAmount != nil {
+		where = append(where, `t.amount <= `+arg(*query.MaxAmount))
+	}
+	if query.Search != "" {
+		pattern := arg(likePattern(query.Search))
+		where = append(where, `(LOWER(t.name) LIKE `+pattern+` ESCAPE '\' OR LOWER(t.merchant_name) LIKE `+pattern+` ESCAPE '\')`)
+	}
+	if query.Category != "" {
+		category := arg(query.Category)
+		where = append(where, `(t.category_primary = `+category+` OR t.category_detailed = `+category+`)`)
+	}
+	if query.ExpenseID != uuid.Nil {
+		where = append(where, `te.expense_id = `+arg(query.ExpenseID))
+	}
+	if query.Pending != nil {
+		where = append(where, `COALESCE(t.pending, false) = `+arg(*query.Pending))
+	}
+	if query.Uncategorized {
+		where = append(where, `te.transcation_id IS NULL`)
+	}
+
+	direction, comparison := "ASC", ">"
+	if query.Desc {
+		direction, comparison = "DESC", "<"
+	}
+	if query.After != "" {
+		value, id, err := decodeTransactionCursor(query)
+		if err != nil {
+			return TransactionPage{}, err
+		}
+		where = append(where, `(`+sortColumn+`, t.transaction_id) `+comparison+` (`+arg(value)+`, `+arg(id)+`)`)
+	}
+
+	// One extra row tells whether there is a next page.
+	sqlQuery := `SELECT ` + transactionColumns + ` FROM ` + transactionFrom + ` WHERE ` + strings.Join(where, " AND ") +
+		` ORDER BY ` + sortColumn + ` ` + direction + `, t.transaction_id ` + direction + ` LIMIT ` + arg(query.Limit+1)
+
+	rows, err := r.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return TransactionPage{}, err
+	}
+	defer rows.Close()
+
+	page := TransactionPage{Transactions: []Transaction{}}
+	for rows.Next() {
+		t, err := scanTransaction(rows)
+		if err != nil {
+			return TransactionPage{}, err
+		}
+		page.Transactions = append(page.Transactions, t)
+	}
+	if err := rows.Err(); err != nil {
+		return TransactionPage{}, err
+	}
+
+	if len(page.Transactions) > query.Limit {
+		page.Transactions = page.Transactions[:query.Limit]
+		page.NextCursor = encodeTransactionCursor(query, page.Transactions[query.Limit-1])
+	}
+	return page, nil
+}
+
+const insertTransaction = `INSERT INTO "TransactionRaw" (transaction_id, user_id, item_id, plaid_transaction_id, plaid_account_id, name, merchant_name, amount, iso_currency_code, date, pending,
+		category_primary, category_detailed, plaid_category, personal_finance_category, raw)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`
+
+func insertTransactionArgs(t Transaction) []interface{} {
+	return []interface{}{t.ID, t.UserID, t.ItemID, t.PlaidTransactionID, t.PlaidAccountID, t.Name, nullString(t.MerchantName), t.Amount, t.ISOCurrencyCode, t.Date.UTC(), t.Pending,
+		nullString(t.CategoryPrimary), nullString(t.CategoryDetailed), nullJSON(t.PlaidCategory), nullJSON(t.PersonalFinanceCategory), nullJSON(t.Raw)}
+}
+
+func (r *sqlTransactionRepo) Upsert(ctx context.Context, transactions []Transaction) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := range transactions {
+		t := &transactions[i]
+		if t.ID == uuid.Nil {
+			t.ID = uuid.New()
+		}
+		_, err := tx.ExecContext(ctx, insertTransaction+`
+			ON CONFLICT (user_id, plaid_transaction_id) DO UPDATE SET plaid_account_id = EXCLUDED.plaid_account_id, name = EXCLUDED.name, merchant_name = EXCLUDED.merchant_name,
+				amount = EXCLUDED.amount, iso_currency_code = EXCLUDED.iso_currency_code, date = EXCLUDED.date, pending = EXCLUDED.pending,
+				category_primary = EXCLUDED.category_primary, category_detailed = EXCLUDED.category_detailed, plaid_category = EXCLUDED.plaid_category,
+				personal_finance_category = EXCLUDED.personal_finance_category, raw = EXCLUDED.raw, updated_at = CURRENT_TIMESTAMP`,
+			insertTransactionArgs(*t)...)
+		if err != nil {
+			return err
+		}
+		// An update keeps the id the row was first stored with.
+		err = tx.QueryRowContext(ctx, `SELECT transaction_id FROM "TransactionRaw" WHERE user_id = $1 AND plaid_transaction_id = $2`, t.UserID, t.PlaidTransactionID).Scan(&t.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *sqlTransactionRepo) InsertNew(ctx context.Context, transactions []Transaction) ([]Transaction, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	inserted := []Transaction{}
+	for _, t := range transactions {
+		if t.ID == uuid.Nil {
+			t.ID = uuid.New()
+		}
+		res, err := tx.ExecContext(ctx, insertTransaction+` ON CONFLICT (user_id, plaid_transaction_id) DO NOTHING`, insertTransactionArgs(t)...)
+		if err != nil {
+			return nil, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			inserted = append(inserted, t)
+		}
+	}
+
+	return inserted, tx.Commit()
+}
+
+func (r *sqlTransactionRepo) ExpenseTotals(ctx context.Context, userid string, from time.Time, to time.Time) ([]ExpenseTotal, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT te.expense_id, t.iso_currency_code, t.date, SUM(t.amount) FROM `+transactionFrom+`
+		WHERE t.user_id = $1 AND t.date >= $2 AND t.date <= $3 AND (te.expense_id IS NOT NULL OR t.amount > 0)
+		GROUP BY te.expense_id, t.iso_currency_code, t.date
+		ORDER BY t.date`, userid, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []ExpenseTotal{}
+	for rows.Next() {
+		var total ExpenseTotal
+		var expenseID uuid.NullUUID
+		var currency sql.NullString
+		if err := rows.Scan(&expenseID, &currency, &total.Date, &total.Total); err != nil {
+			return nil, err
+		}
+		total.ExpenseID, total.ISOCurrencyCode = expenseID.UUID, currency.String
+		totals = append(totals, total)
+	}
+
+	return totals, rows.Err()
+}
+
+func (r *sqlTransactionRepo) Inflows(ctx context.Context, userid string, from time.Time, to time.Time) ([]CurrencyTotal, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT iso_currency_code, date, SUM(-amount) FROM "TransactionRaw"
+		WHERE user_id = $1 AND date >= $2 AND date <= $3 AND amount < 0
+		GROUP BY iso_currency_code, date
+		ORDER BY date`, userid, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []CurrencyTotal{}
+	for rows.Next() {
+		var total CurrencyTotal
+		var currency sql.NullString
+		if err := rows.Scan(&currency, &total.Date, &total.Total); err != nil {
+			return nil, err
+		}
+		total.ISOCurrencyCode = currency.String
+		totals = append(totals, total)
+	}
+
+	return totals, rows.Err()
+}
+
+func (r *sqlTransactionRepo) MerchantTotals(ctx context.Context, userid string, from time.Time, to time.Time) ([]MerchantAmount, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT COALESCE(NULLIF(merchant_name, ''), name) AS merchant, iso_currency_code, date, SUM(amount), COUNT(*) FROM "TransactionRaw"
+		WHERE user_id = $1 AND date >= $2 AND date <= $3 AND amount > 0
+		GROUP BY COALESCE(NULLIF(merchant_name, ''), name), iso_currency_code, date
+		ORDER BY merchant, date`, userid, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	merchants := []MerchantAmount{}
+	for rows.Next() {
+		var merchant MerchantAmount
+		var currency sql.NullString
+		if err := rows.Scan(&merchant.Name, &currency, &merchant.Date, &merchant.Total, &merchant.Transactions); err != nil {
+			return nil, err
+		}
+		merchant.ISOCurrencyCode = currency.String
+		merchants = append(merchants, merchant)
+	}
+
+	return merchants, rows.Err()
+}
+
+func (r *sqlTransactionRepo) Assignments(ctx context.Context, userid string) ([]TransactionExpense, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT te.transcation_id, te.expense_id, te.confidence, te.source FROM "TransactionExpense" te
+		JOIN "TransactionRaw" t ON t.transaction_id = te.transcation_id WHERE t.user_id = $1 ORDER BY te.transcation_id`, userid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignments := []TransactionExpense{}
+	for rows.Next() {
+		var a TransactionExpense
+		var confidence sql.NullFloat64
+		if err := rows.Scan(&a.TransactionID, &a.ExpenseID, &confidence, &a.Source); err != nil {
+			return nil, err
+		}
+		a.Confidence = confidence.Float64
+		assignments = append(assignments, a)
+	}
+
+	return assignments, rows.Err()
+}
+
+func (r *sqlTransactionRepo) Assign(ctx context.Context, assignments []TransactionExpense) ([]TransactionExpense, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	assigned := []TransactionExpense{}
+	for _, a := range assignments {
+		res, err := tx.ExecContext(ctx, `INSERT INTO "TransactionExpense" (transcation_id, expense_id, confidence, source) VALUES ($1, $2, $3, $4) ON CONFLICT (transcation_id) DO NOTHING`,
+			a.TransactionID, a.ExpenseID, a.Confidence, a.Source)
+		if err != nil {
+			return nil, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			assigned = append(assigned, a)
+		}
+	}
+
+	return assigned, tx.Commit()
+}
+
+func (r *sqlTransactionRepo) SetExpense(ctx context.Context, userid string, transactionID uuid.UUID, expenseID *uuid.UUID) (*uuid.UUID, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var previous uuid.NullUUID
+	err = tx.QueryRowContext(ctx, `SELECT te.expense_id FROM "TransactionRaw" t
+		LEFT JOIN "TransactionExpense" te ON te.transcation_id = t.transaction_id
+		WHERE t.user_id = $1 AND t.transaction_id = $2`, userid, transactionID).Scan(&previous)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if expenseID != nil {
+		var exists int
+		err := tx.QueryRowContext(ctx, `SELECT 1 FROM "Expenses" WHERE expense_id = $1 AND user_id = $2`, *expenseID, userid).Scan(&exists)
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM "TransactionExpense" WHERE transcation_id = $1`, transactionID); err != nil {
+		return nil, err
+	}
+	if expenseID != nil {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO "TransactionExpense" (transcation_id, expense_id, confidence, source) VALUES ($1, $2, $3, $4)`,
+			transactionID, *expenseID, 1.0, CategorizedManually); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if !previous.Valid {
+		return nil, nil
+	}
+	return &previous.UUID, nil
+}
+
+func (r *sqlTransactionRepo) Delete(ctx context.Context, userid string, plaidTransactionIDs []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, id := range plaidTransactionIDs {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM "TransactionRaw" WHERE user_id = $1 AND plaid_transaction_id = $2`, userid, id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func nullJSON(raw []byte) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
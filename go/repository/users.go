@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+type sqlUserRepo struct {
+	db *sql.DB
+}
+
+const userColumns = `user_id, username, email, password_hash, plaid_access_token, totp_secret, totp_enabled, role, disabled, created_at`
+
+func scanUser(row *sql.Row) (User, error) {
+	var user User
+	var plaidToken, totpSecret sql.NullString
+	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &plaidToken, &totpSecret, &user.TOTPEnabled, &user.Role, &user.Disabled, &user.CreatedAt)
+	if err != nil {
+		return User{}, notFound(err)
+	}
+	user.PlaidAccessToken = plaidToken.String
+	user.TOTPSecret = totpSecret.String
+	return user, nil
+}
+
+func (r *sqlUserRepo) ByID(ctx context.Context, id string) (User, error) {
+	return scanUser(r.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM "Users" WHERE user_id = $1`, id))
+}
+
+func (r *sqlUserRepo) ByUsername(ctx context.Context, username string) (User, error) {
+	return scanUser(r.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM "Users" WHERE username = $1`, username))
+}
+
+func (r *sqlUserRepo) List(ctx context.Context) ([]UserSummary, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT u.user_id, u.username, u.email, u.role, u.disabled, u.totp_enabled, u.created_at,
+		(SELECT COUNT(*) FROM "PlaidItem" p WHERE p.user_id = u.user_id)
+		FROM "Users" u ORDER BY u.username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []UserSummary{}
+	for rows.Next() {
+		var user UserSummary
+		err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.Disabled, &user.TOTPEnabled, &user.CreatedAt, &user.PlaidItems)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// keepsAnAdmin is true for a user whose change cannot take away the last
+// enabled admin: one who is no enabled admin, or not the only one.
+const keepsAnAdmin = `(role <> 'admin' OR disabled OR (SELECT COUNT(*) FROM "Users" WHERE role = 'admin' AND NOT disabled) > 1)`
+
+func (r *sqlUserRepo) SetRole(ctx context.Context, id string, role string) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE "Users" SET role = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = $2 AND ($3 OR `+keepsAnAdmin+`)`, role, id, role == "admin")
+	return r.guardedUpdate(ctx, id, res, err)
+}
+
+func (r *sqlUserRepo) SetDisabled(ctx context.Context, id string, disabled bool) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE "Users" SET disabled = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = $2 AND (NOT $1 OR `+keepsAnAdmin+`)`, disabled, id)
+	return r.guardedUpdate(ctx, id, res, err)
+}
+
+// guardedUpdate tells an update the keepsAnAdmin guard refused, ErrConflict,
+// from one of a user that does not exist.
+func (r *sqlUserRepo) guardedUpdate(ctx context.Context, id string, res sql.Result, err error) error {
+	err = affectedOne(res, err)
+	if err != ErrNotFound {
+		return err
+	}
+	var exists bool
+	if err := r.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM "Users" WHERE user_id = $1)`, id).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return ErrConflict
+	}
+	return ErrNotFound
+}
+
+func (r *sqlUserRepo) SetTOTPSecret(ctx context.Context, id string, secret string) error {
+	return affectedOne(r.db.ExecContext(ctx, `UPDATE "Users" SET totp_secret = $1, updated_at = CURRENT_TIMESTAMP WHERE user_id = $2`, secret, id))
+}
+
+func (r *sqlUserRepo) EnableTOTP(ctx context.Context, id string) error {
+	return affectedOne(r.db.ExecContext(ctx, `UPDATE "Users" SET totp_enabled = true, updated_at = CURRENT_TIMESTAMP WHERE user_id = $1`, id))
+}
+
+func (r *sqlUserRepo) UseTOTPStep(ctx context.Context, id string, step int64) error {
+	return affectedOne(r.db.ExecContext(ctx, `UPDATE "Users" SET totp_last_step = $1
+		WHERE user_id = $2 AND (totp_last_step IS NULL OR totp_last_step < $1)`, step, id))
+}
+
+func (r *sqlUserRepo) ReplaceRecoveryCodes(ctx context.Context, id string, hashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM "RecoveryCode" WHERE user_id = $1`, id); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO "RecoveryCode" (recovery_code_id, user_id, code_hash) VALUES ($1, $2, $3)`, uuid.New(), id, hash); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *sqlUserRepo) UnusedRecoveryCodes(ctx context.Context, id string) ([]RecoveryCode, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT recovery_code_id, code_hash FROM "RecoveryCode" WHERE user_id = $1 AND used_at IS NULL`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	codes := []RecoveryCode{}
+	for rows.Next() {
+		var code RecoveryCode
+		if err := rows.Scan(&code.ID, &code.CodeHash); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, rows.Err()
+}
+
+func (r *sqlUserRepo) MarkRecoveryCodeUsed(ctx context.Context, codeID string) error {
+	// used_at IS NULL keeps two concurrent logins from spending the same code.
+	return affectedOne(r.db.ExecContext(ctx, `UPDATE "RecoveryCode" SET used_at = CURRENT_TIMESTAMP WHERE recovery_code_id = $1 AND used_at IS NULL`, codeID))
+}
@@ -0,0 +1,277 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type sqlWebhookRepo struct {
+	db *sql.DB
+}
+
+const webhookEndpointColumns = `endpoint_id, user_id, url, description, events, secret, enabled, created_at`
+
+func scanWebhookEndpoint(row rowScanner) (WebhookEndpoint, error) {
+	var endpoint WebhookEndpoint
+	var description sql.NullString
+	var events string
+	if err := row.Scan(&endpoint.ID, &endpoint.UserID, &endpoint.URL, &description, &events, &endpoint.Secret, &endpoint.Enabled, &endpoint.CreatedAt); err != nil {
+		return WebhookEndpoint{}, err
+	}
+	endpoint.Description = description.String
+	endpoint.Events = []string{}
+	if events != "" {
+		endpoint.Events = strings.Split(events, ",")
+	}
+	return endpoint, nil
+}
+
+func (r *sqlWebhookRepo) queryEndpoints(ctx context.Context, query string, args ...interface{}) ([]WebhookEndpoint, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	endpoints := []WebhookEndpoint{}
+	for rows.Next() {
+		endpoint, err := scanWebhookEndpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	return endpoints, rows.Err()
+}
+
+func (r *sqlWebhookRepo) Endpoints(ctx context.Context, userid string) ([]WebhookEndpoint, error) {
+	return r.queryEndpoints(ctx, `SELECT `+webhookEndpointColumns+` FROM "WebhookEndpoint" WHERE user_id = $1 ORDER BY created_at, endpoint_id`, userid)
+}
+
+func (r *sqlWebhookRepo) CreateEndpoint(ctx context.Context, userid string, endpoint WebhookEndpoint) (WebhookEndpoint, error) {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO "WebhookEndpoint" (endpoint_id, user_id, url, description, events, secret, enabled) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		endpoint.ID, userid, endpoint.URL, nullString(endpoint.Description), strings.Join(endpoint.Events, ","), endpoint.Secret, endpoint.Enabled)
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+	return r.endpoint(ctx, endpoint.ID)
+}
+
+func (r *sqlWebhookRepo) UpdateEndpoint(ctx context.Context, userid string, endpoint WebhookEndpoint) (WebhookEndpoint, error) {
+	err := affectedOne(r.db.ExecContext(ctx, `UPDATE "WebhookEndpoint" SET url = $1, description = $2, events = $3, secret = $4, enabled = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE endpoint_id = $6 AND user_id = $7`,
+		endpoint.URL, nullString(endpoint.Description), strings.Join(endpoint.Events, ","), endpoint.Secret, endpoint.Enabled, endpoint.ID, userid))
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+	return r.endpoint(ctx, endpoint.ID)
+}
+
+func (r *sqlWebhookRepo) endpoint(ctx context.Context, id uuid.UUID) (WebhookEndpoint, error) {
+	saved, err := scanWebhookEndpoint(r.db.QueryRowContext(ctx, `SELECT `+webhookEndpointColumns+` FROM "WebhookEndpoint" WHERE endpoint_id = $1`, id))
+	return saved, notFound(err)
+}
+
+func (r *sqlWebhookRepo) DeleteEndpoint(ctx context.Context, userid string, id uuid.UUID) error {
+	return affectedOne(r.db.ExecContext(ctx, `DELETE FROM "WebhookEndpoint" WHERE endpoint_id = $1 AND user_id = $2`, id, userid))
+}
+
+func (r *sqlWebhookRepo) Subscribed(ctx context.Context, eventType string, createdBefore time.Time) ([]WebhookEndpoint, error) {
+	// events is matched in Go: a LIKE on the comma-separated list would
+	// also match event types that contain this one.
+	endpoints, err := r.queryEndpoints(ctx, `SELECT `+webhookEndpointColumns+` FROM "WebhookEndpoint" e
+		WHERE e.enabled AND e.created_at < $1
+		AND EXISTS (SELECT 1 FROM "Users" u WHERE u.user_id = e.user_id AND NOT u.disabled)
+		ORDER BY e.user_id, e.created_at`, createdBefore)
+	if err != nil {
+		return nil, err
+	}
+	subscribed := []WebhookEndpoint{}
+	for _, endpoint := range endpoints {
+		for _, event := range endpoint.Events {
+			if event == eventType {
+				subscribed = append(subscribed, endpoint)
+				break
+			}
+		}
+	}
+	return subscribed, nil
+}
+
+func (r *sqlWebhookRepo) Enqueue(ctx context.Context, deliveries []WebhookDelivery) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	queued := 0
+	for _, d := range deliveries {
+		if d.ID == uuid.Nil {
+			d.ID = uuid.New()
+		}
+		res, err := tx.ExecContext(ctx, `INSERT INTO "WebhookDelivery" (delivery_id, endpoint_id, event_id, event_type, payload) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (endpoint_id, event_id) WHERE redelivery_of IS NULL DO NOTHING`,
+			d.ID, d.EndpointID, d.EventID, d.EventType, string(d.Payload))
+		if err != nil {
+			return 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		queued += int(n)
+	}
+
+	return queued, tx.Commit()
+}
+
+const webhookDeliveryColumns = `d.delivery_id, d.endpoint_id, d.event_id, d.event_type, d.payload, d.status, d.attempts, d.next_attempt_at,
+	d.response_status, d.last_error, d.delivered_at, d.redelivery_of, d.created_at`
+
+func scanWebhookDelivery(row rowScanner, extra ...interface{}) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	var payload string
+	var responseStatus sql.NullInt64
+	var lastError sql.NullString
+	var deliveredAt sql.NullTime
+	var redeliveryOf uuid.NullUUID
+	dest := append([]interface{}{&d.ID, &d.EndpointID, &d.EventID, &d.EventType, &payload, &d.Status, &d.Attempts, &d.NextAttemptAt,
+		&responseStatus, &lastError, &deliveredAt, &redeliveryOf, &d.CreatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return WebhookDelivery{}, err
+	}
+	d.Payload = []byte(payload)
+	if responseStatus.Valid {
+		status := int(responseStatus.Int64)
+		d.ResponseStatus = &status
+	}
+	d.LastError = lastError.String
+	if deliveredAt.Valid {
+		d.DeliveredAt = &deliveredAt.Time
+	}
+	if redeliveryOf.Valid {
+		d.RedeliveryOf = &redeliveryOf.UUID
+	}
+	return d, nil
+}
+
+func (r *sqlWebhookRepo) ClaimDeliveries(ctx context.Context, now time.Time, leaseUntil time.Time, limit int) ([]PendingWebhookDelivery, error) {
+	// The outer conditions are checked again once a concurrent claim of the
+	// same rows commits, so only one instance gets each delivery.
+	rows, err := r.db.QueryContext(ctx, `UPDATE "WebhookDelivery" SET next_attempt_at = $1
+		WHERE status = $2 AND next_attempt_at <= $3 AND delivery_id IN (
+			SELECT d.delivery_id FROM "WebhookDelivery" d
+			JOIN "WebhookEndpoint" e ON e.endpoint_id = d.endpoint_id
+			JOIN "Users" u ON u.user_id = e.user_id
+			WHERE d.status = $2 AND d.next_attempt_at <= $3 AND e.enabled AND NOT u.disabled
+			ORDER BY d.next_attempt_at, d.created_at LIMIT $4)
+		RETURNING delivery_id`, leaseUntil, WebhookPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	ids := []interface{}{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(ids) == 0 {
+		return []PendingWebhookDelivery{}, err
+	}
+
+	placeholders := make([]string, len(ids))
+	for i := range ids {
+		placeholders[i] = "$" + strconv.Itoa(i+1)
+	}
+	rows, err = r.db.QueryContext(ctx, `SELECT `+webhookDeliveryColumns+`, e.url, e.secret FROM "WebhookDelivery" d
+		JOIN "WebhookEndpoint" e ON e.endpoint_id = d.endpoint_id
+		WHERE d.delivery_id IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY d.created_at, d.delivery_id`, ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []PendingWebhookDelivery{}
+	for rows.Next() {
+		var d PendingWebhookDelivery
+		d.WebhookDelivery, err = scanWebhookDelivery(rows, &d.URL, &d.Secret)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (r *sqlWebhookRepo) MarkAttempted(ctx context.Context, d WebhookDelivery) error {
+	var responseStatus sql.NullInt64
+	if d.ResponseStatus != nil {
+		responseStatus = sql.NullInt64{Int64: int64(*d.ResponseStatus), Valid: true}
+	}
+	return affectedOne(r.db.ExecContext(ctx, `UPDATE "WebhookDelivery" SET status = $1, attempts = attempts + 1, next_attempt_at = $2, response_status = $3, last_error = $4,
+		delivered_at = CASE WHEN $1 = 'delivered' THEN CURRENT_TIMESTAMP ELSE delivered_at END
+		WHERE delivery_id = $5`,
+		d.Status, d.NextAttemptAt, responseStatus, nullString(d.LastError), d.ID))
+}
+
+func (r *sqlWebhookRepo) QueuedDeliveries(ctx context.Context) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM "WebhookDelivery" WHERE status = $1`, WebhookPending).Scan(&n)
+	return n, err
+}
+
+func (r *sqlWebhookRepo) Deliveries(ctx context.Context, userid string, endpointID uuid.UUID, status string, limit int) ([]WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM "WebhookDelivery" d
+		JOIN "WebhookEndpoint" e ON e.endpoint_id = d.endpoint_id
+		WHERE d.endpoint_id = $1 AND e.user_id = $2`
+	args := []interface{}{endpointID, userid}
+	if status != "" {
+		query += ` AND d.status = $3`
+		args = append(args, status)
+	}
+	args = append(args, limit)
+	rows, err := r.db.QueryContext(ctx, query+` ORDER BY d.created_at DESC, d.delivery_id LIMIT $`+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (r *sqlWebhookRepo) Redeliver(ctx context.Context, userid string, endpointID uuid.UUID, deliveryID uuid.UUID) (WebhookDelivery, error) {
+	id := uuid.New()
+	err := affectedOne(r.db.ExecContext(ctx, `INSERT INTO "WebhookDelivery" (delivery_id, endpoint_id, event_id, event_type, payload, redelivery_of)
+		SELECT $1, d.endpoint_id, d.event_id, d.event_type, d.payload, d.delivery_id FROM "WebhookDelivery" d
+		JOIN "WebhookEndpoint" e ON e.endpoint_id = d.endpoint_id
+		WHERE d.delivery_id = $2 AND d.endpoint_id = $3 AND e.user_id = $4`,
+		id, deliveryID, endpointID, userid))
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+
+	d, err := scanWebhookDelivery(r.db.QueryRowContext(ctx, `SELECT `+webhookDeliveryColumns+` FROM "WebhookDelivery" d WHERE d.delivery_id = $1`, id))
+	return d, notFound(err)
+}
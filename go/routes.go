@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type apiRoute struct {
+	method  string
+	path    string
+	handler gin.HandlerFunc
+}
+
+// plaidAPIRoutes proxy the Plaid API. They are served under /api/v1/plaid
+// and, as legacy aliases, straight under /api.
+var plaidAPIRoutes = []apiRoute{
+	{http.MethodPost, "/set_access_token", getAccessToken},
+	{http.MethodPost, "/create_link_token_for_payment", createLinkTokenForPayment},
+	{http.MethodGet, "/auth", auth},
+	{http.MethodGet, "/accounts", accounts},
+	{http.MethodGet, "/balance", balance},
+	{http.MethodGet, "/plaid_categories", getPlaidCategories},
+	{http.MethodGet, "/item", item},
+	{http.MethodPost, "/item", item},
+	{http.MethodGet, "/identity", identity},
+	{http.MethodGet, "/transactions", transactions},
+	{http.MethodPost, "/transactions", transactions},
+	{http.MethodGet, "/payment", payment},
+	{http.MethodGet, "/create_public_token", createPublicToken},
+	{http.MethodPost, "/create_link_token", createLinkToken},
+	{http.MethodPost, "/create_user_token", createUserToken},
+	{http.MethodGet, "/investments_transactions", investmentTransactions},
+	{http.MethodGet, "/holdings", holdings},
+	{http.MethodGet, "/assets", assets},
+	{http.MethodGet, "/transfer_authorize", transferAuthorize},
+	{http.MethodGet, "/transfer_create", transferCreate},
+	{http.MethodGet, "/signal_evaluate", signalEvaluate},
+	{http.MethodGet, "/statements", statements},
+	{http.MethodGet, "/cra/get_base_report", getCraBaseReportHandler},
+	{http.MethodGet, "/cra/get_income_insights", getCraIncomeInsightsHandler},
+	{http.MethodGet, "/cra/get_partner_insights", getCraPartnerInsightsHandler},
+}
+
+// legacyRoute is a route from before /api/v1, still served at its old path
+// under /api until clients have moved to successor.
+type legacyRoute struct {
+	apiRoute
+	successor string
+}
+
+type legacyGroup struct {
+	group  *gin.RouterGroup
+	routes []legacyRoute
+}
+
+// legacySuccessors maps the method and route path of each legacy route to
+// its successor's path.
+var legacySuccessors = map[string]string{}
+
+// registerLegacyRoutes serves the pre-/api/v1 paths as deprecated aliases
+// with the same middleware as their successors. Errors use the /api/v1
+// envelope there too.
+func registerLegacyRoutes(r *gin.Engine, authLimit gin.HandlerFunc, apiLimit gin.HandlerFunc, plaidLimit gin.HandlerFunc) {
+	// The Deprecation header goes on every response, including those of
+	// middleware that rejects the request, so it is set first.
+	legacy := r.Group("/api", deprecatedRoutes(legacySuccessors))
+	legacy.POST("/info", info)
+	legacySuccessors[http.MethodPost+" /api/info"] = "/api/v1/info"
+
+	public := legacy.Group("/", authLimit, ValidateRequest())
+	stream := legacy.Group("/", eventStreamToken(), AuthMiddleware(), apiLimit, ValidateRequest())
+	protected := legacy.Group("/", AuthMiddleware(), apiLimit, ValidateRequest())
+	catalog := protected.Group("/admin", RequireRole(RoleHouseholdOwner))
+	admin := protected.Group("/admin", RequireRole(RoleAdmin))
+	plaidRoutes := protected.Group("/", plaidLimit, PlaidTokenMiddleware())
+
+	groups := []legacyGroup{
+		{public, []legacyRoute{
+			{apiRoute{http.MethodPost, "/auth/login", loginHandler}, "/api/v1/auth/login"},
+			{apiRoute{http.MethodPost, "/auth/login/totp", loginTOTPHandler}, "/api/v1/auth/login/totp"},
+		}},
+		{stream, []legacyRoute{
+			{apiRoute{http.MethodGet, "/events", eventsHandler}, "/api/v1/events"},
+		}},
+		{protected, []legacyRoute{
+			{apiRoute{http.MethodGet, "/audit", getAuditHandler}, "/api/v1/audit"},
+			{apiRoute{http.MethodPost, "/auth/totp/enroll", enrollTOTPHandler}, "/api/v1/auth/totp/enroll"},
+			{apiRoute{http.MethodPost, "/auth/totp/confirm", confirmTOTPHandler}, "/api/v1/auth/totp/confirm"},
+			{apiRoute{http.MethodGet, "/categories", getCategories}, "/api/v1/categories"},
+			{apiRoute{http.MethodPost, "/save_budget", saveBudgetHandler}, "/api/v1/budget"},
+			{apiRoute{http.MethodGet, "/budget", getBudgetHandler}, "/api/v1/budget"},
+			{apiRoute{http.MethodGet, "/dummy/transactions", getDummyTransactions}, "/api/v1/dummy/transactions"},
+			{apiRoute{http.MethodGet, "/networth", getNetworthHandler}, "/api/v1/networth"},
+			{apiRoute{http.MethodGet, "/networth/manual", getManualAssetsHandler}, "/api/v1/networth/manual"},
+			{apiRoute{http.MethodPost, "/networth/manual", saveManualAssetHandler}, "/api/v1/networth/manual"},
+			{apiRoute{http.MethodPut, "/networth/manual/:id", saveManualAssetHandler}, "/api/v1/networth/manual/:id"},
+			{apiRoute{http.MethodDelete, "/networth/manual/:id", deleteManualAssetHandler}, "/api/v1/networth/manual/:id"},
+		}},
+		{catalog, []legacyRoute{
+			{apiRoute{http.MethodGet, "/categories", adminListCategories}, "/api/v1/admin/categories"},
+			{apiRoute{http.MethodPost, "/categories", adminCreateCategory}, "/api/v1/admin/categories"},
+			{apiRoute{http.MethodPut, "/categories/:id", adminUpdateCategory}, "/api/v1/admin/categories/:id"},
+			{apiRoute{http.MethodDelete, "/categories/:id", adminDeleteCategory}, "/api/v1/admin/categories/:id"},
+		}},
+		{admin, []legacyRoute{
+			{apiRoute{http.MethodGet, "/audit", adminGetAuditHandler}, "/api/v1/admin/audit"},
+			{apiRoute{http.MethodGet, "/users", adminListUsers}, "/api/v1/admin/users"},
+			{apiRoute{http.MethodPut, "/users/:id/role", adminSetUserRole}, "/api/v1/admin/users/:id/role"},
+			{apiRoute{http.MethodPost, "/users/:id/disable", adminDisableUser}, "/api/v1/admin/users/:id/disable"},
+			{apiRoute{http.MethodPost, "/users/:id/enable", adminEnableUser}, "/api/v1/admin/users/:id/enable"},
+			{apiRoute{http.MethodGet, "/users/:id/items", adminListUserItems}, "/api/v1/admin/users/:id/items"},
+			{apiRoute{http.MethodDelete, "/users/:id/items/:item_id", adminUnlinkItem}, "/api/v1/admin/users/:id/items/:item_id"},
+		}},
+	}
+	var plaid []legacyRoute
+	for _, route := range plaidAPIRoutes {
+		plaid = append(plaid, legacyRoute{route, "/api/v1/plaid" + route.path})
+	}
+	groups = append(groups, legacyGroup{plaidRoutes, plaid})
+
+	for _, g := range groups {
+		for _, route := range g.routes {
+			g.group.Handle(route.method, route.path, route.handler)
+			legacySuccessors[route.method+" "+joinPath(g.group.BasePath(), route.path)] = route.successor
+		}
+	}
+}
+
+// deprecatedRoutes marks responses of routes kept at their pre-/api/v1
+// paths, pointing clients at the successor, which successors holds by
+// method and route path. Path parameters are filled in from the request.
+func deprecatedRoutes(successors map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		successor, ok := successors[c.Request.Method+" "+c.FullPath()]
+		if !ok {
+			c.Next()
+			return
+		}
+		for _, p := range c.Params {
+			successor = strings.Replace(successor, ":"+p.Key, p.Value, 1)
+		}
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+successor+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
+
+// joinPath joins a group's base path and a route path as gin does.
+func joinPath(base string, path string) string {
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(path, "/")
+}
@@ -0,0 +1,44 @@
+-- Development seed data. Safe to run repeatedly: `go run . seed`.
+-- Every row has a fixed primary key so re-running is a no-op.
+
+INSERT INTO "Users" ("user_id", "username", "email", "password_hash", "plaid_access_token", "role") VALUES ('ed1bec4c-0a1b-4783-b47f-16ba0650b821', 'admin', 'admin@smartsplit.com', '$2a$10$nLavVuPde6DTLfHwkoxKkOOYfUt/QZrIg2Uq0W5HcyetavCl7ND12', '', 'admin') ON CONFLICT DO NOTHING; -- `seed` encrypts the sandbox access token into this row
+--INSERT INTO "Users" ("user_id", "username", "email", "password_hash", "plaid_access_token") VALUES ('ed1bec4c-0a1b-4783-b47f-16ba0650b821', 'admin', 'admin@smartsplit.com', '$2a$10$nLavVuPde6DTLfHwkoxKkOOYfUt/QZrIg2Uq0W5HcyetavCl7ND12', ''); -- No access token user
+INSERT INTO "PlaidItem" ("item_id", "user_id", "plaid_item_id", "plaid_access_token", "institution_name") VALUES ('5d3c1f0e-7b7a-4c53-9a8e-2f4b6c1d9e01', 'ed1bec4c-0a1b-4783-b47f-16ba0650b821', 'N1ayPx6y4KuazZmV8Zm6C78AAJn5XWIW7Veoj', '', 'Bank of America') ON CONFLICT DO NOTHING;
+
+INSERT INTO "Allocations" ("allocation_type", "allocation_description", "allocation_factor", "user_id") VALUES ('9f3c76e9-9d43-4480-a56d-a176b783f24d', 'Needs', 0.5, 'ed1bec4c-0a1b-4783-b47f-16ba0650b821') ON CONFLICT DO NOTHING;
+INSERT INTO "Allocations" ("allocation_type", "allocation_description", "allocation_factor", "user_id") VALUES ('ac184cdf-b7ff-4eb9-b757-628770d566fb', 'Debts and Repayment', 0.1, 'ed1bec4c-0a1b-4783-b47f-16ba0650b821') ON CONFLICT DO NOTHING;
+INSERT INTO "Allocations" ("allocation_type", "allocation_description", "allocation_factor", "user_id") VALUES ('f981f988-5be8-4a9b-bb39-392dd646ddbd', 'Wants', 0.3, 'ed1bec4c-0a1b-4783-b47f-16ba0650b821') ON CONFLICT DO NOTHING;
+INSERT INTO "Allocations" ("allocation_type", "allocation_description", "allocation_factor", "user_id") VALUES ('184906a8-94f8-459e-b654-88e42d246579', 'Savings', 0.1, 'ed1bec4c-0a1b-4783-b47f-16ba0650b821') ON CONFLICT DO NOTHING;
+
+INSERT INTO "Category" ("category_id", "plaid_category_primary_descriptor", "plaid_category_detailed_descriptor", "category_name","category_description") VALUES ('1ae53e57-8b82-45f2-a8cd-94d43932ab54', 'RENT_AND_UTILITIES', 'RENT_AND_UTILITIES_RENT', 'Rent','Payment, Rent') ON CONFLICT DO NOTHING;
+INSERT INTO "Category" ("category_id", "plaid_category_primary_descriptor", "plaid_category_detailed_descriptor", "category_name","category_description") VALUES ('c2f89cdc-5ff7-46fc-92b3-f14bbdec7404', 'RENT_AND_UTILITIES', 'RENT_AND_UTILITIES_GAS_AND_ELECTRICITY', 'Utilities','Electric, Utilities') ON CONFLICT DO NOTHING;
+INSERT INTO "Category" ("category_id", "plaid_category_primary_descriptor", "plaid_category_detailed_descriptor", "category_name","category_description") VALUES ('b0332b93-42a8-4eac-936f-ab8d859e9ce3', 'FOOD_AND_DRINK', 'FOOD_AND_DRINK_GROCERIES', 'Groceries','Groceries, Food') ON CONFLICT DO NOTHING;
+INSERT INTO "Category" ("category_id", "plaid_category_primary_descriptor", "plaid_category_detailed_descriptor", "category_name","category_description") VALUES ('a9f5ae3e-d44e-49ac-82c5-1bc13c354f58', 'FOOD_AND_DRINK', 'FOOD_AND_DRINK_RESTAURANT', 'Restaurants','Restaurants, Dining') ON CONFLICT DO NOTHING;
+INSERT INTO "Category" ("category_id", "plaid_category_primary_descriptor", "plaid_category_detailed_descriptor", "category_name","category_description") VALUES ('e4b43a8e-b330-413d-80fa-0dd727bb7bb5', 'TRANSPORTATION', 'TRANSPORTATION_GAS','Gas','Oil and gas') ON CONFLICT DO NOTHING;
+INSERT INTO "Category" ("category_id", "plaid_category_primary_descriptor", "plaid_category_detailed_descriptor", "category_name","category_description") VALUES ('2d899e52-09dd-4815-b045-d41fe26a94e2', 'RENT_AND_UTILITIES', 'RENT_AND_UTILITIES_TELEPHONE', 'Utilities', 'Utilities, Phone Bill') ON CONFLICT DO NOTHING;
+INSERT INTO "Category" ("category_id", "plaid_category_primary_descriptor", "plaid_category_detailed_descriptor", "category_name","category_description") VALUES ('c91064d5-ac80-40a2-92db-b0d82ac7f4e5', 'LOAN_PAYMENTS', 'LOAN_PAYMENTS_STUDENT_LOAN_PAYMENT',  'Loan Payment', 'Student Loan Payment') ON CONFLICT DO NOTHING;
+INSERT INTO "Category" ("category_id", "plaid_category_primary_descriptor", "plaid_category_detailed_descriptor", "category_name","category_description") VALUES ('615b48c8-7b43-42ff-b147-6580219235e6', 'LOAN_PAYMENTS', 'LOAN_PAYMENTS_CREDIT_CARD_PAYMENT',  'Credit Card', 'Credit Card Payment') ON CONFLICT DO NOTHING;
+INSERT INTO "Category" ("category_id", "plaid_category_primary_descriptor", "plaid_category_detailed_descriptor", "category_name","category_description") VALUES ('ff8b02f5-d11f-4344-89c9-d6880227746a', 'RENT_AND_UTILITIES', 'RENT_AND_UTILITIES_INTERNET_AND_CABLE', 'Internet Service', 'Internet, Services') ON CONFLICT DO NOTHING;
+INSERT INTO "Category" ("category_id", "plaid_category_primary_descriptor", "plaid_category_detailed_descriptor", "category_name","category_description") VALUES ('fa5a2c7b-3674-4522-8e38-078fa1da0585', 'GENERAL_SERVICES', 'GENERAL_SERVICES_STORAGE',  'Storage Service', 'Storage, Services') ON CONFLICT DO NOTHING;
+INSERT INTO "Category" ("category_id", "plaid_category_primary_descriptor", "plaid_category_detailed_descriptor", "category_name","category_description") VALUES ('3be2fb20-9cbc-42a5-bcc4-ddb98e93a194', 'PERSONAL_CARE', 'PERSONAL_CARE_GYMS_AND_FITNESS_CENTERS',  'Gym', 'Gyms and Fitness Centers, Recreation') ON CONFLICT DO NOTHING;
+INSERT INTO "Category" ("category_id", "plaid_category_primary_descriptor", "plaid_category_detailed_descriptor", "category_name","category_description") VALUES ('aac6042d-9026-4dd3-b34e-23eb68598b90', 'ENTERTAINMENT', 'ENTERTAINMENT_OTHER_ENTERTAINMENT', 'Social Clubs', 'Social Clubs, Arts and Entertainment') ON CONFLICT DO NOTHING;
+INSERT INTO "Category" ("category_id", "plaid_category_primary_descriptor", "plaid_category_detailed_descriptor", "category_name","category_description") VALUES ('bb29d737-319e-4585-864c-a00701b2a231', 'ENTERTAINMENT', 'ENTERTAINMENT_TV_AND_MOVIES', 'Subscription Service', 'Subscription, Services') ON CONFLICT DO NOTHING;
+INSERT INTO "Category" ("category_id", "plaid_category_primary_descriptor", "plaid_category_detailed_descriptor", "category_name","category_description") VALUES ('30bb0179-90db-4f99-a0bb-45abbbff8efb', 'GENERAL_SERVICES', 'GENERAL_SERVICES_INSURANCE',  'Insurance', 'Insurance, Services') ON CONFLICT DO NOTHING;
+INSERT INTO "Category" ("category_id", "plaid_category_primary_descriptor", "plaid_category_detailed_descriptor", "category_name","category_description") VALUES ('367e8925-3682-42f0-bbce-beb0eee2fbab', 'MEDICAL', 'MEDICAL_PHARMACIES_AND_SUPPLEMENTS',  'Pharmacy', 'Pharmacy, Health') ON CONFLICT DO NOTHING;
+INSERT INTO "Category" ("category_id", "plaid_category_primary_descriptor", "plaid_category_detailed_descriptor", "category_name","category_description") VALUES ('fdf97f4c-9739-43b9-acc7-2fb074bfb012', '', '',  'Savings', 'Emergency Funds, Savings') ON CONFLICT DO NOTHING;
+
+INSERT INTO "Income" ("income_id", "income_description", "income_amount", "user_id", "income_frequency") VALUES ('8693199c-c2c6-4f75-8138-bda75a186581', 'Salary', 5000, 'ed1bec4c-0a1b-4783-b47f-16ba0650b821', 'Semi-Monthly') ON CONFLICT DO NOTHING;
+
+INSERT INTO "Expenses" ("expense_id", "expense_description", "expense_amount", "expense_category", "user_id", "allocation_type") VALUES ('2f0f1fa8-3df2-5a89-8c01-5ec54905acc7', 'Rent', 1500, '1ae53e57-8b82-45f2-a8cd-94d43932ab54', 'ed1bec4c-0a1b-4783-b47f-16ba0650b821', '9f3c76e9-9d43-4480-a56d-a176b783f24d') ON CONFLICT DO NOTHING;
+INSERT INTO "Expenses" ("expense_id", "expense_description", "expense_amount", "expense_category", "user_id", "allocation_type") VALUES ('60bbb2e2-4fac-561d-83f1-5097f47dc60e', 'FPL', 100, 'c2f89cdc-5ff7-46fc-92b3-f14bbdec7404', 'ed1bec4c-0a1b-4783-b47f-16ba0650b821', '9f3c76e9-9d43-4480-a56d-a176b783f24d') ON CONFLICT DO NOTHING;
+INSERT INTO "Expenses" ("expense_id", "expense_description", "expense_amount", "expense_category", "user_id", "allocation_type") VALUES ('fafe1349-780e-59d2-97ff-964cb61b6152', 'Groceries', 500, 'b0332b93-42a8-4eac-936f-ab8d859e9ce3', 'ed1bec4c-0a1b-4783-b47f-16ba0650b821', '9f3c76e9-9d43-4480-a56d-a176b783f24d') ON CONFLICT DO NOTHING;
+INSERT INTO "Expenses" ("expense_id", "expense_description", "expense_amount", "expense_category", "user_id", "allocation_type") VALUES ('dd2229ce-be9b-566a-99f9-6e10b09c1dc9', 'Dining out', 400, 'a9f5ae3e-d44e-49ac-82c5-1bc13c354f58', 'ed1bec4c-0a1b-4783-b47f-16ba0650b821', 'f981f988-5be8-4a9b-bb39-392dd646ddbd') ON CONFLICT DO NOTHING;
+INSERT INTO "Expenses" ("expense_id", "expense_description", "expense_amount", "expense_category", "user_id", "allocation_type") VALUES ('55d2f5a7-2b3a-5a4f-8c88-2da87baefebf', 'Vehicle gas', 120, 'e4b43a8e-b330-413d-80fa-0dd727bb7bb5', 'ed1bec4c-0a1b-4783-b47f-16ba0650b821', '9f3c76e9-9d43-4480-a56d-a176b783f24d') ON CONFLICT DO NOTHING;
+INSERT INTO "Expenses" ("expense_id", "expense_description", "expense_amount", "expense_category", "user_id", "allocation_type") VALUES ('e055eb74-b6f6-5b57-a664-81f9e0ef3fa4', 'Internet', 80, 'ff8b02f5-d11f-4344-89c9-d6880227746a', 'ed1bec4c-0a1b-4783-b47f-16ba0650b821', '9f3c76e9-9d43-4480-a56d-a176b783f24d') ON CONFLICT DO NOTHING;
+INSERT INTO "Expenses" ("expense_id", "expense_description", "expense_amount", "expense_category", "user_id", "allocation_type") VALUES ('1ea33004-8892-577a-97a7-f39ea9d837d6', 'Phone Bill', 150, '2d899e52-09dd-4815-b045-d41fe26a94e2', 'ed1bec4c-0a1b-4783-b47f-16ba0650b821', '9f3c76e9-9d43-4480-a56d-a176b783f24d') ON CONFLICT DO NOTHING;
+INSERT INTO "Expenses" ("expense_id", "expense_description", "expense_amount", "expense_category", "user_id", "allocation_type") VALUES ('023b5f42-126b-51a7-b50d-9ff6658ba300', 'Storage', 100, 'fa5a2c7b-3674-4522-8e38-078fa1da0585', 'ed1bec4c-0a1b-4783-b47f-16ba0650b821', '9f3c76e9-9d43-4480-a56d-a176b783f24d') ON CONFLICT DO NOTHING;
+INSERT INTO "Expenses" ("expense_id", "expense_description", "expense_amount", "expense_category", "user_id", "allocation_type") VALUES ('7e2dfeb6-c1a3-551b-aa5a-6adc3b7b490a', 'Gym', 50, '3be2fb20-9cbc-42a5-bcc4-ddb98e93a194', 'ed1bec4c-0a1b-4783-b47f-16ba0650b821', 'f981f988-5be8-4a9b-bb39-392dd646ddbd') ON CONFLICT DO NOTHING;
+INSERT INTO "Expenses" ("expense_id", "expense_description", "expense_amount", "expense_category", "user_id", "allocation_type") VALUES ('b76be10a-8b78-59be-9734-5d41928e714c', 'TV subscriptions', 30, 'bb29d737-319e-4585-864c-a00701b2a231', 'ed1bec4c-0a1b-4783-b47f-16ba0650b821', 'f981f988-5be8-4a9b-bb39-392dd646ddbd') ON CONFLICT DO NOTHING;
+INSERT INTO "Expenses" ("expense_id", "expense_description", "expense_amount", "expense_category", "user_id", "allocation_type") VALUES ('4e61d84e-f590-5903-84bf-c3ff69098446', 'Car insurance', 120, '30bb0179-90db-4f99-a0bb-45abbbff8efb', 'ed1bec4c-0a1b-4783-b47f-16ba0650b821', '9f3c76e9-9d43-4480-a56d-a176b783f24d') ON CONFLICT DO NOTHING;
+INSERT INTO "Expenses" ("expense_id", "expense_description", "expense_amount", "expense_category", "user_id", "allocation_type") VALUES ('b3e4b3d3-f757-592b-8ad5-d44cdb6c255b', 'Credit card payment', 50, '615b48c8-7b43-42ff-b147-6580219235e6', 'ed1bec4c-0a1b-4783-b47f-16ba0650b821', 'ac184cdf-b7ff-4eb9-b757-628770d566fb') ON CONFLICT DO NOTHING;
+INSERT INTO "Expenses" ("expense_id", "expense_description", "expense_amount", "expense_category", "user_id", "allocation_type") VALUES ('4ad3caad-4175-51e8-ace6-33a8ab2e06b7', 'Student loan payment', 350, 'c91064d5-ac80-40a2-92db-b0d82ac7f4e5', 'ed1bec4c-0a1b-4783-b47f-16ba0650b821', 'ac184cdf-b7ff-4eb9-b757-628770d566fb') ON CONFLICT DO NOTHING;
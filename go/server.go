@@ -6,9 +6,9 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"sort"
@@ -19,6 +19,8 @@ import (
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	plaid "github.com/plaid/plaid-go/v31/plaid"
+	"github.com/plaid/quickstart/money"
+	"github.com/plaid/quickstart/repository"
 )
 
 var (
@@ -31,6 +33,7 @@ var (
 	APP_PORT                             = ""
 	client              *plaid.APIClient = nil
 	DB                  *sql.DB          = nil
+	repos               repository.Repositories
 )
 
 var environments = map[string]plaid.Environment{
@@ -40,59 +43,153 @@ var environments = map[string]plaid.Environment{
 
 // Category represents a category in the database
 
-type Allocation struct {
-	Id                    uuid.UUID `json:"id"`
-	AllocationType        string    `json:"allocation_type"`
-	AllocationDescription string    `json:"allocation_description"`
-	AllocationFactor      float64   `json:"allocation_factor"`
-}
-
 type Category struct {
 	ID   uuid.UUID `json:"id"`
 	Name string    `json:"name"`
 }
 
-type Expense struct {
-	Id             uuid.UUID `json:"id"`
-	Description    string    `json:"description"`
-	Amount         float64   `json:"amount"`
-	Category       string    `json:"category"`
-	AllocationType string    `json:"allocation_type"`
+type Allocation = repository.Allocation
+type Expense = repository.Expense
+type Income = repository.Income
+
+// loginRequest is the login payload, as JSON or as the form fields user
+// and password.
+type loginRequest struct {
+	Username string `json:"username" form:"user" binding:"required"`
+	Password string `json:"password" form:"password" binding:"required"`
 }
 
-type Income struct {
-	Id        uuid.UUID `json:"id"`
-	Amount    float64   `json:"amount"`
-	Frequency string    `json:"frequency"`
+type loginResponse struct {
+	Message     string `json:"message"`
+	Token       string `json:"token"`
+	PlaidLinked bool   `json:"plaid_linked"`
+	UserID      string `json:"user_id"`
+	Username    string `json:"username"`
 }
 
-// LoginRequest represents the login payload
-type loginRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+// totpChallengeResponse answers a correct password when 2FA is enabled.
+type totpChallengeResponse struct {
+	Message        string `json:"message"`
+	TOTPRequired   bool   `json:"totp_required"`
+	ChallengeToken string `json:"challenge_token"`
+}
+
+type getBudgetResponse = repository.Budget
+
+type budgetResponse struct {
+	Budget getBudgetResponse `json:"budget"`
+}
+
+type categoriesResponse struct {
+	Categories []Category `json:"categories"`
+}
+
+type publicTokenRequest struct {
+	PublicToken string `json:"public_token" form:"public_token" binding:"required"`
+}
+
+type infoResponse struct {
+	ItemID   string   `json:"item_id"`
+	Products []string `json:"products"`
+}
+
+// The Plaid routes answer with Plaid's own models, wrapped as below.
+
+type plaidCategoriesResponse struct {
+	Categories []plaid.Category `json:"categories"`
+}
+
+type itemIDResponse struct {
+	ItemID string `json:"item_id"`
+}
+
+type linkTokenResponse struct {
+	LinkToken string `json:"link_token"`
+}
+
+type userTokenResponse struct {
+	UserToken string `json:"user_token"`
+}
+
+type publicTokenResponse struct {
+	PublicToken string `json:"public_token"`
+}
+
+type authResponse struct {
+	Accounts []plaid.AccountBase  `json:"accounts"`
+	Numbers  plaid.AuthGetNumbers `json:"numbers"`
+}
+
+type accountsResponse struct {
+	Accounts []plaid.AccountBase `json:"accounts"`
+}
+
+type itemResponse struct {
+	Item        plaid.ItemWithConsentFields `json:"item"`
+	Institution plaid.Institution           `json:"institution"`
+}
+
+type identityResponse struct {
+	Identity []plaid.AccountIdentity `json:"identity"`
+}
+
+type latestTransactionsResponse struct {
+	LatestTransactions []plaid.Transaction `json:"latest_transactions"`
+}
+
+type paymentResponse struct {
+	Payment plaid.PaymentInitiationPaymentGetResponse `json:"payment"`
+}
+
+type investmentTransactionsResponse struct {
+	InvestmentsTransactions plaid.InvestmentsTransactionsGetResponse `json:"investments_transactions"`
+}
+
+type holdingsResponse struct {
+	Holdings plaid.InvestmentsHoldingsGetResponse `json:"holdings"`
+}
+
+// Reports come with their PDF, base64 encoded.
+
+type plaidStatementsResponse struct {
+	JSON plaid.StatementsListResponse `json:"json"`
+	PDF  string                       `json:"pdf"`
+}
+
+type assetsResponse struct {
+	JSON plaid.AssetReport `json:"json"`
+	PDF  string            `json:"pdf"`
+}
+
+type craBaseReportResponse struct {
+	Report plaid.BaseReport `json:"report"`
+	PDF    string           `json:"pdf"`
+}
+
+type craIncomeInsightsResponse struct {
+	Report *plaid.CraIncomeInsights `json:"report"`
+	PDF    string                   `json:"pdf"`
 }
 
-type getBudgetResponse struct {
-	Expenses    []Expense    `json:"expenses"`
-	Incomes     []Income     `json:"incomes"`
-	Allocations []Allocation `json:"allocations"`
+type craPartnerInsightsResponse struct {
+	Report *plaid.CraPartnerInsights `json:"report"`
 }
 
 // Define the structure of the JSON data
 type Transaction struct {
-	TransactionID           string   `json:"transaction_id"`
-	AccountID               string   `json:"account_id"`
-	Amount                  float64  `json:"amount"`
-	ISOCurrencyCode         string   `json:"iso_currency_code"`
-	Date                    string   `json:"date"`
-	AuthorizedDate          string   `json:"authorized_date"`
-	Name                    string   `json:"name"`
-	MerchantName            string   `json:"merchant_name"`
-	PaymentChannel          string   `json:"payment_channel"`
-	Pending                 bool     `json:"pending"`
-	TransactionType         string   `json:"transaction_type"`
-	Category                []string `json:"category"`
-	CategoryID              string   `json:"category_id"`
+	TransactionID           string        `json:"transaction_id"`
+	AccountID               string        `json:"account_id"`
+	Amount                  money.Decimal `json:"amount"`
+	ISOCurrencyCode         string        `json:"iso_currency_code"`
+	Date                    string        `json:"date"`
+	AuthorizedDate          string        `json:"authorized_date"`
+	Name                    string        `json:"name"`
+	MerchantName            string        `json:"merchant_name"`
+	PaymentChannel          string        `json:"payment_channel"`
+	Pending                 bool          `json:"pending"`
+	TransactionType         string        `json:"transaction_type"`
+	Category                []string      `json:"category"`
+	CategoryID              string        `json:"category_id"`
 	PersonalFinanceCategory struct {
 		Primary         string `json:"primary"`
 		Detailed        string `json:"detailed"`
@@ -109,11 +206,16 @@ type getDummyTransactionsResponse struct {
 	LatestTransactions []Transaction `json:"latest_transactions"`
 }
 
-func init() {
+// loadConfig reads the environment, and the .env file if any, and sets up
+// logging and the Plaid client. main runs it before anything else.
+func loadConfig() {
 	// load env vars from .env file
-	err := godotenv.Load()
-	if err != nil {
-		fmt.Println("Error when loading environment variables from .env file %w", err)
+	envErr := godotenv.Load()
+	if err := configureLogging(os.Stderr); err != nil {
+		fatal(err)
+	}
+	if envErr != nil {
+		logger("server").Info("no environment variables loaded from .env", "err", envErr)
 	}
 
 	// set constants from env
@@ -122,7 +224,7 @@ func init() {
 	PLAID_SECRET := os.Getenv("PLAID_SECRET")
 
 	if PLAID_CLIENT_ID == "" || PLAID_SECRET == "" {
-		log.Fatal("Error: PLAID_SECRET or PLAID_CLIENT_ID is not set. Did you copy .env.example to .env and fill it out?")
+		fatal(errors.New("PLAID_SECRET or PLAID_CLIENT_ID is not set. Did you copy .env.example to .env and fill it out?"))
 	}
 
 	PLAID_ENV = os.Getenv("PLAID_ENV")
@@ -145,10 +247,10 @@ func init() {
 		APP_PORT = "8000"
 	}
 	if PLAID_CLIENT_ID == "" {
-		log.Fatal("PLAID_CLIENT_ID is not set. Make sure to fill out the .env file")
+		fatal(errors.New("PLAID_CLIENT_ID is not set. Make sure to fill out the .env file"))
 	}
 	if PLAID_SECRET == "" {
-		log.Fatal("PLAID_SECRET is not set. Make sure to fill out the .env file")
+		fatal(errors.New("PLAID_SECRET is not set. Make sure to fill out the .env file"))
 	}
 
 	// create Plaid client
@@ -156,26 +258,243 @@ func init() {
 	configuration.AddDefaultHeader("PLAID-CLIENT-ID", PLAID_CLIENT_ID)
 	configuration.AddDefaultHeader("PLAID-SECRET", PLAID_SECRET)
 	configuration.UseEnvironment(environments[PLAID_ENV])
+	configuration.HTTPClient = &http.Client{Transport: plaidTransport{base: http.DefaultTransport}}
 	client = plaid.NewAPIClient(configuration)
 }
 
 func main() {
-	r := gin.Default()
+	loadConfig()
+
+	r := gin.New()
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		fatal(fmt.Errorf("TRUSTED_PROXIES: %w", err))
+	}
+	r.Use(RequestIDMiddleware(), AccessLogMiddleware(), MetricsMiddleware(), RecoveryMiddleware())
 
 	DB, err := InitDB()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
 	defer CloseDB(DB)
+	repos = repository.New(DB)
+
+	// `migrate [up|down|status] [n]` and `seed` manage the schema, then exit.
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(context.Background(), DB, dbDriver, os.Args[2:]); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "seed" {
+		cipher, err := newTokenCipherFromEnv()
+		if err != nil {
+			fatal(err)
+		}
+		if err := Seed(context.Background(), DB); err != nil {
+			fatal(err)
+		}
+		if err := seedAccessTokens(context.Background(), cipher, repos.Secrets); err != nil {
+			fatal(err)
+		}
+		logger("server").Info("seed data loaded")
+		return
+	}
+	// A fresh SQLite file is only usable once migrated, so SQLite migrates on
+	// start unless MIGRATE_ON_START says otherwise.
+	migrateOnStart := os.Getenv("MIGRATE_ON_START")
+	if migrateOnStart == "true" || (migrateOnStart == "" && dbDriver == driverSQLite) {
+		if err := runMigrateCommand(context.Background(), DB, dbDriver, []string{"up"}); err != nil {
+			fatal(err)
+		}
+	}
+
+	// `import -user NAME -account NAME FILE` loads a CSV, OFX or QIF
+	// statement into a manual account, then exits.
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := runImportCommand(context.Background(), os.Args[2:]); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	// `backup -user NAME FILE` and `restore -user NAME FILE` archive a user's
+	// data or replace it from an archive, then exit.
+	if len(os.Args) > 1 && (os.Args[1] == "backup" || os.Args[1] == "restore") {
+		if err := runBackupCommand(context.Background(), os.Args[1], os.Args[2:]); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	// `fx load FILE` stores exchange rates from a CSV or ECB XML file, and
+	// `fx refresh` fetches new ones from FX_PROVIDER, then exit.
+	if len(os.Args) > 1 && os.Args[1] == "fx" {
+		if err := runFXCommand(context.Background(), os.Args[2:]); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if tokenCipher, err = newTokenCipherFromEnv(); err != nil {
+		fatal(err)
+	}
+
+	// `rotate-token-keys` rewraps every token under
+	// TOKEN_ENCRYPTION_PRIMARY_KEY, and with -migrate-legacy encrypts legacy
+	// ones, then exits.
+	if len(os.Args) > 1 && os.Args[1] == "rotate-token-keys" {
+		n, err := runRotateTokenKeysCommand(context.Background(), tokenCipher, repos.Secrets, os.Args[2:])
+		if err != nil {
+			fatal(err)
+		}
+		writeAudit(context.Background(), repository.AuditEntry{
+			Action:   AuditTokenKeysRotated,
+			Metadata: auditMetadata(gin.H{"rotated": n, "primary_key": tokenCipher.keys.PrimaryKeyID()}),
+		})
+		logger("server").Info("rotated access tokens", "count", n)
+		return
+	}
+
+	if jwtSecret, err = loadJWTSecret(); err != nil {
+		fatal(err)
+	}
+
+	limits, err := loadRateLimitSettings(repos.RateLimits)
+	if err != nil {
+		fatal(err)
+	}
+	loginLockout = limits.Lockout
+
+	mailer, err = newMailerFromEnv()
+	if err != nil {
+		fatal(err)
+	}
+	if mailer != nil {
+		go runStatementMailer(context.Background(), mailer)
+	}
+	go runBalanceSnapshots(context.Background())
+	go runInvestmentSync(context.Background())
+	go runAlertDelivery(context.Background())
+	go runWebhookDelivery(context.Background())
+	metricsToken, err := loadMetricsToken(PLAID_ENV)
+	if err != nil {
+		fatal(err)
+	}
+
+	fxProvider, err := newFXProviderFromEnv()
+	if err != nil {
+		fatal(err)
+	}
+	if fxProvider != nil {
+		go runFXRefresh(context.Background(), fxProvider)
+	}
+
+	authLimit := RateLimitMiddleware(limits.Store, "auth", limits.Auth, rateLimitByIP)
+	apiLimit := RateLimitMiddleware(limits.Store, "api", limits.API, rateLimitByIP, rateLimitByUser)
+	// Every Plaid route gets a tighter per-user budget to keep a single
+	// client from burning through our Plaid quota.
+	plaidLimit := RateLimitMiddleware(limits.Store, "plaid", limits.Plaid, rateLimitByUser)
+
+	r.GET("/api/openapi.json", openAPIHandler)
+	r.GET("/metrics", metricsHandler(metricsToken))
+
+	v1 := r.Group("/api/v1")
+	v1.POST("/info", info)
+
+	public := v1.Group("/")
+	public.Use(authLimit, ValidateRequest())
+	{
+		public.POST("/auth/login", loginHandler)
+		public.POST("/auth/login/totp", loginTOTPHandler)
+	}
 
-	r.POST("/api/auth/login", loginHandler)
+	// EventSource cannot send an Authorization header, so the event stream
+	// also takes the token as a query parameter.
+	stream := v1.Group("/")
+	stream.Use(eventStreamToken(), AuthMiddleware(), apiLimit, ValidateRequest())
+	{
+		stream.GET("/events", eventsHandler)
+	}
 
-	protected := r.Group("/")
-	protected.Use(AuthMiddleware())
+	protected := v1.Group("/")
+	protected.Use(AuthMiddleware(), apiLimit, ValidateRequest())
 	{
-		r.POST("/api/info", info)
+		protected.GET("/audit", getAuditHandler)
+		protected.POST("/auth/totp/enroll", enrollTOTPHandler)
+		protected.POST("/auth/totp/confirm", confirmTOTPHandler)
+		protected.GET("/categories", getCategories)
+		protected.GET("/budget", getBudgetHandler)
+		protected.POST("/budget", saveBudgetHandler)
+		protected.GET("/dummy/transactions", getDummyTransactions)
+		protected.GET("/transactions", getTransactionsHandler)
+		protected.PUT("/transactions/:id/expense", setTransactionExpenseHandler)
+		protected.POST("/transactions/import", importTransactionsHandler)
+		protected.GET("/export/transactions", exportTransactionsHandler)
+		protected.GET("/export/budget", exportBudgetHandler)
+		protected.GET("/export/budget-vs-actual", exportBudgetVsActualHandler)
+		protected.GET("/backup", getBackupHandler)
+		protected.POST("/restore", restoreBackupHandler)
+		protected.GET("/statements", getStatementHandler)
+		protected.GET("/statements/settings", getStatementSettingsHandler)
+		protected.PUT("/statements/settings", putStatementSettingsHandler)
+		protected.GET("/statements/notes/:period", getStatementNoteHandler)
+		protected.PUT("/statements/notes/:period", putStatementNoteHandler)
+		protected.GET("/settings/currency", getCurrencySettingsHandler)
+		protected.PUT("/settings/currency", putCurrencySettingsHandler)
+		protected.GET("/goals", getGoalsHandler)
+		protected.POST("/goals", saveGoalHandler)
+		protected.PUT("/goals/:id", saveGoalHandler)
+		protected.DELETE("/goals/:id", deleteGoalHandler)
+		protected.GET("/alerts", getAlertsHandler)
+		protected.POST("/alerts/:id/read", markAlertReadHandler)
+		protected.GET("/alerts/rules", getAlertRulesHandler)
+		protected.POST("/alerts/rules", saveAlertRuleHandler)
+		protected.PUT("/alerts/rules/:id", saveAlertRuleHandler)
+		protected.DELETE("/alerts/rules/:id", deleteAlertRuleHandler)
+		protected.GET("/alerts/settings", getAlertSettingsHandler)
+		protected.PUT("/alerts/settings", putAlertSettingsHandler)
+		protected.GET("/webhooks", getWebhooksHandler)
+		protected.POST("/webhooks", saveWebhookHandler)
+		protected.PUT("/webhooks/:id", saveWebhookHandler)
+		protected.DELETE("/webhooks/:id", deleteWebhookHandler)
+		protected.GET("/webhooks/:id/deliveries", getWebhookDeliveriesHandler)
+		protected.POST("/webhooks/:id/deliveries/:delivery/redeliver", redeliverWebhookHandler)
+		protected.GET("/investments/portfolio", getPortfolioHandler)
+		protected.GET("/networth", getNetworthHandler)
+		protected.GET("/networth/manual", getManualAssetsHandler)
+		protected.POST("/networth/manual", saveManualAssetHandler)
+		protected.PUT("/networth/manual/:id", saveManualAssetHandler)
+		protected.DELETE("/networth/manual/:id", deleteManualAssetHandler)
+	}
+
+	catalog := protected.Group("/admin")
+	catalog.Use(RequireRole(RoleHouseholdOwner))
+	{
+		catalog.GET("/categories", adminListCategories)
+		catalog.POST("/categories", adminCreateCategory)
+		catalog.PUT("/categories/:id", adminUpdateCategory)
+		catalog.DELETE("/categories/:id", adminDeleteCategory)
+	}
 
+	admin := protected.Group("/admin")
+	admin.Use(RequireRole(RoleAdmin))
+	{
+		admin.GET("/audit", adminGetAuditHandler)
+		admin.GET("/users", adminListUsers)
+		admin.PUT("/users/:id/role", adminSetUserRole)
+		admin.POST("/users/:id/disable", adminDisableUser)
+		admin.POST("/users/:id/enable", adminEnableUser)
+		admin.GET("/users/:id/items", adminListUserItems)
+		admin.GET("/users/:id/budget", adminGetUserBudget)
+		admin.DELETE("/users/:id/items/:item_id", adminUnlinkItem)
+	}
+
+	// The routes that proxy Plaid live under /plaid, apart from our own
+	// transactions and statements.
+	plaidRoutes := protected.Group("/plaid")
+	plaidRoutes.Use(plaidLimit, PlaidTokenMiddleware())
+	{
 		// For OAuth flows, the process looks as follows.
 		// 1. Create a link token with the redirectURI (as white listed at https://dashboard.plaid.com/team/api).
 		// 2. Once the flow succeeds, Plaid Link will redirect to redirectURI with
@@ -183,46 +502,32 @@ func main() {
 		// 3. Re-initialize with the link token (from step 1) and the full received redirect URI
 		// from step 2.
 
-		protected.POST("/api/set_access_token", getAccessToken)
-		protected.POST("/api/create_link_token_for_payment", createLinkTokenForPayment)
-		protected.GET("/api/auth", auth)
-		protected.GET("/api/accounts", accounts)
-		protected.GET("/api/balance", balance)
-		protected.GET("/api/plaid_categories", getPlaidCategories)
-		protected.GET("/api/categories", getCategories)
-		protected.GET("/api/item", item)
-		protected.POST("/api/item", item)
-		protected.GET("/api/identity", identity)
-		protected.GET("/api/transactions", transactions)
-		protected.POST("/api/transactions", transactions)
-		protected.GET("/api/payment", payment)
-		protected.GET("/api/create_public_token", createPublicToken)
-		protected.POST("/api/create_link_token", createLinkToken)
-		protected.POST("/api/create_user_token", createUserToken)
-		protected.GET("/api/investments_transactions", investmentTransactions)
-		protected.GET("/api/holdings", holdings)
-		protected.GET("/api/assets", assets)
-		protected.GET("/api/transfer_authorize", transferAuthorize)
-		protected.GET("/api/transfer_create", transferCreate)
-		protected.GET("/api/signal_evaluate", signalEvaluate)
-		protected.GET("/api/statements", statements)
-		protected.GET("/api/cra/get_base_report", getCraBaseReportHandler)
-		protected.GET("/api/cra/get_income_insights", getCraIncomeInsightsHandler)
-		protected.GET("/api/cra/get_partner_insights", getCraPartnerInsightsHandler)
-		protected.POST("/api/save_budget", saveBudgetHandler)
-		protected.GET("/api/budget", getBudgetHandler)
-		protected.GET("/api/dummy/transactions", getDummyTransactions)
+		for _, route := range plaidAPIRoutes {
+			plaidRoutes.Handle(route.method, route.path, route.handler)
+		}
 	}
 
+	registerLegacyRoutes(r, authLimit, apiLimit, plaidLimit)
+
+	// Every route must be described, so the document and the validation
+	// against it cannot fall behind the routes.
+	openAPI, err = buildAPISpec(r.Routes())
+	if err != nil {
+		fatal(err)
+	}
+	r.NoRoute(func(c *gin.Context) {
+		respondError(c, http.StatusNotFound, "Not found")
+	})
+
 	err = r.Run(":" + APP_PORT)
 	if err != nil {
 		panic("unable to start server")
 	}
 }
 
-// We store the access_token and user_token in memory - in production, store it in a secure
-// persistent data store.
-var accessToken string
+// We store the user_token in memory - in production, store it in a secure
+// persistent data store. Access tokens are encrypted in the database and only
+// decrypted per request by PlaidTokenMiddleware.
 var userToken string
 var itemID string
 
@@ -236,47 +541,117 @@ var accountID string
 
 func loginHandler(c *gin.Context) {
 
-	//var requestBody loginRequest
-	uname := c.PostForm("user")
-	passwd := c.PostForm("password")
+	var request loginRequest
+	if err := c.ShouldBind(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "Username and password are required")
+		return
+	}
+	uname, passwd := request.Username, request.Password
+
+	// Failures count per account and client address, so that guessing from
+	// one address cannot lock the owner out from everywhere else.
+	lockoutKey := "login:" + strings.ToLower(uname) + ":" + c.ClientIP()
+	wait, err := loginLockout.LockedFor(c.Request.Context(), lockoutKey)
+	if err != nil {
+		logger("auth").WarnContext(c.Request.Context(), "login lockout store unavailable, failing open", "err", err)
+	}
+	if wait > 0 {
+		recordAudit(c, repository.AuditEntry{
+			Action:   AuditLoginFailed,
+			Metadata: auditMetadata(gin.H{"username": uname, "reason": "locked_out"}),
+		})
+		tooManyAttempts(c, wait)
+		return
+	}
 
-	auth, userid, plaidToken, err := AuthenthicateUser(uname, passwd, DB)
+	auth, userid, plaidToken, err := AuthenthicateUser(c.Request.Context(), uname, passwd, repos.Users)
 	if err != nil || !auth {
 
-		if err == sql.ErrNoRows || !auth {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"message": "Invalid credentials",
+		if err == repository.ErrNotFound || !auth {
+			recordAudit(c, repository.AuditEntry{
+				SubjectID: userid,
+				Action:    AuditLoginFailed,
+				Metadata:  auditMetadata(gin.H{"username": uname, "reason": "invalid_credentials"}),
 			})
+			wait, err := loginLockout.Fail(c.Request.Context(), lockoutKey)
+			if err != nil {
+				logger("auth").WarnContext(c.Request.Context(), "could not record failed login", "err", err)
+			}
+			if wait > 0 {
+				tooManyAttempts(c, wait)
+				return
+			}
+			respondError(c, http.StatusUnauthorized, "Invalid credentials")
 			return
 		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Internal server error: Could not authenticate user",
-			})
+			respondError(c, http.StatusInternalServerError, "Internal server error: Could not authenticate user")
 		}
 
 		return
 	}
 
-	token, err := GenerateJWT(userid, DB)
+	if err := loginLockout.Reset(c.Request.Context(), lockoutKey); err != nil {
+		logger("auth").WarnContext(c.Request.Context(), "could not reset login failures", "err", err)
+	}
+
+	_, disabled, err := userAccountStatus(c.Request.Context(), userid, repos.Users)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Internal server error: Could not authenticate user")
+		return
+	}
+	if disabled {
+		recordAudit(c, repository.AuditEntry{
+			SubjectID: userid,
+			Action:    AuditLoginFailed,
+			Metadata:  auditMetadata(gin.H{"username": uname, "reason": "disabled"}),
+		})
+		respondError(c, http.StatusForbidden, "Account disabled")
+		return
+	}
+
+	_, totpEnabled, err := userTOTP(c.Request.Context(), userid, repos.Users)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Internal server error: Could not generate token",
+		respondError(c, http.StatusInternalServerError, "Internal server error: Could not authenticate user")
+		return
+	}
+
+	// With 2FA enabled the password only earns a challenge token; the session
+	// token is issued by loginTOTPHandler once the code checks out.
+	if totpEnabled {
+		challenge, err := GenerateChallengeJWT(userid)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "Internal server error: Could not generate token")
+			return
+		}
+
+		c.JSON(http.StatusOK, totpChallengeResponse{
+			Message:        "TOTP code required",
+			TOTPRequired:   true,
+			ChallengeToken: challenge,
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":    "Login successful",
-		"token":      token,
-		"plaidToken": plaidToken,
-		"user_id":    userid,
-		"username":   uname,
+	token, err := GenerateJWT(userid)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Internal server error: Could not generate token")
+		return
+	}
+
+	recordAudit(c, repository.AuditEntry{ActorID: userid, Action: AuditLoginSucceeded})
+
+	c.JSON(http.StatusOK, loginResponse{
+		Message:     "Login successful",
+		Token:       token,
+		PlaidLinked: plaidToken != "",
+		UserID:      userid,
+		Username:    uname,
 	})
 
 }
 
 func getPlaidCategories(c *gin.Context) {
-	ctx := context.Background()
+	ctx := requestContext(c)
 	categoriesResp, _, err := client.PlaidApi.CategoriesGet(ctx).Body(nil).Execute()
 
 	if err != nil {
@@ -284,47 +659,38 @@ func getPlaidCategories(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"categories": categoriesResp.GetCategories(),
+	c.JSON(http.StatusOK, plaidCategoriesResponse{
+		Categories: categoriesResp.GetCategories(),
 	})
 }
 
 func getCategories(c *gin.Context) {
-	rows, err := executeQuery(`SELECT "category_id", "category_name" FROM "Category"`, DB)
+	categories, err := repos.Categories.List(c.Request.Context())
 	if err != nil {
 		renderError(c, err)
+		return
 	}
 
 	returnCategories := []Category{}
-	for rows.Next() {
-		var category Category
-		err = rows.Scan(&category.ID, &category.Name)
-		if err != nil {
-			renderError(c, err)
-		}
-		returnCategories = append(returnCategories, category)
+	for _, category := range categories {
+		returnCategories = append(returnCategories, Category{ID: category.ID, Name: category.Name})
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"categories": returnCategories,
+	c.JSON(http.StatusOK, categoriesResponse{
+		Categories: returnCategories,
 	})
 
 }
 
-func renderError(c *gin.Context, originalErr error) {
-	if plaidError, err := plaid.ToPlaidError(originalErr); err == nil {
-		// Return 200 and allow the front end to render the error.
-		c.JSON(http.StatusOK, gin.H{"error": plaidError})
+func getAccessToken(c *gin.Context) {
+	var request publicTokenRequest
+	if err := c.ShouldBind(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "public_token is required")
 		return
 	}
-
-	c.JSON(http.StatusInternalServerError, gin.H{"error": originalErr.Error()})
-}
-
-func getAccessToken(c *gin.Context) {
-	publicToken := c.PostForm("public_token")
-	user := c.PostForm("user")
-	ctx := context.Background()
+	publicToken := request.PublicToken
+	userid := c.GetString("userid")
+	ctx := requestContext(c)
 
 	// exchange the public_token for an access_token
 	exchangePublicTokenResp, _, err := client.PlaidApi.ItemPublicTokenExchange(ctx).ItemPublicTokenExchangeRequest(
@@ -335,23 +701,24 @@ func getAccessToken(c *gin.Context) {
 		return
 	}
 
-	accessToken = exchangePublicTokenResp.GetAccessToken()
+	accessToken := exchangePublicTokenResp.GetAccessToken()
 	itemID = exchangePublicTokenResp.GetItemId()
 
-	ok, err := saveAccessToken(accessToken, user, DB)
+	ok, err := saveAccessToken(ctx, accessToken, itemID, userid, repos.PlaidItems)
 	if err != nil {
 		renderError(c, err)
 		return
 	}
 
 	if ok {
-		fmt.Println("public token: " + publicToken)
-		fmt.Println("access token: " + accessToken)
-		fmt.Println("item ID: " + itemID)
+		recordAudit(c, repository.AuditEntry{
+			Action:       AuditPlaidItemLinked,
+			ResourceType: "plaid_item",
+			ResourceID:   itemID,
+		})
 
-		c.JSON(http.StatusOK, gin.H{
-			"access_token": accessToken,
-			"item_id":      itemID,
+		c.JSON(http.StatusOK, itemIDResponse{
+			ItemID: itemID,
 		})
 	}
 
@@ -365,7 +732,7 @@ func getAccessToken(c *gin.Context) {
 // - https://plaid.com/docs/payment-initiation/
 // - https://plaid.com/docs/#payment-initiation-create-link-token-request
 func createLinkTokenForPayment(c *gin.Context) {
-	ctx := context.Background()
+	ctx := requestContext(c)
 
 	// Create payment recipient
 	paymentRecipientRequest := plaid.NewPaymentInitiationRecipientCreateRequest("Harry Potter")
@@ -397,23 +764,24 @@ func createLinkTokenForPayment(c *gin.Context) {
 	// We store the payment_id in memory for demo purposes - in production, store it in a secure
 	// persistent data store along with the Payment metadata, such as userId.
 	paymentID = paymentCreateResp.GetPaymentId()
-	fmt.Println("payment id: " + paymentID)
+	logger("plaid").InfoContext(ctx, "payment created", "payment_id", paymentID)
 
 	// Create the link_token
 	linkTokenCreateReqPaymentInitiation := plaid.NewLinkTokenCreateRequestPaymentInitiation()
 	linkTokenCreateReqPaymentInitiation.SetPaymentId(paymentID)
-	linkToken, err := linkTokenCreate(linkTokenCreateReqPaymentInitiation)
+	linkToken, err := linkTokenCreate(ctx, linkTokenCreateReqPaymentInitiation)
 	if err != nil {
 		renderError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"link_token": linkToken,
+	c.JSON(http.StatusOK, linkTokenResponse{
+		LinkToken: linkToken,
 	})
 }
 
 func auth(c *gin.Context) {
-	ctx := context.Background()
+	ctx := requestContext(c)
+	accessToken := c.GetString("plaidAccessToken")
 
 	authGetResp, _, err := client.PlaidApi.AuthGet(ctx).AuthGetRequest(
 		*plaid.NewAuthGetRequest(accessToken),
@@ -424,14 +792,15 @@ func auth(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"accounts": authGetResp.GetAccounts(),
-		"numbers":  authGetResp.GetNumbers(),
+	c.JSON(http.StatusOK, authResponse{
+		Accounts: authGetResp.GetAccounts(),
+		Numbers:  authGetResp.GetNumbers(),
 	})
 }
 
 func accounts(c *gin.Context) {
-	ctx := context.Background()
+	ctx := requestContext(c)
+	accessToken := c.GetString("plaidAccessToken")
 
 	accountsGetResp, _, err := client.PlaidApi.AccountsGet(ctx).AccountsGetRequest(
 		*plaid.NewAccountsGetRequest(accessToken),
@@ -442,13 +811,16 @@ func accounts(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"accounts": accountsGetResp.GetAccounts(),
+	storeLiveBalances(c.Request.Context(), c.GetString("userid"), accessToken, accountsGetResp.GetAccounts())
+
+	c.JSON(http.StatusOK, accountsResponse{
+		Accounts: accountsGetResp.GetAccounts(),
 	})
 }
 
 func balance(c *gin.Context) {
-	ctx := context.Background()
+	ctx := requestContext(c)
+	accessToken := c.GetString("plaidAccessToken")
 
 	balancesGetResp, _, err := client.PlaidApi.AccountsBalanceGet(ctx).AccountsBalanceGetRequest(
 		*plaid.NewAccountsBalanceGetRequest(accessToken),
@@ -459,13 +831,16 @@ func balance(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"accounts": balancesGetResp.GetAccounts(),
+	storeLiveBalances(c.Request.Context(), c.GetString("userid"), accessToken, balancesGetResp.GetAccounts())
+
+	c.JSON(http.StatusOK, accountsResponse{
+		Accounts: balancesGetResp.GetAccounts(),
 	})
 }
 
 func item(c *gin.Context) {
-	ctx := context.Background()
+	ctx := requestContext(c)
+	accessToken := c.GetString("plaidAccessToken")
 
 	itemGetResp, _, err := client.PlaidApi.ItemGet(ctx).ItemGetRequest(
 		*plaid.NewItemGetRequest(accessToken),
@@ -488,14 +863,15 @@ func item(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"item":        itemGetResp.GetItem(),
-		"institution": institutionGetByIdResp.GetInstitution(),
+	c.JSON(http.StatusOK, itemResponse{
+		Item:        itemGetResp.GetItem(),
+		Institution: institutionGetByIdResp.GetInstitution(),
 	})
 }
 
 func identity(c *gin.Context) {
-	ctx := context.Background()
+	ctx := requestContext(c)
+	accessToken := c.GetString("plaidAccessToken")
 
 	identityGetResp, _, err := client.PlaidApi.IdentityGet(ctx).IdentityGetRequest(
 		*plaid.NewIdentityGetRequest(accessToken),
@@ -505,16 +881,24 @@ func identity(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"identity": identityGetResp.GetAccounts(),
+	c.JSON(http.StatusOK, identityResponse{
+		Identity: identityGetResp.GetAccounts(),
 	})
 }
 
 func transactions(c *gin.Context) {
-	ctx := context.Background()
+	ctx := requestContext(c)
+	accessToken := c.GetString("plaidAccessToken")
+	userid := c.GetString("userid")
 
-	// Set cursor to empty to receive all historical updates
-	var cursor *string
+	// Resume from where the item's last sync ended. Only an item never
+	// synced starts from the empty cursor, which returns its whole history.
+	item, err := currentPlaidItem(ctx, userid, accessToken)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load item")
+		return
+	}
+	cursor := item.SyncCursor
 
 	// New transaction updates since "cursor"
 	var added []plaid.Transaction
@@ -524,29 +908,33 @@ func transactions(c *gin.Context) {
 	// Iterate through each page of new transaction updates for item
 	for hasMore {
 		request := plaid.NewTransactionsSyncRequest(accessToken)
-		if cursor != nil {
-			request.SetCursor(*cursor)
+		if cursor != "" {
+			request.SetCursor(cursor)
 		}
 		resp, _, err := client.PlaidApi.TransactionsSync(
 			ctx,
 		).TransactionsSyncRequest(*request).Execute()
 		if err != nil {
+			recordItemLogin(ctx, item.ID, err)
+			checkAlerts(ctx, userid)
+			publishLive(userid, newWebhookEvent(eventSyncProgress, syncProgressData{Stage: syncFailed}))
 			renderError(c, err)
 			return
 		}
 
 		// Update cursor to the next cursor
-		nextCursor := resp.GetNextCursor()
-		cursor = &nextCursor
+		cursor = resp.GetNextCursor()
 
 		// If no transactions are available yet, wait and poll the endpoint.
-		// Normally, we would listen for a webhook, but the Quickstart doesn't
-		// support webhooks. For a webhook example, see
-		// https://github.com/plaid/tutorial-resources or
-		// https://github.com/plaid/pattern
-
-		if *cursor == "" {
-			time.Sleep(2 * time.Second)
+		// Dashboards following /api/v1/events see the wait as sync progress;
+		// it ends early if the caller goes away.
+		if cursor == "" {
+			publishLive(userid, newWebhookEvent(eventSyncProgress, syncProgressData{Stage: syncWaiting}))
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case <-time.After(syncPollInterval):
+			}
 			continue
 		}
 
@@ -555,22 +943,38 @@ func transactions(c *gin.Context) {
 		modified = append(modified, resp.GetModified()...)
 		removed = append(removed, resp.GetRemoved()...)
 		hasMore = resp.GetHasMore()
+		publishLive(userid, newWebhookEvent(eventSyncProgress, syncProgressData{
+			Stage: syncFetching, Added: len(added), Modified: len(modified), Removed: len(removed),
+		}))
+	}
+
+	// Keep everything synced so /api/v1/transactions can search past the
+	// handful returned here.
+	if err := storeSyncedTransactions(ctx, userid, accessToken, cursor, added, modified, removed); err != nil {
+		publishLive(userid, newWebhookEvent(eventSyncProgress, syncProgressData{Stage: syncFailed}))
+		renderError(c, err)
+		return
 	}
+	publishLive(userid, newWebhookEvent(eventSyncProgress, syncProgressData{
+		Stage: syncDone, Added: len(added), Modified: len(modified), Removed: len(removed),
+	}))
+	recordItemLogin(ctx, item.ID, nil)
+	checkAlerts(ctx, userid)
 
 	sort.Slice(added, func(i, j int) bool {
 		return added[i].GetDate() < added[j].GetDate()
 	})
-	latestTransactions := added[len(added)-9:]
+	latestTransactions := added[max(len(added)-9, 0):]
 
-	c.JSON(http.StatusOK, gin.H{
-		"latest_transactions": latestTransactions,
+	c.JSON(http.StatusOK, latestTransactionsResponse{
+		LatestTransactions: latestTransactions,
 	})
 }
 
 // This functionality is only relevant for the UK Payment Initiation product.
 // Retrieve Payment for a specified Payment ID
 func payment(c *gin.Context) {
-	ctx := context.Background()
+	ctx := requestContext(c)
 
 	paymentGetResp, _, err := client.PlaidApi.PaymentInitiationPaymentGet(ctx).PaymentInitiationPaymentGetRequest(
 		*plaid.NewPaymentInitiationPaymentGetRequest(paymentID),
@@ -581,8 +985,8 @@ func payment(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"payment": paymentGetResp,
+	c.JSON(http.StatusOK, paymentResponse{
+		Payment: paymentGetResp,
 	})
 }
 
@@ -590,7 +994,8 @@ func payment(c *gin.Context) {
 // Create Transfer for a specified Authorization ID
 
 func transferAuthorize(c *gin.Context) {
-	ctx := context.Background()
+	ctx := requestContext(c)
+	accessToken := c.GetString("plaidAccessToken")
 	accountsGetResp, _, err := client.PlaidApi.AccountsGet(ctx).AccountsGetRequest(
 		*plaid.NewAccountsGetRequest(accessToken),
 	).Execute()
@@ -628,7 +1033,8 @@ func transferAuthorize(c *gin.Context) {
 }
 
 func transferCreate(c *gin.Context) {
-	ctx := context.Background()
+	ctx := requestContext(c)
+	accessToken := c.GetString("plaidAccessToken")
 
 	transferCreateRequest := plaid.NewTransferCreateRequest(
 		accessToken,
@@ -648,7 +1054,8 @@ func transferCreate(c *gin.Context) {
 }
 
 func signalEvaluate(c *gin.Context) {
-	ctx := context.Background()
+	ctx := requestContext(c)
+	accessToken := c.GetString("plaidAccessToken")
 	accountsGetResp, _, err := client.PlaidApi.AccountsGet(ctx).AccountsGetRequest(
 		*plaid.NewAccountsGetRequest(accessToken),
 	).Execute()
@@ -677,10 +1084,17 @@ func signalEvaluate(c *gin.Context) {
 }
 
 func investmentTransactions(c *gin.Context) {
-	ctx := context.Background()
-
-	endDate := time.Now().Local().Format("2006-01-02")
-	startDate := time.Now().Local().Add(-30 * 24 * time.Hour).Format("2006-01-02")
+	ctx := requestContext(c)
+	accessToken := c.GetString("plaidAccessToken")
+
+	endDate := c.DefaultQuery("end_date", time.Now().Local().Format("2006-01-02"))
+	startDate := c.DefaultQuery("start_date", time.Now().Local().Add(-30*24*time.Hour).Format("2006-01-02"))
+	for _, date := range []string{startDate, endDate} {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			respondError(c, http.StatusBadRequest, "start_date and end_date must be YYYY-MM-DD")
+			return
+		}
+	}
 
 	request := plaid.NewInvestmentsTransactionsGetRequest(accessToken, startDate, endDate)
 	invTxResp, _, err := client.PlaidApi.InvestmentsTransactionsGet(ctx).InvestmentsTransactionsGetRequest(*request).Execute()
@@ -689,14 +1103,18 @@ func investmentTransactions(c *gin.Context) {
 		renderError(c, err)
 		return
 	}
+	storeLiveInvestments(ctx, c.GetString("userid"), accessToken, func(item repository.PlaidItem) error {
+		return storeInvestmentTransactions(ctx, item, invTxResp)
+	})
 
-	c.JSON(http.StatusOK, gin.H{
-		"investments_transactions": invTxResp,
+	c.JSON(http.StatusOK, investmentTransactionsResponse{
+		InvestmentsTransactions: invTxResp,
 	})
 }
 
 func holdings(c *gin.Context) {
-	ctx := context.Background()
+	ctx := requestContext(c)
+	accessToken := c.GetString("plaidAccessToken")
 
 	holdingsGetResp, _, err := client.PlaidApi.InvestmentsHoldingsGet(ctx).InvestmentsHoldingsGetRequest(
 		*plaid.NewInvestmentsHoldingsGetRequest(accessToken),
@@ -705,22 +1123,25 @@ func holdings(c *gin.Context) {
 		renderError(c, err)
 		return
 	}
+	storeLiveInvestments(ctx, c.GetString("userid"), accessToken, func(item repository.PlaidItem) error {
+		return storeHoldings(ctx, item, holdingsGetResp, time.Now().UTC())
+	})
 
-	c.JSON(http.StatusOK, gin.H{
-		"holdings": holdingsGetResp,
+	c.JSON(http.StatusOK, holdingsResponse{
+		Holdings: holdingsGetResp,
 	})
 }
 
 func info(context *gin.Context) {
-	context.JSON(http.StatusOK, map[string]interface{}{
-		"item_id":      itemID,
-		"access_token": accessToken,
-		"products":     strings.Split(PLAID_PRODUCTS, ","),
+	context.JSON(http.StatusOK, infoResponse{
+		ItemID:   itemID,
+		Products: strings.Split(PLAID_PRODUCTS, ","),
 	})
 }
 
 func createPublicToken(c *gin.Context) {
-	ctx := context.Background()
+	ctx := requestContext(c)
+	accessToken := c.GetString("plaidAccessToken")
 
 	// Create a one-time use public_token for the Item.
 	// This public_token can be used to initialize Link in update mode for a user
@@ -733,27 +1154,27 @@ func createPublicToken(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"public_token": publicTokenCreateResp.GetPublicToken(),
+	c.JSON(http.StatusOK, publicTokenResponse{
+		PublicToken: publicTokenCreateResp.GetPublicToken(),
 	})
 }
 
 func createLinkToken(c *gin.Context) {
-	linkToken, err := linkTokenCreate(nil)
+	linkToken, err := linkTokenCreate(requestContext(c), nil)
 	if err != nil {
 		renderError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"link_token": linkToken})
+	c.JSON(http.StatusOK, linkTokenResponse{LinkToken: linkToken})
 }
 
 func createUserToken(c *gin.Context) {
-	userToken, err := userTokenCreate()
+	userToken, err := userTokenCreate(requestContext(c))
 	if err != nil {
 		renderError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"user_token": userToken})
+	c.JSON(http.StatusOK, userTokenResponse{UserToken: userToken})
 }
 
 func convertCountryCodes(countryCodeStrs []string) []plaid.CountryCode {
@@ -787,10 +1208,9 @@ func containsProduct(products []plaid.Products, product plaid.Products) bool {
 
 // linkTokenCreate creates a link token using the specified parameters
 func linkTokenCreate(
+	ctx context.Context,
 	paymentInitiation *plaid.LinkTokenCreateRequestPaymentInitiation,
 ) (string, error) {
-	ctx := context.Background()
-
 	// Institutions from all listed countries will be shown.
 	countryCodes := convertCountryCodes(strings.Split(PLAID_COUNTRY_CODES, ","))
 	redirectURI := PLAID_REDIRECT_URI
@@ -849,9 +1269,7 @@ func linkTokenCreate(
 
 // Create a user token which can be used for Plaid Check, Income, or Multi-Item link flows
 // https://plaid.com/docs/api/users/#usercreate
-func userTokenCreate() (string, error) {
-	ctx := context.Background()
-
+func userTokenCreate(ctx context.Context) (string, error) {
 	request := plaid.NewUserCreateRequest(
 		// Typically this will be a user ID number from your application.
 		time.Now().String(),
@@ -895,7 +1313,8 @@ func userTokenCreate() (string, error) {
 }
 
 func statements(c *gin.Context) {
-	ctx := context.Background()
+	ctx := requestContext(c)
+	accessToken := c.GetString("plaidAccessToken")
 	statementsListResp, _, err := client.PlaidApi.StatementsList(ctx).StatementsListRequest(
 		*plaid.NewStatementsListRequest(accessToken),
 	).Execute()
@@ -919,14 +1338,15 @@ func statements(c *gin.Context) {
 	// convert pdf to base64
 	encodedPdf := base64.StdEncoding.EncodeToString(content)
 
-	c.JSON(http.StatusOK, gin.H{
-		"json": statementsListResp,
-		"pdf":  encodedPdf,
+	c.JSON(http.StatusOK, plaidStatementsResponse{
+		JSON: statementsListResp,
+		PDF:  encodedPdf,
 	})
 }
 
 func assets(c *gin.Context) {
-	ctx := context.Background()
+	ctx := requestContext(c)
+	accessToken := c.GetString("plaidAccessToken")
 
 	createRequest := plaid.NewAssetReportCreateRequest(10)
 	createRequest.SetAccessTokens([]string{accessToken})
@@ -967,9 +1387,9 @@ func assets(c *gin.Context) {
 	// convert pdf to base64
 	encodedPdf := base64.StdEncoding.EncodeToString(content)
 
-	c.JSON(http.StatusOK, gin.H{
-		"json": assetReportGetResp.GetReport(),
-		"pdf":  encodedPdf,
+	c.JSON(http.StatusOK, assetsResponse{
+		JSON: assetReportGetResp.GetReport(),
+		PDF:  encodedPdf,
 	})
 }
 
@@ -986,7 +1406,7 @@ func pollForAssetReport(ctx context.Context, client *plaid.APIClient, assetRepor
 // Base report: https://plaid.com/docs/check/api/#cracheck_reportbase_reportget
 // PDF: https://plaid.com/docs/check/api/#cracheck_reportpdfget
 func getCraBaseReportHandler(c *gin.Context) {
-	ctx := context.Background()
+	ctx := requestContext(c)
 	getResponse, err := getCraBaseReportWithRetries(ctx, userToken)
 	if err != nil {
 		renderError(c, err)
@@ -1011,9 +1431,9 @@ func getCraBaseReportHandler(c *gin.Context) {
 	// convert pdf to base64
 	encodedPdf := base64.StdEncoding.EncodeToString(content)
 
-	c.JSON(http.StatusOK, gin.H{
-		"report": getResponse.Report,
-		"pdf":    encodedPdf,
+	c.JSON(http.StatusOK, craBaseReportResponse{
+		Report: getResponse.Report,
+		PDF:    encodedPdf,
 	})
 }
 
@@ -1030,7 +1450,7 @@ func getCraBaseReportWithRetries(ctx context.Context, userToken string) (*plaid.
 // Income insights: https://plaid.com/docs/check/api/#cracheck_reportincome_insightsget
 // PDF w/ income insights: https://plaid.com/docs/check/api/#cracheck_reportpdfget
 func getCraIncomeInsightsHandler(c *gin.Context) {
-	ctx := context.Background()
+	ctx := requestContext(c)
 	getResponse, err := getCraIncomeInsightsWithRetries(ctx, userToken)
 	if err != nil {
 		renderError(c, err)
@@ -1056,9 +1476,9 @@ func getCraIncomeInsightsHandler(c *gin.Context) {
 	// convert pdf to base64
 	encodedPdf := base64.StdEncoding.EncodeToString(content)
 
-	c.JSON(http.StatusOK, gin.H{
-		"report": getResponse.Report,
-		"pdf":    encodedPdf,
+	c.JSON(http.StatusOK, craIncomeInsightsResponse{
+		Report: getResponse.Report,
+		PDF:    encodedPdf,
 	})
 }
 
@@ -1074,15 +1494,15 @@ func getCraIncomeInsightsWithRetries(ctx context.Context, userToken string) (*pl
 // Retrieve CRA Partner Insights
 // https://plaid.com/docs/check/api/#cracheck_reportpartner_insightsget
 func getCraPartnerInsightsHandler(c *gin.Context) {
-	ctx := context.Background()
+	ctx := requestContext(c)
 	getResponse, err := getCraPartnerInsightsWithRetries(ctx, userToken)
 	if err != nil {
 		renderError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"report": getResponse.Report,
+	c.JSON(http.StatusOK, craPartnerInsightsResponse{
+		Report: getResponse.Report,
 	})
 }
 
@@ -1117,115 +1537,65 @@ func pollWithRetries[T any](requestCallback func() (T, error), ms int, retriesLe
 	return response, nil
 }
 
+// saveBudgetHandler replaces the caller's budget with the one in the request
+// body. Rows with an id are updated, rows without one are created, and rows
+// missing from the request are deleted.
 func saveBudgetHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	userid := c.GetString("userid")
 
-}
-
-func getBudgetHandler(c *gin.Context) {
-
-	user_id := c.Query("user_id")
-	if user_id == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "User ID is required",
-		})
+	var request getBudgetResponse
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid budget: "+err.Error())
 		return
 	}
-
-	income_query := fmt.Sprintf(`SELECT "income_id", "income_amount", "income_frequency" FROM "Income" WHERE "user_id" = '%s'`, user_id)
-
-	income_row, err := DB.Query(income_query)
-	if err != nil {
-
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{
-				"message": "No income found for the given user ID",
-			})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Internal server error: Could not execute income query",
-			})
-
+	for _, allocation := range request.Allocations {
+		if allocation.AllocationFactor.Sign() < 0 || allocation.AllocationFactor.GreaterThan(money.One) {
+			respondError(c, http.StatusBadRequest, "Allocation factors must be between 0 and 1")
+			return
 		}
-
-		return
 	}
 
-	expenses_query := fmt.Sprintf(`SELECT "expense_id", "expense_description", "expense_amount", "expense_category", "allocation_type" FROM "Expenses" WHERE "user_id" = '%s'`, user_id)
-
-	expenses_row, err := DB.Query(expenses_query)
+	previous, err := repos.Budgets.Load(ctx, userid)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Internal server error: Could not execute expenses query",
-		})
+		respondError(c, http.StatusInternalServerError, "Internal server error: Could not load budget")
 		return
 	}
 
-	allocations_query := fmt.Sprintf(`SELECT "allocation_type", "allocation_description", "allocation_factor" FROM "Allocations" WHERE "user_id" = '%s'`, user_id)
-
-	allocations_row, err := DB.Query(allocations_query)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Internal server error: Could not execute allocations query",
-		})
+	if err := repos.Budgets.Save(ctx, userid, request); err != nil {
+		respondError(c, http.StatusInternalServerError, "Internal server error: Could not save budget")
 		return
 	}
 
-	defer expenses_row.Close()
-	defer income_row.Close()
-	defer allocations_row.Close()
-
-	incomes := []Income{}
-	expenses := []Expense{}
-	allocations := []Allocation{}
-
-	for income_row.Next() {
-		var income Income
-		err = income_row.Scan(&income.Id, &income.Amount, &income.Frequency)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Internal server error: Could not scan income row",
-			})
-			return
-		}
-		incomes = append(incomes, income)
-
+	saved, err := repos.Budgets.Load(ctx, userid)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Internal server error: Could not load budget")
+		return
 	}
 
-	for expenses_row.Next() {
-		var expense Expense
-		err = expenses_row.Scan(&expense.Id, &expense.Description, &expense.Amount, &expense.Category, &expense.AllocationType)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Internal server error: Could not scan expenses row",
-			})
-			return
-		}
-		expenses = append(expenses, expense)
-
-	}
+	publishEvent(ctx, userid, newWebhookEvent(eventBudgetSaved, saved))
 
-	for allocations_row.Next() {
-		var allocation Allocation
-		err = allocations_row.Scan(&allocation.AllocationType, &allocation.AllocationDescription, &allocation.AllocationFactor)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Internal server error: Could not scan allocations row",
-			})
-			return
-		}
-		allocations = append(allocations, allocation)
+	before, after := auditDiff(previous, saved)
+	recordAudit(c, repository.AuditEntry{
+		Action:       AuditBudgetSaved,
+		ResourceType: "budget",
+		ResourceID:   userid,
+		Before:       before,
+		After:        after,
+	})
 
-	}
+	c.JSON(http.StatusOK, budgetResponse{Budget: saved})
+}
 
-	getBudgetResponse := getBudgetResponse{
-		Incomes:     incomes,
-		Allocations: allocations,
-		Expenses:    expenses,
+// getBudgetHandler returns the caller's budget.
+func getBudgetHandler(c *gin.Context) {
+	budget, err := repos.Budgets.Load(c.Request.Context(), c.GetString("userid"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Internal server error: Could not load budget")
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"budget": getBudgetResponse,
-	})
+	c.JSON(http.StatusOK, budgetResponse{Budget: budget})
 
 }
 
@@ -1234,18 +1604,18 @@ func getDummyTransactions(c *gin.Context) {
 	// Open the JSON file
 	file, err := os.Open("data/test_transactions(first period payment).json")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open JSON file"})
+		respondError(c, http.StatusInternalServerError, "Failed to open JSON file")
 		return
 	}
 	defer file.Close()
 
 	var response getDummyTransactionsResponse
 	if err := json.NewDecoder(file).Decode(&response); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse JSON file"})
+		respondError(c, http.StatusInternalServerError, "Failed to parse JSON file")
 		return
 	}
 
 	// Send the parsed data as a JSON respons	e
-	c.JSON(http.StatusOK, gin.H{"latest_transactions": response.LatestTransactions})
+	c.JSON(http.StatusOK, response)
 
 }
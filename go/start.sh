@@ -1,3 +1,3 @@
 #!/usr/bin/env bash
 
-go run server.go
\ No newline at end of file
+go run .
\ No newline at end of file
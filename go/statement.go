@@ -0,0 +1,688 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jung-kurt/gofpdf"
+	"github.com/plaid/quickstart/money"
+	"github.com/plaid/quickstart/repository"
+)
+
+const (
+	statementPeriodLayout = "2006-01"
+	statementTopMerchants = 10
+	// statementMailInterval is how often closed periods are checked for
+	// statements still to be emailed.
+	statementMailInterval = time.Hour
+)
+
+// statement is everything a statement PDF shows for one user and date range.
+type statement struct {
+	Username    string
+	From        time.Time
+	To          time.Time
+	GeneratedAt time.Time
+	// Currency is the reporting currency every amount is converted to.
+	// MissingRates lists the currencies left unconverted for want of a rate.
+	Currency       string
+	MissingRates   []string
+	Incomes        []Income
+	IncomeReceived money.Decimal
+	Spent          money.Decimal
+	Buckets        []statementBucket
+	Merchants      []repository.MerchantTotal
+	Goals          []repository.Goal
+	// Notes are the user's notes for each month of the range, by period.
+	Notes map[string]string
+}
+
+// statementBucket is an allocation with the expenses it holds. Target is
+// the allocation's share of planned income.
+type statementBucket struct {
+	Name    string
+	Factor  money.Decimal
+	Target  money.Decimal
+	Planned money.Decimal
+	Actual  money.Decimal
+	Lines   []statementLine
+}
+
+type statementLine struct {
+	Description string
+	Category    string
+	Planned     money.Decimal
+	Actual      money.Decimal
+	// Unassigned marks the line of spending assigned to no expense.
+	Unassigned bool
+}
+
+// statementPeriods lists the YYYY-MM periods the range touches.
+func statementPeriods(from time.Time, to time.Time) []string {
+	periods := []string{}
+	for month := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC); !month.After(to); month = month.AddDate(0, 1, 0) {
+		periods = append(periods, month.Format(statementPeriodLayout))
+	}
+	return periods
+}
+
+// periodRange returns the first and last day of a YYYY-MM period.
+func periodRange(period string) (time.Time, time.Time, error) {
+	from, err := time.Parse(statementPeriodLayout, period)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return from, from.AddDate(0, 1, -1), nil
+}
+
+// buildStatement gathers the statement of the user's budget and transactions
+// within [from, to]. Expense plans are monthly and are scaled by the number
+// of months the range touches, as in the budget-vs-actual export.
+func buildStatement(ctx context.Context, userid string, from time.Time, to time.Time) (statement, error) {
+	s := statement{From: from, To: to, GeneratedAt: time.Now().UTC(), Notes: map[string]string{}}
+
+	user, err := repos.Users.ByID(ctx, userid)
+	if err != nil {
+		return s, err
+	}
+	s.Username = user.Username
+
+	budget, lookups, err := loadBudgetLookups(ctx, userid)
+	if err != nil {
+		return s, err
+	}
+	s.Incomes = budget.Incomes
+
+	fx, err := newFXConverter(ctx, userid, from, to)
+	if err != nil {
+		return s, err
+	}
+	s.Currency = fx.Currency
+	expenseTotals, err := repos.Transactions.ExpenseTotals(ctx, userid, from, to)
+	if err != nil {
+		return s, err
+	}
+	totals := fx.ExpenseTotals(expenseTotals)
+	for _, total := range totals {
+		s.Spent = s.Spent.Add(total)
+	}
+	inflows, err := repos.Transactions.Inflows(ctx, userid, from, to)
+	if err != nil {
+		return s, err
+	}
+	s.IncomeReceived = fx.ConvertTotals(inflows)
+	merchants, err := repos.Transactions.MerchantTotals(ctx, userid, from, to)
+	if err != nil {
+		return s, err
+	}
+	s.Merchants = fx.MerchantTotals(merchants, statementTopMerchants)
+	contributions, err := investmentContributions(ctx, userid, from, to, fx)
+	if err != nil {
+		return s, err
+	}
+	if s.Goals, err = repos.Goals.List(ctx, userid); err != nil {
+		return s, err
+	}
+	for _, period := range statementPeriods(from, to) {
+		note, err := repos.Statements.Note(ctx, userid, period)
+		if err != nil {
+			return s, err
+		}
+		if note != "" {
+			s.Notes[period] = note
+		}
+	}
+
+	// Buckets follow the budget's allocation order; expenses without an
+	// allocation, and spending assigned to no expense, come last.
+	buckets := map[string]*statementBucket{}
+	order := []string{}
+	bucket := func(name string) *statementBucket {
+		if _, ok := buckets[name]; !ok {
+			buckets[name] = &statementBucket{Name: name}
+			order = append(order, name)
+		}
+		return buckets[name]
+	}
+	months := monthsBetween(from, to)
+	targets := allocationTargets(budget, months, fx.Currency)
+	for i, allocation := range budget.Allocations {
+		b := bucket(allocation.AllocationDescription)
+		b.Factor = allocation.AllocationFactor
+		b.Target = b.Target.Add(targets[i].Amount)
+	}
+	for _, expense := range budget.Expenses {
+		b := bucket(lookups.bucket(expense))
+		line := statementLine{
+			Description: expense.Description,
+			Category:    lookups.categories[expense.Category],
+			Planned:     expense.Amount.MulInt(int64(months)),
+			Actual:      totals[expense.Id],
+		}
+		b.Lines = append(b.Lines, line)
+		b.Planned = b.Planned.Add(line.Planned)
+		b.Actual = b.Actual.Add(line.Actual)
+	}
+	if !contributions.IsZero() {
+		b := bucket(lookups.savingsBucket(budget))
+		b.Lines = append(b.Lines, statementLine{Description: investmentContributionsLine, Actual: contributions})
+		b.Actual = b.Actual.Add(contributions)
+	}
+	if unassigned, ok := totals[uuid.Nil]; ok {
+		b := bucket(exportUnassigned)
+		b.Lines = append(b.Lines, statementLine{Description: "Uncategorized spending", Actual: unassigned, Unassigned: true})
+		b.Actual = b.Actual.Add(unassigned)
+	}
+	for _, name := range order {
+		s.Buckets = append(s.Buckets, *buckets[name])
+	}
+	s.MissingRates = fx.Missing()
+
+	return s, nil
+}
+
+// Title returns "January 2026" for a whole month, and the dates otherwise.
+func (s statement) Title() string {
+	if s.From.Day() == 1 && s.To.Equal(s.From.AddDate(0, 1, -1)) {
+		return s.From.Format("January 2006")
+	}
+	return s.From.Format("Jan 2, 2006") + " – " + s.To.Format("Jan 2, 2006")
+}
+
+// Filename is the download name of the statement PDF.
+func (s statement) Filename() string {
+	return fmt.Sprintf("statement-%s-%s-%s.pdf", s.Username, s.From.Format("20060102"), s.To.Format("20060102"))
+}
+
+// Observations are the notes the statement adds on its own: overspent
+// expenses and spending that no expense accounts for.
+func (s statement) Observations() []string {
+	observations := []string{}
+	for _, b := range s.Buckets {
+		for _, line := range b.Lines {
+			if line.Planned.Sign() > 0 && line.Actual.GreaterThan(line.Planned) {
+				observations = append(observations, fmt.Sprintf("%s went over plan by %s (%s of %s).",
+					line.Description, formatAmount(line.Actual.Sub(line.Planned)), formatAmount(line.Actual), formatAmount(line.Planned)))
+			}
+			if line.Unassigned && line.Actual.Sign() > 0 {
+				observations = append(observations, fmt.Sprintf("%s of spending is not assigned to a budget expense.", formatAmount(line.Actual)))
+			}
+		}
+	}
+	if len(s.MissingRates) > 0 {
+		observations = append(observations, fmt.Sprintf("No exchange rate was known for %s; those amounts are counted unconverted.", strings.Join(s.MissingRates, ", ")))
+	}
+	if net := s.IncomeReceived.Sub(s.Spent); net.Sign() < 0 {
+		observations = append(observations, fmt.Sprintf("Spending exceeded income received by %s.", formatAmount(net.Neg())))
+	}
+	return observations
+}
+
+// formatAmount formats money with thousands separators and two decimals.
+// The statement names its currency once, so no symbol is added.
+func formatAmount(v money.Decimal) string {
+	sign := ""
+	if v.Sign() < 0 {
+		sign, v = "-", v.Neg()
+	}
+	whole, cents, _ := strings.Cut(v.StringFixed(2), ".")
+	var grouped strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteByte(',')
+		}
+		grouped.WriteRune(digit)
+	}
+	return sign + grouped.String() + "." + cents
+}
+
+// Colors of the statement PDF.
+var (
+	statementInk     = [3]int{33, 37, 41}
+	statementMuted   = [3]int{108, 117, 125}
+	statementRule    = [3]int{222, 226, 230}
+	statementPlanned = [3]int{206, 212, 218}
+	statementActual  = [3]int{13, 110, 253}
+	statementOver    = [3]int{220, 53, 69}
+	statementGoal    = [3]int{25, 135, 84}
+)
+
+// statementPDF lays out a statement on A4 pages with the core fonts, which
+// need text in cp1252.
+type statementPDF struct {
+	pdf   *gofpdf.Fpdf
+	tr    func(string) string
+	width float64
+}
+
+func newStatementPDF() *statementPDF {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(15, 15, 15)
+	pdf.SetAutoPageBreak(true, 15)
+	pageWidth, _ := pdf.GetPageSize()
+	return &statementPDF{pdf: pdf, tr: pdf.UnicodeTranslatorFromDescriptor(""), width: pageWidth - 30}
+}
+
+func (p *statementPDF) color(rgb [3]int) {
+	p.pdf.SetTextColor(rgb[0], rgb[1], rgb[2])
+}
+
+// ensureSpace starts a new page unless h millimetres fit on this one.
+func (p *statementPDF) ensureSpace(h float64) {
+	_, pageHeight := p.pdf.GetPageSize()
+	_, _, _, bottom := p.pdf.GetMargins()
+	if p.pdf.GetY()+h > pageHeight-bottom {
+		p.pdf.AddPage()
+	}
+}
+
+func (p *statementPDF) heading(text string) {
+	p.ensureSpace(20)
+	p.pdf.Ln(4)
+	p.pdf.SetFont("Helvetica", "B", 12)
+	p.color(statementInk)
+	p.pdf.CellFormat(p.width, 7, p.tr(text), "", 1, "L", false, 0, "")
+	p.pdf.SetDrawColor(statementRule[0], statementRule[1], statementRule[2])
+	p.pdf.Line(15, p.pdf.GetY(), 15+p.width, p.pdf.GetY())
+	p.pdf.Ln(2)
+}
+
+// row writes one line of cells, shortening any that overflow. widths are in
+// mm and aligns holds an L, C or R per column.
+func (p *statementPDF) row(bold bool, widths []float64, aligns string, cells ...string) {
+	style := ""
+	if bold {
+		style = "B"
+	}
+	p.pdf.SetFont("Helvetica", style, 9)
+	p.color(statementInk)
+	for i, cell := range cells {
+		p.pdf.CellFormat(widths[i], 6, p.fit(p.tr(cell), widths[i]-2), "", 0, string(aligns[i]), false, 0, "")
+	}
+	p.pdf.Ln(-1)
+}
+
+// fit shortens text with an ellipsis until it is at most w mm wide.
+func (p *statementPDF) fit(text string, w float64) string {
+	if p.pdf.GetStringWidth(text) <= w {
+		return text
+	}
+	for len(text) > 0 && p.pdf.GetStringWidth(text+"...") > w {
+		text = text[:len(text)-1]
+	}
+	return text + "..."
+}
+
+// bar draws a bar chart row at the right of the current line: planned as a
+// grey track and actual over it, red where it exceeds the plan.
+func (p *statementPDF) bar(x float64, w float64, scale float64, planned float64, actual float64) {
+	if scale <= 0 {
+		return
+	}
+	y := p.pdf.GetY() - 4.5
+	if planned > 0 {
+		p.pdf.SetFillColor(statementPlanned[0], statementPlanned[1], statementPlanned[2])
+		p.pdf.Rect(x, y, w*math.Min(planned/scale, 1), 3, "F")
+	}
+	if actual > 0 {
+		fill := statementActual
+		if planned > 0 && actual > planned {
+			fill = statementOver
+		}
+		p.pdf.SetFillColor(fill[0], fill[1], fill[2])
+		p.pdf.Rect(x, y+0.75, w*math.Min(actual/scale, 1), 1.5, "F")
+	}
+}
+
+func (p *statementPDF) text(size float64, rgb [3]int, text string) {
+	p.pdf.SetFont("Helvetica", "", size)
+	p.color(rgb)
+	p.pdf.MultiCell(p.width, 5, p.tr(text), "", "L", false)
+}
+
+// writeStatementPDF renders the statement to w.
+func writeStatementPDF(w io.Writer, s statement) error {
+	p := newStatementPDF()
+	pdf := p.pdf
+	pdf.SetTitle("Statement "+s.Title(), true)
+	pdf.SetCreator("SmartSplit", true)
+	pdf.SetCreationDate(s.GeneratedAt)
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.SetFont("Helvetica", "", 8)
+		p.color(statementMuted)
+		pdf.CellFormat(p.width, 5, fmt.Sprintf("Generated %s - page %d", s.GeneratedAt.Format("Jan 2, 2006 15:04 MST"), pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 18)
+	p.color(statementInk)
+	pdf.CellFormat(p.width, 9, p.tr("Statement"), "", 1, "L", false, 0, "")
+	p.text(10, statementMuted, s.Username+" - "+s.Title()+" - amounts in "+s.Currency)
+	pdf.Ln(4)
+
+	summary := []float64{p.width / 3, p.width / 3, p.width / 3}
+	p.row(false, summary, "LLL", "Income received", "Spent", "Net")
+	pdf.SetFont("Helvetica", "B", 14)
+	p.color(statementInk)
+	for i, v := range []money.Decimal{s.IncomeReceived, s.Spent, s.IncomeReceived.Sub(s.Spent)} {
+		pdf.CellFormat(summary[i], 8, formatAmount(v), "", 0, "L", false, 0, "")
+	}
+	pdf.Ln(-1)
+
+	p.heading("Income")
+	incomeWidths := []float64{p.width - 70, 35, 35}
+	p.row(true, incomeWidths, "LLR", "Planned income", "Frequency", "Amount")
+	for _, income := range s.Incomes {
+		p.row(false, incomeWidths, "LLR", income.Description, income.Frequency, formatAmount(income.Amount))
+	}
+	p.row(true, incomeWidths, "LLR", "Received in period", "", formatAmount(s.IncomeReceived))
+
+	chart := 55.0
+	amountWidths := []float64{p.width - chart - 112, 28, 28, 28, 28, chart}
+	scale := 0.0
+	for _, b := range s.Buckets {
+		scale = math.Max(scale, math.Max(b.Planned.Float64(), b.Actual.Float64()))
+	}
+	p.heading("Allocations vs plan")
+	p.row(true, amountWidths, "LRRRRL", "Allocation", "Target", "Planned", "Actual", "Remaining", "")
+	for _, b := range s.Buckets {
+		name, target := b.Name, ""
+		if b.Factor.Sign() > 0 {
+			name = fmt.Sprintf("%s (%.0f%%)", b.Name, b.Factor.Float64()*100)
+			target = formatAmount(b.Target)
+		}
+		p.ensureSpace(6)
+		p.row(false, amountWidths, "LRRRRL", name, target, formatAmount(b.Planned), formatAmount(b.Actual), formatAmount(b.Planned.Sub(b.Actual)), "")
+		p.bar(15+p.width-chart+3, chart-3, scale, b.Planned.Float64(), b.Actual.Float64())
+	}
+
+	p.heading("Top merchants")
+	if len(s.Merchants) == 0 {
+		p.text(9, statementMuted, "No spending in this period.")
+	}
+	merchantScale := 0.0
+	if len(s.Merchants) > 0 {
+		merchantScale = s.Merchants[0].Total.Float64()
+	}
+	merchantWidths := []float64{p.width - chart - 60, 30, 30, chart}
+	if len(s.Merchants) > 0 {
+		p.row(true, merchantWidths, "LRRL", "Merchant", "Transactions", "Total", "")
+	}
+	for _, merchant := range s.Merchants {
+		p.ensureSpace(6)
+		p.row(false, merchantWidths, "LRRL", merchant.Name, fmt.Sprintf("%d", merchant.Transactions), formatAmount(merchant.Total), "")
+		p.bar(15+p.width-chart+3, chart-3, merchantScale, 0, merchant.Total.Float64())
+	}
+
+	p.heading("Expenses")
+	lineWidths := []float64{p.width - chart - 100, 40, 30, 30, chart}
+	for _, b := range s.Buckets {
+		if len(b.Lines) == 0 {
+			continue
+		}
+		lineScale := 0.0
+		for _, line := range b.Lines {
+			lineScale = math.Max(lineScale, math.Max(line.Planned.Float64(), line.Actual.Float64()))
+		}
+		p.ensureSpace(18)
+		p.row(true, lineWidths, "LLRRL", b.Name, "Category", "Planned", "Actual", "")
+		lines := append([]statementLine(nil), b.Lines...)
+		sort.SliceStable(lines, func(i, j int) bool { return lines[i].Actual.GreaterThan(lines[j].Actual) })
+		for _, line := range lines {
+			p.ensureSpace(6)
+			p.row(false, lineWidths, "LLRRL", line.Description, line.Category, formatAmount(line.Planned), formatAmount(line.Actual), "")
+			p.bar(15+p.width-chart+3, chart-3, lineScale, line.Planned.Float64(), line.Actual.Float64())
+		}
+		pdf.Ln(2)
+	}
+
+	p.heading("Goals")
+	if len(s.Goals) == 0 {
+		p.text(9, statementMuted, "No goals set.")
+	}
+	goalWidths := []float64{p.width - chart - 90, 30, 30, 30, chart}
+	if len(s.Goals) > 0 {
+		p.row(true, goalWidths, "LRRRL", "Goal", "Saved", "Target", "Target date", "")
+	}
+	for _, goal := range s.Goals {
+		targetDate := ""
+		if goal.TargetDate != nil {
+			targetDate = goal.TargetDate.Format("Jan 2, 2006")
+		}
+		p.ensureSpace(6)
+		p.row(false, goalWidths, "LRRRL", goal.Name, formatAmount(goal.SavedAmount), formatAmount(goal.TargetAmount), targetDate, "")
+		x, y, w := 15+p.width-chart+3, pdf.GetY()-4.5, chart-3
+		pdf.SetFillColor(statementPlanned[0], statementPlanned[1], statementPlanned[2])
+		pdf.Rect(x, y, w, 3, "F")
+		progress := math.Min(goal.SavedAmount.Float64()/goal.TargetAmount.Float64(), 1)
+		pdf.SetFillColor(statementGoal[0], statementGoal[1], statementGoal[2])
+		pdf.Rect(x, y, w*progress, 3, "F")
+	}
+
+	p.heading("Notes")
+	periods := statementPeriods(s.From, s.To)
+	wrote := false
+	for _, period := range periods {
+		note, ok := s.Notes[period]
+		if !ok {
+			continue
+		}
+		if len(periods) > 1 {
+			p.row(true, []float64{p.width}, "L", period)
+		}
+		p.text(9, statementInk, note)
+		pdf.Ln(1)
+		wrote = true
+	}
+	for _, observation := range s.Observations() {
+		p.text(9, statementInk, "- "+observation)
+		wrote = true
+	}
+	if !wrote {
+		p.text(9, statementMuted, "Nothing to note.")
+	}
+
+	return pdf.Output(w)
+}
+
+// statementRange reads ?period=YYYY-MM, or ?from= and ?to= defaulting to the
+// current month.
+func statementRange(c *gin.Context) (time.Time, time.Time, bool) {
+	if period := c.Query("period"); period != "" {
+		from, to, err := periodRange(period)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "period must be a month in YYYY-MM format")
+			return time.Time{}, time.Time{}, false
+		}
+		return from, to, true
+	}
+
+	from, to, ok := dateRangeParams(c)
+	if !ok {
+		return from, to, false
+	}
+	from, to = budgetPeriod(from, to)
+	return from, to, true
+}
+
+// getStatementHandler renders the caller's statement for a period as a PDF.
+func getStatementHandler(c *gin.Context) {
+	from, to, ok := statementRange(c)
+	if !ok {
+		return
+	}
+	userid := c.GetString("userid")
+
+	s, err := buildStatement(c.Request.Context(), userid, from, to)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not build statement")
+		return
+	}
+	var buf bytes.Buffer
+	if err := writeStatementPDF(&buf, s); err != nil {
+		logger("statements").ErrorContext(c.Request.Context(), "could not render statement", "err", err)
+		respondError(c, http.StatusInternalServerError, "Could not render statement")
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, s.Filename()))
+	c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+}
+
+type statementNoteRequest struct {
+	Body string `json:"body"`
+}
+
+type statementNoteResponse struct {
+	Period string `json:"period"`
+	Body   string `json:"body"`
+}
+
+func getStatementNoteHandler(c *gin.Context) {
+	period := c.Param("period")
+	if _, _, err := periodRange(period); err != nil {
+		respondError(c, http.StatusBadRequest, "period must be a month in YYYY-MM format")
+		return
+	}
+
+	body, err := repos.Statements.Note(c.Request.Context(), c.GetString("userid"), period)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load note")
+		return
+	}
+	c.JSON(http.StatusOK, statementNoteResponse{Period: period, Body: body})
+}
+
+// putStatementNoteHandler sets the note shown on the period's statement. An
+// empty body removes it.
+func putStatementNoteHandler(c *gin.Context) {
+	period := c.Param("period")
+	if _, _, err := periodRange(period); err != nil {
+		respondError(c, http.StatusBadRequest, "period must be a month in YYYY-MM format")
+		return
+	}
+	var request statementNoteRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid note")
+		return
+	}
+
+	body := strings.TrimSpace(request.Body)
+	if err := repos.Statements.SetNote(c.Request.Context(), c.GetString("userid"), period, body); err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not save note")
+		return
+	}
+	c.JSON(http.StatusOK, statementNoteResponse{Period: period, Body: body})
+}
+
+type statementSettings struct {
+	EmailEnabled bool `json:"email_enabled"`
+}
+
+// statementSettingsResponse says whether the server can send email at all.
+type statementSettingsResponse struct {
+	EmailEnabled   bool `json:"email_enabled"`
+	EmailAvailable bool `json:"email_available"`
+}
+
+func getStatementSettingsHandler(c *gin.Context) {
+	enabled, err := repos.Statements.EmailEnabled(c.Request.Context(), c.GetString("userid"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load statement settings")
+		return
+	}
+	c.JSON(http.StatusOK, statementSettingsResponse{EmailEnabled: enabled, EmailAvailable: mailer != nil})
+}
+
+// putStatementSettingsHandler opts the caller in or out of receiving each
+// month's statement by email once the month has closed.
+func putStatementSettingsHandler(c *gin.Context) {
+	var request statementSettings
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid statement settings")
+		return
+	}
+
+	if err := repos.Statements.SetEmailEnabled(c.Request.Context(), c.GetString("userid"), request.EmailEnabled); err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not save statement settings")
+		return
+	}
+	c.JSON(http.StatusOK, statementSettingsResponse{EmailEnabled: request.EmailEnabled, EmailAvailable: mailer != nil})
+}
+
+// emailClosedStatements sends last month's statement to every user who asked
+// for statements by email and has not been sent it yet.
+func emailClosedStatements(ctx context.Context, m Mailer, now time.Time) {
+	lastMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+	period := lastMonth.Format(statementPeriodLayout)
+	from, to, _ := periodRange(period)
+
+	recipients, err := repos.Statements.PendingRecipients(ctx, period)
+	if err != nil {
+		logger("statements").ErrorContext(ctx, "could not load statement recipients", "err", err)
+		return
+	}
+	for _, recipient := range recipients {
+		claimed, err := repos.Statements.ClaimDelivery(ctx, recipient.UserID, period)
+		if err != nil || !claimed {
+			continue
+		}
+		if err := emailStatement(ctx, m, recipient, from, to); err != nil {
+			logger("statements").WarnContext(ctx, "could not email statement", "user_id", recipient.UserID, "period", period, "err", err)
+			if err := repos.Statements.ReleaseDelivery(ctx, recipient.UserID, period); err != nil {
+				logger("statements").ErrorContext(ctx, "could not release statement delivery", "user_id", recipient.UserID, "period", period, "err", err)
+			}
+			continue
+		}
+		writeAudit(ctx, repository.AuditEntry{
+			SubjectID:    recipient.UserID,
+			Action:       AuditStatementEmailed,
+			ResourceType: "statement",
+			ResourceID:   period,
+		})
+	}
+}
+
+func emailStatement(ctx context.Context, m Mailer, recipient repository.StatementRecipient, from time.Time, to time.Time) error {
+	s, err := buildStatement(ctx, recipient.UserID, from, to)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := writeStatementPDF(&buf, s); err != nil {
+		return err
+	}
+
+	return m.Send(ctx, MailMessage{
+		To:      recipient.Email,
+		Subject: "Your statement for " + s.Title(),
+		Body: fmt.Sprintf("Hi %s,\n\nYour SmartSplit statement for %s is attached.\n\nIncome received: %s %s\nSpent: %s %s\n",
+			s.Username, s.Title(), formatAmount(s.IncomeReceived), s.Currency, formatAmount(s.Spent), s.Currency),
+		Attachments: []MailAttachment{{Filename: s.Filename(), ContentType: "application/pdf", Data: buf.Bytes()}},
+	})
+}
+
+// runStatementMailer emails closed statements now and every
+// statementMailInterval until ctx is done.
+func runStatementMailer(ctx context.Context, m Mailer) {
+	ticker := time.NewTicker(statementMailInterval)
+	defer ticker.Stop()
+	for {
+		runJob(ctx, "statements", func() { emailClosedStatements(ctx, m, time.Now().UTC()) })
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/plaid/quickstart/repository"
+)
+
+// Encrypted tokens are stored as
+//
+//	enc:v2:<key id>:<base64 wrapped data key>:<base64 nonce||ciphertext>
+//
+// Each token gets its own random data key, which is wrapped by a key
+// encryption key from the KeyProvider. Rotating the KEK only rewraps data keys.
+// The ciphertext is bound to the table, column and row it is stored in, so
+// a value copied to another row does not decrypt there.
+const encryptedTokenPrefix = "enc:v2:"
+
+// legacyTokenPrefix marks tokens encrypted before they were bound to their
+// row. Like plaintext tokens, they are only read by rotate-token-keys
+// -migrate-legacy, which re-encrypts them.
+const legacyTokenPrefix = "enc:v1:"
+
+var (
+	errTokenKeyNotFound = errors.New("token encryption key not found")
+	errLegacyToken      = errors.New("stored token is not encrypted with the current format; run rotate-token-keys -migrate-legacy")
+)
+
+// KeyProvider wraps and unwraps data keys. Its shape matches cloud KMS
+// Encrypt/Decrypt APIs so a KMS-backed provider can replace localKeyProvider.
+type KeyProvider interface {
+	PrimaryKeyID() string
+	WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// localKeyProvider holds AES-256 key encryption keys in memory.
+type localKeyProvider struct {
+	primary string
+	keys    map[string][]byte
+}
+
+// newLocalKeyProviderFromEnv reads TOKEN_ENCRYPTION_KEYS, a comma-separated
+// list of <key id>:<base64 32-byte key>. New tokens are encrypted with
+// TOKEN_ENCRYPTION_PRIMARY_KEY, or the first key listed. Older keys stay in the
+// list until rotate-token-keys has rewrapped everything they protect.
+func newLocalKeyProviderFromEnv() (*localKeyProvider, error) {
+	spec := os.Getenv("TOKEN_ENCRYPTION_KEYS")
+	if spec == "" {
+		return nil, errors.New("TOKEN_ENCRYPTION_KEYS is not set. Generate a key with `openssl rand -base64 32`")
+	}
+
+	provider := &localKeyProvider{keys: map[string][]byte{}}
+	for _, entry := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("TOKEN_ENCRYPTION_KEYS: entry %q must look like <key id>:<base64 key>", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("TOKEN_ENCRYPTION_KEYS: key %q must be 32 bytes, base64 encoded", id)
+		}
+		provider.keys[id] = key
+		if provider.primary == "" {
+			provider.primary = id
+		}
+	}
+
+	if primary := os.Getenv("TOKEN_ENCRYPTION_PRIMARY_KEY"); primary != "" {
+		if _, ok := provider.keys[primary]; !ok {
+			return nil, fmt.Errorf("TOKEN_ENCRYPTION_PRIMARY_KEY: key %q is not in TOKEN_ENCRYPTION_KEYS", primary)
+		}
+		provider.primary = primary
+	}
+
+	return provider, nil
+}
+
+func (p *localKeyProvider) PrimaryKeyID() string {
+	return p.primary
+}
+
+func (p *localKeyProvider) WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	kek, ok := p.keys[keyID]
+	if !ok {
+		return nil, errTokenKeyNotFound
+	}
+	return sealAESGCM(kek, dataKey, nil)
+}
+
+func (p *localKeyProvider) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	kek, ok := p.keys[keyID]
+	if !ok {
+		return nil, errTokenKeyNotFound
+	}
+	return openAESGCM(kek, wrapped, nil)
+}
+
+func sealAESGCM(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func openAESGCM(key []byte, sealed []byte, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// TokenCipher envelope-encrypts Plaid access tokens, webhook secrets and TOTP
+// secrets before they are stored.
+type TokenCipher struct {
+	keys KeyProvider
+	// migrateLegacy lets Rotate read plaintext and unbound tokens. Only
+	// rotate-token-keys -migrate-legacy sets it.
+	migrateLegacy bool
+}
+
+var tokenCipher *TokenCipher
+
+// newTokenCipherFromEnv builds the cipher from TOKEN_ENCRYPTION_KEYS.
+func newTokenCipherFromEnv() (*TokenCipher, error) {
+	keys, err := newLocalKeyProviderFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return &TokenCipher{keys: keys}, nil
+}
+
+// tokenAdditionalData names where a token is stored, to be authenticated
+// with it.
+func tokenAdditionalData(column repository.SecretColumn, id string) []byte {
+	return []byte(column.Table + "." + column.Column + ":" + id)
+}
+
+// Encrypt encrypts token for the row id of column.
+func (t *TokenCipher) Encrypt(ctx context.Context, token string, column repository.SecretColumn, id string) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	sealed, err := sealAESGCM(dataKey, []byte(token), tokenAdditionalData(column, id))
+	if err != nil {
+		return "", err
+	}
+
+	keyID := t.keys.PrimaryKeyID()
+	wrapped, err := t.keys.WrapKey(ctx, keyID, dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	return formatEncryptedToken(encryptedTokenPrefix, keyID, wrapped, sealed), nil
+}
+
+// Decrypt returns the plaintext of a token stored in the row id of column.
+// Anything else than a current encrypted token is refused.
+func (t *TokenCipher) Decrypt(ctx context.Context, stored string, column repository.SecretColumn, id string) (string, error) {
+	if !strings.HasPrefix(stored, encryptedTokenPrefix) {
+		return "", errLegacyToken
+	}
+	return t.open(ctx, stored, encryptedTokenPrefix, tokenAdditionalData(column, id))
+}
+
+func (t *TokenCipher) open(ctx context.Context, stored string, prefix string, additionalData []byte) (string, error) {
+	keyID, wrapped, sealed, err := splitEncryptedToken(stored, prefix)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := t.keys.UnwrapKey(ctx, keyID, wrapped)
+	if err != nil {
+		return "", err
+	}
+	token, err := openAESGCM(dataKey, sealed, additionalData)
+	if err != nil {
+		return "", err
+	}
+
+	return string(token), nil
+}
+
+// Rotate rewraps the data key of an encrypted token under the primary key.
+// With migrateLegacy it also encrypts plaintext tokens and re-encrypts
+// unbound ones. It reports whether stored changed.
+func (t *TokenCipher) Rotate(ctx context.Context, stored string, column repository.SecretColumn, id string) (string, bool, error) {
+	if !strings.HasPrefix(stored, encryptedTokenPrefix) {
+		if !t.migrateLegacy {
+			return "", false, errLegacyToken
+		}
+		token := stored
+		if strings.HasPrefix(stored, legacyTokenPrefix) {
+			var err error
+			if token, err = t.open(ctx, stored, legacyTokenPrefix, nil); err != nil {
+				return "", false, err
+			}
+		}
+		encrypted, err := t.Encrypt(ctx, token, column, id)
+		return encrypted, err == nil, err
+	}
+
+	keyID, wrapped, sealed, err := splitEncryptedToken(stored, encryptedTokenPrefix)
+	if err != nil {
+		return "", false, err
+	}
+	primary := t.keys.PrimaryKeyID()
+	if keyID == primary {
+		return stored, false, nil
+	}
+
+	dataKey, err := t.keys.UnwrapKey(ctx, keyID, wrapped)
+	if err != nil {
+		return "", false, err
+	}
+	rewrapped, err := t.keys.WrapKey(ctx, primary, dataKey)
+	if err != nil {
+		return "", false, err
+	}
+
+	return formatEncryptedToken(encryptedTokenPrefix, primary, rewrapped, sealed), true, nil
+}
+
+func formatEncryptedToken(prefix string, keyID string, wrapped []byte, sealed []byte) string {
+	return prefix + keyID + ":" +
+		base64.StdEncoding.EncodeToString(wrapped) + ":" +
+		base64.StdEncoding.EncodeToString(sealed)
+}
+
+func splitEncryptedToken(stored string, prefix string) (string, []byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(stored, prefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, errors.New("malformed encrypted token")
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return parts[0], wrapped, sealed, nil
+}
+
+// runRotateTokenKeysCommand implements `rotate-token-keys [-migrate-legacy]`.
+// -migrate-legacy treats unprefixed values as plaintext tokens to encrypt;
+// without it they are an error, as everywhere else.
+func runRotateTokenKeysCommand(ctx context.Context, cipher *TokenCipher, secrets repository.SecretRepo, args []string) (int, error) {
+	flags := flag.NewFlagSet("rotate-token-keys", flag.ContinueOnError)
+	migrateLegacy := flags.Bool("migrate-legacy", false, "encrypt plaintext tokens and re-encrypt tokens not bound to their row")
+	if err := flags.Parse(args); err != nil {
+		return 0, err
+	}
+	if flags.NArg() != 0 {
+		return 0, errors.New("usage: rotate-token-keys [-migrate-legacy]")
+	}
+
+	legacy := *cipher
+	legacy.migrateLegacy = *migrateLegacy
+	return rotateStoredTokens(ctx, &legacy, secrets)
+}
+
+// rotateStoredTokens re-encrypts every stored token and secret under the
+// primary key. It is safe to run repeatedly.
+func rotateStoredTokens(ctx context.Context, cipher *TokenCipher, secrets repository.SecretRepo) (int, error) {
+	rotated := 0
+	for _, column := range repository.SecretColumns {
+		stored, err := secrets.Stored(ctx, column)
+		if err != nil {
+			return rotated, err
+		}
+		for _, secret := range stored {
+			next, changed, err := cipher.Rotate(ctx, secret.Value, column, secret.ID)
+			if err != nil {
+				return rotated, fmt.Errorf("%s.%s %s: %w", column.Table, column.Column, secret.ID, err)
+			}
+			if !changed {
+				continue
+			}
+			if err := secrets.Replace(ctx, secret, next); err != nil {
+				return rotated, fmt.Errorf("%s.%s %s: %w", column.Table, column.Column, secret.ID, err)
+			}
+			rotated++
+		}
+	}
+
+	return rotated, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/plaid/quickstart/repository"
+)
+
+func testKeyProvider(primary string, ids ...string) *localKeyProvider {
+	provider := &localKeyProvider{primary: primary, keys: map[string][]byte{}}
+	for i, id := range ids {
+		provider.keys[id] = bytes.Repeat([]byte{byte(i + 1)}, 32)
+	}
+	return provider
+}
+
+func TestTokenCipherRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	cipher := &TokenCipher{keys: testKeyProvider("k1", "k1")}
+
+	tests := []struct {
+		name   string
+		token  string
+		column repository.SecretColumn
+		id     string
+	}{
+		{"item token", "access-sandbox-1234", repository.ItemAccessToken, "5d3c1f0e-7b7a-4c53-9a8e-2f4b6c1d9e01"},
+		{"user token", "access-sandbox-1234", repository.UserAccessToken, "ed1bec4c-0a1b-4783-b47f-16ba0650b821"},
+		{"webhook secret", "whsec_abc:def", repository.WebhookEndpointSecret, "e1"},
+		{"empty", "", repository.AlertWebhookSecret, "u1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted, err := cipher.Encrypt(ctx, tt.token, tt.column, tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(encrypted, encryptedTokenPrefix+"k1:") || (tt.token != "" && strings.Contains(encrypted, tt.token)) {
+				t.Fatalf("encrypted = %q", encrypted)
+			}
+			got, err := cipher.Decrypt(ctx, encrypted, tt.column, tt.id)
+			if err != nil || got != tt.token {
+				t.Fatalf("Decrypt = %q, %v, want %q", got, err, tt.token)
+			}
+		})
+	}
+}
+
+func TestTokenCipherBindsRow(t *testing.T) {
+	ctx := context.Background()
+	cipher := &TokenCipher{keys: testKeyProvider("k1", "k1")}
+	encrypted, err := cipher.Encrypt(ctx, "access-sandbox-1234", repository.ItemAccessToken, "item-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		column repository.SecretColumn
+		id     string
+	}{
+		{"other row", repository.ItemAccessToken, "item-2"},
+		{"other table", repository.UserAccessToken, "item-1"},
+		{"other column", repository.WebhookEndpointSecret, "item-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := cipher.Decrypt(ctx, encrypted, tt.column, tt.id); err == nil {
+				t.Errorf("Decrypt = %q, want an error", got)
+			}
+		})
+	}
+}
+
+func TestTokenCipherRefusesLegacy(t *testing.T) {
+	ctx := context.Background()
+	cipher := &TokenCipher{keys: testKeyProvider("k1", "k1")}
+	unbound, err := sealAESGCM(bytes.Repeat([]byte{9}, 32), []byte("access-sandbox-1234"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := cipher.keys.WrapKey(ctx, "k1", bytes.Repeat([]byte{9}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		stored string
+	}{
+		{"plaintext", "access-sandbox-1234"},
+		{"unbound", formatEncryptedToken(legacyTokenPrefix, "k1", wrapped, unbound)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := cipher.Decrypt(ctx, tt.stored, repository.ItemAccessToken, "item-1"); !errors.Is(err, errLegacyToken) {
+				t.Errorf("Decrypt err = %v, want errLegacyToken", err)
+			}
+			if _, _, err := cipher.Rotate(ctx, tt.stored, repository.ItemAccessToken, "item-1"); !errors.Is(err, errLegacyToken) {
+				t.Errorf("Rotate err = %v, want errLegacyToken", err)
+			}
+
+			migrating := &TokenCipher{keys: cipher.keys, migrateLegacy: true}
+			rotated, changed, err := migrating.Rotate(ctx, tt.stored, repository.ItemAccessToken, "item-1")
+			if err != nil || !changed {
+				t.Fatalf("Rotate with migrateLegacy = %v, %v", changed, err)
+			}
+			if got, err := cipher.Decrypt(ctx, rotated, repository.ItemAccessToken, "item-1"); err != nil || got != "access-sandbox-1234" {
+				t.Errorf("Decrypt after migration = %q, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestTokenCipherRotation(t *testing.T) {
+	ctx := context.Background()
+	old := &TokenCipher{keys: testKeyProvider("k1", "k1", "k2")}
+	encrypted, err := old.Encrypt(ctx, "access-sandbox-1234", repository.ItemAccessToken, "item-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		primary     string
+		wantChanged bool
+	}{
+		{"same primary", "k1", false},
+		{"new primary", "k2", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cipher := &TokenCipher{keys: testKeyProvider(tt.primary, "k1", "k2")}
+			rotated, changed, err := cipher.Rotate(ctx, encrypted, repository.ItemAccessToken, "item-1")
+			if err != nil || changed != tt.wantChanged {
+				t.Fatalf("Rotate = %v, %v, want changed %v", changed, err, tt.wantChanged)
+			}
+			if !strings.HasPrefix(rotated, encryptedTokenPrefix+tt.primary+":") {
+				t.Errorf("rotated = %q, want key %s", rotated, tt.primary)
+			}
+
+			// Once rotated, the old key is no longer needed.
+			onlyPrimary := &TokenCipher{keys: &localKeyProvider{primary: tt.primary, keys: map[string][]byte{tt.primary: cipher.keys.(*localKeyProvider).keys[tt.primary]}}}
+			if got, err := onlyPrimary.Decrypt(ctx, rotated, repository.ItemAccessToken, "item-1"); err != nil || got != "access-sandbox-1234" {
+				t.Errorf("Decrypt with %s only = %q, %v", tt.primary, got, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"image/png"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/plaid/quickstart/repository"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	totpIssuer        = "SmartSplit"
+	totpPeriod        = 30 // seconds, the totp package default
+	recoveryCodeCount = 10
+)
+
+type totpCodeRequest struct {
+	Code string `json:"code" form:"code" binding:"required"`
+}
+
+type totpLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" form:"challenge_token" binding:"required"`
+	Code           string `json:"code" form:"code" binding:"required"`
+}
+
+// totpEnrollResponse carries the new secret both as a URI and as a QR code,
+// a base64 PNG, for the authenticator app.
+type totpEnrollResponse struct {
+	OTPAuthURI string `json:"otpauth_uri"`
+	QRPNG      string `json:"qr_png"`
+}
+
+type totpConfirmResponse struct {
+	Message       string   `json:"message"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// userTOTP returns the decrypted secret, or "" before enrollment, and
+// whether 2FA has been confirmed.
+func userTOTP(ctx context.Context, userid string, users repository.UserRepo) (string, bool, error) {
+	user, err := users.ByID(ctx, userid)
+	if err != nil || user.TOTPSecret == "" {
+		return "", user.TOTPEnabled, err
+	}
+
+	secret, err := tokenCipher.Decrypt(ctx, user.TOTPSecret, repository.UserTOTPSecret, userid)
+	if err != nil {
+		return "", false, err
+	}
+	return secret, user.TOTPEnabled, nil
+}
+
+// totpStep returns the time step of the code for secret that matches code,
+// checking the steps totp.Validate accepts: the current one and one either
+// side of it.
+func totpStep(code string, secret string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	current := now.Unix() / totpPeriod
+	for step := current - 1; step <= current+1; step++ {
+		expected, err := totp.GenerateCode(secret, time.Unix(step*totpPeriod, 0))
+		if err == nil && subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// useTOTPCode accepts a TOTP code at most once: a code, or any code of an
+// earlier time step, is refused once one has been accepted.
+func useTOTPCode(ctx context.Context, userid string, secret string, code string, users repository.UserRepo) (bool, error) {
+	step, ok := totpStep(code, secret, time.Now())
+	if !ok {
+		return false, nil
+	}
+	err := users.UseTOTPStep(ctx, userid, step)
+	if err == repository.ErrNotFound {
+		// Replayed, or raced by a concurrent login with the same code.
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// generateRecoveryCodes returns plaintext codes of the form xxxxx-xxxxx. Only
+// their bcrypt hashes are persisted.
+func generateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+
+	return codes, nil
+}
+
+func saveRecoveryCodes(ctx context.Context, userid string, codes []string, users repository.UserRepo) error {
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hash, err := hashPassword(code)
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, hash)
+	}
+
+	return users.ReplaceRecoveryCodes(ctx, userid, hashes)
+}
+
+// useRecoveryCode marks the matching unused recovery code as spent.
+func useRecoveryCode(ctx context.Context, userid string, code string, users repository.UserRepo) (bool, error) {
+	codes, err := users.UnusedRecoveryCodes(ctx, userid)
+	if err != nil {
+		return false, err
+	}
+
+	for _, stored := range codes {
+		if comparePasswords(stored.CodeHash, strings.ToLower(strings.TrimSpace(code))) {
+			err := users.MarkRecoveryCodeUsed(ctx, stored.ID)
+			if err == repository.ErrNotFound {
+				// Spent by a concurrent login.
+				return false, nil
+			}
+			return err == nil, err
+		}
+	}
+
+	return false, nil
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused recovery code.
+func verifySecondFactor(ctx context.Context, userid string, code string, users repository.UserRepo) (bool, error) {
+	secret, enabled, err := userTOTP(ctx, userid, users)
+	if err != nil {
+		return false, err
+	}
+	if !enabled {
+		return false, nil
+	}
+	if ok, err := useTOTPCode(ctx, userid, secret, code, users); ok || err != nil {
+		return ok, err
+	}
+
+	return useRecoveryCode(ctx, userid, code, users)
+}
+
+// enrollTOTPHandler generates a new secret for the current user. 2FA stays
+// disabled until the secret is confirmed with a valid code.
+func enrollTOTPHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	userid := c.GetString("userid")
+
+	user, err := repos.Users.ByID(ctx, userid)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Internal server error: Could not load user")
+		return
+	}
+	if user.TOTPEnabled {
+		respondError(c, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: user.Username,
+	})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Internal server error: Could not generate TOTP secret")
+		return
+	}
+
+	secret, err := tokenCipher.Encrypt(ctx, key.Secret(), repository.UserTOTPSecret, userid)
+	if err == nil {
+		err = repos.Users.SetTOTPSecret(ctx, userid, secret)
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Internal server error: Could not save TOTP secret")
+		return
+	}
+
+	img, err := key.Image(256, 256)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Internal server error: Could not render QR code")
+		return
+	}
+	var qr bytes.Buffer
+	if err := png.Encode(&qr, img); err != nil {
+		respondError(c, http.StatusInternalServerError, "Internal server error: Could not render QR code")
+		return
+	}
+
+	recordAudit(c, repository.AuditEntry{Action: AuditTOTPEnrolled})
+
+	c.JSON(http.StatusOK, totpEnrollResponse{
+		OTPAuthURI: key.URL(),
+		QRPNG:      base64.StdEncoding.EncodeToString(qr.Bytes()),
+	})
+}
+
+// confirmTOTPHandler enables 2FA once the user proves their authenticator
+// produces valid codes, and returns a fresh set of recovery codes.
+func confirmTOTPHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	userid := c.GetString("userid")
+
+	var request totpCodeRequest
+	if err := c.ShouldBind(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "TOTP code is required")
+		return
+	}
+
+	secret, enabled, err := userTOTP(ctx, userid, repos.Users)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Internal server error: Could not load user")
+		return
+	}
+	if enabled {
+		respondError(c, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	if secret == "" {
+		respondError(c, http.StatusBadRequest, "Start enrollment before confirming")
+		return
+	}
+	// Using up the code here keeps it from also completing a login.
+	ok, err := useTOTPCode(ctx, userid, secret, request.Code, repos.Users)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Internal server error: Could not verify code")
+		return
+	}
+	if !ok {
+		respondError(c, http.StatusUnauthorized, "Invalid TOTP code")
+		return
+	}
+
+	codes, err := generateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Internal server error: Could not generate recovery codes")
+		return
+	}
+	if err := saveRecoveryCodes(ctx, userid, codes, repos.Users); err != nil {
+		respondError(c, http.StatusInternalServerError, "Internal server error: Could not save recovery codes")
+		return
+	}
+
+	if err := repos.Users.EnableTOTP(ctx, userid); err != nil {
+		respondError(c, http.StatusInternalServerError, "Internal server error: Could not enable two-factor authentication")
+		return
+	}
+
+	recordAudit(c, repository.AuditEntry{Action: AuditTOTPEnabled})
+
+	c.JSON(http.StatusOK, totpConfirmResponse{
+		Message:       "Two-factor authentication enabled",
+		RecoveryCodes: codes,
+	})
+}
+
+// loginTOTPHandler is the second step of a two-factor login. It trades the
+// challenge token from loginHandler plus a TOTP or recovery code for a session token.
+func loginTOTPHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var request totpLoginRequest
+	if err := c.ShouldBind(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "Challenge token and code are required")
+		return
+	}
+
+	userid, err := ValidateChallengeJWT(request.ChallengeToken)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Invalid or expired challenge")
+		return
+	}
+
+	lockoutKey := "totp:" + userid
+	if wait, err := loginLockout.LockedFor(ctx, lockoutKey); err == nil && wait > 0 {
+		recordAudit(c, repository.AuditEntry{
+			SubjectID: userid,
+			Action:    AuditLoginFailed,
+			Metadata:  auditMetadata(gin.H{"stage": "totp", "reason": "locked_out"}),
+		})
+		tooManyAttempts(c, wait)
+		return
+	}
+
+	ok, err := verifySecondFactor(ctx, userid, request.Code, repos.Users)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Internal server error: Could not verify code")
+		return
+	}
+	if !ok {
+		recordAudit(c, repository.AuditEntry{
+			SubjectID: userid,
+			Action:    AuditLoginFailed,
+			Metadata:  auditMetadata(gin.H{"stage": "totp", "reason": "invalid_code"}),
+		})
+		if wait, _ := loginLockout.Fail(ctx, lockoutKey); wait > 0 {
+			tooManyAttempts(c, wait)
+			return
+		}
+		respondError(c, http.StatusUnauthorized, "Invalid TOTP code")
+		return
+	}
+
+	loginLockout.Reset(ctx, lockoutKey)
+
+	token, err := GenerateJWT(userid)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Internal server error: Could not generate token")
+		return
+	}
+
+	user, err := repos.Users.ByID(ctx, userid)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Internal server error: Could not load user")
+		return
+	}
+
+	recordAudit(c, repository.AuditEntry{
+		ActorID:  userid,
+		Action:   AuditLoginSucceeded,
+		Metadata: auditMetadata(gin.H{"second_factor": true}),
+	})
+
+	c.JSON(http.StatusOK, loginResponse{
+		Message:     "Login successful",
+		Token:       token,
+		PlaidLinked: user.PlaidAccessToken != "",
+		UserID:      userid,
+		Username:    user.Username,
+	})
+}
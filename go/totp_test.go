@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/plaid/quickstart/repository"
+	"github.com/pquerna/otp/totp"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+func totpCodeAt(t *testing.T, at time.Time) string {
+	t.Helper()
+	code, err := totp.GenerateCode(testTOTPSecret, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestTOTPStep(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	current := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", totpCodeAt(t, now), current, true},
+		{"with spaces", " " + totpCodeAt(t, now) + " ", current, true},
+		{"previous step", totpCodeAt(t, now.Add(-totpPeriod*time.Second)), current - 1, true},
+		{"next step", totpCodeAt(t, now.Add(totpPeriod*time.Second)), current + 1, true},
+		{"two steps old", totpCodeAt(t, now.Add(-2*totpPeriod*time.Second)), 0, false},
+		{"wrong code", "000000", 0, false},
+		{"empty", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := totpStep(tt.code, testTOTPSecret, now)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("totpStep = %d, %v, want %d, %v", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestVerifySecondFactorRejectsReplay(t *testing.T) {
+	ctx := context.Background()
+	db := openTestSQLite(t)
+	if err := runMigrateCommand(ctx, db, driverSQLite, []string{"up"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Seed(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	users := repository.New(db).Users
+
+	saved := tokenCipher
+	tokenCipher = &TokenCipher{keys: testKeyProvider("k1", "k1")}
+	t.Cleanup(func() { tokenCipher = saved })
+
+	encrypted, err := tokenCipher.Encrypt(ctx, testTOTPSecret, repository.UserTOTPSecret, seedUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := users.SetTOTPSecret(ctx, seedUserID, encrypted); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.EnableTOTP(ctx, seedUserID); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	current := totpCodeAt(t, now)
+	tests := []struct {
+		name string
+		code string
+		want bool
+	}{
+		{"fresh code", current, true},
+		{"same code again", current, false},
+		{"code of an earlier step", totpCodeAt(t, now.Add(-totpPeriod*time.Second)), false},
+		{"code of the next step", totpCodeAt(t, now.Add(totpPeriod*time.Second)), true},
+		{"current code after the next", current, false},
+	}
+	for _, tt := range tests {
+		ok, err := verifySecondFactor(ctx, seedUserID, tt.code, users)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if ok != tt.want {
+			t.Errorf("%s: accepted = %v, want %v", tt.name, ok, tt.want)
+		}
+	}
+}
+
+func TestUserTOTPRefusesPlaintextSecret(t *testing.T) {
+	ctx := context.Background()
+	db := openTestSQLite(t)
+	if err := runMigrateCommand(ctx, db, driverSQLite, []string{"up"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Seed(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	users := repository.New(db).Users
+
+	saved := tokenCipher
+	tokenCipher = &TokenCipher{keys: testKeyProvider("k1", "k1")}
+	t.Cleanup(func() { tokenCipher = saved })
+
+	if err := users.SetTOTPSecret(ctx, seedUserID, testTOTPSecret); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := userTOTP(ctx, seedUserID, users); err == nil {
+		t.Error("userTOTP read a plaintext secret")
+	}
+}
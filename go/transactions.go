@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	plaid "github.com/plaid/plaid-go/v31/plaid"
+	"github.com/plaid/quickstart/money"
+	"github.com/plaid/quickstart/repository"
+)
+
+const transactionsMaxPageSize = 500
+
+// currentPlaidItem finds the stored item whose token is the user's current one.
+func currentPlaidItem(ctx context.Context, userid string, accessToken string) (repository.PlaidItem, error) {
+	items, err := repos.PlaidItems.ListByUser(ctx, userid)
+	if err != nil {
+		return repository.PlaidItem{}, err
+	}
+	for _, item := range items {
+		// Manual and restored items have no token.
+		if item.AccessToken == "" {
+			continue
+		}
+		itemAccessToken, err := tokenCipher.Decrypt(ctx, item.AccessToken, repository.ItemAccessToken, item.ID.String())
+		if err != nil {
+			return repository.PlaidItem{}, err
+		}
+		if itemAccessToken == accessToken {
+			return item, nil
+		}
+	}
+	return repository.PlaidItem{}, repository.ErrNotFound
+}
+
+// storeSyncedTransactions applies one /transactions/sync run to "TransactionRaw"
+// and remembers the cursor it ended at.
+func storeSyncedTransactions(ctx context.Context, userid string, accessToken string, cursor string, added []plaid.Transaction, modified []plaid.Transaction, removed []plaid.RemovedTransaction) error {
+	item, err := currentPlaidItem(ctx, userid, accessToken)
+	if err != nil {
+		return err
+	}
+
+	upserts := make([]repository.Transaction, 0, len(added)+len(modified))
+	for _, t := range append(added, modified...) {
+		transaction, err := storedTransaction(userid, item.ID, t)
+		if err != nil {
+			return err
+		}
+		upserts = append(upserts, transaction)
+	}
+	if err := repos.Transactions.Upsert(ctx, upserts); err != nil {
+		return err
+	}
+	if _, err := categorizeTransactions(ctx, userid, transactionSourceSync, upserts); err != nil {
+		return err
+	}
+
+	removedIDs := make([]string, 0, len(removed))
+	for _, t := range removed {
+		removedIDs = append(removedIDs, t.GetTransactionId())
+	}
+	if err := repos.Transactions.Delete(ctx, userid, removedIDs); err != nil {
+		return err
+	}
+
+	if err := repos.PlaidItems.SetSyncCursor(ctx, item.ID, cursor); err != nil {
+		return err
+	}
+	transactionsProcessed.WithLabelValues(transactionSourceSync, "added").Add(float64(len(added)))
+	transactionsProcessed.WithLabelValues(transactionSourceSync, "modified").Add(float64(len(modified)))
+	transactionsProcessed.WithLabelValues(transactionSourceSync, "removed").Add(float64(len(removedIDs)))
+	if len(upserts) > 0 || len(removedIDs) > 0 {
+		publishEvent(ctx, userid, newWebhookEvent(eventTransactionSynced, transactionSyncedData{
+			ItemID: item.ID, Added: upserts[:len(added)], Modified: upserts[len(added):], Removed: removedIDs,
+		}))
+	}
+	return nil
+}
+
+func storedTransaction(userid string, itemid uuid.UUID, t plaid.Transaction) (repository.Transaction, error) {
+	date, err := time.Parse(time.DateOnly, t.GetDate())
+	if err != nil {
+		return repository.Transaction{}, err
+	}
+	raw, err := json.Marshal(t)
+	if err != nil {
+		return repository.Transaction{}, err
+	}
+
+	currency := t.GetIsoCurrencyCode()
+	if currency == "" {
+		currency = t.GetUnofficialCurrencyCode()
+	}
+
+	transaction := repository.Transaction{
+		UserID:             userid,
+		ItemID:             itemid,
+		PlaidTransactionID: t.GetTransactionId(),
+		PlaidAccountID:     t.GetAccountId(),
+		Name:               t.GetName(),
+		MerchantName:       t.GetMerchantName(),
+		Amount:             money.FromFloat(t.GetAmount()),
+		ISOCurrencyCode:    currency,
+		Date:               date,
+		Pending:            t.GetPending(),
+		Raw:                raw,
+	}
+	if category := t.GetCategory(); len(category) > 0 {
+		transaction.PlaidCategory, _ = json.Marshal(category)
+	}
+	if pfc, ok := t.GetPersonalFinanceCategoryOk(); ok && pfc != nil {
+		transaction.CategoryPrimary = pfc.GetPrimary()
+		transaction.CategoryDetailed = pfc.GetDetailed()
+		transaction.PersonalFinanceCategory, _ = json.Marshal(pfc)
+	}
+	return transaction, nil
+}
+
+// transactionQueryParams reads the filter, sort and paging query parameters
+// of GET /api/v1/transactions.
+func transactionQueryParams(c *gin.Context) (repository.TransactionQuery, bool) {
+	query := repository.TransactionQuery{
+		AccountID: c.Query("account_id"),
+		Search:    c.Query("q"),
+		Category:  c.Query("category"),
+		Sort:      repository.SortByDate,
+		Desc:      true,
+		After:     c.Query("cursor"),
+		Limit:     repository.DefaultTransactionPageSize,
+	}
+	fail := func(message string) (repository.TransactionQuery, bool) {
+		respondError(c, http.StatusBadRequest, message)
+		return repository.TransactionQuery{}, false
+	}
+
+	for name, dst := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		if v := c.Query(name); v != "" {
+			t, err := time.Parse(time.DateOnly, v)
+			if err != nil {
+				return fail(name + " must be a date in YYYY-MM-DD format")
+			}
+			*dst = t
+		}
+	}
+	if !query.From.IsZero() && !query.To.IsZero() && query.To.Before(query.From) {
+		return fail("to must not be before from")
+	}
+
+	for name, dst := range map[string]**money.Decimal{"min_amount": &query.MinAmount, "max_amount": &query.MaxAmount} {
+		if v := c.Query(name); v != "" {
+			n, err := money.Parse(v)
+			if err != nil {
+				return fail(name + " must be a number")
+			}
+			*dst = &n
+		}
+	}
+
+	if v := c.Query("expense_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			return fail("Invalid expense id")
+		}
+		query.ExpenseID = id
+	}
+
+	if v := c.Query("pending"); v != "" {
+		pending, err := strconv.ParseBool(v)
+		if err != nil {
+			return fail("pending must be true or false")
+		}
+		query.Pending = &pending
+	}
+
+	if v := c.Query("uncategorized"); v != "" {
+		uncategorized, err := strconv.ParseBool(v)
+		if err != nil {
+			return fail("uncategorized must be true or false")
+		}
+		query.Uncategorized = uncategorized
+	}
+
+	switch v := c.Query("sort"); v {
+	case "":
+	case repository.SortByDate, repository.SortByAmount, repository.SortByName:
+		query.Sort = v
+	default:
+		return fail("sort must be one of date, amount or name")
+	}
+
+	switch c.Query("order") {
+	case "", "desc":
+	case "asc":
+		query.Desc = false
+	default:
+		return fail("order must be asc or desc")
+	}
+
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fail("limit must be a positive integer")
+		}
+		query.Limit = min(n, transactionsMaxPageSize)
+	}
+
+	return query, true
+}
+
+// getTransactionsHandler pages through the caller's stored transactions.
+// Pass next_cursor back as ?cursor= with the same sort and order for the next page.
+func getTransactionsHandler(c *gin.Context) {
+	query, ok := transactionQueryParams(c)
+	if !ok {
+		return
+	}
+
+	page, err := repos.Transactions.Search(c.Request.Context(), c.GetString("userid"), query)
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		respondError(c, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load transactions")
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// transactionExpenseRequest assigns a transaction to an expense, or clears
+// its assignment when ExpenseID is null.
+type transactionExpenseRequest struct {
+	ExpenseID *uuid.UUID `json:"expense_id"`
+}
+
+// setTransactionExpenseHandler recategorizes one of the caller's
+// transactions by hand.
+func setTransactionExpenseHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	userid := c.GetString("userid")
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid transaction id")
+		return
+	}
+	var request transactionExpenseRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "expense_id must be an expense id or null")
+		return
+	}
+
+	previous, err := repos.Transactions.SetExpense(ctx, userid, id, request.ExpenseID)
+	if err == repository.ErrNotFound {
+		respondError(c, http.StatusNotFound, "Transaction or expense not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not categorize transaction")
+		return
+	}
+
+	before, after := auditDiff(transactionExpenseRequest{ExpenseID: previous}, request)
+	recordAudit(c, repository.AuditEntry{
+		Action:       AuditTransactionCategorized,
+		ResourceType: "transaction",
+		ResourceID:   id.String(),
+		Before:       before,
+		After:        after,
+		Metadata:     auditMetadata(gin.H{"source": repository.CategorizedManually}),
+	})
+	if request.ExpenseID != nil {
+		publishEvent(ctx, userid, newWebhookEvent(eventTransactionCategorized, transactionCategorizedData{Assignments: []categorizedTransaction{
+			{TransactionID: id, ExpenseID: *request.ExpenseID, Confidence: 1, Source: repository.CategorizedManually},
+		}}))
+	}
+
+	c.JSON(http.StatusOK, request)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/plaid/quickstart/money"
+	"github.com/plaid/quickstart/repository"
+)
+
+func TestSearchPagesByAmount(t *testing.T) {
+	ctx := context.Background()
+	useSeededRepos(t)
+	item, err := repos.PlaidItems.LinkManual(ctx, seedUserID, "checking")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Amounts that sort differently as text, with a tie for the id to break.
+	amounts := []string{"9.99", "10.00", "-5.25", "100.5", "0.1", "0.10"}
+	transactions := make([]repository.Transaction, len(amounts))
+	for i, amount := range amounts {
+		transactions[i] = repository.Transaction{
+			UserID: seedUserID, ItemID: item.ID, PlaidTransactionID: fmt.Sprintf("txn-%d", i), PlaidAccountID: "checking",
+			Name: "Test", Amount: money.MustParse(amount), ISOCurrencyCode: "USD", Date: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		}
+	}
+	if err := repos.Transactions.Upsert(ctx, transactions); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, desc := range []bool{false, true} {
+		query := repository.TransactionQuery{Sort: repository.SortByAmount, Desc: desc, Limit: 2}
+		var got []string
+		for pages := 0; pages < len(amounts); pages++ {
+			page, err := repos.Transactions.Search(ctx, seedUserID, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, t := range page.Transactions {
+				got = append(got, t.Amount.String())
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			query.After = page.NextCursor
+		}
+		if len(got) != len(amounts) {
+			t.Fatalf("desc=%v: paged through %v, want all %d amounts once", desc, got, len(amounts))
+		}
+		for i := 1; i < len(got); i++ {
+			previous, current := money.MustParse(got[i-1]), money.MustParse(got[i])
+			if (!desc && previous.GreaterThan(current)) || (desc && current.GreaterThan(previous)) {
+				t.Errorf("desc=%v: %v is out of order", desc, got)
+				break
+			}
+		}
+	}
+}
+
+func TestTransactionRecategorizationIsAudited(t *testing.T) {
+	ctx := context.Background()
+	useSeededRepos(t)
+	gin.SetMode(gin.TestMode)
+	item, err := repos.PlaidItems.LinkManual(ctx, seedUserID, "checking")
+	if err != nil {
+		t.Fatal(err)
+	}
+	budget, err := repos.Budgets.Load(ctx, seedUserID)
+	if err != nil || len(budget.Expenses) < 2 {
+		t.Fatalf("budget: %v, %v", budget, err)
+	}
+	rent, other := budget.Expenses[0], budget.Expenses[1]
+	categories, err := repos.Categories.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rentCategory CatalogCategory
+	for _, category := range categories {
+		if category.ID.String() == rent.Category {
+			rentCategory = category
+		}
+	}
+	transactions := []repository.Transaction{{
+		UserID: seedUserID, ItemID: item.ID, PlaidTransactionID: "txn-rent", PlaidAccountID: "checking", Name: "Landlord",
+		Amount: money.MustParse("1500"), Date: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), CategoryDetailed: rentCategory.PlaidDetailed,
+	}}
+	if err := repos.Transactions.Upsert(ctx, transactions); err != nil {
+		t.Fatal(err)
+	}
+	id := transactions[0].ID
+
+	if n, err := categorizeTransactions(ctx, seedUserID, transactionSourceSync, transactions); err != nil || n != 1 {
+		t.Fatalf("categorized %d, %v; want 1", n, err)
+	}
+
+	r := gin.New()
+	r.PUT("/transactions/:id/expense", func(c *gin.Context) {
+		c.Set("userid", seedUserID)
+		setTransactionExpenseHandler(c)
+	})
+	put := func(transactionID string, body string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/transactions/"+transactionID+"/expense", strings.NewReader(body)))
+		return w.Code
+	}
+	if code := put(id.String(), fmt.Sprintf(`{"expense_id": %q}`, other.Id)); code != http.StatusOK {
+		t.Fatalf("reassign: status %d", code)
+	}
+	if code := put(id.String(), `{"expense_id": null}`); code != http.StatusOK {
+		t.Fatalf("clear: status %d", code)
+	}
+	if code := put(uuid.NewString(), `{"expense_id": null}`); code != http.StatusNotFound {
+		t.Errorf("unknown transaction: status %d, want 404", code)
+	}
+	if code := put(id.String(), fmt.Sprintf(`{"expense_id": %q}`, uuid.New())); code != http.StatusNotFound {
+		t.Errorf("unknown expense: status %d, want 404", code)
+	}
+
+	entries, err := repos.Audit.List(ctx, repository.AuditQuery{UserID: seedUserID, Action: AuditTransactionCategorized, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The order of entries written within the same instant is not defined.
+	want := map[string]bool{
+		`null -> "` + rent.Id.String() + `"`:                        true,
+		`"` + rent.Id.String() + `" -> "` + other.Id.String() + `"`: true,
+		`"` + other.Id.String() + `" -> null`:                       true,
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("%d audit entries, want %d", len(entries), len(want))
+	}
+	for _, entry := range entries {
+		var before, after struct {
+			ExpenseID json.RawMessage `json:"expense_id"`
+		}
+		if err := json.Unmarshal(entry.Before, &before); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(entry.After, &after); err != nil {
+			t.Fatal(err)
+		}
+		change := string(before.ExpenseID) + " -> " + string(after.ExpenseID)
+		if entry.ResourceID != id.String() || !want[change] {
+			t.Errorf("unexpected entry for %s: %s", entry.ResourceID, change)
+		}
+		delete(want, change)
+	}
+}
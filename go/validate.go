@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/plaid/quickstart/money"
+)
+
+// validateMaxBodyBytes caps request bodies unless their operation sets
+// MaxBytes. Multipart forms keep up to validateMultipartMemory in memory, as
+// gin does, and the rest of their files in temporary files.
+const (
+	validateMaxBodyBytes    = 1 << 20
+	validateMultipartMemory = 32 << 20
+)
+
+// requestProblem is one way a request differs from the API description. In
+// is path, query or body; Name is the parameter, or the field within the
+// body, e.g. expenses[0].amount.
+type requestProblem struct {
+	In      string `json:"in"`
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+func (p requestProblem) String() string {
+	switch {
+	case p.In == "body" && p.Name == "":
+		return "body " + p.Message
+	case p.In == "body":
+		return "body field " + p.Name + " " + p.Message
+	}
+	return p.In + " parameter " + p.Name + " " + p.Message
+}
+
+// ValidateRequest rejects requests whose path and query parameters or body
+// do not match the route's operation in the OpenAPI document, answering
+// 400 with every problem in the error details, or 413 when the body is too
+// large. Unknown fields and parameters are let through, as the handlers
+// ignore them. Files in multipart uploads are left to their handlers.
+func ValidateRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		problems, err := openAPI.validate(c)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			abortError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body must not exceed %d bytes", tooLarge.Limit))
+			return
+		}
+		if len(problems) > 0 {
+			respondErrorCode(c, http.StatusBadRequest, errorCode(http.StatusBadRequest),
+				"Request does not match the API description: "+problems[0].String(), problems)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// validate returns the request's problems, or the *http.MaxBytesError of a
+// body over its limit.
+func (spec *apiSpec) validate(c *gin.Context) ([]requestProblem, error) {
+	op := spec.document.Paths[openAPIPath(c.FullPath())][strings.ToLower(c.Request.Method)]
+	if op == nil {
+		return nil, nil
+	}
+
+	var problems []requestProblem
+	query := c.Request.URL.Query()
+	for _, param := range op.Parameters {
+		values := query[param.Name]
+		if param.In == "path" {
+			values = []string{c.Param(param.Name)}
+		}
+		// Handlers read an empty query parameter as a missing one.
+		present := false
+		for _, v := range values {
+			if v == "" {
+				continue
+			}
+			present = true
+			if message := spec.checkString(param.Schema, v); message != "" {
+				problems = append(problems, requestProblem{In: param.In, Name: param.Name, Message: message})
+			}
+		}
+		if !present && param.Required {
+			problems = append(problems, requestProblem{In: param.In, Name: param.Name, Message: "is required"})
+		}
+	}
+
+	key := c.Request.Method + " " + c.FullPath()
+	bodies := spec.bodies[key]
+	if bodies == nil {
+		return problems, nil
+	}
+	limit := spec.limits[key]
+	if limit == 0 {
+		limit = validateMaxBodyBytes
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+
+	// Like gin's form binding, this parses forms once for the handler.
+	var tooLarge *http.MaxBytesError
+	contentType := c.ContentType()
+	switch {
+	case contentType == gin.MIMEMultipartPOSTForm && bodies[gin.MIMEMultipartPOSTForm] != nil:
+		if err := c.Request.ParseMultipartForm(validateMultipartMemory); errors.As(err, &tooLarge) {
+			return nil, tooLarge
+		} else if err != nil {
+			return append(problems, requestProblem{In: "body", Message: "is not a valid form"}), nil
+		}
+		problems = append(problems, spec.checkForm(bodies[gin.MIMEMultipartPOSTForm], multipartFields(c.Request.MultipartForm))...)
+	case bodies[gin.MIMEPOSTForm] != nil && contentType != gin.MIMEJSON:
+		if err := c.Request.ParseForm(); errors.As(err, &tooLarge) {
+			return nil, tooLarge
+		} else if err != nil {
+			return append(problems, requestProblem{In: "body", Message: "is not a valid form"}), nil
+		}
+		problems = append(problems, spec.checkForm(bodies[gin.MIMEPOSTForm], c.Request.PostForm)...)
+	case bodies[gin.MIMEJSON] != nil:
+		found, err := spec.checkJSONBody(c, bodies[gin.MIMEJSON])
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, found...)
+	}
+	return problems, nil
+}
+
+// multipartFields returns the values of a multipart form, with the name of
+// each file standing in for it so that required files are checked too.
+func multipartFields(form *multipart.Form) map[string][]string {
+	fields := make(map[string][]string, len(form.Value)+len(form.File))
+	for name, values := range form.Value {
+		fields[name] = values
+	}
+	for name, files := range form.File {
+		for _, file := range files {
+			fields[name] = append(fields[name], file.Filename)
+		}
+	}
+	return fields
+}
+
+// checkJSONBody reads the body, hands an identical one on to the handler,
+// and checks it against schema.
+func (spec *apiSpec) checkJSONBody(c *gin.Context, schema *openAPISchema) ([]requestProblem, error) {
+	body, err := io.ReadAll(c.Request.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, tooLarge
+	}
+	if err != nil {
+		return []requestProblem{{In: "body", Message: "could not be read"}}, nil
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if len(bytes.TrimSpace(body)) == 0 {
+		return []requestProblem{{In: "body", Message: "is required"}}, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		return []requestProblem{{In: "body", Message: "is not valid JSON"}}, nil
+	}
+	var problems []requestProblem
+	spec.checkValue(schema, value, "", &problems)
+	return problems, nil
+}
+
+// checkForm checks form fields, which are all strings, against an object
+// schema.
+func (spec *apiSpec) checkForm(schema *openAPISchema, form map[string][]string) []requestProblem {
+	var problems []requestProblem
+	schema = spec.resolve(schema)
+	for _, name := range schema.Required {
+		if len(form[name]) == 0 || form[name][0] == "" {
+			problems = append(problems, requestProblem{In: "body", Name: name, Message: "is required"})
+		}
+	}
+	for _, name := range sortedKeys(form) {
+		property, ok := schema.Properties[name]
+		if !ok {
+			continue
+		}
+		for _, v := range form[name] {
+			if message := spec.checkString(property, v); message != "" && v != "" {
+				problems = append(problems, requestProblem{In: "body", Name: name, Message: message})
+			}
+		}
+	}
+	return problems
+}
+
+// checkString checks a path, query or form value, which are strings
+// however the schema types them.
+func (spec *apiSpec) checkString(s *openAPISchema, v string) string {
+	s = spec.resolve(s)
+	switch s.Type {
+	case "integer":
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return "must be an integer"
+		}
+		return checkRange(s, float64(n))
+	case "number":
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return "must be a number"
+		}
+		return checkRange(s, n)
+	case "boolean":
+		if _, err := strconv.ParseBool(v); err != nil {
+			return "must be true or false"
+		}
+		return ""
+	}
+	return checkStringValue(s, v)
+}
+
+// checkValue checks a decoded JSON value, adding what is wrong with it to
+// problems. JSON null is accepted anywhere, as encoding/json accepts it.
+func (spec *apiSpec) checkValue(s *openAPISchema, v interface{}, name string, problems *[]requestProblem) {
+	s = spec.resolve(s)
+	if v == nil {
+		return
+	}
+	if len(s.OneOf) > 0 {
+		var first []requestProblem
+		for i, alternative := range s.OneOf {
+			var found []requestProblem
+			spec.checkValue(alternative, v, name, &found)
+			if len(found) == 0 {
+				return
+			}
+			if i == 0 {
+				first = found
+			}
+		}
+		*problems = append(*problems, first...)
+		return
+	}
+
+	fail := func(message string) {
+		*problems = append(*problems, requestProblem{In: "body", Name: name, Message: message})
+	}
+	switch s.Type {
+	case "object":
+		object, ok := v.(map[string]interface{})
+		if !ok {
+			fail("must be an object")
+			return
+		}
+		for _, field := range s.Required {
+			if _, ok := object[field]; !ok {
+				*problems = append(*problems, requestProblem{In: "body", Name: joinField(name, field), Message: "is required"})
+			}
+		}
+		for _, field := range sortedKeys(object) {
+			value := object[field]
+			if property, ok := s.Properties[field]; ok {
+				spec.checkValue(property, value, joinField(name, field), problems)
+			} else if s.AdditionalProperties != nil {
+				spec.checkValue(s.AdditionalProperties, value, joinField(name, field), problems)
+			}
+		}
+	case "array":
+		array, ok := v.([]interface{})
+		if !ok {
+			fail("must be an array")
+			return
+		}
+		for i, item := range array {
+			spec.checkValue(s.Items, item, fmt.Sprintf("%s[%d]", name, i), problems)
+		}
+	case "string":
+		str, ok := v.(string)
+		if n, isNumber := v.(json.Number); isNumber && s.Format == "decimal" {
+			str, ok = n.String(), true
+		}
+		if !ok {
+			fail("must be a string")
+			return
+		}
+		if message := checkStringValue(s, str); message != "" {
+			fail(message)
+		}
+	case "integer":
+		n, ok := v.(json.Number)
+		i, err := n.Int64()
+		if !ok || err != nil {
+			fail("must be an integer")
+			return
+		}
+		if message := checkRange(s, float64(i)); message != "" {
+			fail(message)
+		}
+	case "number":
+		n, ok := v.(json.Number)
+		f, err := n.Float64()
+		if !ok || err != nil {
+			fail("must be a number")
+			return
+		}
+		if message := checkRange(s, f); message != "" {
+			fail(message)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			fail("must be true or false")
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinField(name string, field string) string {
+	if name == "" {
+		return field
+	}
+	return name + "." + field
+}
+
+func checkRange(s *openAPISchema, n float64) string {
+	if s.Minimum != nil && n < *s.Minimum {
+		return "must be at least " + strconv.FormatFloat(*s.Minimum, 'f', -1, 64)
+	}
+	if s.Maximum != nil && n > *s.Maximum {
+		return "must be at most " + strconv.FormatFloat(*s.Maximum, 'f', -1, 64)
+	}
+	return ""
+}
+
+// checkStringValue checks a string's length, format, pattern and enum.
+func checkStringValue(s *openAPISchema, v string) string {
+	if s.MinLength != nil && len(v) < *s.MinLength {
+		if *s.MinLength == 1 {
+			return "must not be empty"
+		}
+		return fmt.Sprintf("must be at least %d characters", *s.MinLength)
+	}
+	switch s.Format {
+	case "date":
+		if _, err := time.Parse(time.DateOnly, v); err != nil {
+			return "must be a date in YYYY-MM-DD format"
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, v); err != nil {
+			return "must be an RFC 3339 timestamp"
+		}
+	case "uuid":
+		if _, err := uuid.Parse(v); err != nil {
+			return "must be a UUID"
+		}
+	case "decimal":
+		if _, err := money.ParseAmount(v); errors.Is(err, money.ErrOverflow) {
+			return "must be at most " + money.MaxAmount.StringFixed(0) + " in magnitude"
+		} else if err != nil {
+			return "must be a decimal number"
+		}
+	}
+	if s.Pattern != "" && !compiledPattern(s.Pattern).MatchString(v) {
+		if s.Description != "" {
+			return "must be " + strings.ToLower(s.Description[:1]) + strings.TrimSuffix(s.Description[1:], ".")
+		}
+		return "must match " + s.Pattern
+	}
+	if len(s.Enum) > 0 && !containsString(s.Enum, v) {
+		return "must be one of " + strings.Join(s.Enum, ", ")
+	}
+	return ""
+}
+
+var patterns sync.Map
+
+func compiledPattern(pattern string) *regexp.Regexp {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(pattern)
+	patterns.Store(pattern, re)
+	return re
+}
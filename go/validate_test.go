@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type validateTestBody struct {
+	Name  string `json:"name" form:"name" binding:"required"`
+	Count int    `json:"count" form:"count" binding:"gte=1"`
+	Kind  string `json:"kind" form:"kind" binding:"oneof=a b"`
+}
+
+type validateTestUpload struct {
+	File apiFile `form:"file" binding:"required"`
+	Kind string  `form:"kind" binding:"oneof=a b"`
+	Flag bool    `form:"flag"`
+}
+
+// useValidateTestRoutes serves test operations behind ValidateRequest. The
+// handlers answer with what they read of the request.
+func useValidateTestRoutes(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	previous, previousSpec := apiOperations, openAPI
+	t.Cleanup(func() { apiOperations, openAPI = previous, previousSpec })
+	apiOperations = map[string]apiOperation{
+		"POST /api/v1/things": {ID: "saveThing", Tag: "test", Request: validateTestBody{}, Form: true,
+			Query: []apiParam{{Name: "limit", Schema: &openAPISchema{Type: "integer"}}}},
+		"POST /api/v1/uploads": {ID: "upload", Tag: "test", Multipart: validateTestUpload{}, MaxBytes: 4 << 10},
+	}
+
+	r := gin.New()
+	v1 := r.Group("/api/v1", ValidateRequest())
+	v1.POST("/things", func(c *gin.Context) {
+		if c.ContentType() == gin.MIMEJSON {
+			body, _ := io.ReadAll(c.Request.Body)
+			c.String(http.StatusOK, string(body))
+			return
+		}
+		c.String(http.StatusOK, c.PostForm("name"))
+	})
+	v1.POST("/uploads", func(c *gin.Context) {
+		file, _, err := c.Request.FormFile("file")
+		if err != nil {
+			c.Status(http.StatusTeapot)
+			return
+		}
+		defer file.Close()
+		content, _ := io.ReadAll(file)
+		c.String(http.StatusOK, string(content))
+	})
+
+	spec, err := buildAPISpec(r.Routes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	openAPI = spec
+	return r
+}
+
+func multipartBody(t *testing.T, fields map[string]string, file string) (string, *bytes.Buffer) {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := w.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if file != "" {
+		part, err := w.CreateFormFile("file", "statement.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(file))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return w.FormDataContentType(), &body
+}
+
+func TestValidateRequest(t *testing.T) {
+	r := useValidateTestRoutes(t)
+	tooLargeJSON := `{"name": "` + strings.Repeat("x", validateMaxBodyBytes) + `"}`
+	bigUpload := strings.Repeat("x", 8<<10)
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		multipart   map[string]string
+		file        string
+		wantStatus  int
+		wantBody    string
+		wantProblem string
+	}{
+		{"json", "/api/v1/things", gin.MIMEJSON, `{"name": "rent", "count": 2, "kind": "a"}`, nil, "", http.StatusOK, `{"name": "rent", "count": 2, "kind": "a"}`, ""},
+		{"json missing field", "/api/v1/things", gin.MIMEJSON, `{"count": 2}`, nil, "", http.StatusBadRequest, "", "body field name is required"},
+		{"json below minimum", "/api/v1/things", gin.MIMEJSON, `{"name": "rent", "count": 0}`, nil, "", http.StatusBadRequest, "", "body field count must be at least 1"},
+		{"json wrong type", "/api/v1/things", gin.MIMEJSON, `{"name": 3}`, nil, "", http.StatusBadRequest, "", "body field name must be a string"},
+		{"json not in enum", "/api/v1/things", gin.MIMEJSON, `{"name": "rent", "kind": "c"}`, nil, "", http.StatusBadRequest, "", "body field kind must be one of a, b"},
+		{"json malformed", "/api/v1/things", gin.MIMEJSON, `{"name":`, nil, "", http.StatusBadRequest, "", "body is not valid JSON"},
+		{"json empty", "/api/v1/things", gin.MIMEJSON, ``, nil, "", http.StatusBadRequest, "", "body is required"},
+		{"json too large", "/api/v1/things", gin.MIMEJSON, tooLargeJSON, nil, "", http.StatusRequestEntityTooLarge, "", ""},
+		{"query not an integer", "/api/v1/things?limit=ten", gin.MIMEJSON, `{"name": "rent"}`, nil, "", http.StatusBadRequest, "", "query parameter limit must be an integer"},
+		{"form", "/api/v1/things", gin.MIMEPOSTForm, "name=rent&count=2", nil, "", http.StatusOK, "rent", ""},
+		{"form not in enum", "/api/v1/things", gin.MIMEPOSTForm, "name=rent&kind=c", nil, "", http.StatusBadRequest, "", "body field kind must be one of a, b"},
+		{"form too large", "/api/v1/things", gin.MIMEPOSTForm, "name=" + strings.Repeat("x", validateMaxBodyBytes), nil, "", http.StatusRequestEntityTooLarge, "", ""},
+		{"multipart form", "/api/v1/things", "", "", map[string]string{"name": "rent", "count": "2"}, "", http.StatusOK, "rent", ""},
+		{"multipart form missing field", "/api/v1/things", "", "", map[string]string{"count": "2"}, "", http.StatusBadRequest, "", "body field name is required"},
+		{"upload", "/api/v1/uploads", "", "", map[string]string{"kind": "a", "flag": "true"}, "date,name", http.StatusOK, "date,name", ""},
+		{"upload without file", "/api/v1/uploads", "", "", map[string]string{"kind": "a"}, "", http.StatusBadRequest, "", "body field file is required"},
+		{"upload field not in enum", "/api/v1/uploads", "", "", map[string]string{"kind": "c"}, "date,name", http.StatusBadRequest, "", "body field kind must be one of a, b"},
+		{"upload field not a boolean", "/api/v1/uploads", "", "", map[string]string{"flag": "maybe"}, "date,name", http.StatusBadRequest, "", "body field flag must be true or false"},
+		{"upload too large", "/api/v1/uploads", "", "", nil, bigUpload, http.StatusRequestEntityTooLarge, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contentType, body := tt.contentType, bytes.NewBufferString(tt.body)
+			if tt.multipart != nil || tt.file != "" {
+				contentType, body = multipartBody(t, tt.multipart, tt.file)
+			}
+			req := httptest.NewRequest(http.MethodPost, tt.path, body)
+			req.Header.Set("Content-Type", contentType)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("handler read %q, want %q", w.Body, tt.wantBody)
+			}
+			if tt.wantProblem != "" {
+				var resp apiErrorResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				if !strings.HasSuffix(resp.Error.Message, tt.wantProblem) {
+					t.Errorf("message = %q, want it to end with %q", resp.Error.Message, tt.wantProblem)
+				}
+			}
+		})
+	}
+}
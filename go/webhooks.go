@@ -0,0 +1,700 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/plaid/quickstart/money"
+	"github.com/plaid/quickstart/repository"
+)
+
+// Event types webhook endpoints subscribe to.
+const (
+	eventTransactionSynced      = "transaction.synced"
+	eventTransactionCategorized = "transaction.categorized"
+	eventBudgetSaved            = "budget.saved"
+	eventPeriodClosed           = "period.closed"
+	eventAlertFired             = "alert.fired"
+)
+
+var webhookEventTypes = []string{eventTransactionSynced, eventTransactionCategorized, eventBudgetSaved, eventPeriodClosed, eventAlertFired}
+
+const (
+	// webhookDeliveryInterval is how often deliveries due for a retry are
+	// sent. New events are sent as soon as they are queued.
+	webhookDeliveryInterval = time.Minute
+	webhookDeliveryBatch    = 100
+	// webhookMaxAttempts is when a delivery goes dead: with the delay
+	// doubling from a minute, about eight and a half hours after the event.
+	webhookMaxAttempts = 10
+	webhookTimeout     = 10 * time.Second
+	// webhookEndpointBudget bounds the time one run spends sending to one
+	// endpoint, and webhookConcurrency how many endpoints it sends to at once.
+	webhookEndpointBudget = 30 * time.Second
+	webhookConcurrency    = 8
+	// webhookClaimLease is how long claimed deliveries are held for the
+	// instance that claimed them: past any run, so they are sent once.
+	webhookClaimLease = 2 * time.Minute
+	// webhookResponseLimit caps how much of a response is read, so that
+	// connections can be reused.
+	webhookResponseLimit = 64 << 10
+
+	maxWebhookEndpoints           = 10
+	webhookDeliveriesDefaultLimit = 50
+	webhookDeliveriesMaxLimit     = 500
+	webhookSignatureHeader        = "X-SmartSplit-Signature"
+	webhookEventHeader            = "X-SmartSplit-Event"
+	webhookDeliveryHeader         = "X-SmartSplit-Delivery"
+	webhookSecretPrefix           = "whsec_"
+)
+
+// webhookClient sends webhooks and alert webhooks to URLs users chose, so
+// it only connects to public addresses, checked after DNS resolution, and
+// does not follow redirects. Proxies from the environment would connect on
+// its behalf and are not used.
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: webhookTimeout, Control: dialPublicOnly}).DialContext,
+		TLSHandshakeTimeout: webhookTimeout,
+		MaxIdleConnsPerHost: 2,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// errPrivateAddress is returned for webhook URLs that are or resolve to
+// addresses inside our network.
+var errPrivateAddress = errors.New("webhook URLs must resolve to public addresses")
+
+// publicAddress reports whether ip is routable on the internet: not
+// loopback, private, link-local (which includes cloud metadata services such
+// as 169.254.169.254), multicast, unspecified or one of nonPublicPrefixes.
+func publicAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// nonPublicPrefixes are special-purpose ranges IsGlobalUnicast accepts:
+// carrier-grade NAT, IETF protocol assignments, benchmarking and NAT64,
+// which reaches any IPv4 address including private ones.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// dialPublicOnly is the net.Dialer Control of webhookClient. It sees the
+// address actually dialed, so a name that resolved to a public address when
+// the URL was saved cannot be pointed inside later.
+func dialPublicOnly(network string, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !publicAddress(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", errPrivateAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+// webhookEvent is the JSON body of every webhook request.
+type webhookEvent struct {
+	ID        uuid.UUID   `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+func newWebhookEvent(eventType string, data interface{}) webhookEvent {
+	return webhookEvent{ID: uuid.New(), Type: eventType, CreatedAt: time.Now().UTC(), Data: data}
+}
+
+// webhookWake tells runWebhookDelivery that new deliveries are queued.
+var webhookWake = make(chan struct{}, 1)
+
+// publishEvent streams event to the user's dashboards and queues it for
+// their enabled endpoints subscribed to it. Events are a side effect of the
+// change that raised them, so a failure is logged rather than returned.
+func publishEvent(ctx context.Context, userid string, event webhookEvent) {
+	payload, err := json.Marshal(event)
+	if err == nil {
+		liveEvents.Publish(userid, event.Type, payload)
+		var endpoints []repository.WebhookEndpoint
+		endpoints, err = repos.Webhooks.Endpoints(ctx, userid)
+		if err == nil {
+			err = queueEvent(ctx, endpoints, event, payload)
+		}
+	}
+	if err != nil {
+		logger("webhooks").ErrorContext(ctx, "could not queue webhook event", "user_id", userid, "type", event.Type, "err", err)
+	}
+}
+
+// queueEvent queues event, encoded as payload, for those of endpoints
+// that are enabled and subscribed to it.
+func queueEvent(ctx context.Context, endpoints []repository.WebhookEndpoint, event webhookEvent, payload []byte) error {
+	deliveries := []repository.WebhookDelivery{}
+	for _, endpoint := range endpoints {
+		if endpoint.Enabled && containsString(endpoint.Events, event.Type) {
+			deliveries = append(deliveries, repository.WebhookDelivery{EndpointID: endpoint.ID, EventID: event.ID, EventType: event.Type, Payload: payload})
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	queued, err := repos.Webhooks.Enqueue(ctx, deliveries)
+	if queued > 0 {
+		wakeWebhookDelivery()
+	}
+	return err
+}
+
+func wakeWebhookDelivery() {
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// publishClosedPeriods sends period.closed for last month, with its
+// statement totals, to endpoints that existed when it ended. The event id
+// is derived from the user and period, so each endpoint gets it once
+// however often this runs.
+func publishClosedPeriods(ctx context.Context, now time.Time) error {
+	to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, -1, 0)
+	period := from.Format(statementPeriodLayout)
+
+	endpoints, err := repos.Webhooks.Subscribed(ctx, eventPeriodClosed, to)
+	if err != nil {
+		return err
+	}
+	byUser := map[string][]repository.WebhookEndpoint{}
+	users := []string{}
+	for _, endpoint := range endpoints {
+		if _, ok := byUser[endpoint.UserID]; !ok {
+			users = append(users, endpoint.UserID)
+		}
+		byUser[endpoint.UserID] = append(byUser[endpoint.UserID], endpoint)
+	}
+
+	for _, userid := range users {
+		s, err := buildStatement(ctx, userid, from, to.AddDate(0, 0, -1))
+		if err != nil {
+			logger("webhooks").ErrorContext(ctx, "could not build closed period", "user_id", userid, "err", err)
+			continue
+		}
+		event := webhookEvent{
+			ID:        uuid.NewSHA1(uuid.NameSpaceURL, []byte("smartsplit:"+eventPeriodClosed+":"+userid+":"+period)),
+			Type:      eventPeriodClosed,
+			CreatedAt: to,
+			Data: periodClosedData{
+				Period: period, From: from.Format(time.DateOnly), To: to.AddDate(0, 0, -1).Format(time.DateOnly),
+				Currency: s.Currency, IncomeReceived: s.IncomeReceived, Spent: s.Spent,
+			},
+		}
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if err := queueEvent(ctx, byUser[userid], event, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type periodClosedData struct {
+	Period         string        `json:"period"`
+	From           string        `json:"from"`
+	To             string        `json:"to"`
+	Currency       string        `json:"currency"`
+	IncomeReceived money.Decimal `json:"income_received"`
+	Spent          money.Decimal `json:"spent"`
+}
+
+// transactionSyncedData lists what one Plaid sync stored for an item.
+type transactionSyncedData struct {
+	ItemID   uuid.UUID                `json:"item_id"`
+	Added    []repository.Transaction `json:"added"`
+	Modified []repository.Transaction `json:"modified"`
+	// Removed are Plaid transaction ids.
+	Removed []string `json:"removed"`
+}
+
+type transactionCategorizedData struct {
+	Assignments []categorizedTransaction `json:"assignments"`
+}
+
+type categorizedTransaction struct {
+	TransactionID uuid.UUID `json:"transaction_id"`
+	ExpenseID     uuid.UUID `json:"expense_id"`
+	Confidence    float64   `json:"confidence"`
+	Source        string    `json:"source"`
+}
+
+// signWebhook returns "t=<unix seconds>,v1=<hex HMAC-SHA256 of t.body>".
+// Receivers recompute it with their secret to check that a request came
+// from SmartSplit, and compare t with their clock to reject replays.
+func signWebhook(secret string, at time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return webhookSecretPrefix + hex.EncodeToString(b), nil
+}
+
+// checkWebhookURL returns a message for the user if s is not an http or
+// https URL whose host resolves only to public addresses, or "".
+func checkWebhookURL(ctx context.Context, s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Hostname() == "" {
+		return "must be an http or https URL", nil
+	}
+	addresses, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return "must name a host that exists", nil
+		}
+		return "", err
+	}
+	for _, address := range addresses {
+		if !publicAddress(address) {
+			return "must resolve to public addresses only", nil
+		}
+	}
+	return "", nil
+}
+
+// postWebhook sends body, signed with secret, and returns the response
+// status. Any status outside 2xx is an error.
+func postWebhook(ctx context.Context, client *http.Client, target string, secret string, eventType string, deliveryID uuid.UUID, body []byte) (int, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(webhookEventHeader, eventType)
+	request.Header.Set(webhookDeliveryHeader, deliveryID.String())
+	request.Header.Set(webhookSignatureHeader, signWebhook(secret, time.Now(), body))
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, webhookResponseLimit))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("endpoint answered %s", response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+// deliveryRetryDelay is the wait after a failed attempt: a minute,
+// doubling with each attempt.
+func deliveryRetryDelay(attempts int) time.Duration {
+	return time.Minute << attempts
+}
+
+// deliverWebhooks claims and sends the deliveries that are due. A failed
+// delivery is retried later, until it has been tried webhookMaxAttempts
+// times and is marked dead. Endpoints are sent to concurrently, each in
+// order and for at most webhookEndpointBudget, so a slow endpoint holds up
+// only its own deliveries; those it did not get to wait for the claim to end.
+func deliverWebhooks(ctx context.Context, now time.Time) {
+	deliveries, err := repos.Webhooks.ClaimDeliveries(ctx, now, now.Add(webhookClaimLease), webhookDeliveryBatch)
+	if err != nil {
+		logger("webhooks").ErrorContext(ctx, "could not claim webhook deliveries", "err", err)
+		return
+	}
+
+	var endpoints []uuid.UUID
+	byEndpoint := map[uuid.UUID][]repository.PendingWebhookDelivery{}
+	for _, pending := range deliveries {
+		if _, ok := byEndpoint[pending.EndpointID]; !ok {
+			endpoints = append(endpoints, pending.EndpointID)
+		}
+		byEndpoint[pending.EndpointID] = append(byEndpoint[pending.EndpointID], pending)
+	}
+
+	// Nothing is sent after the claim could have ended, when another
+	// instance may claim the same deliveries.
+	runCtx, cancel := context.WithTimeout(ctx, webhookClaimLease-webhookTimeout)
+	defer cancel()
+	slots := make(chan struct{}, webhookConcurrency)
+	var wg sync.WaitGroup
+	for _, endpoint := range endpoints {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(queue []repository.PendingWebhookDelivery) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			runJob(ctx, "webhooks", func() {
+				sendCtx, cancel := context.WithTimeout(runCtx, webhookEndpointBudget)
+				defer cancel()
+				for _, pending := range queue {
+					if sendCtx.Err() != nil {
+						return
+					}
+					deliverWebhook(ctx, sendCtx, now, pending)
+				}
+			})
+		}(byEndpoint[endpoint])
+	}
+	wg.Wait()
+}
+
+// deliverWebhook sends one delivery with sendCtx and records the attempt.
+func deliverWebhook(ctx context.Context, sendCtx context.Context, now time.Time, pending repository.PendingWebhookDelivery) {
+	d := pending.WebhookDelivery
+	secret, err := tokenCipher.Decrypt(ctx, pending.Secret, repository.WebhookEndpointSecret, d.EndpointID.String())
+	var status int
+	if err == nil {
+		status, err = postWebhook(sendCtx, webhookClient, pending.URL, secret, d.EventType, d.ID, d.Payload)
+	}
+
+	d.ResponseStatus, d.LastError = nil, ""
+	if status != 0 {
+		d.ResponseStatus = &status
+	}
+	switch {
+	case err == nil:
+		d.Status = repository.WebhookDelivered
+	case d.Attempts+1 >= webhookMaxAttempts:
+		d.Status, d.LastError = repository.WebhookDead, err.Error()
+	default:
+		d.Status, d.LastError = repository.WebhookPending, err.Error()
+		d.NextAttemptAt = now.Add(deliveryRetryDelay(d.Attempts))
+	}
+	if err != nil {
+		logger("webhooks").WarnContext(ctx, "webhook delivery failed", "delivery_id", d.ID, "url", pending.URL, "attempts", d.Attempts+1, "err", err)
+	}
+	if err := repos.Webhooks.MarkAttempted(ctx, d); err != nil {
+		logger("webhooks").ErrorContext(ctx, "could not record webhook delivery", "delivery_id", d.ID, "err", err)
+	}
+}
+
+// runWebhookDelivery sends webhook deliveries now, whenever new ones are
+// queued and every webhookDeliveryInterval until ctx is done, and queues
+// period.closed once a month. WEBHOOK_DELIVERY=false turns the job off;
+// events are still queued and wait for it.
+func runWebhookDelivery(ctx context.Context) {
+	if os.Getenv("WEBHOOK_DELIVERY") == "false" {
+		return
+	}
+	ticker := time.NewTicker(webhookDeliveryInterval)
+	defer ticker.Stop()
+	closed := ""
+	for {
+		runJob(ctx, "webhooks", func() {
+			now := time.Now().UTC()
+			if period := now.Format(statementPeriodLayout); period != closed {
+				if err := publishClosedPeriods(ctx, now); err != nil {
+					logger("webhooks").ErrorContext(ctx, "could not publish closed periods", "err", err)
+				} else {
+					closed = period
+				}
+			}
+			deliverWebhooks(ctx, now)
+		})
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-webhookWake:
+		}
+	}
+}
+
+// webhookEndpointResponse shows an endpoint's secret after it is created
+// or rotated, the only times the caller sees it.
+type webhookEndpointResponse struct {
+	repository.WebhookEndpoint
+	Secret string `json:"secret,omitempty"`
+}
+
+type webhooksResponse struct {
+	Endpoints []repository.WebhookEndpoint `json:"endpoints"`
+	// EventTypes are the events endpoints can subscribe to.
+	EventTypes []string `json:"event_types"`
+}
+
+type webhookDeliveriesResponse struct {
+	Deliveries []repository.WebhookDelivery `json:"deliveries"`
+}
+
+func getWebhooksHandler(c *gin.Context) {
+	endpoints, err := repos.Webhooks.Endpoints(c.Request.Context(), c.GetString("userid"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load webhooks")
+		return
+	}
+	c.JSON(http.StatusOK, webhooksResponse{Endpoints: endpoints, EventTypes: webhookEventTypes})
+}
+
+type webhookEndpointRequest struct {
+	URL         string   `json:"url" binding:"required"`
+	Description string   `json:"description"`
+	Events      []string `json:"events"`
+	// Enabled defaults to true.
+	Enabled *bool `json:"enabled"`
+	// RotateSecret replaces the endpoint's signing secret.
+	RotateSecret bool `json:"rotate_secret"`
+}
+
+// endpointEvents checks events and returns them once each, in the order
+// of webhookEventTypes, or a message for the user.
+func endpointEvents(events []string) ([]string, string) {
+	for _, event := range events {
+		if !containsString(webhookEventTypes, event) {
+			return nil, fmt.Sprintf("unknown event type %q", event)
+		}
+	}
+	subscribed := []string{}
+	for _, event := range webhookEventTypes {
+		if containsString(events, event) {
+			subscribed = append(subscribed, event)
+		}
+	}
+	if len(subscribed) == 0 {
+		return nil, "events must name at least one of " + strings.Join(webhookEventTypes, ", ")
+	}
+	return subscribed, ""
+}
+
+// saveWebhookHandler registers an endpoint on POST and replaces one on
+// PUT.
+func saveWebhookHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	userid := c.GetString("userid")
+
+	var request webhookEndpointRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "A url is required")
+		return
+	}
+	if message, err := checkWebhookURL(c.Request.Context(), request.URL); err != nil {
+		respondError(c, http.StatusBadGateway, "Could not resolve the url's host")
+		return
+	} else if message != "" {
+		respondError(c, http.StatusBadRequest, "url "+message)
+		return
+	}
+	events, message := endpointEvents(request.Events)
+	if message != "" {
+		respondError(c, http.StatusBadRequest, message)
+		return
+	}
+
+	endpoints, err := repos.Webhooks.Endpoints(ctx, userid)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load webhooks")
+		return
+	}
+	endpoint := repository.WebhookEndpoint{ID: uuid.New(), Enabled: true}
+	var previous *repository.WebhookEndpoint
+	if c.Param("id") != "" {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "Invalid webhook id")
+			return
+		}
+		for i := range endpoints {
+			if endpoints[i].ID == id {
+				previous = &endpoints[i]
+			}
+		}
+		if previous == nil {
+			respondError(c, http.StatusNotFound, "Webhook not found")
+			return
+		}
+		endpoint = *previous
+	} else if len(endpoints) >= maxWebhookEndpoints {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("You can register at most %d webhooks", maxWebhookEndpoints))
+		return
+	}
+
+	endpoint.URL, endpoint.Description, endpoint.Events = request.URL, strings.TrimSpace(request.Description), events
+	if request.Enabled != nil {
+		endpoint.Enabled = *request.Enabled
+	}
+	secret := ""
+	if previous == nil || request.RotateSecret {
+		secret, err = newWebhookSecret()
+		if err == nil {
+			endpoint.Secret, err = tokenCipher.Encrypt(ctx, secret, repository.WebhookEndpointSecret, endpoint.ID.String())
+		}
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "Could not create webhook secret")
+			return
+		}
+	}
+
+	var saved repository.WebhookEndpoint
+	if previous == nil {
+		saved, err = repos.Webhooks.CreateEndpoint(ctx, userid, endpoint)
+	} else {
+		saved, err = repos.Webhooks.UpdateEndpoint(ctx, userid, endpoint)
+	}
+	if err == repository.ErrNotFound {
+		respondError(c, http.StatusNotFound, "Webhook not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not save webhook")
+		return
+	}
+
+	var before interface{}
+	if previous != nil {
+		before = *previous
+	}
+	beforeFields, afterFields := auditDiff(before, saved)
+	recordAudit(c, repository.AuditEntry{
+		Action:       AuditWebhookSaved,
+		ResourceType: "webhook",
+		ResourceID:   saved.ID.String(),
+		Before:       beforeFields,
+		After:        afterFields,
+	})
+
+	c.JSON(http.StatusOK, webhookEndpointResponse{WebhookEndpoint: saved, Secret: secret})
+}
+
+func deleteWebhookHandler(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid webhook id")
+		return
+	}
+	err = repos.Webhooks.DeleteEndpoint(c.Request.Context(), c.GetString("userid"), id)
+	if err == repository.ErrNotFound {
+		respondError(c, http.StatusNotFound, "Webhook not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not delete webhook")
+		return
+	}
+
+	recordAudit(c, repository.AuditEntry{
+		Action:       AuditWebhookDeleted,
+		ResourceType: "webhook",
+		ResourceID:   id.String(),
+	})
+	c.Status(http.StatusNoContent)
+}
+
+// getWebhookDeliveriesHandler is an endpoint's delivery log, newest first,
+// optionally filtered with ?status=pending|delivered|dead.
+func getWebhookDeliveriesHandler(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid webhook id")
+		return
+	}
+	status := c.Query("status")
+	if status != "" && status != repository.WebhookPending && status != repository.WebhookDelivered && status != repository.WebhookDead {
+		respondError(c, http.StatusBadRequest, "status must be pending, delivered or dead")
+		return
+	}
+	limit := webhookDeliveriesDefaultLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			respondError(c, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = min(n, webhookDeliveriesMaxLimit)
+	}
+
+	ctx := c.Request.Context()
+	userid := c.GetString("userid")
+	endpoints, err := repos.Webhooks.Endpoints(ctx, userid)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load webhooks")
+		return
+	}
+	found := false
+	for _, endpoint := range endpoints {
+		found = found || endpoint.ID == id
+	}
+	if !found {
+		respondError(c, http.StatusNotFound, "Webhook not found")
+		return
+	}
+
+	deliveries, err := repos.Webhooks.Deliveries(ctx, userid, id, status, limit)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not load webhook deliveries")
+		return
+	}
+	c.JSON(http.StatusOK, webhookDeliveriesResponse{Deliveries: deliveries})
+}
+
+// redeliverWebhookHandler queues a delivery again, whatever became of it,
+// as a new delivery of the same event.
+func redeliverWebhookHandler(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid webhook id")
+		return
+	}
+	deliveryID, err := uuid.Parse(c.Param("delivery"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid delivery id")
+		return
+	}
+
+	delivery, err := repos.Webhooks.Redeliver(c.Request.Context(), c.GetString("userid"), id, deliveryID)
+	if err == repository.ErrNotFound {
+		respondError(c, http.StatusNotFound, "Delivery not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Could not redeliver webhook")
+		return
+	}
+	wakeWebhookDelivery()
+	c.JSON(http.StatusAccepted, delivery)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/plaid/quickstart/repository"
+)
+
+func TestPublicAddress(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00:ec2::254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+		{"64:ff9b::a00:1", false},
+	}
+	for _, tt := range tests {
+		if got := publicAddress(netip.MustParseAddr(tt.ip)); got != tt.public {
+			t.Errorf("publicAddress(%s) = %v, want %v", tt.ip, got, tt.public)
+		}
+	}
+}
+
+func TestCheckWebhookURL(t *testing.T) {
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"https://93.184.216.34/hook", true},
+		{"http://[2606:2800:220:1:248:1893:25c8:1946]:8080/hook", true},
+		{"http://127.0.0.1:8000/hook", false},
+		{"http://localhost/hook", false},
+		{"http://169.254.169.254/latest/meta-data/", false},
+		{"http://[::1]/hook", false},
+		{"http://10.0.0.5/hook", false},
+		{"ftp://93.184.216.34/hook", false},
+		{"https:///hook", false},
+		{"not a url", false},
+	}
+	for _, tt := range tests {
+		message, err := checkWebhookURL(context.Background(), tt.url)
+		if err != nil {
+			t.Errorf("checkWebhookURL(%q): %v", tt.url, err)
+			continue
+		}
+		if (message == "") != tt.valid {
+			t.Errorf("checkWebhookURL(%q) = %q, want valid = %v", tt.url, message, tt.valid)
+		}
+	}
+}
+
+func TestWebhookClientRefusesPrivateAddresses(t *testing.T) {
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hits++ }))
+	defer server.Close()
+
+	_, err := postWebhook(context.Background(), webhookClient, server.URL, "secret", eventBudgetSaved, uuid.New(), []byte("{}"))
+	if !errors.Is(err, errPrivateAddress) {
+		t.Errorf("err = %v, want errPrivateAddress", err)
+	}
+	if hits != 0 {
+		t.Errorf("server got %d requests", hits)
+	}
+}
+
+func TestWebhookClientDoesNotFollowRedirects(t *testing.T) {
+	followed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/internal" {
+			followed = true
+			return
+		}
+		http.Redirect(w, r, "/internal", http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	// The test server is on loopback, so swap in a transport that may reach it.
+	client := *webhookClient
+	client.Transport = http.DefaultTransport
+	status, err := postWebhook(context.Background(), &client, server.URL, "secret", eventBudgetSaved, uuid.New(), []byte("{}"))
+	if err == nil || status != http.StatusTemporaryRedirect {
+		t.Errorf("status = %d, err = %v, want an error for the redirect", status, err)
+	}
+	if followed {
+		t.Error("the redirect was followed")
+	}
+}
+
+func TestClaimDeliveriesOnce(t *testing.T) {
+	ctx := context.Background()
+	db := openTestSQLite(t)
+	if err := runMigrateCommand(ctx, db, driverSQLite, []string{"up"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Seed(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	webhooks := repository.New(db).Webhooks
+
+	endpoint, err := webhooks.CreateEndpoint(ctx, seedUserID, repository.WebhookEndpoint{
+		ID: uuid.New(), URL: "https://93.184.216.34/hook", Events: []string{eventBudgetSaved}, Enabled: true, Secret: "sealed",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := []repository.WebhookDelivery{
+		{EndpointID: endpoint.ID, EventID: uuid.New(), EventType: eventBudgetSaved, Payload: []byte("{}")},
+		{EndpointID: endpoint.ID, EventID: uuid.New(), EventType: eventBudgetSaved, Payload: []byte("{}")},
+	}
+	if _, err := webhooks.Enqueue(ctx, queued); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC().Add(time.Minute)
+	lease := now.Add(webhookClaimLease)
+	tests := []struct {
+		name string
+		at   time.Time
+		want int
+	}{
+		{"first claim", now, 2},
+		{"claimed again", now, 0},
+		{"before the lease ends", lease.Add(-time.Second), 0},
+		{"after the lease ends", lease.Add(time.Second), 2},
+	}
+	for _, tt := range tests {
+		claimed, err := webhooks.ClaimDeliveries(ctx, tt.at, tt.at.Add(webhookClaimLease), webhookDeliveryBatch)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(claimed) != tt.want {
+			t.Errorf("%s: claimed %d deliveries, want %d", tt.name, len(claimed), tt.want)
+		}
+		for _, d := range claimed {
+			if d.URL != endpoint.URL || d.Secret != "sealed" {
+				t.Errorf("%s: claimed %+v without its endpoint", tt.name, d)
+			}
+		}
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	body := []byte(`{"id":"1"}`)
+	want := "t=1700000000,v1=11bf4466ea17c3df3fd743af0b435368e16b7a05eb8eced85e8c4670767bdec5"
+
+	tests := []struct {
+		name   string
+		secret string
+		at     time.Time
+		body   []byte
+		same   bool
+	}{
+		{"same input", "whsec_test", at, body, true},
+		{"other secret", "whsec_other", at, body, false},
+		{"other time", "whsec_test", at.Add(time.Second), body, false},
+		{"other body", "whsec_test", at, []byte(`{"id":"2"}`), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := signWebhook(tt.secret, tt.at, tt.body); (got == want) != tt.same {
+				t.Errorf("signWebhook = %s, same as %s: %v, want %v", got, want, got == want, tt.same)
+			}
+		})
+	}
+}
+
+func TestDeliveryRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 2 * time.Minute},
+		{2, 4 * time.Minute},
+		{5, 32 * time.Minute},
+		{webhookMaxAttempts - 1, 512 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := deliveryRetryDelay(tt.attempts); got != tt.want {
+			t.Errorf("deliveryRetryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}